@@ -16,8 +16,10 @@ import (
 	"github.com/espebra/filebin2/internal/ds"
 	"github.com/espebra/filebin2/internal/geoip"
 	"github.com/espebra/filebin2/internal/lurker"
+	"github.com/espebra/filebin2/internal/processor"
 	"github.com/espebra/filebin2/internal/s3"
 	"github.com/espebra/filebin2/internal/web"
+	"github.com/espebra/filebin2/internal/webhook"
 	"github.com/espebra/filebin2/internal/workspace"
 
 	"github.com/dustin/go-humanize"
@@ -35,22 +37,48 @@ var (
 	// Various
 	contactFlag               = flag.String("contact", "", "The contact information, such as an email address, that will be shown on the website for people that want to get in touch with the service owner.")
 	expirationFlag            = flag.Int("expiration", 604800, "Bin expiration time in seconds since the last bin update")
+	expirationMinFlag         = flag.Int("expiration-min", 3600, "The shortest bin expiration time in seconds that uploaders and bin owners can request. Requests for shorter expiration times are raised to this value. 0 means that the expiration time can not be shortened.")
+	expirationMaxFlag         = flag.Int("expiration-max", 0, "The longest bin expiration time in seconds that uploaders and bin owners can request. Requests for longer expiration times are lowered to this value. Defaults to the expiration time if lower than it.")
 	tmpdirFlag                = flag.String("tmpdir", os.TempDir(), "Comma-separated list of directories for temporary files. Multiple directories will be benchmarked at startup, and the fastest one with sufficient free space will be used for each upload.")
 	tmpdirThresholdFlag       = flag.Float64("tmpdir-capacity-threshold", 4.0, "Workspace capacity threshold multiplier. A workspace must have at least this multiplier times the file size available to be selected (e.g., 4.0 requires 4x the file size available).")
 	baseURLFlag               = flag.String("baseurl", "https://filebin.net", "The base URL to use. Required for self-hosted instances.")
 	requireApprovalFlag       = flag.Bool("manual-approval", false, "Require manual admin approval of new bins before files can be downloaded.")
 	requireCookieFlag         = flag.Bool("require-verification-cookie", false, "Require cookie before allowing a download to happen.")
 	cookieLifetimeFlag        = flag.Int("verification-cookie-lifetime", 365, "Number of days before cookie expiration.")
+	requireOwnerTokenFlag     = flag.Bool("require-owner-token", false, "Require the owner token of a bin to delete or lock the bin, or to delete files from it. Disable to allow anyone to perform these operations.")
+	sessionSecretFlag         = flag.String("session-secret", "", "Secret used to sign the sessions of password protected bins. A random secret is generated at startup if not set, which means that visitors have to enter the password again after a restart.")
 	expectedCookieValueFlag   = flag.String("expected-cookie-value", "2024-05-24", "Which cookie value to expect to avoid showing a warning message.")
 	mmdbCityPathFlag          = flag.String("mmdb-city", "", "The path to an mmdb formatted geoip database like GeoLite2-City.mmdb.")
 	mmdbASNPathFlag           = flag.String("mmdb-asn", "", "The path to an mmdb formatted geoip database like GeoLite2-ASN.mmdb.")
 	allowRobotsFlag           = flag.Bool("allow-robots", false, "Allow robots to crawl and index the site (using X-Robots-Tag response header).")
-	postUploadHookFlag        = flag.String("post-upload-hook", "", "Command to execute after every successful file upload, after the file has been stored in S3 and its metadata persisted. Invoked with the named arguments --bin-id, --filename, --content-type, --size, and --sha256. Exit code and output are logged but do not affect the response to the client.")
+	postUploadHookFlag        = flag.String("post-upload-hook", "", "Command to execute after every successful file upload, after the file has been stored in S3 and its metadata persisted. Invoked with the named arguments --bin-id, --filename, --content-type, --size, and --sha256. The hook is queued and run in the background by the job workers. Exit code and output are logged but do not affect the response to the client.")
 	postUploadHookTimeoutFlag = flag.Duration("post-upload-hook-timeout", 10*time.Second, "Timeout for the post-upload hook command execution")
+	clamdAddressFlag          = flag.String("clamd-address", "", "Address of the clamd daemon used to scan uploaded content for malware, either unix:///path/to/clamd.sock or tcp://host:port. Scanning is disabled if not set.")
+	clamdTimeoutFlag          = flag.Duration("clamd-timeout", 5*time.Minute, "Timeout for scanning a single file with clamd")
+	blockSimilarDistanceFlag  = flag.Int("block-similar-distance", 0, "The largest Hamming distance between the perceptual hashes of an uploaded image and blocked content for the image to be considered a copy of the blocked content. 0 disables the comparison.")
+	blockSimilarActionFlag    = flag.String("block-similar-action", "reject", "What to do with uploads of images that are similar to blocked content, either reject to reject the upload, or review to require the bin to be approved")
+	webhookURLsFlag           = flag.String("webhook-urls", "", "A whitespace separated list of URLs to send signed webhook events to when bins and files are created, changed or deleted. Webhooks are disabled if not set.")
+	webhookSecretFlag         = flag.String("webhook-secret", "", "Secret used to sign the webhook requests with HMAC-SHA256. Required if webhooks are enabled.")
+	webhookTimeoutFlag        = flag.Duration("webhook-timeout", 10*time.Second, "Timeout for each webhook delivery attempt")
+	webhookMaxAttemptsFlag    = flag.Int("webhook-max-attempts", 10, "The number of attempts to deliver a webhook event before giving up")
+	jobWorkersFlag            = flag.Int("job-workers", 2, "The number of workers processing uploaded content in the background, such as computing the perceptual hash of images. 0 disables the processing.")
+	resumableUploadTTLFlag    = flag.Duration("resumable-upload-ttl", 24*time.Hour, "Time a resumable upload is kept after the last received chunk before it is considered abandoned and removed by the lurker.")
 
 	// Limits
 	limitFileDownloadsFlag       = flag.Uint64("limit-file-downloads", 0, "Limit the number of downloads per file. 0 disables this limit.")
+	limitPasswordAttemptsFlag    = flag.Int("limit-password-attempts", 10, "Limit the number of password checks per client within 15 minutes. 0 disables this limit.")
 	limitStorageFlag             = flag.String("limit-storage", "0", "Limit the storage capacity to use (examples: 100MB, 20GB, 2TB). 0 disables this limit.")
+	limitUploadSizeFlag          = flag.String("limit-upload-size", "0", "Limit the size of each uploaded file (examples: 100MB, 20GB). Also applies to uploads of unknown size, which are aborted when they exceed the limit. 0 disables this limit.")
+	limitExtractFilesFlag        = flag.Int("limit-extract-files", 1000, "Limit the number of files in archives that are extracted on upload. 0 disables the extraction of archives.")
+	limitExtractSizeFlag         = flag.String("limit-extract-size", "1GB", "Limit the total size of the files in archives that are extracted on upload (examples: 500MB, 10GB). 0 disables this limit.")
+	limitExtractRatioFlag        = flag.Uint64("limit-extract-ratio", 100, "Limit the compression ratio of archives that are extracted on upload, which is the total size of the files divided by the size of the archive. 0 disables this limit.")
+	limitClientScopeFlag         = flag.String("limit-client-scope", "ip", "The scope that the per-client limits are counted in, either ip, network or asn. The network and asn scopes require geoip databases.")
+	limitClientUploadHourFlag    = flag.String("limit-client-upload-hour", "0", "Limit the bytes uploaded per client within an hour (examples: 500MB, 10GB). 0 disables this limit.")
+	limitClientUploadDayFlag     = flag.String("limit-client-upload-day", "0", "Limit the bytes uploaded per client within a day (examples: 5GB, 100GB). 0 disables this limit.")
+	limitClientFilesHourFlag     = flag.Int("limit-client-files-hour", 0, "Limit the number of files uploaded per client within an hour. 0 disables this limit.")
+	limitClientFilesDayFlag      = flag.Int("limit-client-files-day", 0, "Limit the number of files uploaded per client within a day. 0 disables this limit.")
+	limitClientBinsDayFlag       = flag.Int("limit-client-bins-day", 0, "Limit the number of bins created per client within a day. 0 disables this limit.")
+	limitClientConcurrentFlag    = flag.Int("limit-client-concurrent-uploads", 0, "Limit the number of concurrent uploads per client. 0 disables this limit.")
 	rejectFileExtensions         = flag.String("reject-file-extensions", "", "A whitespace separated list of file extensions that will be rejected")
 	clientUploadFailuresCapFlag  = flag.Int("client-upload-failures-cap", 500, "Maximum number of recent client-reported upload failures retained in memory for /admin/telemetry/upload-failures. 0 disables in-memory retention; Prometheus metrics are unaffected.")
 	clientUploadSuccessesCapFlag = flag.Int("client-upload-successes-cap", 200, "Maximum number of recent client-reported upload successes retained in memory for /admin/telemetry/upload-successes. 0 disables in-memory retention; Prometheus metrics are unaffected.")
@@ -86,6 +114,7 @@ var (
 	s3SecretKeyFlag            = flag.String("s3-secret-key", "", "S3 secret key")
 	s3SecureFlag               = flag.Bool("s3-secure", true, "Use TLS when connecting to S3")
 	s3UrlTtlFlag               = flag.String("s3-url-ttl", "1m", "The time to live for presigned S3 URLs, for example 30s or 5m")
+	downloadModeFlag           = flag.String("download-mode", "redirect", "How files are downloaded, either redirect to redirect the clients to presigned S3 URLs, or proxy to stream the files through filebin")
 	s3TimeoutFlag              = flag.String("s3-timeout", "30s", "Timeout for quick S3 operations (delete, head, stat)")
 	s3TransferTimeoutFlag      = flag.String("s3-transfer-timeout", "10m", "Timeout for S3 data transfers (put, get, copy)")
 	s3MultipartPartSizeFlag    = flag.String("s3-multipart-part-size", "64MB", "Multipart upload part size (e.g. 5MB, 64MB, 128MB). Files larger than this use multipart upload.")
@@ -153,6 +182,16 @@ func main() {
 			*expirationFlag = i
 		}
 	}
+	if v := os.Getenv("FILEBIN_EXPIRATION_MIN"); v != "" && *expirationMinFlag == 3600 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*expirationMinFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_EXPIRATION_MAX"); v != "" && *expirationMaxFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*expirationMaxFlag = i
+		}
+	}
 	if v := os.Getenv("FILEBIN_TMPDIR"); v != "" && *tmpdirFlag == os.TempDir() {
 		*tmpdirFlag = v
 	}
@@ -170,11 +209,17 @@ func main() {
 	if v := os.Getenv("FILEBIN_REQUIRE_VERIFICATION_COOKIE"); v != "" {
 		*requireCookieFlag = v == "true" || v == "1" || v == "yes"
 	}
+	if v := os.Getenv("FILEBIN_REQUIRE_OWNER_TOKEN"); v != "" {
+		*requireOwnerTokenFlag = v == "true" || v == "1" || v == "yes"
+	}
 	if v := os.Getenv("FILEBIN_VERIFICATION_COOKIE_LIFETIME"); v != "" && *cookieLifetimeFlag == 365 {
 		if i, err := strconv.Atoi(v); err == nil {
 			*cookieLifetimeFlag = i
 		}
 	}
+	if *sessionSecretFlag == "" {
+		*sessionSecretFlag = os.Getenv("FILEBIN_SESSION_SECRET")
+	}
 	if v := os.Getenv("FILEBIN_EXPECTED_COOKIE_VALUE"); v != "" && *expectedCookieValueFlag == "2024-05-24" {
 		*expectedCookieValueFlag = v
 	}
@@ -195,6 +240,48 @@ func main() {
 			*postUploadHookTimeoutFlag = d
 		}
 	}
+	if *clamdAddressFlag == "" {
+		*clamdAddressFlag = os.Getenv("FILEBIN_CLAMD_ADDRESS")
+	}
+	if v := os.Getenv("FILEBIN_CLAMD_TIMEOUT"); v != "" && *clamdTimeoutFlag == 5*time.Minute {
+		if d, err := time.ParseDuration(v); err == nil {
+			*clamdTimeoutFlag = d
+		}
+	}
+	if v := os.Getenv("FILEBIN_BLOCK_SIMILAR_DISTANCE"); v != "" && *blockSimilarDistanceFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*blockSimilarDistanceFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_BLOCK_SIMILAR_ACTION"); v != "" && *blockSimilarActionFlag == "reject" {
+		*blockSimilarActionFlag = v
+	}
+	if *webhookURLsFlag == "" {
+		*webhookURLsFlag = os.Getenv("FILEBIN_WEBHOOK_URLS")
+	}
+	if *webhookSecretFlag == "" {
+		*webhookSecretFlag = os.Getenv("FILEBIN_WEBHOOK_SECRET")
+	}
+	if v := os.Getenv("FILEBIN_WEBHOOK_TIMEOUT"); v != "" && *webhookTimeoutFlag == 10*time.Second {
+		if d, err := time.ParseDuration(v); err == nil {
+			*webhookTimeoutFlag = d
+		}
+	}
+	if v := os.Getenv("FILEBIN_WEBHOOK_MAX_ATTEMPTS"); v != "" && *webhookMaxAttemptsFlag == 10 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*webhookMaxAttemptsFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_JOB_WORKERS"); v != "" && *jobWorkersFlag == 2 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*jobWorkersFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_RESUMABLE_UPLOAD_TTL"); v != "" && *resumableUploadTTLFlag == 24*time.Hour {
+		if d, err := time.ParseDuration(v); err == nil {
+			*resumableUploadTTLFlag = d
+		}
+	}
 
 	// Limits
 	if v := os.Getenv("FILEBIN_LIMIT_FILE_DOWNLOADS"); v != "" && *limitFileDownloadsFlag == 0 {
@@ -202,9 +289,59 @@ func main() {
 			*limitFileDownloadsFlag = i
 		}
 	}
+	if v := os.Getenv("FILEBIN_LIMIT_PASSWORD_ATTEMPTS"); v != "" && *limitPasswordAttemptsFlag == 10 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitPasswordAttemptsFlag = i
+		}
+	}
 	if v := os.Getenv("FILEBIN_LIMIT_STORAGE"); v != "" && *limitStorageFlag == "0" {
 		*limitStorageFlag = v
 	}
+	if v := os.Getenv("FILEBIN_LIMIT_UPLOAD_SIZE"); v != "" && *limitUploadSizeFlag == "0" {
+		*limitUploadSizeFlag = v
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_EXTRACT_FILES"); v != "" && *limitExtractFilesFlag == 1000 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitExtractFilesFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_EXTRACT_SIZE"); v != "" && *limitExtractSizeFlag == "1GB" {
+		*limitExtractSizeFlag = v
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_EXTRACT_RATIO"); v != "" && *limitExtractRatioFlag == 100 {
+		if i, err := strconv.ParseUint(v, 10, 64); err == nil {
+			*limitExtractRatioFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_SCOPE"); v != "" && *limitClientScopeFlag == "ip" {
+		*limitClientScopeFlag = v
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_UPLOAD_HOUR"); v != "" && *limitClientUploadHourFlag == "0" {
+		*limitClientUploadHourFlag = v
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_UPLOAD_DAY"); v != "" && *limitClientUploadDayFlag == "0" {
+		*limitClientUploadDayFlag = v
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_FILES_HOUR"); v != "" && *limitClientFilesHourFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitClientFilesHourFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_FILES_DAY"); v != "" && *limitClientFilesDayFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitClientFilesDayFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_BINS_DAY"); v != "" && *limitClientBinsDayFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitClientBinsDayFlag = i
+		}
+	}
+	if v := os.Getenv("FILEBIN_LIMIT_CLIENT_CONCURRENT_UPLOADS"); v != "" && *limitClientConcurrentFlag == 0 {
+		if i, err := strconv.Atoi(v); err == nil {
+			*limitClientConcurrentFlag = i
+		}
+	}
 	if *rejectFileExtensions == "" {
 		*rejectFileExtensions = os.Getenv("FILEBIN_REJECT_FILE_EXTENSIONS")
 	}
@@ -306,6 +443,9 @@ func main() {
 	if v := os.Getenv("FILEBIN_S3_URL_TTL"); v != "" && *s3UrlTtlFlag == "1m" {
 		*s3UrlTtlFlag = v
 	}
+	if v := os.Getenv("FILEBIN_DOWNLOAD_MODE"); v != "" && *downloadModeFlag == "redirect" {
+		*downloadModeFlag = v
+	}
 	if v := os.Getenv("FILEBIN_S3_TIMEOUT"); v != "" && *s3TimeoutFlag == "30s" {
 		*s3TimeoutFlag = v
 	}
@@ -429,6 +569,26 @@ func main() {
 	}
 	slog.Info("configured presigned S3 URL TTL", "ttl_seconds", s3UrlTtl.Seconds())
 
+	if *downloadModeFlag != "redirect" && *downloadModeFlag != "proxy" {
+		slog.Error("--download-mode must be either redirect or proxy", "value", *downloadModeFlag)
+		os.Exit(2)
+	}
+	slog.Info("configured download mode", "mode", *downloadModeFlag)
+
+	if *blockSimilarDistanceFlag < 0 || *blockSimilarDistanceFlag > 64 {
+		slog.Error("--block-similar-distance must be between 0 and 64", "value", *blockSimilarDistanceFlag)
+		os.Exit(2)
+	}
+	if *blockSimilarActionFlag != ds.SimilarReject && *blockSimilarActionFlag != ds.SimilarReview {
+		slog.Error("--block-similar-action must be either reject or review", "value", *blockSimilarActionFlag)
+		os.Exit(2)
+	}
+
+	if *limitClientScopeFlag != ds.ClientScopeIP && *limitClientScopeFlag != ds.ClientScopeNetwork && *limitClientScopeFlag != ds.ClientScopeASN {
+		slog.Error("--limit-client-scope must be either ip, network or asn", "value", *limitClientScopeFlag)
+		os.Exit(2)
+	}
+
 	s3Timeout, err := time.ParseDuration(*s3TimeoutFlag)
 	if err != nil {
 		slog.Error("unable to parse --s3-timeout", "error", err)
@@ -450,6 +610,27 @@ func main() {
 		slog.Info("rejecting file extension", "extension", v)
 	}
 
+	webhookURLs := strings.Fields(*webhookURLsFlag)
+	for _, v := range webhookURLs {
+		wu, err := url.Parse(v)
+		if err != nil || (wu.Scheme != "http" && wu.Scheme != "https") || wu.Host == "" {
+			slog.Error("url specified by --webhook-urls is not a valid http or https url", "url", v)
+			os.Exit(2)
+		}
+	}
+	if len(webhookURLs) > 0 && *webhookSecretFlag == "" {
+		slog.Error("--webhook-secret is required when --webhook-urls is set")
+		os.Exit(2)
+	}
+	if *webhookMaxAttemptsFlag < 1 {
+		slog.Error("--webhook-max-attempts must be at least 1")
+		os.Exit(2)
+	}
+	if *jobWorkersFlag < 0 {
+		slog.Error("--job-workers must not be negative")
+		os.Exit(2)
+	}
+
 	s3MultipartPartSize, err := humanize.ParseBytes(*s3MultipartPartSizeFlag)
 	if err != nil {
 		slog.Error("unable to parse --s3-multipart-part-size", "error", err)
@@ -486,8 +667,14 @@ func main() {
 	// Clean up stale temporary files from previous runs
 	wm.CleanStaleFiles(24 * time.Hour)
 
+	// Create and start the webhook dispatcher
+	webhooks := webhook.New(&daoconn, webhookURLs, *webhookSecretFlag)
+	webhooks.Init(*webhookTimeoutFlag, *webhookMaxAttemptsFlag)
+	webhooks.Run()
+
 	// Create and start the lurker process
 	l := lurker.New(&daoconn, &s3conn, wm)
+	l.SetWebhooks(webhooks)
 	l.Init(*lurkerIntervalFlag, *lurkerThrottleFlag, *logRetentionFlag)
 	l.Run()
 
@@ -510,12 +697,22 @@ func main() {
 		AllowRobots:              *allowRobotsFlag,
 		BaseUrl:                  *u,
 		Expiration:               *expirationFlag,
+		ExpirationMin:            *expirationMinFlag,
+		ExpirationMax:            *expirationMaxFlag,
 		HttpHost:                 *listenHostFlag,
 		HttpAccessLog:            *accessLogFlag,
 		HttpPort:                 *listenPortFlag,
 		HttpProxyHeaders:         *proxyHeadersFlag,
 		IdleTimeout:              *idleTimeoutFlag,
 		LimitFileDownloads:       *limitFileDownloadsFlag,
+		LimitPasswordAttempts:    *limitPasswordAttemptsFlag,
+		LimitExtractFiles:        *limitExtractFilesFlag,
+		LimitExtractRatio:        *limitExtractRatioFlag,
+		LimitClientScope:         *limitClientScopeFlag,
+		LimitClientHourFiles:     *limitClientFilesHourFlag,
+		LimitClientDayFiles:      *limitClientFilesDayFlag,
+		LimitClientDayBins:       *limitClientBinsDayFlag,
+		LimitClientConcurrent:    *limitClientConcurrentFlag,
 		ClientUploadFailuresCap:  *clientUploadFailuresCapFlag,
 		ClientUploadSuccessesCap: *clientUploadSuccessesCapFlag,
 		ReadHeaderTimeout:        *readHeaderTimeoutFlag,
@@ -523,10 +720,18 @@ func main() {
 		RequireApproval:          *requireApprovalFlag,
 		RequireCookie:            *requireCookieFlag,
 		CookieLifetime:           *cookieLifetimeFlag,
+		RequireOwnerToken:        *requireOwnerTokenFlag,
+		SessionSecret:            *sessionSecretFlag,
 		ExpectedCookieValue:      *expectedCookieValueFlag,
 		RejectFileExtensions:     strings.Fields(*rejectFileExtensions),
 		PostUploadHook:           *postUploadHookFlag,
 		PostUploadHookTimeout:    *postUploadHookTimeoutFlag,
+		ClamdAddress:             *clamdAddressFlag,
+		ClamdTimeout:             *clamdTimeoutFlag,
+		DownloadMode:             *downloadModeFlag,
+		SimilarDistance:          *blockSimilarDistanceFlag,
+		SimilarAction:            *blockSimilarActionFlag,
+		ResumableUploadTTL:       *resumableUploadTTLFlag,
 		SlackSecret:              *slackSecretFlag,
 		SlackDomain:              *slackDomainFlag,
 		SlackChannel:             *slackChannelFlag,
@@ -541,6 +746,34 @@ func main() {
 	}
 	config.LimitStorageReadable = humanize.Bytes(config.LimitStorageBytes)
 
+	config.LimitUploadBytes, err = humanize.ParseBytes(*limitUploadSizeFlag)
+	if err != nil {
+		slog.Error("unable to parse the --limit-upload-size parameter", "value", *limitUploadSizeFlag, "error", err)
+		os.Exit(2)
+	}
+	config.LimitUploadReadable = humanize.Bytes(config.LimitUploadBytes)
+
+	config.LimitExtractBytes, err = humanize.ParseBytes(*limitExtractSizeFlag)
+	if err != nil {
+		slog.Error("unable to parse the --limit-extract-size parameter", "value", *limitExtractSizeFlag, "error", err)
+		os.Exit(2)
+	}
+	config.LimitExtractReadable = humanize.Bytes(config.LimitExtractBytes)
+
+	config.LimitClientHourBytes, err = humanize.ParseBytes(*limitClientUploadHourFlag)
+	if err != nil {
+		slog.Error("unable to parse the --limit-client-upload-hour parameter", "value", *limitClientUploadHourFlag, "error", err)
+		os.Exit(2)
+	}
+	config.LimitClientHourReadable = humanize.Bytes(config.LimitClientHourBytes)
+
+	config.LimitClientDayBytes, err = humanize.ParseBytes(*limitClientUploadDayFlag)
+	if err != nil {
+		slog.Error("unable to parse the --limit-client-upload-day parameter", "value", *limitClientUploadDayFlag, "error", err)
+		os.Exit(2)
+	}
+	config.LimitClientDayReadable = humanize.Bytes(config.LimitClientDayBytes)
+
 	// Create Prometheus registry and metrics
 	metricsRegistry := prometheus.NewRegistry()
 	metricsRegistry.MustRegister(collectors.NewGoCollector())
@@ -554,14 +787,26 @@ func main() {
 	// Wire database metrics
 	daoconn.SetMetrics(metrics)
 
+	// Create and start the content processor
+	p := processor.New(&daoconn, &s3conn)
+	p.Init(*jobWorkersFlag)
+	p.SetMetrics(metrics)
+	p.SetPostUploadHook(config.PostUploadHook, config.PostUploadHookTimeout)
+	if config.PostUploadHook != "" && *jobWorkersFlag <= 0 {
+		slog.Warn("the post-upload hook is queued but not run since the job workers are disabled")
+	}
+	p.Run()
+
 	// Create and initialize HTTP server
 	h := web.New(&daoconn, &s3conn, &geodb, wm, config, metrics, metricsRegistry)
+	h.SetWebhooks(webhooks)
+	h.SetProcessor(p)
 
 	if err := h.Init(); err != nil {
 		slog.Error("unable to start the HTTP server", "error", err)
 		os.Exit(2)
 	}
-	slog.Info("uploaded files expiration configured", "expiration_seconds", config.ExpirationDuration.Seconds())
+	slog.Info("uploaded files expiration configured", "expiration_seconds", config.ExpirationDuration.Seconds(), "expiration_min_seconds", config.ExpirationMinDuration.Seconds(), "expiration_max_seconds", config.ExpirationMaxDuration.Seconds())
 
 	// Start the http server
 	h.Run()
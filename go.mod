@@ -14,6 +14,7 @@ require (
 	github.com/gabriel-vasile/mimetype v1.4.13
 	github.com/gorilla/handlers v1.5.2
 	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.18.0
 	github.com/lib/pq v1.12.3
 	github.com/oschwald/maxminddb-golang/v2 v2.3.0
 	github.com/prometheus/client_golang v1.23.2
@@ -4,10 +4,13 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"errors"
+	"fmt"
 	"math/big"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/dustin/go-humanize"
 	"github.com/espebra/filebin2/internal/ds"
@@ -15,6 +18,12 @@ import (
 
 var invalidBin = regexp.MustCompile("[^A-Za-z0-9-_.]")
 
+// Limits of the title and the description of bins
+const (
+	maxTitleLength          = 256
+	maxBinDescriptionLength = 16384
+)
+
 type BinDao struct {
 	db      *sql.DB
 	metrics DBMetricsObserver
@@ -36,9 +45,27 @@ func (d *BinDao) ValidateInput(bin *ds.Bin) error {
 	if strings.HasPrefix(bin.Id, ".") {
 		return errors.New("invalid bin specified")
 	}
-	if bin.UpdatedAt.After(bin.ExpiredAt) {
+	// Pinned bins do not expire, so the expiry time of a pinned bin may
+	// have passed
+	if bin.UpdatedAt.After(bin.ExpiredAt) && !bin.IsPinned() {
 		return errors.New("the bin cannot be updated when it has expired")
 	}
+	if bin.ExpirationSeconds < 0 {
+		return errors.New("the bin expiration cannot be negative")
+	}
+	title := strings.TrimSpace(bin.Title)
+	if !utf8.ValidString(title) || strings.IndexFunc(title, unicode.IsControl) >= 0 {
+		return errors.New("the title contains invalid characters")
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("the title is too long, the limit is %d characters", maxTitleLength)
+	}
+	bin.Title = title
+	description, err := normalizeDescription(bin.Description, maxBinDescriptionLength)
+	if err != nil {
+		return err
+	}
+	bin.Description = description
 	return nil
 }
 
@@ -95,9 +122,9 @@ func (d *BinDao) GenerateId() string {
 
 func (d *BinDao) GetByID(id string) (bin ds.Bin, found bool, err error) {
 	// Get bin info
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id = file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.id = $1 GROUP BY bin.id LIMIT 1"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, COALESCE(bin.owner_token_hash, ''), COALESCE(bin.password_hash, ''), bin.encrypted, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id = file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.id = $1 GROUP BY bin.id LIMIT 1"
 	t0 := time.Now()
-	err = d.db.QueryRow(sqlStatement, id).Scan(&bin.Id, &bin.Readonly, &bin.Downloads, &bin.FileDownloads, &bin.Bytes, &bin.Files, &bin.UpdatedAt, &bin.CreatedAt, &bin.ApprovedAt, &bin.ExpiredAt, &bin.DeletedAt)
+	err = d.db.QueryRow(sqlStatement, id).Scan(&bin.Id, &bin.Readonly, &bin.Downloads, &bin.FileDownloads, &bin.Bytes, &bin.Files, &bin.UpdatedAt, &bin.CreatedAt, &bin.ApprovedAt, &bin.ExpiredAt, &bin.DeletedAt, &bin.OwnerTokenHash, &bin.PasswordHash, &bin.Encrypted, &bin.ExpirationSeconds, &bin.PinnedAt, &bin.DownloadLimit, &bin.Title, &bin.Description)
 	observeQuery(d.metrics, "bin_get_by_id", t0, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -122,10 +149,18 @@ func (d *BinDao) Insert(bin *ds.Bin) (inserted bool, err error) {
 	if bin.IsApproved() {
 		bin.ApprovedAt.Time = bin.ApprovedAt.Time.UTC().Truncate(time.Microsecond)
 	}
-	sqlStatement := "INSERT INTO bin (id, readonly, downloads, updates, updated_at, created_at, approved_at, expired_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) DO NOTHING RETURNING id"
+	var ownerTokenHash sql.NullString
+	if bin.HasOwner() {
+		ownerTokenHash = sql.NullString{String: bin.OwnerTokenHash, Valid: true}
+	}
+	var passwordHash sql.NullString
+	if bin.HasPassword() {
+		passwordHash = sql.NullString{String: bin.PasswordHash, Valid: true}
+	}
+	sqlStatement := "INSERT INTO bin (id, readonly, downloads, updates, updated_at, created_at, approved_at, expired_at, owner_token_hash, password_hash, encrypted, expiration_seconds, download_limit, title, description) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) ON CONFLICT (id) DO NOTHING RETURNING id"
 	var id string
 	t0 := time.Now()
-	err = d.db.QueryRow(sqlStatement, bin.Id, readonly, downloads, updates, now, now, bin.ApprovedAt, bin.ExpiredAt).Scan(&id)
+	err = d.db.QueryRow(sqlStatement, bin.Id, readonly, downloads, updates, now, now, bin.ApprovedAt, bin.ExpiredAt, ownerTokenHash, passwordHash, bin.Encrypted, bin.ExpirationSeconds, bin.DownloadLimit, bin.Title, bin.Description).Scan(&id)
 	observeQuery(d.metrics, "bin_insert", t0, err)
 	if err == sql.ErrNoRows {
 		return false, nil
@@ -162,6 +197,73 @@ func (d *BinDao) Update(bin *ds.Bin) (err error) {
 	return nil
 }
 
+// UpdatePassword stores the password hash of the bin. An empty hash removes
+// the password protection.
+func (d *BinDao) UpdatePassword(bin *ds.Bin) (err error) {
+	var passwordHash sql.NullString
+	if bin.HasPassword() {
+		passwordHash = sql.NullString{String: bin.PasswordHash, Valid: true}
+	}
+	var id string
+	sqlStatement := "UPDATE bin SET password_hash = $1 WHERE id = $2 RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, passwordHash, bin.Id).Scan(&id)
+	observeQuery(d.metrics, "bin_update_password", t0, err)
+	return err
+}
+
+// UpdateExpiration stores the lifetime and the expiry time of the bin. A
+// lifetime of 0 means that the default expiration applies to the bin.
+func (d *BinDao) UpdateExpiration(bin *ds.Bin) (err error) {
+	if err := d.ValidateInput(bin); err != nil {
+		return err
+	}
+	bin.ExpiredAt = bin.ExpiredAt.UTC().Truncate(time.Microsecond)
+	var id string
+	sqlStatement := "UPDATE bin SET expiration_seconds = $1, expired_at = $2 WHERE id = $3 RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, bin.ExpirationSeconds, bin.ExpiredAt, bin.Id).Scan(&id)
+	observeQuery(d.metrics, "bin_update_expiration", t0, err)
+	if err != nil {
+		return err
+	}
+	hydrateBin(bin)
+	return nil
+}
+
+// UpdatePinned pins or unpins the bin. Pinned bins never expire, and the
+// expiry time is stored together with the pin so that unpinned bins get a
+// new expiry time.
+func (d *BinDao) UpdatePinned(bin *ds.Bin) (err error) {
+	bin.ExpiredAt = bin.ExpiredAt.UTC().Truncate(time.Microsecond)
+	if bin.IsPinned() {
+		bin.PinnedAt.Time = bin.PinnedAt.Time.UTC().Truncate(time.Microsecond)
+	}
+	var id string
+	sqlStatement := "UPDATE bin SET pinned_at = $1, expired_at = $2 WHERE id = $3 RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, bin.PinnedAt, bin.ExpiredAt, bin.Id).Scan(&id)
+	observeQuery(d.metrics, "bin_update_pinned", t0, err)
+	if err != nil {
+		return err
+	}
+	hydrateBin(bin)
+	return nil
+}
+
+// UpdateMetadata stores the title and the description of the bin
+func (d *BinDao) UpdateMetadata(bin *ds.Bin) (err error) {
+	if err := d.ValidateInput(bin); err != nil {
+		return err
+	}
+	var id string
+	sqlStatement := "UPDATE bin SET title = $1, description = $2 WHERE id = $3 RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, bin.Title, bin.Description, bin.Id).Scan(&id)
+	observeQuery(d.metrics, "bin_update_metadata", t0, err)
+	return err
+}
+
 func (d *BinDao) Delete(bin *ds.Bin) (err error) {
 	sqlStatement := "DELETE FROM bin WHERE id = $1"
 	t0 := time.Now()
@@ -207,49 +309,49 @@ func (d *BinDao) RegisterUpdate(bin *ds.Bin) (err error) {
 
 func (d *BinDao) GetAll() (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.updated_at DESC"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.updated_at DESC"
 	bins, err = d.binQuery(sqlStatement, now)
 	return bins, err
 }
 
 func (d *BinDao) GetPendingDelete() (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename) AS files, bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id = file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at < $1 AND bin.deleted_at IS NULL GROUP BY bin.id"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename) AS files, bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id = file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at < $1 AND bin.pinned_at IS NULL AND bin.deleted_at IS NULL GROUP BY bin.id"
 	bins, err = d.binQuery(sqlStatement, now)
 	return bins, err
 }
 
 func (d *BinDao) GetLastUpdated(limit int) (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.updated_at DESC LIMIT $2"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.updated_at DESC LIMIT $2"
 	bins, err = d.binQuery(sqlStatement, now, limit)
 	return bins, err
 }
 
 func (d *BinDao) GetByBytes(limit int) (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY COALESCE(SUM(file_content.bytes), 0) DESC LIMIT $2"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY COALESCE(SUM(file_content.bytes), 0) DESC LIMIT $2"
 	bins, err = d.binQuery(sqlStatement, now, limit)
 	return bins, err
 }
 
 func (d *BinDao) GetByDownloads(limit int) (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.downloads + COALESCE(SUM(file.downloads), 0) DESC LIMIT $2"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.downloads + COALESCE(SUM(file.downloads), 0) DESC LIMIT $2"
 	bins, err = d.binQuery(sqlStatement, now, limit)
 	return bins, err
 }
 
 func (d *BinDao) GetByFiles(limit int) (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY COUNT(file.filename) DESC LIMIT $2"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY COUNT(file.filename) DESC LIMIT $2"
 	bins, err = d.binQuery(sqlStatement, now, limit)
 	return bins, err
 }
 
 func (d *BinDao) GetByCreated(limit int) (bins []ds.Bin, err error) {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE bin.expired_at > $1 AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.created_at ASC LIMIT $2"
+	sqlStatement := "SELECT bin.id, bin.readonly, bin.downloads, COALESCE(SUM(file.downloads), 0), COALESCE(SUM(file_content.bytes), 0), COUNT(file.filename), bin.updates, bin.updated_at, bin.created_at, bin.approved_at, bin.expired_at, bin.deleted_at, bin.expiration_seconds, bin.pinned_at, bin.download_limit, bin.title, bin.description FROM bin LEFT JOIN file ON bin.id=file.bin_id AND file.deleted_at IS NULL LEFT JOIN file_content ON file.sha256 = file_content.sha256 AND file_content.in_storage = true WHERE (bin.expired_at > $1 OR bin.pinned_at IS NOT NULL) AND bin.deleted_at IS NULL GROUP BY bin.id ORDER BY bin.created_at ASC LIMIT $2"
 	bins, err = d.binQuery(sqlStatement, now, limit)
 	return bins, err
 }
@@ -264,7 +366,7 @@ func (d *BinDao) binQuery(sqlStatement string, params ...interface{}) (bins []ds
 	defer func() { _ = rows.Close() }()
 	for rows.Next() {
 		var bin ds.Bin
-		err = rows.Scan(&bin.Id, &bin.Readonly, &bin.Downloads, &bin.FileDownloads, &bin.Bytes, &bin.Files, &bin.Updates, &bin.UpdatedAt, &bin.CreatedAt, &bin.ApprovedAt, &bin.ExpiredAt, &bin.DeletedAt)
+		err = rows.Scan(&bin.Id, &bin.Readonly, &bin.Downloads, &bin.FileDownloads, &bin.Bytes, &bin.Files, &bin.Updates, &bin.UpdatedAt, &bin.CreatedAt, &bin.ApprovedAt, &bin.ExpiredAt, &bin.DeletedAt, &bin.ExpirationSeconds, &bin.PinnedAt, &bin.DownloadLimit, &bin.Title, &bin.Description)
 		if err != nil {
 			return bins, err
 		}
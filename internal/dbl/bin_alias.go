@@ -0,0 +1,139 @@
+package dbl
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+type BinAliasDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// GenerateId returns a random identifier for a bin alias. Aliases share the
+// URL namespace with bins and look the same, so the identifier is checked
+// against both.
+func (d *BinAliasDao) GenerateId() (string, error) {
+	characters := []rune("abcdefghijklmnopqrstuvwxyz1234567890")
+	length := 16
+	maxAttempts := 10
+	charLen := big.NewInt(int64(len(characters)))
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		id := make([]rune, length)
+		for i := range id {
+			n, err := rand.Int(rand.Reader, charLen)
+			if err != nil {
+				return "", err
+			}
+			id[i] = characters[n.Int64()]
+		}
+
+		var exists bool
+		sqlStatement := "SELECT EXISTS (SELECT 1 FROM bin WHERE id = $1) OR EXISTS (SELECT 1 FROM bin_alias WHERE id = $1)"
+		t0 := time.Now()
+		err := d.db.QueryRow(sqlStatement, string(id)).Scan(&exists)
+		observeQuery(d.metrics, "bin_alias_exists", t0, err)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return string(id), nil
+		}
+	}
+	return "", errors.New("unable to generate a unique alias id")
+}
+
+func (d *BinAliasDao) GetByID(id string) (alias ds.BinAlias, found bool, err error) {
+	sqlStatement := "SELECT id, bin_id, created_at, expired_at FROM bin_alias WHERE id = $1 LIMIT 1"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, id).Scan(&alias.Id, &alias.Bin, &alias.CreatedAt, &alias.ExpiredAt)
+	observeQuery(d.metrics, "bin_alias_get_by_id", t0, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return alias, false, nil
+		}
+		return alias, false, err
+	}
+	hydrateBinAlias(&alias)
+	return alias, true, nil
+}
+
+func (d *BinAliasDao) GetByBin(bin string) (aliases []ds.BinAlias, err error) {
+	sqlStatement := "SELECT id, bin_id, created_at, expired_at FROM bin_alias WHERE bin_id = $1 ORDER BY created_at ASC"
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, bin)
+	observeQuery(d.metrics, "bin_alias_get_by_bin", t0, err)
+	if err != nil {
+		return aliases, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var alias ds.BinAlias
+		if err := rows.Scan(&alias.Id, &alias.Bin, &alias.CreatedAt, &alias.ExpiredAt); err != nil {
+			return aliases, err
+		}
+		hydrateBinAlias(&alias)
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+func (d *BinAliasDao) Insert(alias *ds.BinAlias) (err error) {
+	if alias.Id == "" {
+		return errors.New("alias id not specified")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if alias.ExpiredAt != nil {
+		expiredAt := alias.ExpiredAt.UTC().Truncate(time.Microsecond)
+		alias.ExpiredAt = &expiredAt
+	}
+	sqlStatement := "INSERT INTO bin_alias (id, bin_id, created_at, expired_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, alias.Id, alias.Bin, now, alias.ExpiredAt).Scan(&alias.Id)
+	observeQuery(d.metrics, "bin_alias_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	alias.CreatedAt = now
+	hydrateBinAlias(alias)
+	return nil
+}
+
+func (d *BinAliasDao) Delete(alias *ds.BinAlias) (err error) {
+	sqlStatement := "DELETE FROM bin_alias WHERE id = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, alias.Id)
+	observeQuery(d.metrics, "bin_alias_delete", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("alias does not exist")
+	}
+	return nil
+}
+
+// DeleteExpired removes the aliases that have expired, and returns the
+// number of aliases removed. Aliases without expiry are removed by the
+// database when their bin is removed.
+func (d *BinAliasDao) DeleteExpired() (count int64, err error) {
+	sqlStatement := "DELETE FROM bin_alias WHERE expired_at < $1"
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, now)
+	observeQuery(d.metrics, "bin_alias_delete_expired", t0, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,146 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestBinAliasLifecycle(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "aliasedbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := dao.BinAlias().GenerateId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == bin.Id {
+		t.Fatal("Expected the alias id to differ from the bin id")
+	}
+
+	alias := &ds.BinAlias{Id: id, Bin: bin.Id}
+	if err := dao.BinAlias().Insert(alias); err != nil {
+		t.Fatal(err)
+	}
+
+	expiredAt := time.Now().UTC().Add(time.Hour * 2)
+	expiring := &ds.BinAlias{Bin: bin.Id, ExpiredAt: &expiredAt}
+	expiring.Id, err = dao.BinAlias().GenerateId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.BinAlias().Insert(expiring); err != nil {
+		t.Fatal(err)
+	}
+
+	dbAlias, found, err := dao.BinAlias().GetByID(alias.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the alias exists.")
+	}
+	if dbAlias.Bin != bin.Id {
+		t.Errorf("Was expecting alias for bin %s, got %s", bin.Id, dbAlias.Bin)
+	}
+	if dbAlias.ExpiredAt != nil {
+		t.Errorf("Was expecting alias without expiry, got %v", dbAlias.ExpiredAt)
+	}
+
+	dbAlias, _, err = dao.BinAlias().GetByID(expiring.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbAlias.ExpiredAt == nil || !dbAlias.ExpiredAt.Equal(expiredAt.Truncate(time.Microsecond)) {
+		t.Errorf("Was expecting alias expiry %v, got %v", expiredAt, dbAlias.ExpiredAt)
+	}
+
+	aliases, err := dao.BinAlias().GetByBin(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(aliases) != 2 {
+		t.Errorf("Was expecting 2 aliases, got %d", len(aliases))
+	}
+
+	if err := dao.BinAlias().Delete(alias); err != nil {
+		t.Error(err)
+	}
+	_, found, err = dao.BinAlias().GetByID(alias.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Error("Expected found to be false as the alias was revoked.")
+	}
+	if err := dao.BinAlias().Delete(alias); err == nil {
+		t.Error("Expected an error when deleting an alias that does not exist")
+	}
+
+	// The remaining alias is removed along with the bin
+	if err := dao.Bin().Delete(bin); err != nil {
+		t.Error(err)
+	}
+	_, found, err = dao.BinAlias().GetByID(expiring.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Error("Expected the alias to be removed along with the bin")
+	}
+}
+
+func TestDeleteExpiredBinAliases(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "aliasedbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().UTC().Add(-time.Hour)
+	future := time.Now().UTC().Add(time.Hour)
+	aliases := []*ds.BinAlias{
+		{Id: "expiredalias1", Bin: bin.Id, ExpiredAt: &past},
+		{Id: "activealias1", Bin: bin.Id, ExpiredAt: &future},
+		{Id: "permanentalias1", Bin: bin.Id},
+	}
+	for _, alias := range aliases {
+		if err := dao.BinAlias().Insert(alias); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := dao.BinAlias().DeleteExpired()
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 1 {
+		t.Errorf("Was expecting 1 expired alias to be removed, got %d", count)
+	}
+
+	remaining, err := dao.BinAlias().GetByBin(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(remaining) != 2 {
+		t.Errorf("Was expecting 2 remaining aliases, got %d", len(remaining))
+	}
+}
@@ -1,8 +1,10 @@
 package dbl
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/espebra/filebin2/internal/ds"
+	"strings"
 	"testing"
 	"time"
 )
@@ -79,6 +81,327 @@ func TestInsertDuplicatedBin(t *testing.T) {
 	}
 }
 
+func TestBinOwnerToken(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "ownedbin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if err := bin.GenerateOwnerToken(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the bin exists.")
+	}
+	if dbBin.OwnerToken != "" {
+		t.Error("Did not expect the owner token to be stored in the database")
+	}
+	if !dbBin.IsOwnerToken(bin.OwnerToken) {
+		t.Error("Expected the owner token to match the stored hash")
+	}
+
+	// Bins without owner token
+	legacy := &ds.Bin{}
+	legacy.Id = "legacybin1"
+	legacy.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(legacy); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(legacy.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.HasOwner() {
+		t.Error("Did not expect bin without owner token to have an owner")
+	}
+}
+
+func TestBinPassword(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "protectedbin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if err := bin.SetPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the bin exists.")
+	}
+	if !dbBin.IsPassword("secret") {
+		t.Error("Expected the password to match the stored hash")
+	}
+
+	// Change the password
+	if err := dbBin.SetPassword("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.Bin().UpdatePassword(&dbBin); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.IsPassword("secret") || !dbBin.IsPassword("other") {
+		t.Error("Expected the password to be changed")
+	}
+
+	// Remove the password
+	if err := dbBin.SetPassword(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.Bin().UpdatePassword(&dbBin); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.HasPassword() {
+		t.Error("Did not expect the bin to be password protected")
+	}
+
+	// Bins that do not exist
+	missing := &ds.Bin{Id: "missingbin1"}
+	if err := dao.Bin().UpdatePassword(missing); err == nil {
+		t.Error("Expected an error when updating the password of a missing bin")
+	}
+}
+
+func TestEncryptedBin(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "encryptedbin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	bin.Encrypted = true
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the bin exists.")
+	}
+	if !dbBin.Encrypted {
+		t.Error("Expected the bin to be encrypted")
+	}
+
+	plain := &ds.Bin{}
+	plain.Id = "plaintextbin1"
+	plain.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(plain); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(plain.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.Encrypted {
+		t.Error("Did not expect the bin to be encrypted")
+	}
+}
+
+func TestBinExpiration(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "expirationbin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	bin.ExpirationSeconds = 3600
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the bin exists.")
+	}
+	if dbBin.ExpirationSeconds != 3600 {
+		t.Errorf("Expected expiration of 3600 seconds, got %d", dbBin.ExpirationSeconds)
+	}
+	if dbBin.ExpirationPolicy != ds.ExpirationPolicyCustom {
+		t.Errorf("Expected expiration policy %q, got %q", ds.ExpirationPolicyCustom, dbBin.ExpirationPolicy)
+	}
+
+	// Extend the bin
+	dbBin.ExpirationSeconds = 86400
+	dbBin.ExpiredAt = time.Now().UTC().Add(time.Hour * 24)
+	if err := dao.Bin().UpdateExpiration(&dbBin); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.ExpirationSeconds != 86400 {
+		t.Errorf("Expected expiration of 86400 seconds, got %d", dbBin.ExpirationSeconds)
+	}
+	if dbBin.ExpiredAt.Before(time.Now().Add(time.Hour * 23)) {
+		t.Errorf("Expected the expiry time to be extended, got %s", dbBin.ExpiredAt)
+	}
+
+	dbBin.ExpirationSeconds = -1
+	if err := dao.Bin().UpdateExpiration(&dbBin); err == nil {
+		t.Error("Expected an error on negative expiration")
+	}
+}
+
+func TestBinMetadata(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "metadatabin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	bin.Title = "Holiday photos"
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the bin exists.")
+	}
+	if dbBin.Title != "Holiday photos" {
+		t.Errorf("Expected title %q, got %q", "Holiday photos", dbBin.Title)
+	}
+	if dbBin.Description != "" {
+		t.Errorf("Expected an empty description, got %q", dbBin.Description)
+	}
+
+	dbBin.Title = " Trip "
+	dbBin.Description = "Photos from the *trip*"
+	if err := dao.Bin().UpdateMetadata(&dbBin); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, _, err = dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbBin.Title != "Trip" {
+		t.Errorf("Expected title %q, got %q", "Trip", dbBin.Title)
+	}
+	if dbBin.Description != "Photos from the *trip*" {
+		t.Errorf("Expected description %q, got %q", "Photos from the *trip*", dbBin.Description)
+	}
+
+	dbBin.Title = strings.Repeat("a", maxTitleLength+1)
+	if err := dao.Bin().UpdateMetadata(&dbBin); err == nil {
+		t.Error("Expected an error on a too long title")
+	}
+}
+
+func TestPinnedBin(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "pinnedbin1"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pin the bin and let it pass its expiry time
+	_ = bin.PinnedAt.Scan(time.Now().UTC())
+	bin.ExpiredAt = time.Now().UTC().Add(-1 * time.Hour)
+	if err := dao.Bin().UpdatePinned(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBin, _, err := dao.Bin().GetByID(bin.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !dbBin.IsPinned() {
+		t.Fatal("Expected the bin to be pinned")
+	}
+	if dbBin.ExpirationPolicy != ds.ExpirationPolicyPinned {
+		t.Errorf("Expected expiration policy %q, got %q", ds.ExpirationPolicyPinned, dbBin.ExpirationPolicy)
+	}
+	if !dbBin.IsReadable() {
+		t.Error("Expected the pinned bin to be readable")
+	}
+
+	// Pinned bins are listed, and not deleted by the lurker
+	bins, err := dao.Bin().GetAll()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(bins) != 1 {
+		t.Errorf("Expected the pinned bin to be listed, got %d bins", len(bins))
+	}
+	pending, err := dao.Bin().GetPendingDelete()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no bins pending delete, got %d", len(pending))
+	}
+
+	// Unpinned bins expire again
+	dbBin.PinnedAt = sql.NullTime{}
+	if err := dao.Bin().UpdatePinned(&dbBin); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = dao.Bin().GetPendingDelete()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("Expected the unpinned bin to be pending delete, got %d bins", len(pending))
+	}
+}
+
 func TestBinTooLong(t *testing.T) {
 	dao, err := tearUp()
 	if err != nil {
@@ -82,6 +82,49 @@ func TestGenerateIdCharacterDistribution(t *testing.T) {
 	}
 }
 
+func TestBinValidateInputMetadata(t *testing.T) {
+	d := &BinDao{}
+
+	tests := []struct {
+		name        string
+		title       string
+		description string
+		expectError bool
+	}{
+		{name: "empty"},
+		{name: "title and description", title: "Holiday photos", description: "# Photos\n\nFrom the *trip*"},
+		{name: "title with newline", title: "two\nlines", expectError: true},
+		{name: "title too long", title: strings.Repeat("a", maxTitleLength+1), expectError: true},
+		{name: "title with multibyte characters", title: strings.Repeat("å", maxTitleLength)},
+		{name: "description too long", description: strings.Repeat("a", maxBinDescriptionLength+1), expectError: true},
+		{name: "description with control characters", description: "null\x00", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := &ds.Bin{
+				Id:          "abcdefgh",
+				ExpiredAt:   time.Now().Add(time.Hour),
+				Title:       "  " + tt.title + "  ",
+				Description: tt.description,
+			}
+			err := d.ValidateInput(bin)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ValidateInput(%q, %q): expected error, got nil", tt.title, tt.description)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateInput(%q, %q): unexpected error: %v", tt.title, tt.description, err)
+			}
+			if bin.Title != tt.title {
+				t.Errorf("ValidateInput: got title %q, want %q", bin.Title, tt.title)
+			}
+		})
+	}
+}
+
 func FuzzBinValidateInput(f *testing.F) {
 	// Seed corpus with interesting inputs
 	f.Add("abcdefgh")
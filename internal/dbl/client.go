@@ -2,24 +2,34 @@ package dbl
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/espebra/filebin2/internal/ds"
 
 	"github.com/dustin/go-humanize"
 )
 
+// Upper limits of the length of the reason and the note of a ban
+const (
+	maxBanReasonLength = 256
+	maxBanNoteLength   = 2000
+)
+
 type ClientDao struct {
 	db      *sql.DB
 	metrics DBMetricsObserver
 }
 
 func (c *ClientDao) GetByIP(ip net.IP) (client ds.Client, found bool, err error) {
-	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by FROM client WHERE ip = $1 LIMIT 1"
+	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by, ban_reason, ban_note, ban_expired_at FROM client WHERE ip = $1 LIMIT 1"
 	t0 := time.Now()
-	err = c.db.QueryRow(sqlStatement, ip.String()).Scan(&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country, &client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt, &client.LastActiveAt, &client.BannedAt, &client.BannedBy)
+	err = c.db.QueryRow(sqlStatement, ip.String()).Scan(&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country, &client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt, &client.LastActiveAt, &client.BannedAt, &client.BannedBy, &client.BanReason, &client.BanNote, &client.BanExpiredAt)
 	observeQuery(c.metrics, "client_get_by_ip", t0, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -33,10 +43,7 @@ func (c *ClientDao) GetByIP(ip net.IP) (client ds.Client, found bool, err error)
 	client.LastActiveAt = client.LastActiveAt.UTC()
 	client.FirstActiveAtRelative = humanize.Time(client.FirstActiveAt)
 	client.LastActiveAtRelative = humanize.Time(client.LastActiveAt)
-	if client.IsBanned() {
-		client.BannedAt.Time = client.BannedAt.Time.UTC()
-		client.BannedAtRelative = humanize.Time(client.BannedAt.Time)
-	}
+	hydrateClientBan(&client)
 	return client, true, nil
 }
 
@@ -71,26 +78,39 @@ func (c *ClientDao) Update(client *ds.Client) (err error) {
 }
 
 func (c *ClientDao) GetAll() (clients []ds.Client, err error) {
-	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by FROM client ORDER BY last_active_at DESC"
+	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by, ban_reason, ban_note, ban_expired_at FROM client ORDER BY last_active_at DESC"
 	clients, err = c.clientQuery(sqlStatement)
 	return clients, err
 }
 
 func (c *ClientDao) GetByLastActiveAt(limit int) (clients []ds.Client, err error) {
-	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by FROM client ORDER BY last_active_at DESC LIMIT $1"
+	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by, ban_reason, ban_note, ban_expired_at FROM client ORDER BY last_active_at DESC LIMIT $1"
 	clients, err = c.clientQuery(sqlStatement, limit)
 	return clients, err
 }
 
 func (c *ClientDao) GetByRequests(limit int) (clients []ds.Client, err error) {
-	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by FROM client ORDER BY requests DESC LIMIT $1"
+	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by, ban_reason, ban_note, ban_expired_at FROM client ORDER BY requests DESC LIMIT $1"
 	clients, err = c.clientQuery(sqlStatement, limit)
 	return clients, err
 }
 
-func (c *ClientDao) GetByBannedAt(limit int) (clients []ds.Client, err error) {
-	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by FROM client WHERE banned_at > $1 ORDER BY banned_at DESC LIMIT $2"
-	clients, err = c.clientQuery(sqlStatement, time.Unix(0, 0), limit)
+// GetByBannedAt returns the banned clients, latest first. The filter selects
+// the active bans, the expired bans or all bans.
+func (c *ClientDao) GetByBannedAt(limit int, filter string) (clients []ds.Client, err error) {
+	sqlStatement := "SELECT ip, asn, asn_organization, network, city, country, continent, proxy, requests, first_active_at, last_active_at, banned_at, banned_by, ban_reason, ban_note, ban_expired_at FROM client WHERE banned_at > $1"
+	params := []interface{}{time.Unix(0, 0)}
+	switch filter {
+	case ds.BanFilterActive:
+		sqlStatement += " AND (ban_expired_at IS NULL OR ban_expired_at > $2)"
+		params = append(params, time.Now().UTC())
+	case ds.BanFilterExpired:
+		sqlStatement += " AND ban_expired_at <= $2"
+		params = append(params, time.Now().UTC())
+	}
+	sqlStatement += fmt.Sprintf(" ORDER BY banned_at DESC LIMIT $%d", len(params)+1)
+	params = append(params, limit)
+	clients, err = c.clientQuery(sqlStatement, params...)
 	return clients, err
 }
 
@@ -104,7 +124,7 @@ func (c *ClientDao) clientQuery(sqlStatement string, params ...interface{}) (cli
 	defer func() { _ = rows.Close() }()
 	for rows.Next() {
 		var client ds.Client
-		err = rows.Scan(&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country, &client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt, &client.LastActiveAt, &client.BannedAt, &client.BannedBy)
+		err = rows.Scan(&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country, &client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt, &client.LastActiveAt, &client.BannedAt, &client.BannedBy, &client.BanReason, &client.BanNote, &client.BanExpiredAt)
 		if err != nil {
 			return clients, err
 		}
@@ -113,10 +133,7 @@ func (c *ClientDao) clientQuery(sqlStatement string, params ...interface{}) (cli
 		client.LastActiveAt = client.LastActiveAt.UTC()
 		client.FirstActiveAtRelative = humanize.Time(client.FirstActiveAt)
 		client.LastActiveAtRelative = humanize.Time(client.LastActiveAt)
-		if client.IsBanned() {
-			client.BannedAt.Time = client.BannedAt.Time.UTC()
-			client.BannedAtRelative = humanize.Time(client.BannedAt.Time)
-		}
+		hydrateClientBan(&client)
 		clients = append(clients, client)
 	}
 	if err = rows.Err(); err != nil {
@@ -125,14 +142,39 @@ func (c *ClientDao) clientQuery(sqlStatement string, params ...interface{}) (cli
 	return clients, nil
 }
 
-func (c *ClientDao) Ban(IPsToBan []string, banByRemoteAddr string) (err error) {
+// ValidateBan verifies the reason, the note and the expiry of a ban
+func (c *ClientDao) ValidateBan(ban *ds.ClientBan) error {
+	ban.Reason = strings.TrimSpace(ban.Reason)
+	if !utf8.ValidString(ban.Reason) || strings.IndexFunc(ban.Reason, unicode.IsControl) >= 0 {
+		return errors.New("the reason contains invalid characters")
+	}
+	if utf8.RuneCountInString(ban.Reason) > maxBanReasonLength {
+		return fmt.Errorf("the reason is too long, the limit is %d characters", maxBanReasonLength)
+	}
+	ban.Note = strings.TrimSpace(ban.Note)
+	if !utf8.ValidString(ban.Note) {
+		return errors.New("the note contains invalid characters")
+	}
+	if utf8.RuneCountInString(ban.Note) > maxBanNoteLength {
+		return fmt.Errorf("the note is too long, the limit is %d characters", maxBanNoteLength)
+	}
+	if ban.ExpiredAt.Valid && !ban.ExpiredAt.Time.After(time.Now()) {
+		return errors.New("the expiry has already passed")
+	}
+	return nil
+}
+
+func (c *ClientDao) Ban(IPsToBan []string, ban ds.ClientBan) (err error) {
 	// Loop over the IP addresses that will be banned
 	now := time.Now().UTC()
-	sqlStatement := "UPDATE client SET banned_at=$1, banned_by=$2 WHERE ip=$3 RETURNING ip"
+	if ban.ExpiredAt.Valid {
+		ban.ExpiredAt.Time = ban.ExpiredAt.Time.UTC()
+	}
+	sqlStatement := "UPDATE client SET banned_at=$1, banned_by=$2, ban_reason=$3, ban_note=$4, ban_expired_at=$5 WHERE ip=$6 RETURNING ip"
 	var ret string
 	for _, ipToBan := range IPsToBan {
 		t0 := time.Now()
-		err = c.db.QueryRow(sqlStatement, now, banByRemoteAddr, ipToBan).Scan(&ret)
+		err = c.db.QueryRow(sqlStatement, now, ban.BannedBy, ban.Reason, ban.Note, ban.ExpiredAt, ipToBan).Scan(&ret)
 		observeQuery(c.metrics, "client_ban", t0, err)
 		if err != nil {
 			return err
@@ -141,10 +183,30 @@ func (c *ClientDao) Ban(IPsToBan []string, banByRemoteAddr string) (err error) {
 	return err
 }
 
+// Unban lifts the ban of a client, and returns false if the client is not
+// banned
+func (c *ClientDao) Unban(ip string) (found bool, err error) {
+	sqlStatement := "UPDATE client SET banned_at=NULL, banned_by='', ban_reason='', ban_note='', ban_expired_at=NULL WHERE ip=$1 AND banned_at IS NOT NULL"
+	t0 := time.Now()
+	res, err := c.db.Exec(sqlStatement, ip)
+	observeQuery(c.metrics, "client_unban", t0, err)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Cleanup removes the clients that have not been active for the given
+// number of days. Clients that are still banned are kept, so that the ban
+// applies if they return.
 func (c *ClientDao) Cleanup(days uint64) (count int64, err error) {
-	sqlStatement := "DELETE FROM client WHERE last_active_at < CURRENT_DATE - ($1 || ' days')::interval AND banned_at IS NULL"
+	sqlStatement := "DELETE FROM client WHERE last_active_at < CURRENT_DATE - ($1 || ' days')::interval AND (banned_at IS NULL OR ban_expired_at < $2)"
 	t0 := time.Now()
-	res, err := c.db.Exec(sqlStatement, days)
+	res, err := c.db.Exec(sqlStatement, days, time.Now().UTC())
 	observeQuery(c.metrics, "client_cleanup", t0, err)
 	if err != nil {
 		return 0, err
@@ -160,7 +222,7 @@ func (c *ClientDao) GetByFilesUploaded(limit int) (clients []ds.Client, err erro
 	sqlStatement := `
 		SELECT
 			c.ip, c.asn, c.asn_organization, c.network, c.city, c.country, c.continent, c.proxy,
-			c.requests, c.first_active_at, c.last_active_at, c.banned_at, c.banned_by,
+			c.requests, c.first_active_at, c.last_active_at, c.banned_at, c.banned_by, c.ban_reason, c.ban_note, c.ban_expired_at,
 			COALESCE(COUNT(f.id), 0) as files_uploaded,
 			COALESCE(SUM(fc.bytes), 0) as bytes_uploaded
 		FROM client c
@@ -181,7 +243,7 @@ func (c *ClientDao) GetByFilesUploaded(limit int) (clients []ds.Client, err erro
 		err = rows.Scan(
 			&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country,
 			&client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt,
-			&client.LastActiveAt, &client.BannedAt, &client.BannedBy,
+			&client.LastActiveAt, &client.BannedAt, &client.BannedBy, &client.BanReason, &client.BanNote, &client.BanExpiredAt,
 			&client.FilesUploaded, &client.BytesUploaded,
 		)
 		if err != nil {
@@ -192,10 +254,7 @@ func (c *ClientDao) GetByFilesUploaded(limit int) (clients []ds.Client, err erro
 		client.FirstActiveAtRelative = humanize.Time(client.FirstActiveAt)
 		client.LastActiveAtRelative = humanize.Time(client.LastActiveAt)
 		client.BytesUploadedReadable = humanize.Bytes(client.BytesUploaded)
-		if client.IsBanned() {
-			client.BannedAt.Time = client.BannedAt.Time.UTC()
-			client.BannedAtRelative = humanize.Time(client.BannedAt.Time)
-		}
+		hydrateClientBan(&client)
 		clients = append(clients, client)
 	}
 	if err = rows.Err(); err != nil {
@@ -208,7 +267,7 @@ func (c *ClientDao) GetByBytesUploaded(limit int) (clients []ds.Client, err erro
 	sqlStatement := `
 		SELECT
 			c.ip, c.asn, c.asn_organization, c.network, c.city, c.country, c.continent, c.proxy,
-			c.requests, c.first_active_at, c.last_active_at, c.banned_at, c.banned_by,
+			c.requests, c.first_active_at, c.last_active_at, c.banned_at, c.banned_by, c.ban_reason, c.ban_note, c.ban_expired_at,
 			COALESCE(COUNT(f.id), 0) as files_uploaded,
 			COALESCE(SUM(fc.bytes), 0) as bytes_uploaded
 		FROM client c
@@ -229,7 +288,7 @@ func (c *ClientDao) GetByBytesUploaded(limit int) (clients []ds.Client, err erro
 		err = rows.Scan(
 			&client.IP, &client.ASN, &client.ASNOrganization, &client.Network, &client.City, &client.Country,
 			&client.Continent, &client.Proxy, &client.Requests, &client.FirstActiveAt,
-			&client.LastActiveAt, &client.BannedAt, &client.BannedBy,
+			&client.LastActiveAt, &client.BannedAt, &client.BannedBy, &client.BanReason, &client.BanNote, &client.BanExpiredAt,
 			&client.FilesUploaded, &client.BytesUploaded,
 		)
 		if err != nil {
@@ -240,10 +299,7 @@ func (c *ClientDao) GetByBytesUploaded(limit int) (clients []ds.Client, err erro
 		client.FirstActiveAtRelative = humanize.Time(client.FirstActiveAt)
 		client.LastActiveAtRelative = humanize.Time(client.LastActiveAt)
 		client.BytesUploadedReadable = humanize.Bytes(client.BytesUploaded)
-		if client.IsBanned() {
-			client.BannedAt.Time = client.BannedAt.Time.UTC()
-			client.BannedAtRelative = humanize.Time(client.BannedAt.Time)
-		}
+		hydrateClientBan(&client)
 		clients = append(clients, client)
 	}
 	if err = rows.Err(); err != nil {
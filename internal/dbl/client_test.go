@@ -3,9 +3,9 @@ package dbl
 import (
 	//"fmt"
 	"github.com/espebra/filebin2/internal/ds"
-	"testing"
-	//"time"
 	"net"
+	"testing"
+	"time"
 )
 
 func TestGetClientByIP(t *testing.T) {
@@ -86,3 +86,85 @@ func TestClientsGetAll(t *testing.T) {
 		t.Errorf("Was expecting %d clients, got %d\n", len(ips), len(clients))
 	}
 }
+
+func TestClientBan(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	for _, ip := range ips {
+		client := &ds.Client{IP: ip}
+		if err := dao.Client().Update(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A permanent ban, a temporary ban and a ban that has expired
+	if err := dao.Client().Ban([]string{ips[0]}, ds.ClientBan{Reason: "Spam", Note: "Reported by email", BannedBy: "198.51.100.1"}); err != nil {
+		t.Fatal(err)
+	}
+	temporary := ds.ClientBan{BannedBy: "198.51.100.1"}
+	_ = temporary.ExpiredAt.Scan(time.Now().Add(time.Hour))
+	if err := dao.Client().Ban([]string{ips[1]}, temporary); err != nil {
+		t.Fatal(err)
+	}
+	expired := ds.ClientBan{BannedBy: "198.51.100.1"}
+	_ = expired.ExpiredAt.Scan(time.Now().Add(-1 * time.Hour))
+	if err := dao.Client().Ban([]string{ips[2]}, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, err := dao.Client().GetByIP(net.ParseIP(ips[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.IsBanned() || client.BanReason != "Spam" || client.BanNote != "Reported by email" || client.BannedBy != "198.51.100.1" {
+		t.Errorf("Unexpected ban: %+v", client)
+	}
+	client, _, err = dao.Client().GetByIP(net.ParseIP(ips[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.IsBanned() || !client.IsBanExpired() {
+		t.Errorf("Expected the ban to have expired: %+v", client)
+	}
+
+	tests := []struct {
+		filter string
+		count  int
+	}{
+		{ds.BanFilterAll, 3},
+		{ds.BanFilterActive, 2},
+		{ds.BanFilterExpired, 1},
+	}
+	for _, test := range tests {
+		clients, err := dao.Client().GetByBannedAt(10, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clients) != test.count {
+			t.Errorf("Expected %d %s bans, got %d", test.count, test.filter, len(clients))
+		}
+	}
+
+	found, err := dao.Client().Unban(ips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Errorf("Expected the client to be unbanned")
+	}
+	client, _, err = dao.Client().GetByIP(net.ParseIP(ips[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.IsBanned() || client.BanReason != "" {
+		t.Errorf("Expected the ban to be lifted: %+v", client)
+	}
+	if found, _ := dao.Client().Unban(ips[0]); found {
+		t.Errorf("Expected the client to not be banned")
+	}
+}
@@ -0,0 +1,141 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// ClientUsageDao keeps the uploads, new bins and uploads in progress that
+// count towards the per-client limits. The usage is kept in the database,
+// so that the limits apply across all instances.
+type ClientUsageDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// scopeColumn returns the column and the value that the usage in the scope
+// is selected by
+func scopeColumn(scope string, ip string, asn int, network string) (string, interface{}) {
+	switch scope {
+	case ds.ClientScopeNetwork:
+		if network != "" {
+			return "network", network
+		}
+	case ds.ClientScopeASN:
+		if asn != 0 {
+			return "asn", asn
+		}
+	}
+	return "ip", ip
+}
+
+// Insert records usage of a client
+func (d *ClientUsageDao) Insert(usage *ds.ClientUsage) error {
+	if usage.IP == "" {
+		return errors.New("client ip not specified")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	expiredAt := usage.ExpiredAt.UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO client_usage (ip, asn, network, kind, bytes, created_at, expired_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	t0 := time.Now()
+	err := d.db.QueryRow(sqlStatement, usage.IP, usage.ASN, usage.Network, usage.Kind, usage.Bytes, now, expiredAt).Scan(&usage.Id)
+	observeQuery(d.metrics, "client_usage_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	usage.CreatedAt = now
+	usage.ExpiredAt = expiredAt
+	return nil
+}
+
+// Summarize returns the number of events of a kind, the bytes they sum up
+// to and the time of the oldest event, within the scope of the client since
+// the given point in time
+func (d *ClientUsageDao) Summarize(scope string, client ds.Client, kind string, since time.Time) (summary ds.ClientUsageSummary, err error) {
+	column, value := scopeColumn(scope, client.IP, client.ASN, client.Network)
+	sqlStatement := fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(bytes), 0), MIN(created_at) FROM client_usage WHERE %s = $1 AND kind = $2 AND created_at > $3", column)
+	var oldestAt sql.NullTime
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, value, kind, since.UTC()).Scan(&summary.Count, &summary.Bytes, &oldestAt)
+	observeQuery(d.metrics, "client_usage_summarize", t0, err)
+	if err != nil {
+		return summary, err
+	}
+	if oldestAt.Valid {
+		summary.OldestAt = oldestAt.Time.UTC()
+	}
+	return summary, nil
+}
+
+// Acquire records an upload in progress if there are less than limit
+// unexpired uploads in progress within the scope of the client. Concurrent
+// calls for the same scope are serialized with an advisory lock, so that
+// the limit holds across all instances.
+func (d *ClientUsageDao) Acquire(scope string, usage *ds.ClientUsage, limit int) (acquired bool, retErr error) {
+	t0 := time.Now()
+	defer func() { observeQuery(d.metrics, "client_usage_acquire", t0, retErr) }()
+
+	if usage.IP == "" {
+		return false, errors.New("client ip not specified")
+	}
+	column, value := scopeColumn(scope, usage.IP, usage.ASN, usage.Network)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", fmt.Sprintf("client_usage/%s/%v", column, value)); err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var count int
+	sqlCount := fmt.Sprintf("SELECT COUNT(*) FROM client_usage WHERE %s = $1 AND kind = $2 AND expired_at > $3", column)
+	if err := tx.QueryRow(sqlCount, value, ds.ClientUsageActive, now).Scan(&count); err != nil {
+		return false, err
+	}
+	if count >= limit {
+		return false, nil
+	}
+
+	expiredAt := usage.ExpiredAt.UTC().Truncate(time.Microsecond)
+	sqlInsert := "INSERT INTO client_usage (ip, asn, network, kind, bytes, created_at, expired_at) VALUES ($1, $2, $3, $4, 0, $5, $6) RETURNING id"
+	if err := tx.QueryRow(sqlInsert, usage.IP, usage.ASN, usage.Network, ds.ClientUsageActive, now, expiredAt).Scan(&usage.Id); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	usage.Kind = ds.ClientUsageActive
+	usage.CreatedAt = now
+	usage.ExpiredAt = expiredAt
+	return true, nil
+}
+
+// Release removes an upload in progress that was recorded by Acquire
+func (d *ClientUsageDao) Release(usage *ds.ClientUsage) error {
+	sqlStatement := "DELETE FROM client_usage WHERE id = $1"
+	t0 := time.Now()
+	_, err := d.db.Exec(sqlStatement, usage.Id)
+	observeQuery(d.metrics, "client_usage_release", t0, err)
+	return err
+}
+
+// Cleanup removes the usage that has expired, and returns the number of
+// events removed
+func (d *ClientUsageDao) Cleanup() (count int64, err error) {
+	sqlStatement := "DELETE FROM client_usage WHERE expired_at < $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, time.Now().UTC())
+	observeQuery(d.metrics, "client_usage_cleanup", t0, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,144 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestClientUsage(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	expiredAt := time.Now().UTC().Add(time.Hour * 24)
+	for _, usage := range []ds.ClientUsage{
+		{IP: "192.0.2.1", ASN: 64500, Network: "192.0.2.0/24", Kind: ds.ClientUsageUpload, Bytes: 100, ExpiredAt: expiredAt},
+		{IP: "192.0.2.1", ASN: 64500, Network: "192.0.2.0/24", Kind: ds.ClientUsageUpload, Bytes: 200, ExpiredAt: expiredAt},
+		{IP: "192.0.2.2", ASN: 64500, Network: "192.0.2.0/24", Kind: ds.ClientUsageUpload, Bytes: 400, ExpiredAt: expiredAt},
+		{IP: "192.0.2.1", ASN: 64500, Network: "192.0.2.0/24", Kind: ds.ClientUsageBin, ExpiredAt: expiredAt},
+	} {
+		if err := dao.ClientUsage().Insert(&usage); err != nil {
+			t.Fatal(err)
+		}
+		if usage.Id == 0 {
+			t.Errorf("Expected the usage to get an id")
+		}
+	}
+	if err := dao.ClientUsage().Insert(&ds.ClientUsage{Kind: ds.ClientUsageUpload}); err == nil {
+		t.Errorf("Expected an error for usage without an ip address")
+	}
+
+	client := ds.Client{IP: "192.0.2.1", ASN: 64500, Network: "192.0.2.0/24"}
+	since := time.Now().Add(-1 * time.Hour)
+	tests := []struct {
+		scope string
+		kind  string
+		count int
+		bytes uint64
+	}{
+		{ds.ClientScopeIP, ds.ClientUsageUpload, 2, 300},
+		{ds.ClientScopeNetwork, ds.ClientUsageUpload, 3, 700},
+		{ds.ClientScopeASN, ds.ClientUsageUpload, 3, 700},
+		{ds.ClientScopeIP, ds.ClientUsageBin, 1, 0},
+	}
+	for _, test := range tests {
+		summary, err := dao.ClientUsage().Summarize(test.scope, client, test.kind, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Count != test.count || summary.Bytes != test.bytes {
+			t.Errorf("Expected %d %s events of %d bytes in scope %s, got %d of %d bytes", test.count, test.kind, test.bytes, test.scope, summary.Count, summary.Bytes)
+		}
+		if summary.OldestAt.IsZero() {
+			t.Errorf("Expected the time of the oldest event in scope %s", test.scope)
+		}
+	}
+
+	// Clients without geoip details are counted by ip address
+	summary, err := dao.ClientUsage().Summarize(ds.ClientScopeNetwork, ds.Client{IP: "192.0.2.2"}, ds.ClientUsageUpload, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 1 || summary.Bytes != 400 {
+		t.Errorf("Expected 1 upload of 400 bytes, got %d of %d bytes", summary.Count, summary.Bytes)
+	}
+
+	// Nothing is counted after the window
+	summary, err = dao.ClientUsage().Summarize(ds.ClientScopeIP, client, ds.ClientUsageUpload, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count != 0 || summary.Bytes != 0 || !summary.OldestAt.IsZero() {
+		t.Errorf("Expected no usage, got %+v", summary)
+	}
+}
+
+func TestClientUsageAcquire(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	var active []*ds.ClientUsage
+	for i := 0; i < 3; i++ {
+		usage := &ds.ClientUsage{IP: "192.0.2.1", ExpiredAt: time.Now().Add(time.Hour)}
+		acquired, err := dao.ClientUsage().Acquire(ds.ClientScopeIP, usage, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acquired != (i < 2) {
+			t.Errorf("Attempt %d: expected acquired to be %t, got %t", i, i < 2, acquired)
+		}
+		if acquired {
+			active = append(active, usage)
+		}
+	}
+
+	// Other clients have their own uploads in progress
+	acquired, err := dao.ClientUsage().Acquire(ds.ClientScopeIP, &ds.ClientUsage{IP: "192.0.2.2", ExpiredAt: time.Now().Add(time.Hour)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Errorf("Expected another client to acquire an upload")
+	}
+
+	if err := dao.ClientUsage().Release(active[0]); err != nil {
+		t.Fatal(err)
+	}
+	acquired, err = dao.ClientUsage().Acquire(ds.ClientScopeIP, &ds.ClientUsage{IP: "192.0.2.1", ExpiredAt: time.Now().Add(time.Hour)}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Errorf("Expected to acquire an upload after one was released")
+	}
+
+	// Uploads in progress that were not released expire
+	acquired, err = dao.ClientUsage().Acquire(ds.ClientScopeIP, &ds.ClientUsage{IP: "192.0.2.3", ExpiredAt: time.Now().Add(-1 * time.Minute)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Fatalf("Expected to acquire an upload")
+	}
+	acquired, err = dao.ClientUsage().Acquire(ds.ClientScopeIP, &ds.ClientUsage{IP: "192.0.2.3", ExpiredAt: time.Now().Add(time.Hour)}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acquired {
+		t.Errorf("Expected the expired upload to not count")
+	}
+	count, err := dao.ClientUsage().Cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 expired event to be removed, got %d", count)
+	}
+}
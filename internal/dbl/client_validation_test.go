@@ -0,0 +1,43 @@
+package dbl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestClientValidateBan(t *testing.T) {
+	c := &ClientDao{} // No DB needed for validation
+
+	tests := []struct {
+		name    string
+		ban     ds.ClientBan
+		expiry  time.Duration
+		wantErr bool
+	}{
+		{"no reason", ds.ClientBan{}, 0, false},
+		{"reason and note", ds.ClientBan{Reason: " Spam ", Note: "Reported by email\nTwice"}, 0, false},
+		{"future expiry", ds.ClientBan{Reason: "Spam"}, time.Hour, false},
+		{"past expiry", ds.ClientBan{Reason: "Spam"}, -1 * time.Hour, true},
+		{"long reason", ds.ClientBan{Reason: strings.Repeat("a", 257)}, 0, true},
+		{"control characters in reason", ds.ClientBan{Reason: "Spam\nand more"}, 0, true},
+		{"long note", ds.ClientBan{Note: strings.Repeat("a", 2001)}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ban := tt.ban
+			if tt.expiry != 0 {
+				_ = ban.ExpiredAt.Scan(time.Now().Add(tt.expiry))
+			}
+			err := c.ValidateBan(&ban)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateBan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && ban.Reason != strings.TrimSpace(tt.ban.Reason) {
+				t.Errorf("Expected the reason to be trimmed, got %q", ban.Reason)
+			}
+		})
+	}
+}
@@ -31,14 +31,25 @@ func observeQuery(m DBMetricsObserver, operation string, t0 time.Time, err error
 var schemaSQL string
 
 type DAO struct {
-	db             *sql.DB
-	metrics        DBMetricsObserver
-	binDao         *BinDao
-	fileDao        *FileDao
-	fileContentDao *FileContentDao
-	metricsDao     *MetricsDao
-	transactionDao *TransactionDao
-	clientDao      *ClientDao
+	db              *sql.DB
+	metrics         DBMetricsObserver
+	binDao          *BinDao
+	fileDao         *FileDao
+	fileContentDao  *FileContentDao
+	metricsDao      *MetricsDao
+	transactionDao  *TransactionDao
+	clientDao       *ClientDao
+	uploadDao       *UploadDao
+	directUploadDao *DirectUploadDao
+	binAliasDao     *BinAliasDao
+	scanDao         *ScanDao
+	webhookDao      *WebhookDao
+	jobDao          *JobDao
+	thumbnailDao    *ThumbnailDao
+	fileVersionDao  *FileVersionDao
+	clientUsageDao  *ClientUsageDao
+	transferDao     *TransferDao
+	policyDao       *PolicyDao
 }
 
 type DBConfig struct {
@@ -110,6 +121,17 @@ func Init(cfg DBConfig) (DAO, error) {
 	dao.metricsDao = &MetricsDao{db: db}
 	dao.transactionDao = &TransactionDao{db: db}
 	dao.clientDao = &ClientDao{db: db}
+	dao.uploadDao = &UploadDao{db: db}
+	dao.directUploadDao = &DirectUploadDao{db: db}
+	dao.binAliasDao = &BinAliasDao{db: db}
+	dao.scanDao = &ScanDao{db: db}
+	dao.webhookDao = &WebhookDao{db: db}
+	dao.jobDao = &JobDao{db: db}
+	dao.thumbnailDao = &ThumbnailDao{db: db}
+	dao.fileVersionDao = &FileVersionDao{db: db}
+	dao.clientUsageDao = &ClientUsageDao{db: db}
+	dao.transferDao = &TransferDao{db: db}
+	dao.policyDao = &PolicyDao{db: db}
 
 	// Create schema if it doesn't exist
 	if err := dao.CreateSchema(); err != nil {
@@ -133,10 +155,21 @@ func (dao DAO) CreateSchema() error {
 
 func (dao DAO) ResetDB() error {
 	sqlStatements := []string{
+		"DELETE FROM upload",
+		"DELETE FROM direct_upload",
+		"DELETE FROM bin_alias",
+		"DELETE FROM file_version",
 		"DELETE FROM file",
+		"DELETE FROM scan",
+		"DELETE FROM job",
+		"DELETE FROM thumbnail",
+		"DELETE FROM webhook_delivery",
 		"DELETE FROM file_content",
 		"DELETE FROM bin",
 		"DELETE FROM client",
+		"DELETE FROM client_usage",
+		"DELETE FROM transfer",
+		"DELETE FROM access_policy",
 		"DELETE FROM transaction"}
 
 	for _, s := range sqlStatements {
@@ -172,6 +205,50 @@ func (dao DAO) Client() *ClientDao {
 	return dao.clientDao
 }
 
+func (dao DAO) Upload() *UploadDao {
+	return dao.uploadDao
+}
+
+func (dao DAO) DirectUpload() *DirectUploadDao {
+	return dao.directUploadDao
+}
+
+func (dao DAO) BinAlias() *BinAliasDao {
+	return dao.binAliasDao
+}
+
+func (dao DAO) Scan() *ScanDao {
+	return dao.scanDao
+}
+
+func (dao DAO) Webhook() *WebhookDao {
+	return dao.webhookDao
+}
+
+func (dao DAO) Job() *JobDao {
+	return dao.jobDao
+}
+
+func (dao DAO) Thumbnail() *ThumbnailDao {
+	return dao.thumbnailDao
+}
+
+func (dao DAO) FileVersion() *FileVersionDao {
+	return dao.fileVersionDao
+}
+
+func (dao DAO) ClientUsage() *ClientUsageDao {
+	return dao.clientUsageDao
+}
+
+func (dao DAO) Transfer() *TransferDao {
+	return dao.transferDao
+}
+
+func (dao DAO) Policy() *PolicyDao {
+	return dao.policyDao
+}
+
 func (dao DAO) Status() bool {
 	if err := dao.db.Ping(); err != nil {
 		slog.Warn("database status check failed", "error", err)
@@ -193,4 +270,15 @@ func (dao *DAO) SetMetrics(m DBMetricsObserver) {
 	dao.metricsDao.metrics = m
 	dao.transactionDao.metrics = m
 	dao.clientDao.metrics = m
+	dao.uploadDao.metrics = m
+	dao.directUploadDao.metrics = m
+	dao.binAliasDao.metrics = m
+	dao.scanDao.metrics = m
+	dao.webhookDao.metrics = m
+	dao.jobDao.metrics = m
+	dao.thumbnailDao.metrics = m
+	dao.fileVersionDao.metrics = m
+	dao.clientUsageDao.metrics = m
+	dao.transferDao.metrics = m
+	dao.policyDao.metrics = m
 }
@@ -0,0 +1,114 @@
+package dbl
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+type DirectUploadDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// GenerateId returns a random identifier for a direct upload. The
+// identifier is part of the upload URL and acts as the capability to
+// presign parts and finalize the upload, so it needs to be hard to guess.
+func (d *DirectUploadDao) GenerateId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (d *DirectUploadDao) GetByID(id string) (upload ds.DirectUpload, found bool, err error) {
+	sqlStatement := "SELECT id, bin_id, filename, bytes, part_size, parts, sha256, object_key, storage_upload_id, ip, created_at, expired_at FROM direct_upload WHERE id = $1 LIMIT 1"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, id).Scan(&upload.Id, &upload.Bin, &upload.Filename, &upload.Bytes, &upload.PartSize, &upload.Parts, &upload.SHA256, &upload.ObjectKey, &upload.StorageUploadId, &upload.IP, &upload.CreatedAt, &upload.ExpiredAt)
+	observeQuery(d.metrics, "direct_upload_get_by_id", t0, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return upload, false, nil
+		}
+		return upload, false, err
+	}
+	hydrateDirectUpload(&upload)
+	return upload, true, nil
+}
+
+func (d *DirectUploadDao) Insert(upload *ds.DirectUpload) (err error) {
+	if upload.Id == "" {
+		return errors.New("direct upload id not specified")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	upload.ExpiredAt = upload.ExpiredAt.UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO direct_upload (id, bin_id, filename, bytes, part_size, parts, sha256, object_key, storage_upload_id, ip, created_at, expired_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, upload.Id, upload.Bin, upload.Filename, upload.Bytes, upload.PartSize, upload.Parts, upload.SHA256, upload.ObjectKey, upload.StorageUploadId, upload.IP, now, upload.ExpiredAt).Scan(&upload.Id)
+	observeQuery(d.metrics, "direct_upload_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	upload.CreatedAt = now
+	hydrateDirectUpload(upload)
+	return nil
+}
+
+func (d *DirectUploadDao) Delete(upload *ds.DirectUpload) (err error) {
+	sqlStatement := "DELETE FROM direct_upload WHERE id = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, upload.Id)
+	observeQuery(d.metrics, "direct_upload_delete", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("direct upload does not exist")
+	}
+	return nil
+}
+
+// GetPendingDelete returns direct uploads that have expired before they
+// were finalized, or that belong to bins that are no longer writable.
+func (d *DirectUploadDao) GetPendingDelete() (uploads []ds.DirectUpload, err error) {
+	sqlStatement := "SELECT u.id, u.bin_id, u.filename, u.bytes, u.part_size, u.parts, u.sha256, u.object_key, u.storage_upload_id, u.ip, u.created_at, u.expired_at FROM direct_upload u JOIN bin b ON u.bin_id = b.id WHERE u.expired_at < NOW() OR (b.expired_at < NOW() AND b.pinned_at IS NULL) OR b.deleted_at IS NOT NULL OR b.readonly = true ORDER BY u.expired_at ASC"
+	t0 := time.Now()
+	uploads, err = d.directUploadQuery(sqlStatement)
+	observeQuery(d.metrics, "direct_upload_get_pending_delete", t0, err)
+	return uploads, err
+}
+
+func (d *DirectUploadDao) GetAll() (uploads []ds.DirectUpload, err error) {
+	sqlStatement := "SELECT id, bin_id, filename, bytes, part_size, parts, sha256, object_key, storage_upload_id, ip, created_at, expired_at FROM direct_upload ORDER BY created_at DESC"
+	t0 := time.Now()
+	uploads, err = d.directUploadQuery(sqlStatement)
+	observeQuery(d.metrics, "direct_upload_get_all", t0, err)
+	return uploads, err
+}
+
+func (d *DirectUploadDao) directUploadQuery(sqlStatement string, params ...interface{}) (uploads []ds.DirectUpload, err error) {
+	rows, err := d.db.Query(sqlStatement, params...)
+	if err != nil {
+		return uploads, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var upload ds.DirectUpload
+		err = rows.Scan(&upload.Id, &upload.Bin, &upload.Filename, &upload.Bytes, &upload.PartSize, &upload.Parts, &upload.SHA256, &upload.ObjectKey, &upload.StorageUploadId, &upload.IP, &upload.CreatedAt, &upload.ExpiredAt)
+		if err != nil {
+			return uploads, err
+		}
+		hydrateDirectUpload(&upload)
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
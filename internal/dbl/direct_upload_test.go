@@ -0,0 +1,113 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestDirectUploadLifecycle(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "directbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	id := dao.DirectUpload().GenerateId()
+	upload := &ds.DirectUpload{
+		Id:              id,
+		Bin:             bin.Id,
+		Filename:        "artifact.tar",
+		Bytes:           25,
+		PartSize:        10,
+		Parts:           3,
+		ObjectKey:       "staging/" + id,
+		StorageUploadId: "storage-upload-id",
+		IP:              "127.0.0.1",
+		ExpiredAt:       time.Now().UTC().Add(time.Hour * 1),
+	}
+	if err := dao.DirectUpload().Insert(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	dbUpload, found, err := dao.DirectUpload().GetByID(upload.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the direct upload exists.")
+	}
+	if dbUpload.Parts != 3 {
+		t.Errorf("Was expecting 3 parts, got %d", dbUpload.Parts)
+	}
+	if dbUpload.StorageUploadId != "storage-upload-id" {
+		t.Errorf("Was expecting storage upload id storage-upload-id, got %s", dbUpload.StorageUploadId)
+	}
+	if dbUpload.ObjectKey != upload.ObjectKey {
+		t.Errorf("Was expecting object key %s, got %s", upload.ObjectKey, dbUpload.ObjectKey)
+	}
+
+	uploads, err := dao.DirectUpload().GetAll()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(uploads) != 1 {
+		t.Errorf("Was expecting 1 direct upload, got %d", len(uploads))
+	}
+
+	if err := dao.DirectUpload().Delete(&dbUpload); err != nil {
+		t.Error(err)
+	}
+	_, found, err = dao.DirectUpload().GetByID(upload.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Error("Expected found to be false as the direct upload was deleted.")
+	}
+	if err := dao.DirectUpload().Delete(&dbUpload); err == nil {
+		t.Error("Expected an error when deleting a direct upload that does not exist")
+	}
+}
+
+func TestGetPendingDeleteDirectUploads(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "directbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	active := &ds.DirectUpload{Id: dao.DirectUpload().GenerateId(), Bin: bin.Id, Filename: "active", Bytes: 10, PartSize: 10, Parts: 1, ExpiredAt: time.Now().UTC().Add(time.Hour)}
+	expired := &ds.DirectUpload{Id: dao.DirectUpload().GenerateId(), Bin: bin.Id, Filename: "expired", Bytes: 10, PartSize: 10, Parts: 1, ExpiredAt: time.Now().UTC().Add(-time.Hour)}
+	for _, upload := range []*ds.DirectUpload{active, expired} {
+		if err := dao.DirectUpload().Insert(upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploads, err := dao.DirectUpload().GetPendingDelete()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("Was expecting 1 direct upload pending delete, got %d", len(uploads))
+	}
+	if uploads[0].Id != expired.Id {
+		t.Errorf("Was expecting direct upload %s to be pending delete, got %s", expired.Id, uploads[0].Id)
+	}
+}
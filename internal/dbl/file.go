@@ -3,14 +3,16 @@ package dbl
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
-	"path/filepath"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/dustin/go-humanize"
 	"github.com/espebra/filebin2/internal/ds"
+	"github.com/lib/pq"
 )
 
 type FileDao struct {
@@ -18,6 +20,19 @@ type FileDao struct {
 	metrics DBMetricsObserver
 }
 
+// Limits of the relative path in filenames
+const (
+	maxFolderDepth    = 16
+	maxFilenameLength = 1024
+)
+
+// Limits of the descriptions and tags of files
+const (
+	maxFileDescriptionLength = 4096
+	maxTags                  = 16
+	maxTagLength             = 64
+)
+
 func (d *FileDao) ValidateInput(file *ds.File) error {
 	// Trim whitespace before and after the filename.
 	file.Filename = strings.TrimSpace(file.Filename)
@@ -27,45 +42,29 @@ func (d *FileDao) ValidateInput(file *ds.File) error {
 		return errors.New("filename not specified")
 	}
 
-	// Create a new variable to use for filename modifications.
-	n := file.Filename
-
-	// Extract the basename from the filename, in case the filename
-	// is not clean and contains a folder structure.
-	n = filepath.Base(n)
-
-	// Replace all invalid UTF-8 characters in the filename with _
-	n = strings.ToValidUTF8(n, "_")
-
-	// Mapping function to replace non-safe characters with underscore.
-	// It is possible that this filter can be extended to allow more
-	// unicode categories.
-	safe := func(r rune) rune {
-		switch {
-		case unicode.IsNumber(r):
-			return r
-		case unicode.IsLetter(r):
-			return r
-		case strings.ContainsAny(string(r), "-_=+,.()[] "):
-			return r
+	// Filenames may include a relative path of folders, separated by /.
+	// Each folder and the name of the file itself is sanitized separately.
+	var segments []string
+	for _, segment := range strings.Split(strings.ToValidUTF8(file.Filename, "_"), "/") {
+		switch strings.TrimSpace(segment) {
+		case "..":
+			// Reject attempts to escape the bin
+			return errors.New("invalid path in filename")
+		case "", ".":
+			// Skip empty and redundant path segments, such as a leading /
+			continue
+		}
+		if segment = sanitizeFilename(segment); segment != "" {
+			segments = append(segments, segment)
 		}
-		return '_'
 	}
-	n = strings.Map(safe, n)
 
-	// Replace redundant spaces with single spaces
-	n = strings.Join(strings.Fields(n), " ")
-
-	// . is not allowed as the first character
-	if strings.HasPrefix(n, ".") {
-		n = strings.Replace(n, ".", "_", 1)
+	if len(segments) > maxFolderDepth+1 {
+		return errors.New("too many folders in filename")
 	}
-
-	// Truncate long filenames
-	// XXX: The maximum length could be made configurable
-	if len(n) > 120 {
-		slog.Debug("truncating filename to 120 characters", "original_length", len(n), "filename", n)
-		n = strings.ToValidUTF8(strings.TrimRight(n[:120], " "), "_")
+	n := strings.Join(segments, "/")
+	if len(n) > maxFilenameLength {
+		return errors.New("filename too long")
 	}
 
 	// Reject if the filename became empty after sanitization
@@ -80,13 +79,86 @@ func (d *FileDao) ValidateInput(file *ds.File) error {
 
 	file.Filename = n
 
+	// The encrypted filename of files in encrypted bins is opaque to the
+	// server, but is expected to be base64url encoded.
+	if len(file.EncryptedName) > 1024 {
+		return errors.New("encrypted filename too long")
+	}
+	for _, r := range file.EncryptedName {
+		if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_", r) {
+			return errors.New("invalid encrypted filename")
+		}
+	}
+
+	description, err := normalizeDescription(file.Description, maxFileDescriptionLength)
+	if err != nil {
+		return err
+	}
+	file.Description = description
+
+	tags, err := normalizeTags(file.Tags)
+	if err != nil {
+		return err
+	}
+	file.Tags = tags
+
 	return nil
 }
 
+// normalizeTags lowercases the tags of a file and removes duplicates. Tags
+// consist of letters, digits, -, _ and ., and a leading # is removed. The
+// result is never nil, as the tags column can not be NULL.
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("too many tags, the limit is %d", maxTags)
+	}
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" {
+			return nil, errors.New("tags can not be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags can be up to %d characters long", maxTagLength)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.", r) {
+				return nil, fmt.Errorf("invalid tag %q", tag)
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeDescription trims a description and normalizes its line
+// endings. Descriptions are Markdown, which is sanitized when it is
+// rendered.
+func normalizeDescription(description string, limit int) (string, error) {
+	if !utf8.ValidString(description) {
+		return "", errors.New("the description is not valid UTF-8")
+	}
+	description = strings.ReplaceAll(description, "\r\n", "\n")
+	description = strings.TrimSpace(description)
+	if len(description) > limit {
+		return "", fmt.Errorf("the description is too long, the limit is %d bytes", limit)
+	}
+	for _, r := range description {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return "", errors.New("the description contains invalid characters")
+		}
+	}
+	return description, nil
+}
+
 func (d *FileDao) GetByID(id int) (file ds.File, found bool, err error) {
-	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.id = $1 LIMIT 1"
+	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.id = $1 LIMIT 1"
 	t0 := time.Now()
-	err = d.db.QueryRow(sqlStatement, id).Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.UploadDurationMs)
+	err = d.db.QueryRow(sqlStatement, id).Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.DownloadLimit, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.BinPinned, &file.UploadDurationMs, &file.EncryptedName, &file.Description, pq.Array(&file.Tags))
 	observeQuery(d.metrics, "file_get_by_id", t0, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -99,9 +171,9 @@ func (d *FileDao) GetByID(id int) (file ds.File, found bool, err error) {
 }
 
 func (d *FileDao) GetByName(bin string, filename string) (file ds.File, found bool, err error) {
-	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.bin_id = $1 AND f.filename = $2 LIMIT 1"
+	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.bin_id = $1 AND f.filename = $2 LIMIT 1"
 	t0 := time.Now()
-	err = d.db.QueryRow(sqlStatement, bin, filename).Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.UploadDurationMs)
+	err = d.db.QueryRow(sqlStatement, bin, filename).Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.DownloadLimit, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.BinPinned, &file.UploadDurationMs, &file.EncryptedName, &file.Description, pq.Array(&file.Tags))
 	observeQuery(d.metrics, "file_get_by_name", t0, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -129,7 +201,7 @@ func (d *FileDao) IsAvailableForDownload(fileId int) (bool, error) {
 			WHERE f.id = $1
 				AND f.deleted_at IS NULL
 				AND b.deleted_at IS NULL
-				AND b.expired_at > NOW()
+				AND (b.expired_at > NOW() OR b.pinned_at IS NOT NULL)
 				AND fc.in_storage = true
 		)`
 
@@ -158,10 +230,14 @@ func (d *FileDao) Insert(file *ds.File) (bool, error) {
 	if file.Headers == "" {
 		file.Headers = "N/A"
 	}
+	var encryptedName sql.NullString
+	if file.EncryptedName != "" {
+		encryptedName = sql.NullString{String: file.EncryptedName, Valid: true}
+	}
 
-	sqlStatement := "INSERT INTO file (bin_id, filename, sha256, downloads, updates, ip, headers, updated_at, created_at, deleted_at, upload_duration_ms) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (bin_id, filename) DO NOTHING RETURNING id"
+	sqlStatement := "INSERT INTO file (bin_id, filename, sha256, downloads, updates, ip, headers, updated_at, created_at, deleted_at, upload_duration_ms, encrypted_name, download_limit, description, tags) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) ON CONFLICT (bin_id, filename) DO NOTHING RETURNING id"
 	t0 := time.Now()
-	err := d.db.QueryRow(sqlStatement, file.Bin, file.Filename, file.SHA256, downloads, updates, file.IP, file.Headers, now, now, file.DeletedAt, file.UploadDurationMs).Scan(&file.Id)
+	err := d.db.QueryRow(sqlStatement, file.Bin, file.Filename, file.SHA256, downloads, updates, file.IP, file.Headers, now, now, file.DeletedAt, file.UploadDurationMs, encryptedName, file.DownloadLimit, file.Description, pq.Array(file.Tags)).Scan(&file.Id)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -186,9 +262,15 @@ func (d *FileDao) Insert(file *ds.File) (bool, error) {
 func (d *FileDao) Update(file *ds.File) (err error) {
 	var id int
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := "UPDATE file SET filename = $1, sha256 = $2, updates = $3, updated_at = $4, deleted_at = $5, ip = $6, headers = $7, upload_duration_ms = $8 WHERE id = $9 RETURNING id"
+	var encryptedName sql.NullString
+	if file.EncryptedName != "" {
+		encryptedName = sql.NullString{String: file.EncryptedName, Valid: true}
+	}
+	// The downloads of a file with a download limit are counted from zero
+	// when the file is uploaded again, which increments the update counter
+	sqlStatement := "UPDATE file SET filename = $1, sha256 = $2, downloads = CASE WHEN $10 > 0 AND updates <> $3 THEN 0 ELSE downloads END, updates = $3, updated_at = $4, deleted_at = $5, ip = $6, headers = $7, upload_duration_ms = $8, encrypted_name = $9, download_limit = $10 WHERE id = $11 RETURNING id, downloads"
 	t0 := time.Now()
-	err = d.db.QueryRow(sqlStatement, file.Filename, file.SHA256, file.Updates, now, file.DeletedAt, file.IP, file.Headers, file.UploadDurationMs, file.Id).Scan(&id)
+	err = d.db.QueryRow(sqlStatement, file.Filename, file.SHA256, file.Updates, now, file.DeletedAt, file.IP, file.Headers, file.UploadDurationMs, encryptedName, file.DownloadLimit, file.Id).Scan(&id, &file.Downloads)
 	observeQuery(d.metrics, "file_update", t0, err)
 	if err != nil {
 		return err
@@ -203,6 +285,19 @@ func (d *FileDao) Update(file *ds.File) (err error) {
 	return nil
 }
 
+// UpdateMetadata stores the description and the tags of the file
+func (d *FileDao) UpdateMetadata(file *ds.File) (err error) {
+	if err := d.ValidateInput(file); err != nil {
+		return err
+	}
+	var id int
+	sqlStatement := "UPDATE file SET description = $1, tags = $2 WHERE id = $3 RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, file.Description, pq.Array(file.Tags), file.Id).Scan(&id)
+	observeQuery(d.metrics, "file_update_metadata", t0, err)
+	return err
+}
+
 func (d *FileDao) Delete(file *ds.File) (err error) {
 	sqlStatement := "DELETE FROM file WHERE id = $1"
 	t0 := time.Now()
@@ -232,9 +327,36 @@ func (d *FileDao) RegisterDownload(file *ds.File) (err error) {
 	return nil
 }
 
+// RegisterLimitedDownload counts a download of a file if the file has been
+// downloaded fewer times than the limit, where 0 means no limit. The file is
+// flagged as deleted with the last download that its own download limit
+// allows. The download is counted in a single statement, so concurrent
+// downloads can not exceed the limit. Returns false if the download is not
+// allowed.
+func (d *FileDao) RegisterLimitedDownload(file *ds.File, limit uint64) (bool, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `UPDATE file SET downloads = downloads + 1,
+			deleted_at = CASE WHEN download_limit > 0 AND downloads + 1 >= download_limit THEN $1 ELSE deleted_at END
+		WHERE id = $2 AND deleted_at IS NULL AND ($3 = 0 OR downloads < $3)
+		RETURNING downloads, deleted_at`
+	t0 := time.Now()
+	err := d.db.QueryRow(sqlStatement, now, file.Id, limit).Scan(&file.Downloads, &file.DeletedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	observeQuery(d.metrics, "file_register_limited_download", t0, err)
+	if err != nil {
+		return false, err
+	}
+	if file.IsDeleted() {
+		file.DeletedAtRelative = humanize.Time(file.DeletedAt.Time)
+	}
+	return true, nil
+}
+
 func (d *FileDao) GetByBin(id string, inStorage bool) (files []ds.File, err error) {
 	// Join with file_content to check if content is actually in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -245,7 +367,7 @@ func (d *FileDao) GetByBin(id string, inStorage bool) (files []ds.File, err erro
 }
 
 func (d *FileDao) GetByBinAll(id string) (files []ds.File, err error) {
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -276,7 +398,7 @@ func (d *FileDao) GetUploaderIPsByBin(id string) (ips []string, err error) {
 
 func (d *FileDao) GetAll(available bool) (files []ds.File, err error) {
 	// Join with file_content to check if content is actually in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -288,7 +410,7 @@ func (d *FileDao) GetAll(available bool) (files []ds.File, err error) {
 
 func (d *FileDao) GetTopDownloads(limit int) (files []ds.File, err error) {
 	// Join with file_content to only show files whose content is still in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -300,7 +422,7 @@ func (d *FileDao) GetTopDownloads(limit int) (files []ds.File, err error) {
 
 func (d *FileDao) GetByCreated(limit int) (files []ds.File, err error) {
 	// Join with file_content to only show files whose content is still in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -312,7 +434,7 @@ func (d *FileDao) GetByCreated(limit int) (files []ds.File, err error) {
 
 func (d *FileDao) GetByUpdated(limit int) (files []ds.File, err error) {
 	// Join with file_content to only show files whose content is still in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -324,7 +446,7 @@ func (d *FileDao) GetByUpdated(limit int) (files []ds.File, err error) {
 
 func (d *FileDao) GetByBytes(limit int) (files []ds.File, err error) {
 	// Join with file_content to only show files whose content is still in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -336,7 +458,7 @@ func (d *FileDao) GetByBytes(limit int) (files []ds.File, err error) {
 
 func (d *FileDao) GetByUpdates(limit int) (files []ds.File, err error) {
 	// Join with file_content to only show files whose content is still in storage
-	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+	sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 		FROM file f
 		JOIN file_content fc ON f.sha256 = fc.sha256
 		LEFT JOIN bin b ON f.bin_id = b.id
@@ -350,7 +472,7 @@ func (d *FileDao) GetByUpdates(limit int) (files []ds.File, err error) {
 // non-empty, only files matching that MIME type are returned.
 func (d *FileDao) GetRecentUploads(mime string, hours int) (files []ds.File, err error) {
 	if mime == "" {
-		sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+		sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 			FROM file f
 			JOIN file_content fc ON f.sha256 = fc.sha256
 			LEFT JOIN bin b ON f.bin_id = b.id
@@ -358,7 +480,7 @@ func (d *FileDao) GetRecentUploads(mime string, hours int) (files []ds.File, err
 			ORDER BY f.created_at DESC`
 		files, err = d.fileQuery(sqlStatement, hours)
 	} else {
-		sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms
+		sqlStatement := `SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags
 			FROM file f
 			JOIN file_content fc ON f.sha256 = fc.sha256
 			LEFT JOIN bin b ON f.bin_id = b.id
@@ -407,7 +529,7 @@ func (d *FileDao) fileQuery(sqlStatement string, params ...interface{}) (files [
 	defer func() { _ = rows.Close() }()
 	for rows.Next() {
 		var file ds.File
-		err = rows.Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.UploadDurationMs)
+		err = rows.Scan(&file.Id, &file.Bin, &file.Filename, &file.Mime, &file.Bytes, &file.MD5, &file.SHA256, &file.Downloads, &file.DownloadLimit, &file.Updates, &file.InStorage, &file.IP, &file.Headers, &file.UpdatedAt, &file.CreatedAt, &file.DeletedAt, &file.BinDeletedAt, &file.BinExpiredAt, &file.BinPinned, &file.UploadDurationMs, &file.EncryptedName, &file.Description, pq.Array(&file.Tags))
 		if err != nil {
 			return files, err
 		}
@@ -517,18 +639,24 @@ func (d *FileDao) FilesByBytesTotal(limit int) (files []ds.FileByChecksum, err e
 }
 
 func (d *FileDao) FileByChecksum(sha256 string) (files []ds.File, err error) {
-	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, f.upload_duration_ms FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.sha256 = $1 ORDER BY f.created_at DESC"
+	sqlStatement := "SELECT f.id, f.bin_id, f.filename, fc.mime, fc.bytes, fc.md5, f.sha256, f.downloads, f.download_limit, f.updates, fc.in_storage, f.ip, f.headers, f.updated_at, f.created_at, f.deleted_at, b.deleted_at, b.expired_at, b.pinned_at IS NOT NULL, f.upload_duration_ms, COALESCE(f.encrypted_name, ''), f.description, f.tags FROM file f JOIN file_content fc ON f.sha256 = fc.sha256 LEFT JOIN bin b ON f.bin_id = b.id WHERE f.sha256 = $1 ORDER BY f.created_at DESC"
 	files, err = d.fileQuery(sqlStatement, sha256)
 	return files, err
 }
 
 // CountBySHA256 returns the count of active file references with the given SHA256
-// (active = file not deleted AND bin not deleted AND bin not expired)
+// (active = file not deleted AND bin not deleted AND bin not expired), including
+// the earlier versions of files in active bins. Versions are references even if
+// their file is deleted, since they are kept with the file until it is uploaded
+// again.
 func (d *FileDao) CountBySHA256(sha256 string) (int, error) {
 	var count int
-	sqlStatement := `SELECT COUNT(*) FROM file f
+	sqlStatement := `SELECT (SELECT COUNT(*) FROM file f
 JOIN bin b ON f.bin_id = b.id
-WHERE f.sha256 = $1 AND f.deleted_at IS NULL AND b.deleted_at IS NULL AND b.expired_at > NOW()`
+WHERE f.sha256 = $1 AND f.deleted_at IS NULL AND b.deleted_at IS NULL AND (b.expired_at > NOW() OR b.pinned_at IS NOT NULL)) + (SELECT COUNT(*) FROM file_version v
+JOIN file f ON v.file_id = f.id
+JOIN bin b ON f.bin_id = b.id
+WHERE v.sha256 = $1 AND b.deleted_at IS NULL AND (b.expired_at > NOW() OR b.pinned_at IS NOT NULL))`
 	t0 := time.Now()
 	err := d.db.QueryRow(sqlStatement, sha256).Scan(&count)
 	observeQuery(d.metrics, "file_count_by_sha256", t0, err)
@@ -537,3 +665,39 @@ WHERE f.sha256 = $1 AND f.deleted_at IS NULL AND b.deleted_at IS NULL AND b.expi
 	}
 	return count, nil
 }
+
+// sanitizeFilename replaces unsafe characters in the name of a single file
+// or folder, and returns an empty string if nothing is left of it
+func sanitizeFilename(n string) string {
+	// Mapping function to replace non-safe characters with underscore.
+	// It is possible that this filter can be extended to allow more
+	// unicode categories.
+	safe := func(r rune) rune {
+		switch {
+		case unicode.IsNumber(r):
+			return r
+		case unicode.IsLetter(r):
+			return r
+		case strings.ContainsAny(string(r), "-_=+,.()[] "):
+			return r
+		}
+		return '_'
+	}
+	n = strings.Map(safe, n)
+
+	// Replace redundant spaces with single spaces
+	n = strings.Join(strings.Fields(n), " ")
+
+	// . is not allowed as the first character
+	if strings.HasPrefix(n, ".") {
+		n = strings.Replace(n, ".", "_", 1)
+	}
+
+	// Truncate long filenames
+	// XXX: The maximum length could be made configurable
+	if len(n) > 120 {
+		slog.Debug("truncating filename to 120 characters", "original_length", len(n), "filename", n)
+		n = strings.ToValidUTF8(strings.TrimRight(n[:120], " "), "_")
+	}
+	return n
+}
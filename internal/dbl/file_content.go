@@ -26,7 +26,7 @@ func nullString(s string) *string {
 func (d *FileContentDao) GetBySHA256(sha256 string) (*ds.FileContent, error) {
 	var content ds.FileContent
 	var phash sql.NullString
-	sqlStatement := "SELECT sha256, bytes, md5, mime, phash, in_storage, blocked, created_at, last_referenced_at FROM file_content WHERE sha256 = $1"
+	sqlStatement := "SELECT sha256, bytes, md5, mime, phash, in_storage, blocked, created_at, last_referenced_at, COALESCE(scan_status, ''), COALESCE(scan_signature, ''), scanned_at, mime_version, phash_version, thumbnail_version FROM file_content WHERE sha256 = $1"
 	t0 := time.Now()
 	err := d.db.QueryRow(sqlStatement, sha256).Scan(
 		&content.SHA256,
@@ -38,6 +38,12 @@ func (d *FileContentDao) GetBySHA256(sha256 string) (*ds.FileContent, error) {
 		&content.Blocked,
 		&content.CreatedAt,
 		&content.LastReferencedAt,
+		&content.ScanStatus,
+		&content.ScanSignature,
+		&content.ScannedAt,
+		&content.MimeVersion,
+		&content.PHashVersion,
+		&content.ThumbnailVersion,
 	)
 	observeQuery(d.metrics, "file_content_get_by_sha256", t0, err)
 	if err != nil {
@@ -48,18 +54,28 @@ func (d *FileContentDao) GetBySHA256(sha256 string) (*ds.FileContent, error) {
 	}
 	content.PHash = phash.String
 	content.BytesReadable = humanize.Bytes(content.Bytes)
+	if content.ScannedAt.Valid {
+		content.ScannedAt.Time = content.ScannedAt.Time.UTC()
+		content.ScannedAtRelative = humanize.Time(content.ScannedAt.Time)
+	}
 	return &content, nil
 }
 
 // InsertOrIncrement inserts a new file content record or updates last_referenced_at if it already exists
 func (d *FileContentDao) InsertOrIncrement(content *ds.FileContent) error {
 	now := time.Now().UTC().Truncate(time.Microsecond)
-	sqlStatement := `INSERT INTO file_content (sha256, bytes, md5, mime, phash, in_storage, created_at, last_referenced_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	if content.ScanStatus != "" && !content.ScannedAt.Valid {
+		content.ScannedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	sqlStatement := `INSERT INTO file_content (sha256, bytes, md5, mime, phash, in_storage, created_at, last_referenced_at, scan_status, scan_signature, scanned_at, mime_version, phash_version, thumbnail_version)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 ON CONFLICT (sha256) DO UPDATE SET
     in_storage = EXCLUDED.in_storage,
     phash = COALESCE(EXCLUDED.phash, file_content.phash),
-    last_referenced_at = EXCLUDED.last_referenced_at`
+    last_referenced_at = EXCLUDED.last_referenced_at,
+    scan_status = COALESCE(EXCLUDED.scan_status, file_content.scan_status),
+    scan_signature = CASE WHEN EXCLUDED.scan_status IS NULL THEN file_content.scan_signature ELSE EXCLUDED.scan_signature END,
+    scanned_at = COALESCE(EXCLUDED.scanned_at, file_content.scanned_at)`
 
 	t0 := time.Now()
 	_, err := d.db.Exec(sqlStatement,
@@ -71,6 +87,12 @@ ON CONFLICT (sha256) DO UPDATE SET
 		content.InStorage,
 		now,
 		now,
+		nullString(content.ScanStatus),
+		nullString(content.ScanSignature),
+		content.ScannedAt,
+		content.MimeVersion,
+		content.PHashVersion,
+		content.ThumbnailVersion,
 	)
 	observeQuery(d.metrics, "file_content_insert_or_increment", t0, err)
 
@@ -83,16 +105,41 @@ ON CONFLICT (sha256) DO UPDATE SET
 	return nil
 }
 
+// RegisterReference updates last_referenced_at of content that a new file
+// refers to without being uploaded, such as a copy of another file
+func (d *FileContentDao) RegisterReference(sha256 string) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := "UPDATE file_content SET last_referenced_at = $1 WHERE sha256 = $2"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, now, sha256)
+	observeQuery(d.metrics, "file_content_register_reference", t0, err)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("File content does not exist")
+	}
+	return nil
+}
+
 // GetPendingDelete returns file content records that have zero active references
-// (active = file exists AND file not deleted AND bin not deleted AND bin not expired) and still in storage
+// (active = file exists AND file not deleted AND bin not deleted AND bin not expired) and still in storage.
+// Earlier versions of files in active bins are active references as well, also
+// when the file is deleted.
 func (d *FileContentDao) GetPendingDelete() ([]ds.FileContent, error) {
 	sqlStatement := `SELECT fc.sha256, fc.bytes, fc.md5, fc.mime, fc.phash, fc.in_storage, fc.blocked, fc.created_at, fc.last_referenced_at
 FROM file_content fc
 LEFT JOIN file f ON fc.sha256 = f.sha256
 LEFT JOIN bin b ON f.bin_id = b.id
 WHERE fc.in_storage = true
+AND NOT EXISTS (SELECT 1 FROM file_version v JOIN file vf ON v.file_id = vf.id JOIN bin vb ON vf.bin_id = vb.id
+	WHERE v.sha256 = fc.sha256 AND vb.deleted_at IS NULL AND (vb.expired_at > NOW() OR vb.pinned_at IS NOT NULL))
 GROUP BY fc.sha256, fc.bytes, fc.md5, fc.mime, fc.phash, fc.in_storage, fc.blocked, fc.created_at, fc.last_referenced_at
-HAVING COUNT(CASE WHEN f.id IS NOT NULL AND f.deleted_at IS NULL AND b.deleted_at IS NULL AND b.expired_at > NOW() THEN 1 END) = 0
+HAVING COUNT(CASE WHEN f.id IS NOT NULL AND f.deleted_at IS NULL AND b.deleted_at IS NULL AND (b.expired_at > NOW() OR b.pinned_at IS NOT NULL) THEN 1 END) = 0
 ORDER BY fc.last_referenced_at ASC`
 
 	t0 := time.Now()
@@ -167,11 +214,26 @@ WHERE sha256 = $1`
 	return nil
 }
 
-// Delete removes a file content record from the database
+// Delete removes a file content record from the database, along with the
+// earlier versions of files that refer to it
 func (d *FileContentDao) Delete(sha256 string) error {
-	sqlStatement := "DELETE FROM file_content WHERE sha256 = $1"
 	t0 := time.Now()
-	res, err := d.db.Exec(sqlStatement, sha256)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sqlDeleteVersions := "DELETE FROM file_version WHERE sha256 = $1"
+	if _, err := tx.Exec(sqlDeleteVersions, sha256); err != nil {
+		observeQuery(d.metrics, "file_content_delete", t0, err)
+		return err
+	}
+	sqlStatement := "DELETE FROM file_content WHERE sha256 = $1"
+	res, err := tx.Exec(sqlStatement, sha256)
+	if err == nil {
+		err = tx.Commit()
+	}
 	observeQuery(d.metrics, "file_content_delete", t0, err)
 	if err != nil {
 		return err
@@ -270,6 +332,150 @@ func (d *FileContentDao) BlockContent(sha256 string) (retErr error) {
 	return tx.Commit()
 }
 
+// SetMime records the detected content type, and the version of the
+// detection that was used. The files that reference the content get their
+// content type from it.
+func (d *FileContentDao) SetMime(sha256 string, mime string, version int) error {
+	sqlStatement := "UPDATE file_content SET mime = $2, mime_version = $3 WHERE sha256 = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, sha256, mime, version)
+	observeQuery(d.metrics, "file_content_set_mime", t0, err)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("File content does not exist")
+	}
+	return nil
+}
+
+// SetPHash records the perceptual hash, and the version of the computation
+// that was used. An empty hash means that it could not be computed.
+func (d *FileContentDao) SetPHash(sha256 string, phash string, version int) error {
+	sqlStatement := "UPDATE file_content SET phash = $2, phash_version = $3 WHERE sha256 = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, sha256, nullString(phash), version)
+	observeQuery(d.metrics, "file_content_set_phash", t0, err)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("File content does not exist")
+	}
+	return nil
+}
+
+// GetPHashes returns the content that has a perceptual hash, which is the
+// content that can be compared by visual similarity
+func (d *FileContentDao) GetPHashes() ([]ds.FileContent, error) {
+	sqlStatement := `SELECT sha256, bytes, md5, mime, phash, in_storage, blocked, created_at, last_referenced_at
+FROM file_content
+WHERE phash IS NOT NULL`
+	return d.queryPHashes("file_content_get_phashes", sqlStatement)
+}
+
+// GetBlockedPHashes returns the blocked content that has a perceptual hash
+func (d *FileContentDao) GetBlockedPHashes() ([]ds.FileContent, error) {
+	sqlStatement := `SELECT sha256, bytes, md5, mime, phash, in_storage, blocked, created_at, last_referenced_at
+FROM file_content
+WHERE phash IS NOT NULL AND blocked = true`
+	return d.queryPHashes("file_content_get_blocked_phashes", sqlStatement)
+}
+
+func (d *FileContentDao) queryPHashes(name string, sqlStatement string) ([]ds.FileContent, error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var contents []ds.FileContent
+	for rows.Next() {
+		var content ds.FileContent
+		err := rows.Scan(
+			&content.SHA256,
+			&content.Bytes,
+			&content.MD5,
+			&content.Mime,
+			&content.PHash,
+			&content.InStorage,
+			&content.Blocked,
+			&content.CreatedAt,
+			&content.LastReferencedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		content.CreatedAt = content.CreatedAt.UTC()
+		content.LastReferencedAt = content.LastReferencedAt.UTC()
+		content.CreatedAtRelative = humanize.Time(content.CreatedAt)
+		content.LastReferencedAtRelative = humanize.Time(content.LastReferencedAt)
+		content.BytesReadable = humanize.Bytes(content.Bytes)
+		contents = append(contents, content)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// SetThumbnailVersion records the version of the thumbnail generation that
+// was used for the content
+func (d *FileContentDao) SetThumbnailVersion(sha256 string, version int) error {
+	sqlStatement := "UPDATE file_content SET thumbnail_version = $2 WHERE sha256 = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, sha256, version)
+	observeQuery(d.metrics, "file_content_set_thumbnail_version", t0, err)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("File content does not exist")
+	}
+	return nil
+}
+
+// SetScanStatus records the scan state of content, along with the name of
+// the malware that was found if it is infected
+func (d *FileContentDao) SetScanStatus(content *ds.FileContent, status string, signature string) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `UPDATE file_content SET scan_status = $2, scan_signature = $3, scanned_at = $4 WHERE sha256 = $1`
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, content.SHA256, status, nullString(signature), now)
+	observeQuery(d.metrics, "file_content_set_scan_status", t0, err)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("File content does not exist")
+	}
+
+	content.ScanStatus = status
+	content.ScanSignature = signature
+	content.ScannedAt = sql.NullTime{Time: now, Valid: true}
+	content.ScannedAtRelative = humanize.Time(now)
+	return nil
+}
+
 // UnblockContent unblocks content by setting blocked = false
 func (d *FileContentDao) UnblockContent(sha256 string) error {
 	sqlStatement := `UPDATE file_content SET blocked = false WHERE sha256 = $1`
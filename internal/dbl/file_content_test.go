@@ -270,6 +270,55 @@ func TestFileContentGetAll(t *testing.T) {
 	}
 }
 
+func TestFileContentGetPHashes(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	contents := []*ds.FileContent{
+		{SHA256: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Bytes: 100, Mime: "image/png", PHash: "8f3a000000000000", InStorage: true},
+		{SHA256: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Bytes: 200, Mime: "image/jpeg", PHash: "8f3a000000000001", InStorage: true},
+		{SHA256: "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc", Bytes: 300, Mime: "text/plain", InStorage: true},
+	}
+	for _, content := range contents {
+		if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+			t.Fatalf("Failed to insert file content: %s", err)
+		}
+	}
+
+	all, err := dao.FileContent().GetPHashes()
+	if err != nil {
+		t.Fatalf("Failed to get perceptual hashes: %s", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected 2 file contents with perceptual hashes, got %d", len(all))
+	}
+
+	blocked, err := dao.FileContent().GetBlockedPHashes()
+	if err != nil {
+		t.Fatalf("Failed to get blocked perceptual hashes: %s", err)
+	}
+	if len(blocked) != 0 {
+		t.Errorf("Expected no blocked file contents, got %d", len(blocked))
+	}
+
+	if err := dao.FileContent().BlockContent(contents[1].SHA256); err != nil {
+		t.Fatalf("Failed to block content: %s", err)
+	}
+	blocked, err = dao.FileContent().GetBlockedPHashes()
+	if err != nil {
+		t.Fatalf("Failed to get blocked perceptual hashes: %s", err)
+	}
+	if len(blocked) != 1 {
+		t.Fatalf("Expected 1 blocked file content, got %d", len(blocked))
+	}
+	if blocked[0].SHA256 != contents[1].SHA256 || blocked[0].PHash != contents[1].PHash || !blocked[0].Blocked {
+		t.Errorf("Unexpected blocked content: %+v", blocked[0])
+	}
+}
+
 func TestFileContentUpdate(t *testing.T) {
 	dao, err := tearUp()
 	if err != nil {
@@ -310,6 +359,41 @@ func TestFileContentUpdate(t *testing.T) {
 	}
 }
 
+func TestFileContentRegisterReference(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Bytes:     100,
+		MD5:       "d41d8cd98f00b204e9800998ecf8427e",
+		Mime:      "application/octet-stream",
+		InStorage: true,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := dao.FileContent().RegisterReference(content.SHA256); err != nil {
+		t.Fatalf("Failed to register reference: %s", err)
+	}
+	dbContent, err := dao.FileContent().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dbContent.LastReferencedAt.After(content.LastReferencedAt) {
+		t.Errorf("Expected last_referenced_at to be updated, got %s", dbContent.LastReferencedAt)
+	}
+
+	if err := dao.FileContent().RegisterReference("0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Error("Expected an error for content that does not exist")
+	}
+}
+
 func TestFileCountBySHA256(t *testing.T) {
 	dao, err := tearUp()
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/espebra/filebin2/internal/ds"
+	"sync"
 	"testing"
 	"time"
 )
@@ -446,6 +447,7 @@ func TestUpdateFile(t *testing.T) {
 	dbFile.SHA256 = "ff0350c8a7fea1087c5300e9ae922a7ab453648b1c156d5c58437d9f4565244b"
 	dbFile.IP = "127.0.0.2"
 	dbFile.Headers = "second headers"
+	dbFile.EncryptedName = "q83vASNFZ4mrze8B"
 
 	// Ensure new SHA256 exists in file_content before updating
 	err = ensureFileContent(dao, &dbFile)
@@ -474,6 +476,9 @@ func TestUpdateFile(t *testing.T) {
 	if updatedFile.Headers != "second headers" {
 		t.Errorf("Was expecting the updated headers 'second headers', got %s instead.", updatedFile.Headers)
 	}
+	if updatedFile.EncryptedName != "q83vASNFZ4mrze8B" {
+		t.Errorf("Was expecting the updated encrypted filename q83vASNFZ4mrze8B, got %s instead.", updatedFile.EncryptedName)
+	}
 }
 
 func TestUpdateNonExistingFile(t *testing.T) {
@@ -594,6 +599,178 @@ func TestGetFilesByBin(t *testing.T) {
 	}
 }
 
+func TestRegisterLimitedDownload(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	sha256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	bin := &ds.Bin{Id: "limitedbin", ExpiredAt: time.Now().UTC().Add(time.Hour), DownloadLimit: 3}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+	dbBin, found, err := dao.Bin().GetByID(bin.Id)
+	if err != nil || !found {
+		t.Fatalf("Unable to get bin: %v", err)
+	}
+	if dbBin.DownloadLimit != 3 {
+		t.Errorf("Expected the download limit of the bin to be 3, got %d", dbBin.DownloadLimit)
+	}
+
+	// The file is deleted with its last download
+	file := &ds.File{Filename: "limited.txt", Bin: bin.Id, SHA256: sha256, DownloadLimit: 2}
+	if err := ensureFileContent(dao, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		registered, err := dao.File().RegisterLimitedDownload(file, file.DownloadLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if registered != (i <= 2) {
+			t.Errorf("Download %d: expected registered to be %t, got %t", i, i <= 2, registered)
+		}
+		if i == 1 && file.IsDeleted() {
+			t.Error("Expected the file to remain after the first download")
+		}
+	}
+	dbFile, found, err := dao.File().GetByID(file.Id)
+	if err != nil || !found {
+		t.Fatalf("Unable to get file: %v", err)
+	}
+	if !dbFile.IsDeleted() {
+		t.Error("Expected the file to be deleted after the last download")
+	}
+	if dbFile.Downloads != 2 {
+		t.Errorf("Expected 2 downloads, got %d", dbFile.Downloads)
+	}
+
+	// The download limit starts over when the file is uploaded again
+	dbFile.Updates = dbFile.Updates + 1
+	_ = dbFile.DeletedAt.Scan(nil)
+	if err := dao.File().Update(&dbFile); err != nil {
+		t.Fatal(err)
+	}
+	if dbFile.Downloads != 0 {
+		t.Errorf("Expected the downloads to be reset, got %d", dbFile.Downloads)
+	}
+
+	// A lower limit refuses downloads without deleting the file
+	file = &ds.File{Filename: "global.txt", Bin: bin.Id, SHA256: sha256}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		registered, err := dao.File().RegisterLimitedDownload(file, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if registered != (i == 1) {
+			t.Errorf("Download %d: expected registered to be %t, got %t", i, i == 1, registered)
+		}
+	}
+	if file.IsDeleted() {
+		t.Error("Expected the file without a download limit to remain")
+	}
+
+	// Only one of several concurrent downloads of a one-time file is allowed
+	file = &ds.File{Filename: "once.txt", Bin: bin.Id, SHA256: sha256, DownloadLimit: 1}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(f ds.File) {
+			defer wg.Done()
+			registered, err := dao.File().RegisterLimitedDownload(&f, f.DownloadLimit)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if registered {
+				mu.Lock()
+				count++
+				mu.Unlock()
+			}
+		}(*file)
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Errorf("Expected exactly one registered download, got %d", count)
+	}
+}
+
+func TestFileMetadata(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{Id: "metadatafilebin", ExpiredAt: time.Now().UTC().Add(time.Hour)}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files without tags are stored with an empty list of tags
+	file := &ds.File{Filename: "photo.jpg", Bin: bin.Id, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+	if err := ensureFileContent(dao, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+	dbFile, found, err := dao.File().GetByName(bin.Id, file.Filename)
+	if err != nil || !found {
+		t.Fatalf("Unable to get file: %v", err)
+	}
+	if len(dbFile.Tags) != 0 {
+		t.Errorf("Expected no tags, got %q", dbFile.Tags)
+	}
+
+	dbFile.Description = "The *view* from the top"
+	dbFile.Tags = []string{"Holiday", "#mountains", "holiday"}
+	if err := dao.File().UpdateMetadata(&dbFile); err != nil {
+		t.Fatal(err)
+	}
+	dbFile, _, err = dao.File().GetByName(bin.Id, file.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbFile.Description != "The *view* from the top" {
+		t.Errorf("Expected description %q, got %q", "The *view* from the top", dbFile.Description)
+	}
+	if fmt.Sprint(dbFile.Tags) != "[holiday mountains]" {
+		t.Errorf("Expected tags [holiday mountains], got %q", dbFile.Tags)
+	}
+
+	// The metadata is kept when the file is uploaded again
+	dbFile.Updates++
+	if err := dao.File().Update(&dbFile); err != nil {
+		t.Fatal(err)
+	}
+	dbFile, _, err = dao.File().GetByName(bin.Id, file.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbFile.Description == "" || len(dbFile.Tags) != 2 {
+		t.Errorf("Expected the metadata to be kept, got %q and %q", dbFile.Description, dbFile.Tags)
+	}
+
+	dbFile.Tags = []string{"not valid"}
+	if err := dao.File().UpdateMetadata(&dbFile); err == nil {
+		t.Error("Expected an error on an invalid tag")
+	}
+}
+
 func TestIsAvailableForDownload(t *testing.T) {
 	dao, err := tearUp()
 	if err != nil {
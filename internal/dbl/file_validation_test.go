@@ -127,16 +127,59 @@ func TestValidateInput(t *testing.T) {
 			expectError:   true,
 		},
 		{
-			name:           "filename with path (should extract basename)",
+			name:           "filename with path (should keep folders)",
 			inputFilename:  "folder/subfolder/file.txt",
 			expectError:    false,
-			expectedOutput: "file.txt",
+			expectedOutput: "folder/subfolder/file.txt",
 		},
 		{
-			name:           "path traversal attempt (should extract basename)",
-			inputFilename:  "../../etc/passwd",
+			name:          "path traversal attempt (should reject)",
+			inputFilename: "../../etc/passwd",
+			expectError:   true,
+		},
+		{
+			name:          "path traversal within path (should reject)",
+			inputFilename: "folder/ .. /file.txt",
+			expectError:   true,
+		},
+		{
+			name:           "absolute path (should become relative)",
+			inputFilename:  "/etc/passwd",
+			expectError:    false,
+			expectedOutput: "etc/passwd",
+		},
+		{
+			name:           "redundant path segments (should normalize)",
+			inputFilename:  "./folder//./sub/file.txt/",
+			expectError:    false,
+			expectedOutput: "folder/sub/file.txt",
+		},
+		{
+			name:           "folder names are sanitized (should replace)",
+			inputFilename:  ".git/my  folder@home/file.txt",
 			expectError:    false,
-			expectedOutput: "passwd",
+			expectedOutput: "_git/my folder_home/file.txt",
+		},
+		{
+			name:           "long folder name (should truncate)",
+			inputFilename:  strings.Repeat("a", 150) + "/file.txt",
+			expectError:    false,
+			expectedOutput: strings.Repeat("a", 120) + "/file.txt",
+		},
+		{
+			name:          "too many folders (should reject)",
+			inputFilename: strings.Repeat("a/", 17) + "file.txt",
+			expectError:   true,
+		},
+		{
+			name:          "path too long (should reject)",
+			inputFilename: strings.Repeat(strings.Repeat("a", 100)+"/", 11) + "file.txt",
+			expectError:   true,
+		},
+		{
+			name:          "only slashes (should reject)",
+			inputFilename: "/./",
+			expectError:   true,
 		},
 		{
 			name:           "windows path (backslashes become underscores on unix)",
@@ -205,10 +248,10 @@ func TestValidateInput(t *testing.T) {
 			expectedOutput: strings.Repeat("a", 116) + ".txt",
 		},
 		{
-			name:           "filename with slash (should replace)",
+			name:           "filename with slash (should keep folder)",
 			inputFilename:  "test/file.txt",
 			expectError:    false,
-			expectedOutput: "file.txt", // filepath.Base extracts basename
+			expectedOutput: "test/file.txt",
 		},
 		{
 			name:           "filename with backslash (backslash becomes underscore on unix)",
@@ -323,6 +366,185 @@ func TestValidateInput(t *testing.T) {
 	}
 }
 
+func TestValidateInputEncryptedName(t *testing.T) {
+	d := &FileDao{}
+
+	tests := []struct {
+		name          string
+		encryptedName string
+		expectError   bool
+	}{
+		{
+			name:          "no encrypted filename",
+			encryptedName: "",
+			expectError:   false,
+		},
+		{
+			name:          "base64url encoded",
+			encryptedName: "q83vASNFZ4mrze8BI0VniavN7wEjRWeJ-_",
+			expectError:   false,
+		},
+		{
+			name:          "standard base64 padding",
+			encryptedName: "q83vASNFZ4k=",
+			expectError:   true,
+		},
+		{
+			name:          "path separator",
+			encryptedName: "q83v/ASNFZ4k",
+			expectError:   true,
+		},
+		{
+			name:          "too long",
+			encryptedName: strings.Repeat("a", 1025),
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ds.File{Filename: "0123456789abcdef", EncryptedName: tt.encryptedName}
+			err := d.ValidateInput(file)
+			if tt.expectError && err == nil {
+				t.Errorf("ValidateInput(%q): expected error, got nil", tt.encryptedName)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("ValidateInput(%q): unexpected error: %v", tt.encryptedName, err)
+			}
+		})
+	}
+}
+
+func TestValidateInputTags(t *testing.T) {
+	d := &FileDao{}
+
+	tests := []struct {
+		name        string
+		tags        []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "no tags",
+			tags:     nil,
+			expected: []string{},
+		},
+		{
+			name:     "normalized",
+			tags:     []string{" Photos ", "#holiday", "2024", "photos"},
+			expected: []string{"photos", "holiday", "2024"},
+		},
+		{
+			name:     "punctuation",
+			tags:     []string{"v1.2", "snake_case", "kebab-case", "blåbær"},
+			expected: []string{"v1.2", "snake_case", "kebab-case", "blåbær"},
+		},
+		{
+			name:        "empty tag",
+			tags:        []string{"one", " "},
+			expectError: true,
+		},
+		{
+			name:        "whitespace within tag",
+			tags:        []string{"two words"},
+			expectError: true,
+		},
+		{
+			name:        "markup",
+			tags:        []string{"<script>"},
+			expectError: true,
+		},
+		{
+			name:        "too long",
+			tags:        []string{strings.Repeat("a", maxTagLength+1)},
+			expectError: true,
+		},
+		{
+			name:        "too many",
+			tags:        strings.Split(strings.Repeat("a,", maxTags)+"b", ","),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ds.File{Filename: "file.txt", Tags: tt.tags}
+			err := d.ValidateInput(file)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ValidateInput(%q): expected error, got tags %q", tt.tags, file.Tags)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateInput(%q): unexpected error: %v", tt.tags, err)
+			}
+			if file.Tags == nil {
+				t.Fatalf("ValidateInput(%q): expected tags to be non-nil", tt.tags)
+			}
+			if strings.Join(file.Tags, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("ValidateInput(%q): got tags %q, want %q", tt.tags, file.Tags, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateInputDescription(t *testing.T) {
+	d := &FileDao{}
+
+	tests := []struct {
+		name        string
+		description string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:        "empty",
+			description: "",
+			expected:    "",
+		},
+		{
+			name:        "trimmed",
+			description: "  Some *notes*\r\n\tindented\n\n",
+			expected:    "Some *notes*\n\tindented",
+		},
+		{
+			name:        "control characters",
+			description: "bell\a",
+			expectError: true,
+		},
+		{
+			name:        "invalid utf-8",
+			description: "\xff",
+			expectError: true,
+		},
+		{
+			name:        "too long",
+			description: strings.Repeat("a", maxFileDescriptionLength+1),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &ds.File{Filename: "file.txt", Description: tt.description}
+			err := d.ValidateInput(file)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ValidateInput(%q): expected error, got nil", tt.description)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateInput(%q): unexpected error: %v", tt.description, err)
+			}
+			if file.Description != tt.expected {
+				t.Errorf("ValidateInput(%q): got description %q, want %q", tt.description, file.Description, tt.expected)
+			}
+		})
+	}
+}
+
 func TestValidateInputIdempotency(t *testing.T) {
 	d := &FileDao{}
 
@@ -334,6 +556,7 @@ func TestValidateInputIdempotency(t *testing.T) {
 		strings.Repeat("a", 150),
 		".hidden",
 		"  spaces  everywhere  .txt",
+		"/folder/./sub//.hidden",
 	}
 
 	for _, input := range testCases {
@@ -367,6 +590,7 @@ func FuzzFileValidateInput(f *testing.F) {
 	f.Add(".hidden")
 	f.Add("../../etc/passwd")
 	f.Add("folder/subfolder/file.txt")
+	f.Add("./a//b/ . /c/")
 	f.Add("C:\\Users\\test\\file.txt")
 	f.Add(strings.Repeat("a", 200))
 	f.Add("test\x00file.txt")
@@ -395,25 +619,32 @@ func FuzzFileValidateInput(f *testing.F) {
 			t.Error("ValidateInput produced empty filename without error")
 		}
 
-		// Output must not exceed 120 characters
-		if len(output) > 120 {
-			t.Errorf("ValidateInput produced filename longer than 120 chars: %d", len(output))
+		// Output must not exceed the maximum length
+		if len(output) > maxFilenameLength {
+			t.Errorf("ValidateInput produced filename longer than %d chars: %d", maxFilenameLength, len(output))
 		}
 
-		// Output must not start with a dot
-		if strings.HasPrefix(output, ".") {
-			t.Errorf("ValidateInput produced filename starting with dot: %q", output)
+		segments := strings.Split(output, "/")
+		if len(segments) > maxFolderDepth+1 {
+			t.Errorf("ValidateInput produced filename with %d folders: %q", len(segments)-1, output)
 		}
+		for _, segment := range segments {
+			// Segments must not be empty or exceed 120 characters
+			if len(segment) == 0 || len(segment) > 120 {
+				t.Errorf("ValidateInput produced path segment of %d chars in %q", len(segment), output)
+			}
 
-		// Output must not contain path separators
-		if strings.Contains(output, "/") {
-			t.Errorf("ValidateInput produced filename containing slash: %q", output)
-		}
+			// Segments must not start with a dot, which also rules out
+			// path traversal
+			if strings.HasPrefix(segment, ".") {
+				t.Errorf("ValidateInput produced path segment starting with dot: %q", output)
+			}
 
-		// Output must contain only allowed characters
-		for _, r := range output {
-			if !unicode.IsNumber(r) && !unicode.IsLetter(r) && !strings.ContainsAny(string(r), "-_=+,.()[] ") {
-				t.Errorf("ValidateInput produced filename with disallowed rune %U (%q) in %q", r, string(r), output)
+			// Segments must contain only allowed characters
+			for _, r := range segment {
+				if !unicode.IsNumber(r) && !unicode.IsLetter(r) && !strings.ContainsAny(string(r), "-_=+,.()[] ") {
+					t.Errorf("ValidateInput produced filename with disallowed rune %U (%q) in %q", r, string(r), output)
+				}
 			}
 		}
 
@@ -0,0 +1,99 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// FileVersionDao keeps the history of files that are uploaded again. Every
+// version refers to the content that it had, which keeps the content in
+// storage for as long as the file and its bin are available.
+type FileVersionDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+const fileVersionColumns = "v.id, v.file_id, v.sha256, fc.md5, fc.mime, fc.bytes, fc.in_storage, v.ip, v.uploaded_at, v.replaced_at"
+
+func (d *FileVersionDao) query(name string, sqlStatement string, params ...interface{}) (versions []ds.FileVersion, err error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, params...)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return versions, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var version ds.FileVersion
+		if err := rows.Scan(&version.Id, &version.FileId, &version.SHA256, &version.MD5, &version.Mime, &version.Bytes, &version.InStorage, &version.IP, &version.UploadedAt, &version.ReplacedAt); err != nil {
+			return versions, err
+		}
+		hydrateFileVersion(&version)
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return versions, err
+	}
+	return versions, nil
+}
+
+// Insert records the content that a file had before it was replaced
+func (d *FileVersionDao) Insert(version *ds.FileVersion) error {
+	if version.FileId == 0 {
+		return errors.New("file id not specified")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	uploadedAt := version.UploadedAt.UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO file_version (file_id, sha256, ip, uploaded_at, replaced_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	t0 := time.Now()
+	err := d.db.QueryRow(sqlStatement, version.FileId, version.SHA256, version.IP, uploadedAt, now).Scan(&version.Id)
+	observeQuery(d.metrics, "file_version_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	version.UploadedAt = uploadedAt
+	version.ReplacedAt = now
+	hydrateFileVersion(version)
+	return nil
+}
+
+// Get returns a version of a file
+func (d *FileVersionDao) Get(fileId int, id int64) (version ds.FileVersion, found bool, err error) {
+	sqlStatement := "SELECT " + fileVersionColumns + " FROM file_version v JOIN file_content fc ON v.sha256 = fc.sha256 WHERE v.file_id = $1 AND v.id = $2"
+	versions, err := d.query("file_version_get", sqlStatement, fileId, id)
+	if err != nil {
+		return version, false, err
+	}
+	if len(versions) == 0 {
+		return version, false, nil
+	}
+	return versions[0], true, nil
+}
+
+// GetByFile returns the versions of a file, latest first
+func (d *FileVersionDao) GetByFile(fileId int) (versions []ds.FileVersion, err error) {
+	sqlStatement := "SELECT " + fileVersionColumns + " FROM file_version v JOIN file_content fc ON v.sha256 = fc.sha256 WHERE v.file_id = $1 ORDER BY v.replaced_at DESC, v.id DESC"
+	return d.query("file_version_get_by_file", sqlStatement, fileId)
+}
+
+// GetByBin returns the versions of the files in a bin, latest first
+func (d *FileVersionDao) GetByBin(binId string) (versions []ds.FileVersion, err error) {
+	sqlStatement := "SELECT " + fileVersionColumns + " FROM file_version v JOIN file_content fc ON v.sha256 = fc.sha256 JOIN file f ON v.file_id = f.id WHERE f.bin_id = $1 AND f.deleted_at IS NULL ORDER BY v.replaced_at DESC, v.id DESC"
+	return d.query("file_version_get_by_bin", sqlStatement, binId)
+}
+
+// DeleteByFile removes the history of a file, and returns the number of
+// versions removed
+func (d *FileVersionDao) DeleteByFile(fileId int) (count int64, err error) {
+	sqlStatement := "DELETE FROM file_version WHERE file_id = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, fileId)
+	observeQuery(d.metrics, "file_version_delete_by_file", t0, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,146 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestFileVersions(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	var contents []*ds.FileContent
+	for _, sha256 := range []string{
+		"3333333333333333333333333333333333333333333333333333333333333333",
+		"4444444444444444444444444444444444444444444444444444444444444444",
+	} {
+		content := &ds.FileContent{SHA256: sha256, Bytes: 10, MD5: "d41d8cd98f00b204e9800998ecf8427e", Mime: "text/plain", InStorage: true}
+		if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+
+	bin := &ds.Bin{Id: "versionbin", ExpiredAt: time.Now().UTC().Add(time.Hour * 24)}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+	file := &ds.File{Filename: "notes.txt", Bin: bin.Id, SHA256: contents[0].SHA256, IP: "192.0.2.1"}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is uploaded again with other content
+	version := &ds.FileVersion{FileId: file.Id, SHA256: file.SHA256, IP: file.IP, UploadedAt: file.UpdatedAt}
+	if err := dao.FileVersion().Insert(version); err != nil {
+		t.Fatal(err)
+	}
+	file.SHA256 = contents[1].SHA256
+	file.Updates = 1
+	if err := dao.File().Update(file); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := dao.FileVersion().GetByFile(file.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].SHA256 != contents[0].SHA256 || versions[0].Bytes != 10 || versions[0].IP != "192.0.2.1" {
+		t.Fatalf("Unexpected versions: %+v", versions)
+	}
+	if _, found, err := dao.FileVersion().Get(file.Id, version.Id); err != nil || !found {
+		t.Errorf("Expected to find version %d: %v", version.Id, err)
+	}
+	if _, found, _ := dao.FileVersion().Get(file.Id+1, version.Id); found {
+		t.Errorf("Expected version %d to belong to file %d only", version.Id, file.Id)
+	}
+	if versions, err := dao.FileVersion().GetByBin(bin.Id); err != nil || len(versions) != 1 {
+		t.Errorf("Expected 1 version in the bin, got %d: %v", len(versions), err)
+	}
+
+	// The earlier content is referenced by the version
+	count, err := dao.File().CountBySHA256(contents[0].SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 reference to the earlier content, got %d", count)
+	}
+	pending, err := dao.FileContent().GetPendingDelete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no content pending delete, got %d", len(pending))
+	}
+
+	// The versions of deleted files are kept with the file, so they are
+	// still references
+	_ = file.DeletedAt.Scan(time.Now().UTC())
+	if err := dao.File().Update(file); err != nil {
+		t.Fatal(err)
+	}
+	count, err = dao.File().CountBySHA256(contents[0].SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 reference to the earlier content, got %d", count)
+	}
+	pending, err = dao.FileContent().GetPendingDelete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].SHA256 != contents[1].SHA256 {
+		t.Errorf("Expected the content of the deleted file only to be pending delete, got %+v", pending)
+	}
+
+	// The versions of files in deleted bins are not references
+	_ = bin.DeletedAt.Scan(time.Now().UTC())
+	if err := dao.Bin().Update(bin); err != nil {
+		t.Fatal(err)
+	}
+	count, err = dao.File().CountBySHA256(contents[0].SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no references to the earlier content, got %d", count)
+	}
+	pending, err = dao.FileContent().GetPendingDelete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("Expected 2 contents pending delete, got %d", len(pending))
+	}
+
+	// Deleting the content removes the versions that refer to it
+	if err := dao.FileContent().Delete(contents[0].SHA256); err != nil {
+		t.Fatalf("Unable to delete content referenced by a version: %s", err)
+	}
+	versions, err = dao.FileVersion().GetByFile(file.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected the versions to be deleted with the content, got %d", len(versions))
+	}
+
+	version = &ds.FileVersion{FileId: file.Id, SHA256: contents[1].SHA256, IP: file.IP, UploadedAt: file.UpdatedAt}
+	if err := dao.FileVersion().Insert(version); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := dao.FileVersion().DeleteByFile(file.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 version to be removed, got %d", removed)
+	}
+}
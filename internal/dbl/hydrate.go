@@ -27,6 +27,11 @@ func hydrateBin(bin *ds.Bin) {
 		bin.DeletedAt.Time = bin.DeletedAt.Time.UTC()
 		bin.DeletedAtRelative = humanize.Time(bin.DeletedAt.Time)
 	}
+	if bin.IsPinned() {
+		bin.PinnedAt.Time = bin.PinnedAt.Time.UTC()
+		bin.PinnedAtRelative = humanize.Time(bin.PinnedAt.Time)
+	}
+	bin.ExpirationPolicy = bin.ExpiryPolicy()
 	bin.URL = path.Join("/", bin.Id)
 }
 
@@ -53,15 +58,100 @@ func hydrateFile(file *ds.File) {
 	file.UploadDuration = time.Duration(file.UploadDurationMs) * time.Millisecond
 	file.UploadDurationReadable = file.UploadDuration.String()
 	file.URL = path.Join("/", file.Bin, file.Filename)
+	file.Folder = ds.FolderOf(file.Filename)
 
 	// Compute availability: file not deleted, bin not deleted, bin not expired, content in storage
 	binDeleted := file.BinDeletedAt.Valid && !file.BinDeletedAt.Time.IsZero()
-	binExpired := !file.BinExpiredAt.IsZero() && file.BinExpiredAt.Before(time.Now())
+	binExpired := !file.BinExpiredAt.IsZero() && file.BinExpiredAt.Before(time.Now()) && !file.BinPinned
 	file.AvailableForDownload = !file.IsDeleted() && !binDeleted && !binExpired && file.InStorage
 
 	setCategory(file)
 }
 
+// hydrateUpload normalizes timestamps to UTC and populates human-readable fields.
+func hydrateUpload(upload *ds.Upload) {
+	upload.UpdatedAt = upload.UpdatedAt.UTC()
+	upload.CreatedAt = upload.CreatedAt.UTC()
+	upload.ExpiredAt = upload.ExpiredAt.UTC()
+	upload.BytesReadable = humanize.Bytes(upload.Bytes)
+	upload.UpdatedAtRelative = humanize.Time(upload.UpdatedAt)
+	upload.CreatedAtRelative = humanize.Time(upload.CreatedAt)
+	upload.ExpiredAtRelative = humanize.Time(upload.ExpiredAt)
+}
+
+func hydrateDirectUpload(upload *ds.DirectUpload) {
+	upload.CreatedAt = upload.CreatedAt.UTC()
+	upload.ExpiredAt = upload.ExpiredAt.UTC()
+	upload.BytesReadable = humanize.Bytes(upload.Bytes)
+	upload.CreatedAtRelative = humanize.Time(upload.CreatedAt)
+	upload.ExpiredAtRelative = humanize.Time(upload.ExpiredAt)
+}
+
+func hydrateFileVersion(version *ds.FileVersion) {
+	version.UploadedAt = version.UploadedAt.UTC()
+	version.ReplacedAt = version.ReplacedAt.UTC()
+	version.UploadedAtRelative = humanize.Time(version.UploadedAt)
+	version.ReplacedAtRelative = humanize.Time(version.ReplacedAt)
+	version.BytesReadable = humanize.Bytes(version.Bytes)
+}
+
+func hydrateBinAlias(alias *ds.BinAlias) {
+	alias.CreatedAt = alias.CreatedAt.UTC()
+	alias.CreatedAtRelative = humanize.Time(alias.CreatedAt)
+	alias.URL = path.Join("/", alias.Id)
+	if alias.ExpiredAt != nil {
+		expiredAt := alias.ExpiredAt.UTC()
+		alias.ExpiredAt = &expiredAt
+		alias.ExpiredAtRelative = humanize.Time(expiredAt)
+	}
+}
+
+func hydratePolicy(policy *ds.Policy) {
+	policy.CreatedAt = policy.CreatedAt.UTC()
+	policy.CreatedAtRelative = humanize.Time(policy.CreatedAt)
+	if policy.ExpiredAt != nil {
+		expiredAt := policy.ExpiredAt.UTC()
+		policy.ExpiredAt = &expiredAt
+		policy.ExpiredAtRelative = humanize.Time(expiredAt)
+	}
+}
+
+func hydrateClientBan(client *ds.Client) {
+	if client.BannedAt.Valid {
+		client.BannedAt.Time = client.BannedAt.Time.UTC()
+		client.BannedAtRelative = humanize.Time(client.BannedAt.Time)
+	}
+	if client.BanExpiredAt.Valid {
+		client.BanExpiredAt.Time = client.BanExpiredAt.Time.UTC()
+		client.BanExpiredAtRelative = humanize.Time(client.BanExpiredAt.Time)
+	}
+}
+
+func hydrateWebhookDelivery(delivery *ds.WebhookDelivery) {
+	delivery.NextAttemptAt = delivery.NextAttemptAt.UTC()
+	delivery.CreatedAt = delivery.CreatedAt.UTC()
+	delivery.NextAttemptAtRelative = humanize.Time(delivery.NextAttemptAt)
+	delivery.CreatedAtRelative = humanize.Time(delivery.CreatedAt)
+	if delivery.DeliveredAt.Valid {
+		delivery.DeliveredAt.Time = delivery.DeliveredAt.Time.UTC()
+		delivery.DeliveredAtRelative = humanize.Time(delivery.DeliveredAt.Time)
+	}
+}
+
+func hydrateJob(job *ds.Job) {
+	job.RunAt = job.RunAt.UTC()
+	job.CreatedAt = job.CreatedAt.UTC()
+	job.RunAtRelative = humanize.Time(job.RunAt)
+	job.CreatedAtRelative = humanize.Time(job.CreatedAt)
+	if job.StartedAt.Valid {
+		job.StartedAt.Time = job.StartedAt.Time.UTC()
+	}
+	if job.FinishedAt.Valid {
+		job.FinishedAt.Time = job.FinishedAt.Time.UTC()
+		job.FinishedAtRelative = humanize.Time(job.FinishedAt.Time)
+	}
+}
+
 func setCategory(file *ds.File) {
 	if strings.HasPrefix(file.Mime, "image") {
 		file.Category = "image"
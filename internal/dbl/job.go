@@ -0,0 +1,251 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// JobDao is the queue of content processing jobs. Jobs are claimed by
+// workers with SKIP LOCKED, so that any number of workers, in any number of
+// instances, can process the queue concurrently.
+type JobDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+const jobColumns = "id, kind, sha256, COALESCE(file_id, 0), status, attempts, COALESCE(last_error, ''), run_at, created_at, started_at, finished_at"
+
+// Content that is eligible for each kind of job, given the current version
+// of the job as $1. Content in encrypted bins is opaque and is never
+// processed.
+var jobOutdated = map[string]string{
+	ds.JobMime:      "file_content.mime_version < $1",
+	ds.JobPHash:     "file_content.phash_version < $1 AND file_content.mime LIKE 'image/%'",
+	ds.JobThumbnail: "file_content.thumbnail_version < $1 AND file_content.mime LIKE 'image/%'",
+}
+
+const jobEligible = `file_content.in_storage = true AND file_content.blocked = false
+    AND NOT EXISTS (SELECT 1 FROM file JOIN bin ON file.bin_id = bin.id WHERE file.sha256 = file_content.sha256 AND bin.encrypted = true)`
+
+func scanJob(row interface{ Scan(...any) error }, job *ds.Job) error {
+	return row.Scan(
+		&job.Id,
+		&job.Kind,
+		&job.SHA256,
+		&job.FileId,
+		&job.Status,
+		&job.Attempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+}
+
+func (d *JobDao) query(name string, sqlStatement string, params ...interface{}) (jobs []ds.Job, err error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, params...)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return jobs, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var job ds.Job
+		if err := scanJob(rows, &job); err != nil {
+			return jobs, err
+		}
+		hydrateJob(&job)
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+// Enqueue adds a pending job for the content, due immediately. Nothing is
+// added if the content already has a pending or running job of this kind,
+// in which case false is returned.
+func (d *JobDao) Enqueue(kind string, sha256 string) (bool, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `INSERT INTO job (kind, sha256, status, attempts, run_at, created_at) VALUES ($1, $2, 'pending', 0, $3, $3)
+ON CONFLICT (kind, sha256) WHERE status IN ('pending', 'running') AND file_id IS NULL DO NOTHING`
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, kind, sha256, now)
+	observeQuery(d.metrics, "job_enqueue", t0, err)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// EnqueueFile adds a pending job for the file, due immediately. Unlike the
+// jobs for content, a job is added for every call, since each upload of a
+// file is processed separately.
+func (d *JobDao) EnqueueFile(kind string, fileId int, sha256 string) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO job (kind, sha256, file_id, status, attempts, run_at, created_at) VALUES ($1, $2, $3, 'pending', 0, $4, $4)"
+	t0 := time.Now()
+	_, err := d.db.Exec(sqlStatement, kind, sha256, fileId, now)
+	observeQuery(d.metrics, "job_enqueue_file", t0, err)
+	return err
+}
+
+// EnqueueBackfill adds pending jobs of the kind for all content with
+// missing data, or data from a version older than the current version.
+// Content that already has a pending or running job is skipped.
+func (d *JobDao) EnqueueBackfill(kind string, version int) (count int64, err error) {
+	outdated, found := jobOutdated[kind]
+	if !found {
+		return count, fmt.Errorf("Unknown job kind %q", kind)
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `INSERT INTO job (kind, sha256, status, attempts, run_at, created_at)
+SELECT $2, file_content.sha256, 'pending', 0, $3, $3 FROM file_content
+WHERE ` + outdated + ` AND ` + jobEligible + `
+ON CONFLICT (kind, sha256) WHERE status IN ('pending', 'running') AND file_id IS NULL DO NOTHING`
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, version, kind, now)
+	observeQuery(d.metrics, "job_enqueue_backfill", t0, err)
+	if err != nil {
+		return count, err
+	}
+	count, err = res.RowsAffected()
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// CountOutdated returns the number of content with missing data, or data
+// from a version older than the current version of the kind of job
+func (d *JobDao) CountOutdated(kind string, version int) (count int64, err error) {
+	outdated, found := jobOutdated[kind]
+	if !found {
+		return count, fmt.Errorf("Unknown job kind %q", kind)
+	}
+	sqlStatement := "SELECT COUNT(*) FROM file_content WHERE " + outdated + " AND " + jobEligible
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, version).Scan(&count)
+	observeQuery(d.metrics, "job_count_outdated", t0, err)
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// Claim returns the next pending job that is due, and marks it as running.
+// Jobs that have been running for longer than stale are assumed to belong
+// to a worker that stopped, and are claimed again. Found is false if there
+// is nothing to do.
+func (d *JobDao) Claim(stale time.Duration) (job ds.Job, found bool, err error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `UPDATE job SET status = 'running', attempts = attempts + 1, started_at = $1
+WHERE id = (
+    SELECT id FROM job
+    WHERE (status = 'pending' AND run_at <= $1) OR (status = 'running' AND started_at < $2)
+    ORDER BY run_at ASC
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + jobColumns
+	t0 := time.Now()
+	err = scanJob(d.db.QueryRow(sqlStatement, now, now.Add(-stale)), &job)
+	observeQuery(d.metrics, "job_claim", t0, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return job, false, nil
+		}
+		return job, false, err
+	}
+	hydrateJob(&job)
+	return job, true, nil
+}
+
+// Finish records the outcome of a job. Jobs that are to be retried are
+// given status pending and a new run_at.
+func (d *JobDao) Finish(job *ds.Job) error {
+	var finishedAt sql.NullTime
+	if job.Status == ds.JobDone || job.Status == ds.JobFailed {
+		finishedAt = sql.NullTime{Time: time.Now().UTC().Truncate(time.Microsecond), Valid: true}
+	}
+	sqlStatement := "UPDATE job SET status = $1, last_error = $2, run_at = $3, finished_at = $4 WHERE id = $5"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, job.Status, nullString(job.LastError), job.RunAt.UTC(), finishedAt, job.Id)
+	observeQuery(d.metrics, "job_finish", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Job does not exist")
+	}
+	job.FinishedAt = finishedAt
+	hydrateJob(job)
+	return nil
+}
+
+// Stats returns the number of jobs in each state, per kind of job
+func (d *JobDao) Stats() (stats []ds.JobStats, err error) {
+	sqlStatement := `SELECT kind,
+    COUNT(*) FILTER (WHERE status = 'pending'),
+    COUNT(*) FILTER (WHERE status = 'running'),
+    COUNT(*) FILTER (WHERE status = 'done'),
+    COUNT(*) FILTER (WHERE status = 'failed')
+FROM job GROUP BY kind ORDER BY kind`
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement)
+	observeQuery(d.metrics, "job_stats", t0, err)
+	if err != nil {
+		return stats, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var s ds.JobStats
+		if err := rows.Scan(&s.Kind, &s.Pending, &s.Running, &s.Done, &s.Failed); err != nil {
+			return stats, err
+		}
+		stats = append(stats, s)
+	}
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// GetFailed returns the failed jobs, most recently finished first
+func (d *JobDao) GetFailed(limit int) (jobs []ds.Job, err error) {
+	sqlStatement := "SELECT " + jobColumns + " FROM job WHERE status = 'failed' ORDER BY finished_at DESC LIMIT $1"
+	return d.query("job_get_failed", sqlStatement, limit)
+}
+
+// Cleanup removes jobs that were done or failed more than the retention in
+// days ago
+func (d *JobDao) Cleanup(retention uint64) (count int64, err error) {
+	sqlStatement := "DELETE FROM job WHERE status IN ('done', 'failed') AND finished_at < NOW() - ($1 || ' days')::interval"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, retention)
+	observeQuery(d.metrics, "job_cleanup", t0, err)
+	if err != nil {
+		return count, err
+	}
+	n, err := res.RowsAffected()
+	count = n
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
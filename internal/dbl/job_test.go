@@ -0,0 +1,287 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestJobQueue(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000001",
+		Bytes:     10,
+		MD5:       "00000000000000000000000000000001",
+		Mime:      "image/png",
+		InStorage: true,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := dao.Job().Enqueue(ds.JobPHash, content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Error("Expected the job to be queued")
+	}
+
+	// Content has at most one active job of each kind
+	queued, err = dao.Job().Enqueue(ds.JobPHash, content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued {
+		t.Error("Expected the duplicate job not to be queued")
+	}
+
+	job, found, err := dao.Job().Claim(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("Expected to claim the job")
+	}
+	if job.Kind != ds.JobPHash || job.SHA256 != content.SHA256 {
+		t.Errorf("Unexpected job claimed: %+v", job)
+	}
+	if job.Status != ds.JobRunning || job.Attempts != 1 {
+		t.Errorf("Expected a running job with 1 attempt, got status %q and %d attempts", job.Status, job.Attempts)
+	}
+
+	// Running jobs are not claimed again until they are stale
+	_, found, err = dao.Job().Claim(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("Expected no job to claim while the job is running")
+	}
+
+	// Retry the job later
+	job.Status = ds.JobPending
+	job.LastError = "temporary failure"
+	job.RunAt = time.Now().UTC().Add(time.Hour)
+	if err := dao.Job().Finish(&job); err != nil {
+		t.Fatal(err)
+	}
+	_, found, err = dao.Job().Claim(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("Expected no job to claim before it is due")
+	}
+
+	// Fail the job
+	job.Status = ds.JobFailed
+	job.RunAt = time.Now().UTC()
+	if err := dao.Job().Finish(&job); err != nil {
+		t.Fatal(err)
+	}
+	if !job.FinishedAt.Valid {
+		t.Error("Expected the failed job to be finished")
+	}
+	failed, err := dao.Job().GetFailed(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Id != job.Id || failed[0].LastError != "temporary failure" {
+		t.Errorf("Expected the failed job to be listed, got %+v", failed)
+	}
+
+	stats, err := dao.Job().Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Kind != ds.JobPHash || stats[0].Failed != 1 || stats[0].Pending != 0 {
+		t.Errorf("Unexpected job stats: %+v", stats)
+	}
+
+	// Jobs can be queued again once the previous job is finished
+	queued, err = dao.Job().Enqueue(ds.JobPHash, content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Error("Expected the job to be queued again")
+	}
+}
+
+func TestJobEnqueueFile(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000004",
+		Bytes:     10,
+		MD5:       "00000000000000000000000000000004",
+		Mime:      "text/plain",
+		InStorage: true,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+	bin := &ds.Bin{
+		Id:        "testjobbin",
+		ExpiredAt: time.Now().UTC().Add(time.Hour * 24),
+	}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+	file := &ds.File{
+		Filename: "a.txt",
+		Bin:      bin.Id,
+		Bytes:    content.Bytes,
+		SHA256:   content.SHA256,
+	}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every upload of a file gets a job, also of the same content, and
+	// does not prevent jobs for the content
+	for i := 0; i < 2; i++ {
+		if err := dao.Job().EnqueueFile(ds.JobHook, file.Id, content.SHA256); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queued, err := dao.Job().Enqueue(ds.JobMime, content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Error("Expected the content job to be queued")
+	}
+
+	hooks := 0
+	for {
+		job, found, err := dao.Job().Claim(time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+		if job.Kind == ds.JobHook {
+			hooks++
+			if job.FileId != file.Id {
+				t.Errorf("Expected the job for file %d, got %d", file.Id, job.FileId)
+			}
+		} else if job.FileId != 0 {
+			t.Errorf("Expected no file for the content job, got %d", job.FileId)
+		}
+	}
+	if hooks != 2 {
+		t.Errorf("Expected 2 hook jobs, got %d", hooks)
+	}
+}
+
+func TestJobBackfill(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	image := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000002",
+		Bytes:     10,
+		MD5:       "00000000000000000000000000000002",
+		Mime:      "image/jpeg",
+		InStorage: true,
+	}
+	text := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000003",
+		Bytes:     10,
+		MD5:       "00000000000000000000000000000003",
+		Mime:      "text/plain",
+		InStorage: true,
+	}
+	for _, content := range []*ds.FileContent{image, text} {
+		if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only images are eligible for perceptual hashing
+	count, err := dao.Job().CountOutdated(ds.JobPHash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 outdated image, got %d", count)
+	}
+	count, err = dao.Job().CountOutdated(ds.JobMime, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 outdated content, got %d", count)
+	}
+
+	count, err = dao.Job().EnqueueBackfill(ds.JobMime, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 jobs to be queued, got %d", count)
+	}
+
+	// Content with active jobs is skipped
+	count, err = dao.Job().EnqueueBackfill(ds.JobMime, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no jobs to be queued, got %d", count)
+	}
+
+	if _, err := dao.Job().EnqueueBackfill("unknown", 1); err == nil {
+		t.Error("Expected an error for an unknown kind of job")
+	}
+
+	// Content that is up to date is no longer outdated
+	if err := dao.FileContent().SetMime(text.SHA256, "text/plain; charset=utf-8", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.FileContent().SetPHash(image.SHA256, "8000000000000000", 1); err != nil {
+		t.Fatal(err)
+	}
+	count, err = dao.Job().CountOutdated(ds.JobMime, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 outdated content, got %d", count)
+	}
+	count, err = dao.Job().CountOutdated(ds.JobPHash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no outdated images, got %d", count)
+	}
+
+	updated, err := dao.FileContent().GetBySHA256(text.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Mime != "text/plain; charset=utf-8" || updated.MimeVersion != 1 {
+		t.Errorf("Expected the updated content type and version, got %q and %d", updated.Mime, updated.MimeVersion)
+	}
+
+	if err := dao.FileContent().SetMime("nonexistent", "text/plain", 1); err == nil {
+		t.Error("Expected an error when setting the content type of nonexistent content")
+	}
+}
@@ -126,16 +126,24 @@ func (d *MetricsDao) UpdateMetrics(metrics *ds.Metrics) (retErr error) {
 	currentBytes := int64(d.StorageBytesAllocated())
 
 	// Number of current bins
-	sqlStatement = "SELECT COUNT(*) FROM bin WHERE expired_at > $1 AND deleted_at IS NULL"
+	sqlStatement = "SELECT COUNT(*) FROM bin WHERE (expired_at > $1 OR pinned_at IS NOT NULL) AND deleted_at IS NULL"
 	if err := d.db.QueryRow(sqlStatement, now).Scan(&currentBins); err != nil {
 		return err
 	}
 
+	// Number of jobs in each state
+	jobs := JobDao{db: d.db, metrics: d.metrics}
+	jobStats, err := jobs.Stats()
+	if err != nil {
+		return err
+	}
+
 	// Update metrics struct fields (no lock needed for Prometheus metrics as they are thread-safe)
 	metrics.CurrentBins = currentBins
 	metrics.CurrentLogEntries = currentLogEntries
 	metrics.CurrentFiles = currentFiles
 	metrics.CurrentBytes = currentBytes
+	metrics.Jobs = jobStats
 
 	metrics.CurrentFilesReadable = humanize.Comma(metrics.CurrentFiles)
 	metrics.CurrentBinsReadable = humanize.Comma(metrics.CurrentBins)
@@ -0,0 +1,167 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// Upper limit of the length of the reason of a policy rule
+const maxPolicyReasonLength = 256
+
+// PolicyDao keeps the rules of the access policy, which match clients by
+// network, ASN, country or the proxy flag of the geoip database
+type PolicyDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+const policyColumns = "id, match_type, match_value, action, reason, created_by, created_at, expired_at"
+
+// ValidateInput verifies the rule, and normalizes the value that it matches
+// by. Single IP addresses are turned into networks with one address.
+func (d *PolicyDao) ValidateInput(policy *ds.Policy) error {
+	value := strings.TrimSpace(policy.Value)
+	switch policy.Match {
+	case ds.PolicyMatchCIDR:
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return errors.New("the network is not valid CIDR notation")
+			}
+			if ip.To4() != nil {
+				value = value + "/32"
+			} else {
+				value = value + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return errors.New("the network is not valid CIDR notation")
+		}
+		value = network.String()
+	case ds.PolicyMatchASN:
+		value = strings.TrimPrefix(strings.ToUpper(value), "AS")
+		asn, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || asn == 0 {
+			return errors.New("the ASN must be a positive number")
+		}
+		value = strconv.FormatUint(asn, 10)
+	case ds.PolicyMatchCountry:
+		if value == "" {
+			return errors.New("the country is not specified")
+		}
+		if utf8.RuneCountInString(value) > 128 || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return errors.New("the country is not valid")
+		}
+	case ds.PolicyMatchProxy:
+		value = ""
+	default:
+		return fmt.Errorf("unknown match %q", policy.Match)
+	}
+	policy.Value = value
+
+	switch policy.Action {
+	case ds.PolicyDenyUpload, ds.PolicyDenyDownload, ds.PolicyRequireApproval, ds.PolicyRequireCookie, ds.PolicyAllow:
+	default:
+		return fmt.Errorf("unknown action %q", policy.Action)
+	}
+
+	policy.Reason = strings.TrimSpace(policy.Reason)
+	if !utf8.ValidString(policy.Reason) || strings.IndexFunc(policy.Reason, unicode.IsControl) >= 0 {
+		return errors.New("the reason contains invalid characters")
+	}
+	if utf8.RuneCountInString(policy.Reason) > maxPolicyReasonLength {
+		return fmt.Errorf("the reason is too long, the limit is %d characters", maxPolicyReasonLength)
+	}
+	if policy.IsExpired() {
+		return errors.New("the expiry has already passed")
+	}
+	return nil
+}
+
+func (d *PolicyDao) query(name string, sqlStatement string, params ...interface{}) (policies []ds.Policy, err error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, params...)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return policies, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var policy ds.Policy
+		if err := rows.Scan(&policy.Id, &policy.Match, &policy.Value, &policy.Action, &policy.Reason, &policy.CreatedBy, &policy.CreatedAt, &policy.ExpiredAt); err != nil {
+			return policies, err
+		}
+		hydratePolicy(&policy)
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// GetAll returns all rules, latest first
+func (d *PolicyDao) GetAll() (policies []ds.Policy, err error) {
+	sqlStatement := "SELECT " + policyColumns + " FROM access_policy ORDER BY created_at DESC, id DESC"
+	return d.query("policy_get_all", sqlStatement)
+}
+
+// GetActive returns the rules that have not expired
+func (d *PolicyDao) GetActive() (policies []ds.Policy, err error) {
+	sqlStatement := "SELECT " + policyColumns + " FROM access_policy WHERE expired_at IS NULL OR expired_at > $1 ORDER BY id ASC"
+	return d.query("policy_get_active", sqlStatement, time.Now().UTC())
+}
+
+func (d *PolicyDao) Insert(policy *ds.Policy) (err error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if policy.ExpiredAt != nil {
+		expiredAt := policy.ExpiredAt.UTC().Truncate(time.Microsecond)
+		policy.ExpiredAt = &expiredAt
+	}
+	sqlStatement := "INSERT INTO access_policy (match_type, match_value, action, reason, created_by, created_at, expired_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, policy.Match, policy.Value, policy.Action, policy.Reason, policy.CreatedBy, now, policy.ExpiredAt).Scan(&policy.Id)
+	observeQuery(d.metrics, "policy_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	policy.CreatedAt = now
+	hydratePolicy(policy)
+	return nil
+}
+
+// Delete removes a rule, and returns false if the rule does not exist
+func (d *PolicyDao) Delete(id int64) (found bool, err error) {
+	sqlStatement := "DELETE FROM access_policy WHERE id = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, id)
+	observeQuery(d.metrics, "policy_delete", t0, err)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteExpired removes the rules that have expired, and returns the number
+// of rules removed
+func (d *PolicyDao) DeleteExpired() (count int64, err error) {
+	sqlStatement := "DELETE FROM access_policy WHERE expired_at < $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, time.Now().UTC())
+	observeQuery(d.metrics, "policy_delete_expired", t0, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
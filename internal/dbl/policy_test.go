@@ -0,0 +1,76 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestPolicyLifecycle(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	expiredAt := time.Now().Add(time.Hour)
+	policies := []*ds.Policy{
+		{Match: ds.PolicyMatchCIDR, Value: "2001:db8:1:2::/64", Action: ds.PolicyDenyUpload, Reason: "Spam", CreatedBy: "192.0.2.10"},
+		{Match: ds.PolicyMatchASN, Value: "64500", Action: ds.PolicyRequireApproval, ExpiredAt: &expiredAt},
+	}
+	for _, policy := range policies {
+		if err := dao.Policy().Insert(policy); err != nil {
+			t.Fatal(err)
+		}
+		if policy.Id == 0 {
+			t.Errorf("Expected the policy to get an id")
+		}
+	}
+
+	all, err := dao.Policy().GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(all))
+	}
+	if all[1].Value != "2001:db8:1:2::/64" || all[1].Reason != "Spam" || all[1].CreatedBy != "192.0.2.10" || all[1].ExpiredAt != nil {
+		t.Errorf("Unexpected policy: %+v", all[1])
+	}
+	if all[0].ExpiredAt == nil || all[0].ExpiredAtRelative == "" {
+		t.Errorf("Expected the expiry to be set: %+v", all[0])
+	}
+
+	// Rules are no longer active when they expire
+	past := time.Now().Add(-1 * time.Minute)
+	expired := &ds.Policy{Match: ds.PolicyMatchProxy, Action: ds.PolicyDenyDownload, ExpiredAt: &past}
+	if err := dao.Policy().Insert(expired); err != nil {
+		t.Fatal(err)
+	}
+	active, err := dao.Policy().GetActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 {
+		t.Errorf("Expected 2 active policies, got %d", len(active))
+	}
+	count, err := dao.Policy().DeleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 expired policy to be removed, got %d", count)
+	}
+
+	found, err := dao.Policy().Delete(policies[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Errorf("Expected the policy to be removed")
+	}
+	if found, _ := dao.Policy().Delete(policies[0].Id); found {
+		t.Errorf("Expected the policy to be removed already")
+	}
+}
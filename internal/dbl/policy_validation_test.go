@@ -0,0 +1,48 @@
+package dbl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestPolicyValidateInput(t *testing.T) {
+	d := &PolicyDao{} // No DB needed for validation
+	past := time.Now().Add(-1 * time.Minute)
+
+	tests := []struct {
+		name      string
+		policy    ds.Policy
+		wantValue string
+		wantErr   bool
+	}{
+		{"ipv4 network", ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.17/24", Action: ds.PolicyDenyUpload}, "192.0.2.0/24", false},
+		{"ipv6 /64", ds.Policy{Match: ds.PolicyMatchCIDR, Value: " 2001:db8:1:2::5/64 ", Action: ds.PolicyDenyUpload}, "2001:db8:1:2::/64", false},
+		{"single ipv4 address", ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.1", Action: ds.PolicyAllow}, "192.0.2.1/32", false},
+		{"single ipv6 address", ds.Policy{Match: ds.PolicyMatchCIDR, Value: "2001:db8::1", Action: ds.PolicyAllow}, "2001:db8::1/128", false},
+		{"invalid network", ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.0/33", Action: ds.PolicyDenyUpload}, "", true},
+		{"asn", ds.Policy{Match: ds.PolicyMatchASN, Value: "AS64500", Action: ds.PolicyDenyDownload}, "64500", false},
+		{"invalid asn", ds.Policy{Match: ds.PolicyMatchASN, Value: "0", Action: ds.PolicyDenyDownload}, "", true},
+		{"country", ds.Policy{Match: ds.PolicyMatchCountry, Value: " Norway ", Action: ds.PolicyRequireCookie}, "Norway", false},
+		{"missing country", ds.Policy{Match: ds.PolicyMatchCountry, Value: " ", Action: ds.PolicyRequireCookie}, "", true},
+		{"proxy", ds.Policy{Match: ds.PolicyMatchProxy, Value: "yes", Action: ds.PolicyRequireApproval}, "", false},
+		{"unknown match", ds.Policy{Match: "city", Value: "Oslo", Action: ds.PolicyDenyUpload}, "", true},
+		{"unknown action", ds.Policy{Match: ds.PolicyMatchProxy, Action: "ban"}, "", true},
+		{"long reason", ds.Policy{Match: ds.PolicyMatchProxy, Action: ds.PolicyDenyUpload, Reason: strings.Repeat("a", 257)}, "", true},
+		{"expired", ds.Policy{Match: ds.PolicyMatchProxy, Action: ds.PolicyDenyUpload, ExpiredAt: &past}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			err := d.ValidateInput(&policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && policy.Value != tt.wantValue {
+				t.Errorf("Expected value %q, got %q", tt.wantValue, policy.Value)
+			}
+		})
+	}
+}
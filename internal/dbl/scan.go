@@ -0,0 +1,54 @@
+package dbl
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+type ScanDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// Insert adds a scan to the scan history of the content
+func (d *ScanDao) Insert(scan *ds.Scan) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO scan (sha256, scanner, status, signature, error, duration_ms, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	t0 := time.Now()
+	err := d.db.QueryRow(sqlStatement, scan.SHA256, scan.Scanner, scan.Status, nullString(scan.Signature), nullString(scan.Error), scan.DurationMs, now).Scan(&scan.Id)
+	observeQuery(d.metrics, "scan_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	scan.CreatedAt = now
+	scan.CreatedAtRelative = humanize.Time(now)
+	return nil
+}
+
+// GetBySHA256 returns the scan history of the content, newest first
+func (d *ScanDao) GetBySHA256(sha256 string) (scans []ds.Scan, err error) {
+	sqlStatement := "SELECT id, sha256, scanner, status, COALESCE(signature, ''), COALESCE(error, ''), duration_ms, created_at FROM scan WHERE sha256 = $1 ORDER BY created_at DESC, id DESC"
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, sha256)
+	observeQuery(d.metrics, "scan_get_by_sha256", t0, err)
+	if err != nil {
+		return scans, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var scan ds.Scan
+		if err := rows.Scan(&scan.Id, &scan.SHA256, &scan.Scanner, &scan.Status, &scan.Signature, &scan.Error, &scan.DurationMs, &scan.CreatedAt); err != nil {
+			return scans, err
+		}
+		scan.CreatedAt = scan.CreatedAt.UTC()
+		scan.CreatedAtRelative = humanize.Time(scan.CreatedAt)
+		scans = append(scans, scan)
+	}
+	if err = rows.Err(); err != nil {
+		return scans, err
+	}
+	return scans, nil
+}
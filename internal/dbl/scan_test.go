@@ -0,0 +1,113 @@
+package dbl
+
+import (
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestScan(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:     "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		Bytes:      3,
+		MD5:        "acbd18db4cc2f85cedef654fccc4a4d8",
+		Mime:       "text/plain",
+		InStorage:  true,
+		ScanStatus: ds.ScanClean,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+
+	dbContent, err := dao.FileContent().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbContent.ScanStatus != ds.ScanClean {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanClean, dbContent.ScanStatus)
+	}
+	if !dbContent.ScannedAt.Valid {
+		t.Error("Expected the scan timestamp to be set")
+	}
+
+	// Later references to the content without a new scan keep the verdict
+	if err := dao.FileContent().InsertOrIncrement(&ds.FileContent{SHA256: content.SHA256, Bytes: 3, MD5: content.MD5, Mime: content.Mime, InStorage: true}); err != nil {
+		t.Fatal(err)
+	}
+	dbContent, err = dao.FileContent().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dbContent.IsScanned() {
+		t.Errorf("Expected the content to remain scanned, got status %q", dbContent.ScanStatus)
+	}
+
+	if err := dao.FileContent().SetScanStatus(dbContent, ds.ScanInfected, "Eicar-Signature"); err != nil {
+		t.Fatal(err)
+	}
+	dbContent, err = dao.FileContent().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbContent.ScanStatus != ds.ScanInfected {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanInfected, dbContent.ScanStatus)
+	}
+	if dbContent.ScanSignature != "Eicar-Signature" {
+		t.Errorf("Expected scan signature Eicar-Signature, got %q", dbContent.ScanSignature)
+	}
+
+	if err := dao.FileContent().SetScanStatus(&ds.FileContent{SHA256: "missing"}, ds.ScanClean, ""); err == nil {
+		t.Error("Expected an error when setting the scan status of content that does not exist")
+	}
+
+	// Scan history
+	first := &ds.Scan{SHA256: content.SHA256, Scanner: "clamd", Status: ds.ScanClean, DurationMs: 12}
+	if err := dao.Scan().Insert(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Id == 0 {
+		t.Error("Expected the scan to get an id")
+	}
+	second := &ds.Scan{SHA256: content.SHA256, Scanner: "clamd", Status: ds.ScanInfected, Signature: "Eicar-Signature", DurationMs: 8}
+	if err := dao.Scan().Insert(second); err != nil {
+		t.Fatal(err)
+	}
+	third := &ds.Scan{SHA256: content.SHA256, Scanner: "clamd", Status: ds.ScanError, Error: "connect to clamd: connection refused"}
+	if err := dao.Scan().Insert(third); err != nil {
+		t.Fatal(err)
+	}
+
+	scans, err := dao.Scan().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 3 {
+		t.Fatalf("Expected 3 scans, got %d", len(scans))
+	}
+	if scans[0].Id != third.Id || scans[2].Id != first.Id {
+		t.Errorf("Expected the newest scan first, got ids %d, %d, %d", scans[0].Id, scans[1].Id, scans[2].Id)
+	}
+	if scans[0].Error != third.Error {
+		t.Errorf("Expected error %q, got %q", third.Error, scans[0].Error)
+	}
+	if scans[1].Signature != "Eicar-Signature" {
+		t.Errorf("Expected signature Eicar-Signature, got %q", scans[1].Signature)
+	}
+	if scans[2].DurationMs != 12 {
+		t.Errorf("Expected duration 12, got %d", scans[2].DurationMs)
+	}
+
+	scans, err = dao.Scan().GetBySHA256("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 0 {
+		t.Errorf("Expected no scans, got %d", len(scans))
+	}
+}
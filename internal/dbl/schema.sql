@@ -7,7 +7,15 @@ CREATE TABLE IF NOT EXISTS bin (
 	deleted_at	TIMESTAMP,
 	approved_at	TIMESTAMP,
 	downloads	BIGINT NOT NULL,
-	updates		BIGINT NOT NULL
+	updates		BIGINT NOT NULL,
+	owner_token_hash	VARCHAR(128),
+	password_hash	VARCHAR(256),
+	encrypted	BOOLEAN NOT NULL DEFAULT false,
+	expiration_seconds	BIGINT NOT NULL DEFAULT 0,
+	pinned_at	TIMESTAMP,
+	download_limit	BIGINT NOT NULL DEFAULT 0,
+	title		TEXT NOT NULL DEFAULT '',
+	description	TEXT NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS file_content (
@@ -19,13 +27,19 @@ CREATE TABLE IF NOT EXISTS file_content (
 	in_storage	BOOLEAN NOT NULL DEFAULT false,
 	blocked		BOOLEAN NOT NULL DEFAULT false,
 	created_at	TIMESTAMP NOT NULL,
-	last_referenced_at TIMESTAMP NOT NULL
+	last_referenced_at TIMESTAMP NOT NULL,
+	scan_status	VARCHAR(16),
+	scan_signature	TEXT,
+	scanned_at	TIMESTAMP,
+	mime_version	SMALLINT NOT NULL DEFAULT 0,
+	phash_version	SMALLINT NOT NULL DEFAULT 0,
+	thumbnail_version	SMALLINT NOT NULL DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS file (
 	id		BIGSERIAL NOT NULL PRIMARY KEY,
 	bin_id		VARCHAR(64) NOT NULL REFERENCES bin(id) ON DELETE CASCADE,
-	filename        VARCHAR(128) NOT NULL,
+	filename        VARCHAR(1024) NOT NULL,
 	sha256		VARCHAR(128) NOT NULL REFERENCES file_content(sha256) ON DELETE RESTRICT,
 	downloads	BIGINT NOT NULL,
 	updates 	BIGINT NOT NULL,
@@ -35,9 +49,22 @@ CREATE TABLE IF NOT EXISTS file (
 	created_at	TIMESTAMP NOT NULL,
 	deleted_at	TIMESTAMP,
 	upload_duration_ms	BIGINT NOT NULL DEFAULT 0,
+	encrypted_name	TEXT,
+	download_limit	BIGINT NOT NULL DEFAULT 0,
+	description	TEXT NOT NULL DEFAULT '',
+	tags		TEXT[] NOT NULL DEFAULT '{}',
 	UNIQUE(bin_id, filename)
 );
 
+CREATE TABLE IF NOT EXISTS file_version (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	file_id		BIGINT NOT NULL REFERENCES file(id) ON DELETE CASCADE,
+	sha256		VARCHAR(128) NOT NULL REFERENCES file_content(sha256) ON DELETE RESTRICT,
+	ip		VARCHAR(128) NOT NULL,
+	uploaded_at	TIMESTAMP NOT NULL,
+	replaced_at	TIMESTAMP NOT NULL
+);
+
 CREATE TABLE IF NOT EXISTS transaction (
 	id		BIGSERIAL NOT NULL PRIMARY KEY,
 	bin_id		VARCHAR(64) NOT NULL,
@@ -70,6 +97,125 @@ CREATE TABLE IF NOT EXISTS client (
 	banned_by				VARCHAR(64) NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS upload (
+	id			VARCHAR(64) NOT NULL PRIMARY KEY,
+	bin_id			VARCHAR(64) NOT NULL REFERENCES bin(id) ON DELETE CASCADE,
+	filename		VARCHAR(1024) NOT NULL,
+	bytes			BIGINT NOT NULL,
+	upload_offset		BIGINT NOT NULL,
+	part_size		BIGINT NOT NULL,
+	object_key		VARCHAR(128) NOT NULL,
+	storage_upload_id	TEXT NOT NULL,
+	ip			VARCHAR(128) NOT NULL,
+	updated_at		TIMESTAMP NOT NULL,
+	created_at		TIMESTAMP NOT NULL,
+	expired_at		TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS direct_upload (
+	id			VARCHAR(64) NOT NULL PRIMARY KEY,
+	bin_id			VARCHAR(64) NOT NULL REFERENCES bin(id) ON DELETE CASCADE,
+	filename		VARCHAR(1024) NOT NULL,
+	bytes			BIGINT NOT NULL,
+	part_size		BIGINT NOT NULL,
+	parts			INT NOT NULL,
+	sha256			VARCHAR(128) NOT NULL,
+	object_key		VARCHAR(128) NOT NULL,
+	storage_upload_id	TEXT NOT NULL,
+	ip			VARCHAR(128) NOT NULL,
+	created_at		TIMESTAMP NOT NULL,
+	expired_at		TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bin_alias (
+	id		VARCHAR(64) NOT NULL PRIMARY KEY,
+	bin_id		VARCHAR(64) NOT NULL REFERENCES bin(id) ON DELETE CASCADE,
+	created_at	TIMESTAMP NOT NULL,
+	expired_at	TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scan (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	sha256		VARCHAR(128) NOT NULL REFERENCES file_content(sha256) ON DELETE CASCADE,
+	scanner		VARCHAR(64) NOT NULL,
+	status		VARCHAR(16) NOT NULL,
+	signature	TEXT,
+	error		TEXT,
+	duration_ms	BIGINT NOT NULL DEFAULT 0,
+	created_at	TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	event		VARCHAR(64) NOT NULL,
+	url		TEXT NOT NULL,
+	payload		TEXT NOT NULL,
+	status		VARCHAR(16) NOT NULL,
+	attempts	INT NOT NULL DEFAULT 0,
+	last_status_code INT,
+	last_error	TEXT,
+	next_attempt_at	TIMESTAMP NOT NULL,
+	created_at	TIMESTAMP NOT NULL,
+	delivered_at	TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS job (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	kind		VARCHAR(32) NOT NULL,
+	sha256		VARCHAR(128) NOT NULL REFERENCES file_content(sha256) ON DELETE CASCADE,
+	file_id		BIGINT REFERENCES file(id) ON DELETE CASCADE,
+	status		VARCHAR(16) NOT NULL,
+	attempts	INT NOT NULL DEFAULT 0,
+	last_error	TEXT,
+	run_at		TIMESTAMP NOT NULL,
+	created_at	TIMESTAMP NOT NULL,
+	started_at	TIMESTAMP,
+	finished_at	TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS thumbnail (
+	sha256		VARCHAR(128) NOT NULL REFERENCES file_content(sha256) ON DELETE CASCADE,
+	size		INT NOT NULL,
+	width		INT NOT NULL,
+	height		INT NOT NULL,
+	bytes		BIGINT NOT NULL,
+	created_at	TIMESTAMP NOT NULL,
+	PRIMARY KEY(sha256, size)
+);
+
+CREATE TABLE IF NOT EXISTS client_usage (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	ip		VARCHAR(128) NOT NULL,
+	asn		INT NOT NULL DEFAULT 0,
+	network		VARCHAR(128) NOT NULL DEFAULT '',
+	kind		VARCHAR(16) NOT NULL,
+	bytes		BIGINT NOT NULL DEFAULT 0,
+	created_at	TIMESTAMP NOT NULL,
+	expired_at	TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transfer (
+	ip		VARCHAR(128) NOT NULL,
+	file_id		BIGINT NOT NULL REFERENCES file(id) ON DELETE CASCADE,
+	sha256		VARCHAR(128) NOT NULL,
+	bytes		BIGINT NOT NULL DEFAULT 0,
+	downloads	BIGINT NOT NULL DEFAULT 0,
+	created_at	TIMESTAMP NOT NULL,
+	expired_at	TIMESTAMP NOT NULL,
+	PRIMARY KEY(ip, file_id, sha256)
+);
+
+CREATE TABLE IF NOT EXISTS access_policy (
+	id		BIGSERIAL NOT NULL PRIMARY KEY,
+	match_type	VARCHAR(16) NOT NULL,
+	match_value	VARCHAR(128) NOT NULL,
+	action		VARCHAR(32) NOT NULL,
+	reason		TEXT NOT NULL DEFAULT '',
+	created_by	VARCHAR(128) NOT NULL DEFAULT '',
+	created_at	TIMESTAMP NOT NULL,
+	expired_at	TIMESTAMP
+);
+
 CREATE INDEX IF NOT EXISTS idx_bin_id ON transaction(bin_id);
 CREATE INDEX IF NOT EXISTS idx_ip ON transaction(ip);
 CREATE INDEX IF NOT EXISTS idx_transaction_timestamp ON transaction(timestamp);
@@ -81,5 +227,46 @@ CREATE INDEX IF NOT EXISTS idx_file_content_in_storage ON file_content(in_storag
 CREATE INDEX IF NOT EXISTS idx_file_content_blocked ON file_content(blocked) WHERE blocked = true;
 CREATE INDEX IF NOT EXISTS idx_file_sha256_deleted ON file(sha256, deleted_at);
 CREATE INDEX IF NOT EXISTS idx_file_active ON file(bin_id, sha256) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_file_version_file_id ON file_version(file_id, replaced_at);
+CREATE INDEX IF NOT EXISTS idx_file_version_sha256 ON file_version(sha256);
+CREATE INDEX IF NOT EXISTS idx_client_usage_ip ON client_usage(ip, kind, created_at);
+CREATE INDEX IF NOT EXISTS idx_client_usage_network ON client_usage(network, kind, created_at);
+CREATE INDEX IF NOT EXISTS idx_client_usage_asn ON client_usage(asn, kind, created_at);
+CREATE INDEX IF NOT EXISTS idx_client_usage_expired_at ON client_usage(expired_at);
+CREATE INDEX IF NOT EXISTS idx_transfer_expired_at ON transfer(expired_at);
+CREATE INDEX IF NOT EXISTS idx_access_policy_expired_at ON access_policy(expired_at);
+CREATE INDEX IF NOT EXISTS idx_upload_expired_at ON upload(expired_at);
+CREATE INDEX IF NOT EXISTS idx_direct_upload_expired_at ON direct_upload(expired_at);
+CREATE INDEX IF NOT EXISTS idx_bin_alias_bin_id ON bin_alias(bin_id);
+CREATE INDEX IF NOT EXISTS idx_scan_sha256 ON scan(sha256, created_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_pending ON webhook_delivery(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_status ON webhook_delivery(status, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_active ON job(kind, sha256) WHERE status IN ('pending', 'running') AND file_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_job_pending ON job(run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_job_status ON job(status, finished_at);
 
 ALTER TABLE file_content ADD COLUMN IF NOT EXISTS phash VARCHAR(16);
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS owner_token_hash VARCHAR(128);
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS password_hash VARCHAR(256);
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE file ADD COLUMN IF NOT EXISTS encrypted_name TEXT;
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS scan_status VARCHAR(16);
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS scan_signature TEXT;
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS mime_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS phash_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE file_content ADD COLUMN IF NOT EXISTS thumbnail_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE file ALTER COLUMN filename TYPE VARCHAR(1024);
+ALTER TABLE upload ALTER COLUMN filename TYPE VARCHAR(1024);
+ALTER TABLE direct_upload ALTER COLUMN filename TYPE VARCHAR(1024);
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS expiration_seconds BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP;
+ALTER TABLE file ADD COLUMN IF NOT EXISTS download_limit BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS download_limit BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+ALTER TABLE bin ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE file ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE file ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE client ADD COLUMN IF NOT EXISTS ban_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE client ADD COLUMN IF NOT EXISTS ban_note TEXT NOT NULL DEFAULT '';
+ALTER TABLE client ADD COLUMN IF NOT EXISTS ban_expired_at TIMESTAMP;
@@ -0,0 +1,100 @@
+package dbl
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// ThumbnailDao tracks the thumbnails of image content that are stored in
+// S3. Thumbnails are keyed by the content checksum, so all files with the
+// same content share them.
+type ThumbnailDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+func (d *ThumbnailDao) query(name string, sqlStatement string, params ...interface{}) (thumbnails []ds.Thumbnail, err error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, params...)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return thumbnails, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var thumbnail ds.Thumbnail
+		if err := rows.Scan(&thumbnail.SHA256, &thumbnail.Size, &thumbnail.Width, &thumbnail.Height, &thumbnail.Bytes, &thumbnail.CreatedAt); err != nil {
+			return thumbnails, err
+		}
+		thumbnail.CreatedAt = thumbnail.CreatedAt.UTC()
+		thumbnails = append(thumbnails, thumbnail)
+	}
+	if err = rows.Err(); err != nil {
+		return thumbnails, err
+	}
+	return thumbnails, nil
+}
+
+// Upsert records a thumbnail, replacing a thumbnail of the same size that
+// was generated earlier
+func (d *ThumbnailDao) Upsert(thumbnail *ds.Thumbnail) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `INSERT INTO thumbnail (sha256, size, width, height, bytes, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (sha256, size) DO UPDATE SET width = EXCLUDED.width, height = EXCLUDED.height, bytes = EXCLUDED.bytes, created_at = EXCLUDED.created_at`
+	t0 := time.Now()
+	_, err := d.db.Exec(sqlStatement, thumbnail.SHA256, thumbnail.Size, thumbnail.Width, thumbnail.Height, thumbnail.Bytes, now)
+	observeQuery(d.metrics, "thumbnail_upsert", t0, err)
+	if err != nil {
+		return err
+	}
+	thumbnail.CreatedAt = now
+	return nil
+}
+
+func (d *ThumbnailDao) Get(sha256 string, size int) (thumbnail ds.Thumbnail, found bool, err error) {
+	thumbnails, err := d.query("thumbnail_get", "SELECT sha256, size, width, height, bytes, created_at FROM thumbnail WHERE sha256 = $1 AND size = $2", sha256, size)
+	if err != nil {
+		return thumbnail, false, err
+	}
+	if len(thumbnails) == 0 {
+		return thumbnail, false, nil
+	}
+	return thumbnails[0], true, nil
+}
+
+// GetBySHA256 returns the thumbnails of the content, smallest first
+func (d *ThumbnailDao) GetBySHA256(sha256 string) (thumbnails []ds.Thumbnail, err error) {
+	return d.query("thumbnail_get_by_sha256", "SELECT sha256, size, width, height, bytes, created_at FROM thumbnail WHERE sha256 = $1 ORDER BY size ASC", sha256)
+}
+
+// GetByBin returns the thumbnails of the content of the files in the bin
+func (d *ThumbnailDao) GetByBin(binId string) (thumbnails []ds.Thumbnail, err error) {
+	sqlStatement := `SELECT t.sha256, t.size, t.width, t.height, t.bytes, t.created_at FROM thumbnail t
+WHERE t.sha256 IN (SELECT f.sha256 FROM file f WHERE f.bin_id = $1 AND f.deleted_at IS NULL)
+ORDER BY t.sha256, t.size ASC`
+	return d.query("thumbnail_get_by_bin", sqlStatement, binId)
+}
+
+// DeleteBySHA256 removes the records of the thumbnails of the content, and
+// resets the thumbnail version of the content to have them generated again
+// if the content is uploaded again.
+func (d *ThumbnailDao) DeleteBySHA256(sha256 string) (retErr error) {
+	t0 := time.Now()
+	defer func() { observeQuery(d.metrics, "thumbnail_delete_by_sha256", t0, retErr) }()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM thumbnail WHERE sha256 = $1", sha256); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE file_content SET thumbnail_version = 0 WHERE sha256 = $1", sha256); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,106 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestThumbnails(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000004",
+		Bytes:     10,
+		MD5:       "00000000000000000000000000000004",
+		Mime:      "image/png",
+		InStorage: true,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := &ds.Bin{Id: "thumbnailbin", ExpiredAt: time.Now().UTC().Add(time.Hour * 24)}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+	file := &ds.File{Filename: "image.png", Bin: bin.Id, SHA256: content.SHA256, Bytes: content.Bytes, MD5: content.MD5, Mime: content.Mime}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := dao.Job().CountOutdated(ds.JobThumbnail, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 image without thumbnails, got %d", count)
+	}
+
+	for _, size := range ds.ThumbnailSizes {
+		thumbnail := &ds.Thumbnail{SHA256: content.SHA256, Size: size, Width: size, Height: size / 2, Bytes: 100}
+		if err := dao.Thumbnail().Upsert(thumbnail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Generating a thumbnail again replaces it
+	thumbnail := &ds.Thumbnail{SHA256: content.SHA256, Size: ds.ThumbnailSmall, Width: 10, Height: 20, Bytes: 200}
+	if err := dao.Thumbnail().Upsert(thumbnail); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.FileContent().SetThumbnailVersion(content.SHA256, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	found, ok, err := dao.Thumbnail().Get(content.SHA256, ds.ThumbnailSmall)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Expected to find the thumbnail")
+	}
+	if found.Width != 10 || found.Height != 20 || found.Bytes != 200 {
+		t.Errorf("Expected the replaced thumbnail, got %+v", found)
+	}
+
+	thumbnails, err := dao.Thumbnail().GetByBin(bin.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != len(ds.ThumbnailSizes) {
+		t.Errorf("Expected %d thumbnails in the bin, got %d", len(ds.ThumbnailSizes), len(thumbnails))
+	}
+
+	count, err = dao.Job().CountOutdated(ds.JobThumbnail, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no images without thumbnails, got %d", count)
+	}
+
+	// Deleting the thumbnails has them generated again
+	if err := dao.Thumbnail().DeleteBySHA256(content.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	thumbnails, err = dao.Thumbnail().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != 0 {
+		t.Errorf("Expected no thumbnails, got %d", len(thumbnails))
+	}
+	updated, err := dao.FileContent().GetBySHA256(content.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ThumbnailVersion != 0 {
+		t.Errorf("Expected the thumbnail version to be reset, got %d", updated.ThumbnailVersion)
+	}
+}
@@ -0,0 +1,95 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// TransferDao keeps the transfers of files that are streamed through
+// filebin to clients. The transfers are kept in the database, so that the
+// range requests of a client are counted the same way by all instances.
+type TransferDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// Reserve adds the bytes that a client requests of a file to the transfer
+// of the file to the client, and keeps the transfer until the expiration
+// time. A new transfer is started if the client has no transfer of the
+// file, or the transfer has expired. The downloads function returns the
+// number of downloads that a number of bytes amounts to, and the transfer
+// is counted as more downloads when its bytes amount to more downloads than
+// it has been counted as. Concurrent requests from the client are
+// serialized by the lock on the transfer, so that each download is counted
+// by exactly one request. The number of downloads that were added is
+// returned.
+func (d *TransferDao) Reserve(transfer *ds.Transfer, requested uint64, expiredAt time.Time, downloads func(bytes uint64) uint64) (added uint64, retErr error) {
+	t0 := time.Now()
+	defer func() { observeQuery(d.metrics, "transfer_reserve", t0, retErr) }()
+
+	if transfer.IP == "" {
+		return 0, errors.New("client ip not specified")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	expiredAt = expiredAt.UTC().Truncate(time.Microsecond)
+	sqlReserve := `INSERT INTO transfer (ip, file_id, sha256, bytes, downloads, created_at, expired_at) VALUES ($1, $2, $3, $4, 0, $5, $6)
+ON CONFLICT (ip, file_id, sha256) DO UPDATE SET
+    bytes = CASE WHEN transfer.expired_at <= $5 THEN EXCLUDED.bytes ELSE transfer.bytes + EXCLUDED.bytes END,
+    downloads = CASE WHEN transfer.expired_at <= $5 THEN 0 ELSE transfer.downloads END,
+    created_at = CASE WHEN transfer.expired_at <= $5 THEN EXCLUDED.created_at ELSE transfer.created_at END,
+    expired_at = EXCLUDED.expired_at
+RETURNING bytes, downloads, created_at, expired_at`
+	if err := tx.QueryRow(sqlReserve, transfer.IP, transfer.FileId, transfer.SHA256, requested, now, expiredAt).Scan(&transfer.Bytes, &transfer.Downloads, &transfer.CreatedAt, &transfer.ExpiredAt); err != nil {
+		return 0, err
+	}
+	transfer.CreatedAt = transfer.CreatedAt.UTC()
+	transfer.ExpiredAt = transfer.ExpiredAt.UTC()
+
+	if n := downloads(transfer.Bytes); n > transfer.Downloads {
+		sqlCount := "UPDATE transfer SET downloads = $4 WHERE ip = $1 AND file_id = $2 AND sha256 = $3"
+		if _, err := tx.Exec(sqlCount, transfer.IP, transfer.FileId, transfer.SHA256, n); err != nil {
+			return 0, err
+		}
+		added = n - transfer.Downloads
+		transfer.Downloads = n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// Adjust corrects the bytes and the downloads of the transfer, such as by
+// the difference between the bytes that were reserved and the bytes that
+// were sent
+func (d *TransferDao) Adjust(transfer *ds.Transfer, bytes int64, downloads int64) error {
+	sqlStatement := "UPDATE transfer SET bytes = GREATEST(bytes + $4, 0), downloads = GREATEST(downloads + $5, 0) WHERE ip = $1 AND file_id = $2 AND sha256 = $3"
+	t0 := time.Now()
+	_, err := d.db.Exec(sqlStatement, transfer.IP, transfer.FileId, transfer.SHA256, bytes, downloads)
+	observeQuery(d.metrics, "transfer_adjust", t0, err)
+	return err
+}
+
+// Cleanup removes the transfers that have expired, and returns the number
+// of transfers removed
+func (d *TransferDao) Cleanup() (count int64, err error) {
+	sqlStatement := "DELETE FROM transfer WHERE expired_at < $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, time.Now().UTC())
+	observeQuery(d.metrics, "transfer_cleanup", t0, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
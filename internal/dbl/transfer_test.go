@@ -0,0 +1,97 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestTransfer(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	content := &ds.FileContent{
+		SHA256:    "0000000000000000000000000000000000000000000000000000000000000005",
+		Bytes:     100,
+		MD5:       "00000000000000000000000000000005",
+		InStorage: true,
+	}
+	if err := dao.FileContent().InsertOrIncrement(content); err != nil {
+		t.Fatal(err)
+	}
+	bin := &ds.Bin{Id: "transferbin", ExpiredAt: time.Now().UTC().Add(time.Hour * 24)}
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+	file := &ds.File{Filename: "a", Bin: bin.Id, SHA256: content.SHA256, Bytes: content.Bytes, MD5: content.MD5}
+	if _, err := dao.File().Insert(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// A download for every 100 bytes
+	downloads := func(bytes uint64) uint64 {
+		return bytes / 100
+	}
+	expiredAt := time.Now().UTC().Add(time.Hour)
+	tests := []struct {
+		requested uint64
+		added     uint64
+		bytes     uint64
+	}{
+		{60, 0, 60},
+		{60, 1, 120},
+		{60, 0, 180},
+		{100, 1, 280},
+	}
+	for _, test := range tests {
+		transfer := ds.Transfer{IP: "192.0.2.1", FileId: file.Id, SHA256: file.SHA256}
+		added, err := dao.Transfer().Reserve(&transfer, test.requested, expiredAt, downloads)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != test.added || transfer.Bytes != test.bytes {
+			t.Errorf("Expected %d added downloads and %d bytes, got %d and %d", test.added, test.bytes, added, transfer.Bytes)
+		}
+	}
+
+	transfer := ds.Transfer{IP: "192.0.2.1", FileId: file.Id, SHA256: file.SHA256}
+	if err := dao.Transfer().Adjust(&transfer, -100, -1); err != nil {
+		t.Fatal(err)
+	}
+	added, err := dao.Transfer().Reserve(&transfer, 20, expiredAt, downloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || transfer.Bytes != 200 || transfer.Downloads != 2 {
+		t.Errorf("Expected the adjusted transfer to be counted again, got %d added downloads, %d bytes and %d downloads", added, transfer.Bytes, transfer.Downloads)
+	}
+
+	if _, err := dao.Transfer().Reserve(&ds.Transfer{FileId: file.Id, SHA256: file.SHA256}, 10, expiredAt, downloads); err == nil {
+		t.Errorf("Expected an error for a transfer without an ip address")
+	}
+
+	// An expired transfer starts over
+	other := ds.Transfer{IP: "192.0.2.2", FileId: file.Id, SHA256: file.SHA256}
+	if _, err := dao.Transfer().Reserve(&other, 150, time.Now().UTC().Add(-time.Minute), downloads); err != nil {
+		t.Fatal(err)
+	}
+	added, err = dao.Transfer().Reserve(&other, 50, time.Now().UTC().Add(-time.Minute), downloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || other.Bytes != 50 {
+		t.Errorf("Expected the expired transfer to start over, got %d added downloads and %d bytes", added, other.Bytes)
+	}
+
+	count, err := dao.Transfer().Cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 expired transfer to be removed, got %d", count)
+	}
+}
@@ -0,0 +1,138 @@
+package dbl
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+type UploadDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+// GenerateId returns a random identifier for a resumable upload. The
+// identifier is part of the upload URL and acts as the capability to
+// resume the upload, so it needs to be hard to guess.
+func (d *UploadDao) GenerateId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (d *UploadDao) GetByID(id string) (upload ds.Upload, found bool, err error) {
+	sqlStatement := "SELECT id, bin_id, filename, bytes, upload_offset, part_size, object_key, storage_upload_id, ip, updated_at, created_at, expired_at FROM upload WHERE id = $1 LIMIT 1"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, id).Scan(&upload.Id, &upload.Bin, &upload.Filename, &upload.Bytes, &upload.Offset, &upload.PartSize, &upload.ObjectKey, &upload.StorageUploadId, &upload.IP, &upload.UpdatedAt, &upload.CreatedAt, &upload.ExpiredAt)
+	observeQuery(d.metrics, "upload_get_by_id", t0, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return upload, false, nil
+		}
+		return upload, false, err
+	}
+	hydrateUpload(&upload)
+	return upload, true, nil
+}
+
+func (d *UploadDao) Insert(upload *ds.Upload) (err error) {
+	if upload.Id == "" {
+		return errors.New("upload id not specified")
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	upload.ExpiredAt = upload.ExpiredAt.UTC().Truncate(time.Microsecond)
+	sqlStatement := "INSERT INTO upload (id, bin_id, filename, bytes, upload_offset, part_size, object_key, storage_upload_id, ip, updated_at, created_at, expired_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id"
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, upload.Id, upload.Bin, upload.Filename, upload.Bytes, upload.Offset, upload.PartSize, upload.ObjectKey, upload.StorageUploadId, upload.IP, now, now, upload.ExpiredAt).Scan(&upload.Id)
+	observeQuery(d.metrics, "upload_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	upload.UpdatedAt = now
+	upload.CreatedAt = now
+	hydrateUpload(upload)
+	return nil
+}
+
+// UpdateOffset moves the offset of the upload from the given previous value
+// to upload.Offset, and extends the expiry to upload.ExpiredAt. It returns false if the offset in the database did not
+// match the previous value, which means that another request has written to
+// the upload in the meantime.
+func (d *UploadDao) UpdateOffset(upload *ds.Upload, previous uint64) (updated bool, err error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	upload.ExpiredAt = upload.ExpiredAt.UTC().Truncate(time.Microsecond)
+	sqlStatement := "UPDATE upload SET upload_offset = $1, updated_at = $2, expired_at = $3 WHERE id = $4 AND upload_offset = $5 RETURNING id"
+	var id string
+	t0 := time.Now()
+	err = d.db.QueryRow(sqlStatement, upload.Offset, now, upload.ExpiredAt, upload.Id, previous).Scan(&id)
+	observeQuery(d.metrics, "upload_update_offset", t0, err)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	upload.UpdatedAt = now
+	hydrateUpload(upload)
+	return true, nil
+}
+
+func (d *UploadDao) Delete(upload *ds.Upload) (err error) {
+	sqlStatement := "DELETE FROM upload WHERE id = $1"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, upload.Id)
+	observeQuery(d.metrics, "upload_delete", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("upload does not exist")
+	}
+	return nil
+}
+
+// GetPendingDelete returns uploads that have expired before they were
+// completed, or that belong to bins that are no longer writable.
+func (d *UploadDao) GetPendingDelete() (uploads []ds.Upload, err error) {
+	sqlStatement := "SELECT u.id, u.bin_id, u.filename, u.bytes, u.upload_offset, u.part_size, u.object_key, u.storage_upload_id, u.ip, u.updated_at, u.created_at, u.expired_at FROM upload u JOIN bin b ON u.bin_id = b.id WHERE u.expired_at < NOW() OR (b.expired_at < NOW() AND b.pinned_at IS NULL) OR b.deleted_at IS NOT NULL OR b.readonly = true ORDER BY u.expired_at ASC"
+	t0 := time.Now()
+	uploads, err = d.uploadQuery(sqlStatement)
+	observeQuery(d.metrics, "upload_get_pending_delete", t0, err)
+	return uploads, err
+}
+
+func (d *UploadDao) GetAll() (uploads []ds.Upload, err error) {
+	sqlStatement := "SELECT id, bin_id, filename, bytes, upload_offset, part_size, object_key, storage_upload_id, ip, updated_at, created_at, expired_at FROM upload ORDER BY updated_at DESC"
+	t0 := time.Now()
+	uploads, err = d.uploadQuery(sqlStatement)
+	observeQuery(d.metrics, "upload_get_all", t0, err)
+	return uploads, err
+}
+
+func (d *UploadDao) uploadQuery(sqlStatement string, params ...interface{}) (uploads []ds.Upload, err error) {
+	rows, err := d.db.Query(sqlStatement, params...)
+	if err != nil {
+		return uploads, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var upload ds.Upload
+		err = rows.Scan(&upload.Id, &upload.Bin, &upload.Filename, &upload.Bytes, &upload.Offset, &upload.PartSize, &upload.ObjectKey, &upload.StorageUploadId, &upload.IP, &upload.UpdatedAt, &upload.CreatedAt, &upload.ExpiredAt)
+		if err != nil {
+			return uploads, err
+		}
+		hydrateUpload(&upload)
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
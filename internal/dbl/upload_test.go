@@ -0,0 +1,127 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestUploadLifecycle(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "uploadbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := &ds.Upload{
+		Id:              dao.Upload().GenerateId(),
+		Bin:             bin.Id,
+		Filename:        "large.bin",
+		Bytes:           100,
+		PartSize:        64,
+		ObjectKey:       "staging/upload-test",
+		StorageUploadId: "storage-upload-test",
+		IP:              "127.0.0.1",
+		ExpiredAt:       time.Now().UTC().Add(time.Hour * 1),
+	}
+	if err := dao.Upload().Insert(upload); err != nil {
+		t.Fatal(err)
+	}
+
+	dbUpload, found, err := dao.Upload().GetByID(upload.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Fatal("Expected found to be true as the upload exists.")
+	}
+	if dbUpload.Offset != 0 {
+		t.Errorf("Was expecting offset 0, got %d", dbUpload.Offset)
+	}
+	if dbUpload.Bytes != 100 {
+		t.Errorf("Was expecting 100 bytes, got %d", dbUpload.Bytes)
+	}
+	if dbUpload.ObjectKey != upload.ObjectKey || dbUpload.StorageUploadId != upload.StorageUploadId || dbUpload.PartSize != upload.PartSize {
+		t.Errorf("Was expecting the staging of the upload to be kept, got %q, %q and %d", dbUpload.ObjectKey, dbUpload.StorageUploadId, dbUpload.PartSize)
+	}
+
+	dbUpload.Offset = 60
+	updated, err := dao.Upload().UpdateOffset(&dbUpload, 0)
+	if err != nil {
+		t.Error(err)
+	}
+	if !updated {
+		t.Error("Expected the offset to be updated")
+	}
+
+	// A stale previous offset must not overwrite the current one
+	dbUpload.Offset = 80
+	updated, err = dao.Upload().UpdateOffset(&dbUpload, 0)
+	if err != nil {
+		t.Error(err)
+	}
+	if updated {
+		t.Error("Expected the offset update to be rejected")
+	}
+
+	dbUpload, _, err = dao.Upload().GetByID(upload.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if dbUpload.Offset != 60 {
+		t.Errorf("Was expecting offset 60, got %d", dbUpload.Offset)
+	}
+
+	if err := dao.Upload().Delete(&dbUpload); err != nil {
+		t.Error(err)
+	}
+	_, found, err = dao.Upload().GetByID(upload.Id)
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Error("Expected found to be false as the upload was deleted.")
+	}
+}
+
+func TestGetPendingDeleteUploads(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Error(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	bin := &ds.Bin{}
+	bin.Id = "uploadbin"
+	bin.ExpiredAt = time.Now().UTC().Add(time.Hour * 1)
+	if _, err := dao.Bin().Insert(bin); err != nil {
+		t.Fatal(err)
+	}
+
+	active := &ds.Upload{Id: dao.Upload().GenerateId(), Bin: bin.Id, Filename: "active", Bytes: 10, ExpiredAt: time.Now().UTC().Add(time.Hour)}
+	expired := &ds.Upload{Id: dao.Upload().GenerateId(), Bin: bin.Id, Filename: "expired", Bytes: 10, ExpiredAt: time.Now().UTC().Add(-time.Hour)}
+	for _, upload := range []*ds.Upload{active, expired} {
+		if err := dao.Upload().Insert(upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uploads, err := dao.Upload().GetPendingDelete()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(uploads) != 1 {
+		t.Fatalf("Was expecting 1 upload pending delete, got %d", len(uploads))
+	}
+	if uploads[0].Id != expired.Id {
+		t.Errorf("Was expecting upload %s to be pending delete, got %s", expired.Id, uploads[0].Id)
+	}
+}
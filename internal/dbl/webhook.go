@@ -0,0 +1,178 @@
+package dbl
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// WebhookDao is the outbox of webhook deliveries. Deliveries are inserted
+// together with the change that caused the event, and are delivered by a
+// background worker.
+type WebhookDao struct {
+	db      *sql.DB
+	metrics DBMetricsObserver
+}
+
+const webhookDeliveryColumns = "id, event, url, payload, status, attempts, COALESCE(last_status_code, 0), COALESCE(last_error, ''), next_attempt_at, created_at, delivered_at"
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }, delivery *ds.WebhookDelivery) error {
+	return row.Scan(
+		&delivery.Id,
+		&delivery.Event,
+		&delivery.URL,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.NextAttemptAt,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	)
+}
+
+func (d *WebhookDao) query(name string, sqlStatement string, params ...interface{}) (deliveries []ds.WebhookDelivery, err error) {
+	t0 := time.Now()
+	rows, err := d.db.Query(sqlStatement, params...)
+	observeQuery(d.metrics, name, t0, err)
+	if err != nil {
+		return deliveries, err
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var delivery ds.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			return deliveries, err
+		}
+		hydrateWebhookDelivery(&delivery)
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// Insert adds a pending delivery to the outbox, due immediately
+func (d *WebhookDao) Insert(delivery *ds.WebhookDelivery) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	delivery.Status = ds.WebhookPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.CreatedAt = now
+	sqlStatement := "INSERT INTO webhook_delivery (event, url, payload, status, attempts, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	t0 := time.Now()
+	err := d.db.QueryRow(sqlStatement, delivery.Event, delivery.URL, delivery.Payload, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.CreatedAt).Scan(&delivery.Id)
+	observeQuery(d.metrics, "webhook_insert", t0, err)
+	if err != nil {
+		return err
+	}
+	hydrateWebhookDelivery(delivery)
+	return nil
+}
+
+func (d *WebhookDao) GetByID(id int64) (delivery ds.WebhookDelivery, found bool, err error) {
+	sqlStatement := "SELECT " + webhookDeliveryColumns + " FROM webhook_delivery WHERE id = $1"
+	t0 := time.Now()
+	err = scanWebhookDelivery(d.db.QueryRow(sqlStatement, id), &delivery)
+	observeQuery(d.metrics, "webhook_get_by_id", t0, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return delivery, false, nil
+		}
+		return delivery, false, err
+	}
+	hydrateWebhookDelivery(&delivery)
+	return delivery, true, nil
+}
+
+// Claim returns up to limit pending deliveries that are due, and postpones
+// them by the lease. Deliveries claimed by one worker are skipped by other
+// workers until the lease has passed, which only happens if the worker
+// stopped before the outcome of the delivery was recorded.
+func (d *WebhookDao) Claim(limit int, lease time.Duration) (deliveries []ds.WebhookDelivery, err error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := `UPDATE webhook_delivery SET next_attempt_at = $2
+WHERE id IN (
+    SELECT id FROM webhook_delivery
+    WHERE status = 'pending' AND next_attempt_at <= $1
+    ORDER BY next_attempt_at ASC
+    LIMIT $3
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + webhookDeliveryColumns
+	return d.query("webhook_claim", sqlStatement, now, now.Add(lease), limit)
+}
+
+// Update records the outcome of a delivery attempt
+func (d *WebhookDao) Update(delivery *ds.WebhookDelivery) error {
+	var lastStatusCode sql.NullInt64
+	if delivery.LastStatusCode != 0 {
+		lastStatusCode = sql.NullInt64{Int64: int64(delivery.LastStatusCode), Valid: true}
+	}
+	sqlStatement := "UPDATE webhook_delivery SET status = $1, attempts = $2, last_status_code = $3, last_error = $4, next_attempt_at = $5, delivered_at = $6 WHERE id = $7"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, delivery.Status, delivery.Attempts, lastStatusCode, nullString(delivery.LastError), delivery.NextAttemptAt.UTC(), delivery.DeliveredAt, delivery.Id)
+	observeQuery(d.metrics, "webhook_update", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("Webhook delivery does not exist")
+	}
+	hydrateWebhookDelivery(delivery)
+	return nil
+}
+
+// GetUndelivered returns the deliveries that have failed, and the pending
+// deliveries that have failed at least once, newest first
+func (d *WebhookDao) GetUndelivered(limit int) (deliveries []ds.WebhookDelivery, err error) {
+	sqlStatement := "SELECT " + webhookDeliveryColumns + " FROM webhook_delivery WHERE status = 'failed' OR (status = 'pending' AND attempts > 0) ORDER BY created_at DESC LIMIT $1"
+	return d.query("webhook_get_undelivered", sqlStatement, limit)
+}
+
+// Replay makes a failed delivery pending again, to be delivered as soon as
+// possible with a fresh set of attempts
+func (d *WebhookDao) Replay(id int64) error {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	sqlStatement := "UPDATE webhook_delivery SET status = 'pending', attempts = 0, next_attempt_at = $1 WHERE id = $2 AND status = 'failed'"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, now, id)
+	observeQuery(d.metrics, "webhook_replay", t0, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("No failed webhook delivery with this id")
+	}
+	return nil
+}
+
+// Cleanup removes deliveries that were delivered or failed more than the
+// retention in days ago
+func (d *WebhookDao) Cleanup(retention uint64) (count int64, err error) {
+	sqlStatement := "DELETE FROM webhook_delivery WHERE status != 'pending' AND created_at < NOW() - ($1 || ' days')::interval"
+	t0 := time.Now()
+	res, err := d.db.Exec(sqlStatement, retention)
+	observeQuery(d.metrics, "webhook_cleanup", t0, err)
+	if err != nil {
+		return count, err
+	}
+	n, err := res.RowsAffected()
+	count = n
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
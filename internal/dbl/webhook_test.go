@@ -0,0 +1,129 @@
+package dbl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	dao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(dao) }()
+
+	delivery := &ds.WebhookDelivery{
+		Event:   "bin.created",
+		URL:     "https://example.com/hook",
+		Payload: `{"event":"bin.created"}`,
+	}
+	if err := dao.Webhook().Insert(delivery); err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Id == 0 {
+		t.Error("Expected the delivery to get an id")
+	}
+	if delivery.Status != ds.WebhookPending {
+		t.Errorf("Expected status %q, got %q", ds.WebhookPending, delivery.Status)
+	}
+
+	// The delivery is due immediately
+	claimed, err := dao.Webhook().Claim(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Id != delivery.Id {
+		t.Fatalf("Expected to claim the delivery, got %+v", claimed)
+	}
+	if claimed[0].Payload != delivery.Payload {
+		t.Errorf("Expected payload %q, got %q", delivery.Payload, claimed[0].Payload)
+	}
+
+	// Claimed deliveries are leased, and can not be claimed again
+	claimed2, err := dao.Webhook().Claim(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed2) != 0 {
+		t.Errorf("Expected no deliveries to claim during the lease, got %d", len(claimed2))
+	}
+
+	// Record a failed attempt
+	failed := claimed[0]
+	failed.Attempts = 1
+	failed.LastStatusCode = 500
+	failed.LastError = "unexpected response status 500"
+	failed.NextAttemptAt = time.Now().UTC().Add(time.Hour)
+	if err := dao.Webhook().Update(&failed); err != nil {
+		t.Fatal(err)
+	}
+
+	undelivered, err := dao.Webhook().GetUndelivered(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undelivered) != 1 || undelivered[0].LastStatusCode != 500 || undelivered[0].LastError != failed.LastError {
+		t.Errorf("Expected the retrying delivery to be listed, got %+v", undelivered)
+	}
+
+	// Pending deliveries can not be replayed
+	if err := dao.Webhook().Replay(failed.Id); err == nil {
+		t.Error("Expected an error when replaying a pending delivery")
+	}
+
+	// Give up on the delivery
+	failed.Status = ds.WebhookFailed
+	failed.Attempts = 10
+	if err := dao.Webhook().Update(&failed); err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.Webhook().Replay(failed.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	dbDelivery, found, err := dao.Webhook().GetByID(failed.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("Expected to find the delivery")
+	}
+	if dbDelivery.Status != ds.WebhookPending || dbDelivery.Attempts != 0 {
+		t.Errorf("Expected a replayed delivery to be pending with no attempts, got %q with %d attempts", dbDelivery.Status, dbDelivery.Attempts)
+	}
+
+	// Replayed deliveries are due immediately
+	claimed, err = dao.Webhook().Claim(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("Expected to claim the replayed delivery, got %d", len(claimed))
+	}
+	delivered := claimed[0]
+	delivered.Status = ds.WebhookDelivered
+	delivered.Attempts = 1
+	delivered.LastStatusCode = 200
+	delivered.LastError = ""
+	_ = delivered.DeliveredAt.Scan(time.Now().UTC())
+	if err := dao.Webhook().Update(&delivered); err != nil {
+		t.Fatal(err)
+	}
+
+	undelivered, err = dao.Webhook().GetUndelivered(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undelivered) != 0 {
+		t.Errorf("Expected no undelivered deliveries, got %d", len(undelivered))
+	}
+
+	if _, found, err := dao.Webhook().GetByID(-1); err != nil || found {
+		t.Errorf("Expected to not find a delivery that does not exist (found %t, err %v)", found, err)
+	}
+	if err := dao.Webhook().Update(&ds.WebhookDelivery{Id: -1, Status: ds.WebhookPending}); err == nil {
+		t.Error("Expected an error when updating a delivery that does not exist")
+	}
+}
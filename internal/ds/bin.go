@@ -1,14 +1,43 @@
 package ds
 
 import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/url"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Parameters for the salted password hashes of password protected bins
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 600000
+	passwordHashSaltLength = 16
+	passwordHashKeyLength  = 32
+)
+
+// The expiry policies of bins. Bins expire after the configured expiration
+// time by default, or after the lifetime requested for the bin. Pinned bins
+// never expire.
+const (
+	ExpirationPolicyDefault = "default"
+	ExpirationPolicyCustom  = "custom"
+	ExpirationPolicyPinned  = "pinned"
+)
+
 type Bin struct {
 	Id                 string       `json:"id"`
+	Title              string       `json:"title,omitempty"`
+	Description        string       `json:"description,omitempty"`
 	Readonly           bool         `json:"readonly"`
 	Downloads          uint64       `json:"-"`
 	FileDownloads      uint64       `json:"-"`
@@ -27,6 +56,15 @@ type Bin struct {
 	DeletedAt          sql.NullTime `json:"-"`
 	DeletedAtRelative  string       `json:"-"`
 	URL                string       `json:"-"`
+	OwnerToken         string       `json:"owner_token,omitempty"`
+	OwnerTokenHash     string       `json:"-"`
+	PasswordHash       string       `json:"-"`
+	Encrypted          bool         `json:"encrypted"`
+	ExpirationSeconds  int64        `json:"expiration_seconds,omitempty"`
+	ExpirationPolicy   string       `json:"expiration_policy"`
+	PinnedAt           sql.NullTime `json:"-"`
+	PinnedAtRelative   string       `json:"-"`
+	DownloadLimit      uint64       `json:"download_limit,omitempty"`
 }
 
 func (b *Bin) IsReadable() bool {
@@ -54,9 +92,39 @@ func (b *Bin) IsWritable() bool {
 }
 
 func (b *Bin) IsExpired() bool {
+	// Pinned bins never expire
+	if b.IsPinned() {
+		return false
+	}
 	return b.ExpiredAt.Before(time.Now())
 }
 
+// IsPinned returns true if an admin has pinned the bin so that it never
+// expires
+func (b *Bin) IsPinned() bool {
+	return b.PinnedAt.Valid && !b.PinnedAt.Time.IsZero()
+}
+
+// Lifetime returns the time the bin is kept after the last update, which is
+// the lifetime requested for the bin or the default if none was requested
+func (b *Bin) Lifetime(defaultLifetime time.Duration) time.Duration {
+	if b.ExpirationSeconds > 0 {
+		return time.Duration(b.ExpirationSeconds) * time.Second
+	}
+	return defaultLifetime
+}
+
+// ExpiryPolicy returns the expiry policy that applies to the bin
+func (b *Bin) ExpiryPolicy() string {
+	if b.IsPinned() {
+		return ExpirationPolicyPinned
+	}
+	if b.ExpirationSeconds > 0 {
+		return ExpirationPolicyCustom
+	}
+	return ExpirationPolicyDefault
+}
+
 func (b *Bin) IsApproved() bool {
 	return b.ApprovedAt.Valid && !b.ApprovedAt.Time.IsZero()
 }
@@ -69,3 +137,102 @@ func (b *Bin) GenerateURL(u url.URL) {
 	u.Path = path.Join(u.Path, b.Id)
 	b.URL = u.String()
 }
+
+// GenerateOwnerToken creates a new secret owner token for the bin. Only the
+// hash of the token is stored, so the token itself is available in
+// OwnerToken until the bin is fetched from the database again.
+func (b *Bin) GenerateOwnerToken() error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	b.OwnerToken = hex.EncodeToString(buf)
+	b.OwnerTokenHash = HashOwnerToken(b.OwnerToken)
+	return nil
+}
+
+// HasOwner returns true if the bin was created with an owner token. Bins
+// created before owner tokens were introduced do not have one.
+func (b *Bin) HasOwner() bool {
+	return b.OwnerTokenHash != ""
+}
+
+// IsOwnerToken returns true if the token matches the owner token of the bin.
+func (b *Bin) IsOwnerToken(token string) bool {
+	if !b.HasOwner() || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashOwnerToken(token)), []byte(b.OwnerTokenHash)) == 1
+}
+
+// HashOwnerToken returns the hex encoded SHA256 hash of an owner token. The
+// tokens are random, so there is no need for a salt.
+func HashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetPassword protects the bin with a password. Only a salted hash of the
+// password is kept. An empty password removes the protection.
+func (b *Bin) SetPassword(password string) error {
+	if password == "" {
+		b.PasswordHash = ""
+		return nil
+	}
+	hash, err := HashBinPassword(password)
+	if err != nil {
+		return err
+	}
+	b.PasswordHash = hash
+	return nil
+}
+
+// HasPassword returns true if the bin is password protected
+func (b *Bin) HasPassword() bool {
+	return b.PasswordHash != ""
+}
+
+// IsPassword returns true if the password matches the password of the bin
+func (b *Bin) IsPassword(password string) bool {
+	if !b.HasPassword() || password == "" {
+		return false
+	}
+	fields := strings.Split(b.PasswordHash, "$")
+	if len(fields) != 4 || fields[0] != passwordHashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// HashBinPassword returns a salted PBKDF2 hash of a bin password, encoded
+// together with the parameters needed to verify it.
+func HashBinPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+	salt := make([]byte, passwordHashSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, passwordHashKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
@@ -0,0 +1,52 @@
+package ds
+
+import (
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// BinAlias is a read-only share URL for a bin. The alias resolves to the
+// files in the bin for viewing and downloading, but does not reveal the bin
+// itself, which is the capability to upload to and delete from it.
+type BinAlias struct {
+	Id                string     `json:"id"`
+	Bin               string     `json:"-"`
+	URL               string     `json:"url"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CreatedAtRelative string     `json:"created_at_relative"`
+	ExpiredAt         *time.Time `json:"expired_at,omitempty"`
+	ExpiredAtRelative string     `json:"expired_at_relative,omitempty"`
+}
+
+// IsExpired returns true if the alias has an expiry that has passed. Aliases
+// without expiry are valid for as long as the bin is.
+func (a *BinAlias) IsExpired() bool {
+	return a.ExpiredAt != nil && a.ExpiredAt.Before(time.Now())
+}
+
+func (a *BinAlias) GenerateURL(u url.URL) {
+	u.Path = path.Join(u.Path, a.Id)
+	a.URL = u.String()
+}
+
+// Mask presents the bin and its files under the alias, so that the bin id
+// is not exposed to clients that only hold the alias. The bin is presented
+// as read only, and with the expiry of the alias if that is earlier.
+func (a *BinAlias) Mask(bin *Bin, files []File) {
+	bin.Id = a.Id
+	bin.URL = path.Join("/", a.Id)
+	bin.Readonly = true
+	bin.OwnerToken = ""
+	bin.OwnerTokenHash = ""
+	if a.ExpiredAt != nil && a.ExpiredAt.Before(bin.ExpiredAt) {
+		bin.ExpiredAt = *a.ExpiredAt
+		bin.ExpiredAtRelative = humanize.Time(bin.ExpiredAt)
+	}
+	for i := range files {
+		files[i].Bin = a.Id
+		files[i].URL = path.Join("/", a.Id, files[i].Filename)
+	}
+}
@@ -0,0 +1,116 @@
+package ds
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBinAliasIsExpired(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		expiredAt *time.Time
+		want      bool
+	}{
+		{
+			name:      "no expiry",
+			expiredAt: nil,
+			want:      false,
+		},
+		{
+			name:      "expired",
+			expiredAt: &past,
+			want:      true,
+		},
+		{
+			name:      "not expired",
+			expiredAt: &future,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alias := &BinAlias{ExpiredAt: tt.expiredAt}
+			if got := alias.IsExpired(); got != tt.want {
+				t.Errorf("IsExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinAliasGenerateURL(t *testing.T) {
+	alias := &BinAlias{Id: "sharedalias"}
+	u, err := url.Parse("https://filebin.net/app")
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+	alias.GenerateURL(*u)
+	if want := "https://filebin.net/app/sharedalias"; alias.URL != want {
+		t.Errorf("GenerateURL() set URL = %v, want %v", alias.URL, want)
+	}
+}
+
+func TestBinAliasMask(t *testing.T) {
+	binExpiry := time.Now().Add(24 * time.Hour)
+	earlier := time.Now().Add(time.Hour)
+	later := time.Now().Add(48 * time.Hour)
+
+	tests := []struct {
+		name       string
+		expiredAt  *time.Time
+		wantExpiry time.Time
+	}{
+		{
+			name:       "alias without expiry",
+			expiredAt:  nil,
+			wantExpiry: binExpiry,
+		},
+		{
+			name:       "alias expires before the bin",
+			expiredAt:  &earlier,
+			wantExpiry: earlier,
+		},
+		{
+			name:       "alias expires after the bin",
+			expiredAt:  &later,
+			wantExpiry: binExpiry,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := &Bin{Id: "secretbin", URL: "/secretbin", ExpiredAt: binExpiry, OwnerTokenHash: "hash"}
+			files := []File{
+				{Bin: "secretbin", Filename: "a.txt", URL: "/secretbin/a.txt"},
+				{Bin: "secretbin", Filename: "b.txt", URL: "/secretbin/b.txt"},
+			}
+			alias := &BinAlias{Id: "sharedalias", Bin: "secretbin", ExpiredAt: tt.expiredAt}
+			alias.Mask(bin, files)
+
+			if bin.Id != "sharedalias" || bin.URL != "/sharedalias" {
+				t.Errorf("Expected the bin to be presented as the alias, got id %q and url %q", bin.Id, bin.URL)
+			}
+			if !bin.Readonly {
+				t.Error("Expected the bin to be presented as read only")
+			}
+			if bin.HasOwner() {
+				t.Error("Did not expect the owner token hash to be kept")
+			}
+			if !bin.ExpiredAt.Equal(tt.wantExpiry) {
+				t.Errorf("Expected expiry %v, got %v", tt.wantExpiry, bin.ExpiredAt)
+			}
+			for _, file := range files {
+				if file.Bin != "sharedalias" {
+					t.Errorf("Expected file bin to be the alias, got %q", file.Bin)
+				}
+				if want := "/sharedalias/" + file.Filename; file.URL != want {
+					t.Errorf("Expected file URL %q, got %q", want, file.URL)
+				}
+			}
+		})
+	}
+}
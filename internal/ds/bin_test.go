@@ -3,6 +3,7 @@ package ds
 import (
 	"database/sql"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
@@ -40,6 +41,68 @@ func TestBinIsExpired(t *testing.T) {
 	}
 }
 
+func TestBinIsPinned(t *testing.T) {
+	pinnedAt := sql.NullTime{Time: time.Now(), Valid: true}
+	bin := &Bin{ExpiredAt: time.Now().Add(-1 * time.Hour), PinnedAt: pinnedAt}
+	if !bin.IsPinned() {
+		t.Error("Expected the bin to be pinned")
+	}
+	if bin.IsExpired() {
+		t.Error("Expected pinned bin to never expire")
+	}
+	if !bin.IsReadable() {
+		t.Error("Expected pinned bin to be readable")
+	}
+
+	bin.PinnedAt = sql.NullTime{}
+	if bin.IsPinned() {
+		t.Error("Expected the bin to not be pinned")
+	}
+	if !bin.IsExpired() {
+		t.Error("Expected unpinned bin to expire")
+	}
+}
+
+func TestBinExpiryPolicy(t *testing.T) {
+	defaultLifetime := 7 * 24 * time.Hour
+	tests := []struct {
+		name     string
+		bin      Bin
+		policy   string
+		lifetime time.Duration
+	}{
+		{
+			name:     "default",
+			bin:      Bin{},
+			policy:   ExpirationPolicyDefault,
+			lifetime: defaultLifetime,
+		},
+		{
+			name:     "custom",
+			bin:      Bin{ExpirationSeconds: 3600},
+			policy:   ExpirationPolicyCustom,
+			lifetime: time.Hour,
+		},
+		{
+			name:     "pinned",
+			bin:      Bin{ExpirationSeconds: 3600, PinnedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+			policy:   ExpirationPolicyPinned,
+			lifetime: time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.bin.ExpiryPolicy(); got != tt.policy {
+				t.Errorf("ExpiryPolicy() = %q, want %q", got, tt.policy)
+			}
+			if got := tt.bin.Lifetime(defaultLifetime); got != tt.lifetime {
+				t.Errorf("Lifetime() = %s, want %s", got, tt.lifetime)
+			}
+		})
+	}
+}
+
 func TestBinIsDeleted(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -292,3 +355,147 @@ func TestBinGenerateURL(t *testing.T) {
 		})
 	}
 }
+
+func TestBinOwnerToken(t *testing.T) {
+	bin := &Bin{Id: "testbin"}
+	if bin.HasOwner() {
+		t.Error("HasOwner() = true for bin without owner token")
+	}
+	if bin.IsOwnerToken("") {
+		t.Error("IsOwnerToken() = true for bin without owner token")
+	}
+
+	if err := bin.GenerateOwnerToken(); err != nil {
+		t.Fatalf("GenerateOwnerToken() error = %v", err)
+	}
+	if len(bin.OwnerToken) != 64 {
+		t.Errorf("Expected a 64 character token, got %q", bin.OwnerToken)
+	}
+	if bin.OwnerTokenHash == bin.OwnerToken {
+		t.Error("The owner token is stored in plain text")
+	}
+	if !bin.HasOwner() {
+		t.Error("HasOwner() = false after generating owner token")
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{
+			name:  "correct token",
+			token: bin.OwnerToken,
+			want:  true,
+		},
+		{
+			name:  "empty token",
+			token: "",
+			want:  false,
+		},
+		{
+			name:  "wrong token",
+			token: "0000000000000000000000000000000000000000000000000000000000000000",
+			want:  false,
+		},
+		{
+			name:  "token hash",
+			token: bin.OwnerTokenHash,
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bin.IsOwnerToken(tt.token); got != tt.want {
+				t.Errorf("IsOwnerToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	other := &Bin{Id: "otherbin"}
+	if err := other.GenerateOwnerToken(); err != nil {
+		t.Fatalf("GenerateOwnerToken() error = %v", err)
+	}
+	if other.OwnerToken == bin.OwnerToken {
+		t.Error("Expected unique owner tokens")
+	}
+}
+
+func TestBinPassword(t *testing.T) {
+	bin := &Bin{Id: "testbin"}
+	if bin.HasPassword() {
+		t.Error("HasPassword() = true for bin without password")
+	}
+	if bin.IsPassword("") {
+		t.Error("IsPassword() = true for bin without password")
+	}
+
+	if err := bin.SetPassword("correct horse"); err != nil {
+		t.Fatalf("SetPassword() error = %v", err)
+	}
+	if !bin.HasPassword() {
+		t.Error("HasPassword() = false after setting password")
+	}
+	if strings.Contains(bin.PasswordHash, "correct horse") {
+		t.Error("The password is stored in plain text")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{
+			name:     "correct password",
+			password: "correct horse",
+			want:     true,
+		},
+		{
+			name:     "empty password",
+			password: "",
+			want:     false,
+		},
+		{
+			name:     "wrong password",
+			password: "battery staple",
+			want:     false,
+		},
+		{
+			name:     "password hash",
+			password: bin.PasswordHash,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bin.IsPassword(tt.password); got != tt.want {
+				t.Errorf("IsPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The same password is salted differently for each bin
+	other := &Bin{Id: "otherbin"}
+	if err := other.SetPassword("correct horse"); err != nil {
+		t.Fatalf("SetPassword() error = %v", err)
+	}
+	if other.PasswordHash == bin.PasswordHash {
+		t.Error("Expected unique salts")
+	}
+
+	// Malformed hashes never match
+	malformed := &Bin{Id: "malformed", PasswordHash: "pbkdf2-sha256$x$y"}
+	if malformed.IsPassword("correct horse") {
+		t.Error("IsPassword() = true for malformed hash")
+	}
+
+	// An empty password removes the protection
+	if err := bin.SetPassword(""); err != nil {
+		t.Fatalf("SetPassword() error = %v", err)
+	}
+	if bin.HasPassword() {
+		t.Error("HasPassword() = true after removing password")
+	}
+}
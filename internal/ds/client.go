@@ -25,12 +25,46 @@ type Client struct {
 	BannedAt              sql.NullTime `json:"banned_at"`
 	BannedAtRelative      string       `json:"banned_at_relative"`
 	BannedBy              string       `json:"banned_by"`
+	BanReason             string       `json:"ban_reason"`
+	BanNote               string       `json:"ban_note"`
+	BanExpiredAt          sql.NullTime `json:"ban_expired_at"`
+	BanExpiredAtRelative  string       `json:"ban_expired_at_relative"`
 }
 
+// IsBanned returns true if the client is banned and the ban has not expired.
+// Bans without expiry apply until the client is unbanned.
 func (c *Client) IsBanned() bool {
-	return c.BannedAt.Valid && !c.BannedAt.Time.IsZero()
+	if !c.BannedAt.Valid || c.BannedAt.Time.IsZero() {
+		return false
+	}
+	return !c.IsBanExpired()
 }
 
+// IsBanExpired returns true if the client has been banned, and the ban has
+// expired
+func (c *Client) IsBanExpired() bool {
+	return c.BannedAt.Valid && c.BanExpiredAt.Valid && !c.BanExpiredAt.Time.After(time.Now())
+}
+
+// ClientBan is a ban of one or more clients
+type ClientBan struct {
+	// The reason is shown to the banned clients, while the note is only
+	// shown to administrators
+	Reason   string
+	Note     string
+	BannedBy string
+
+	// The ban applies until it is lifted if the expiry is not set
+	ExpiredAt sql.NullTime
+}
+
+// The bans that are listed on the admin clients page
+const (
+	BanFilterAll     = "all"
+	BanFilterActive  = "active"
+	BanFilterExpired = "expired"
+)
+
 type AutonomousSystem struct {
 	ASN                   int          `json:"asn"`
 	Organization          string       `json:"organization"`
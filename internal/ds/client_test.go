@@ -8,9 +8,10 @@ import (
 
 func TestClientIsBanned(t *testing.T) {
 	tests := []struct {
-		name     string
-		bannedAt sql.NullTime
-		want     bool
+		name         string
+		bannedAt     sql.NullTime
+		banExpiredAt sql.NullTime
+		want         bool
 	}{
 		{
 			name: "banned client",
@@ -20,6 +21,30 @@ func TestClientIsBanned(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "temporarily banned client",
+			bannedAt: sql.NullTime{
+				Time:  time.Now(),
+				Valid: true,
+			},
+			banExpiredAt: sql.NullTime{
+				Time:  time.Now().Add(time.Hour),
+				Valid: true,
+			},
+			want: true,
+		},
+		{
+			name: "not banned client (expired ban)",
+			bannedAt: sql.NullTime{
+				Time:  time.Now().Add(-2 * time.Hour),
+				Valid: true,
+			},
+			banExpiredAt: sql.NullTime{
+				Time:  time.Now().Add(-1 * time.Hour),
+				Valid: true,
+			},
+			want: false,
+		},
 		{
 			name: "not banned client (null)",
 			bannedAt: sql.NullTime{
@@ -40,10 +65,13 @@ func TestClientIsBanned(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			client := &Client{BannedAt: tt.bannedAt}
+			client := &Client{BannedAt: tt.bannedAt, BanExpiredAt: tt.banExpiredAt}
 			if got := client.IsBanned(); got != tt.want {
 				t.Errorf("Client.IsBanned() = %v, want %v", got, tt.want)
 			}
+			if got := client.IsBanExpired(); got != (tt.banExpiredAt.Valid && !tt.want) {
+				t.Errorf("Client.IsBanExpired() = %v", got)
+			}
 		})
 	}
 }
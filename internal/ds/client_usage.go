@@ -0,0 +1,45 @@
+package ds
+
+import (
+	"time"
+)
+
+// Kinds of client usage that count towards the per-client limits
+const (
+	// A file that was uploaded
+	ClientUsageUpload = "upload"
+	// A bin that was created
+	ClientUsageBin = "bin"
+	// An upload in progress, which is removed when the upload ends
+	ClientUsageActive = "active"
+)
+
+// The scopes that client usage is counted in. The network and ASN scopes
+// aggregate the usage of the clients that share the geoip details, and fall
+// back to the IP address of clients without these details.
+const (
+	ClientScopeIP      = "ip"
+	ClientScopeNetwork = "network"
+	ClientScopeASN     = "asn"
+)
+
+// ClientUsage is an event that counts towards the limits of the client that
+// caused it until it expires
+type ClientUsage struct {
+	Id        int64     `json:"id"`
+	IP        string    `json:"ip"`
+	ASN       int       `json:"asn"`
+	Network   string    `json:"network"`
+	Kind      string    `json:"kind"`
+	Bytes     uint64    `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// ClientUsageSummary is the usage of a kind within a scope since a point in
+// time
+type ClientUsageSummary struct {
+	Count    int
+	Bytes    uint64
+	OldestAt time.Time
+}
@@ -11,9 +11,29 @@ type Config struct {
 	CookieLifetime           int
 	Expiration               int
 	ExpirationDuration       time.Duration
+	ExpirationMin            int
+	ExpirationMinDuration    time.Duration
+	ExpirationMax            int
+	ExpirationMaxDuration    time.Duration
 	LimitFileDownloads       uint64
+	LimitPasswordAttempts    int
 	LimitStorageReadable     string
 	LimitStorageBytes        uint64
+	LimitUploadReadable      string
+	LimitUploadBytes         uint64
+	LimitExtractFiles        int
+	LimitExtractReadable     string
+	LimitExtractBytes        uint64
+	LimitExtractRatio        uint64
+	LimitClientScope         string
+	LimitClientHourReadable  string
+	LimitClientHourBytes     uint64
+	LimitClientDayReadable   string
+	LimitClientDayBytes      uint64
+	LimitClientHourFiles     int
+	LimitClientDayFiles      int
+	LimitClientDayBins       int
+	LimitClientConcurrent    int
 	ClientUploadFailuresCap  int
 	ClientUploadSuccessesCap int
 	HttpPort                 int
@@ -34,11 +54,19 @@ type Config struct {
 	RequireApproval          bool
 	RequireCookie            bool
 	ExpectedCookieValue      string
+	RequireOwnerToken        bool
+	SessionSecret            string
 	AllowRobots              bool
 	BaseUrl                  url.URL
 	RejectFileExtensions     []string
 	PostUploadHook           string
 	PostUploadHookTimeout    time.Duration
+	ResumableUploadTTL       time.Duration
+	ClamdAddress             string
+	ClamdTimeout             time.Duration
+	DownloadMode             string
+	SimilarDistance          int
+	SimilarAction            string
 
 	// Timeouts for the HTTP server
 	ReadTimeout       time.Duration
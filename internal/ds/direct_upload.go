@@ -0,0 +1,43 @@
+package ds
+
+import (
+	"time"
+)
+
+// DirectUpload is an S3 multipart upload in progress, where the client
+// uploads the parts directly to S3 using presigned URLs. The parts are
+// assembled under a staging key until the upload is finalized.
+type DirectUpload struct {
+	Id                string    `json:"id"`
+	Bin               string    `json:"bin"`
+	Filename          string    `json:"filename"`
+	Bytes             uint64    `json:"bytes"`
+	BytesReadable     string    `json:"bytes_readable"`
+	PartSize          uint64    `json:"part_size"`
+	Parts             int       `json:"parts"`
+	SHA256            string    `json:"sha256,omitempty"`
+	ObjectKey         string    `json:"-"`
+	StorageUploadId   string    `json:"-"`
+	IP                string    `json:"-"`
+	CreatedAt         time.Time `json:"created_at"`
+	CreatedAtRelative string    `json:"created_at_relative"`
+	ExpiredAt         time.Time `json:"expired_at"`
+	ExpiredAtRelative string    `json:"expired_at_relative"`
+}
+
+// PartBytes returns the number of bytes expected in the given part, where
+// the first part is number 1. All parts except the last one are PartSize
+// bytes long.
+func (u *DirectUpload) PartBytes(number int) uint64 {
+	if number < 1 || number > u.Parts {
+		return 0
+	}
+	if number < u.Parts {
+		return u.PartSize
+	}
+	return u.Bytes - u.PartSize*uint64(u.Parts-1)
+}
+
+func (u *DirectUpload) IsExpired() bool {
+	return u.ExpiredAt.Before(time.Now())
+}
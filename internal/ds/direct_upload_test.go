@@ -0,0 +1,49 @@
+package ds
+
+import (
+	"testing"
+)
+
+func TestDirectUploadPartBytes(t *testing.T) {
+	upload := &DirectUpload{Bytes: 25, PartSize: 10, Parts: 3}
+
+	tests := []struct {
+		name   string
+		number int
+		want   uint64
+	}{
+		{
+			name:   "first part",
+			number: 1,
+			want:   10,
+		},
+		{
+			name:   "middle part",
+			number: 2,
+			want:   10,
+		},
+		{
+			name:   "last part",
+			number: 3,
+			want:   5,
+		},
+		{
+			name:   "part number zero",
+			number: 0,
+			want:   0,
+		},
+		{
+			name:   "part number out of range",
+			number: 4,
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upload.PartBytes(tt.number); got != tt.want {
+				t.Errorf("PartBytes(%d) = %d, want %d", tt.number, got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,10 @@ type File struct {
 	Id                     int           `json:"-"`
 	Bin                    string        `json:"-"`
 	Filename               string        `json:"filename"`
+	Folder                 string        `json:"folder,omitempty"`
+	Description            string        `json:"description,omitempty"`
+	Tags                   []string      `json:"tags,omitempty"`
+	EncryptedName          string        `json:"encrypted_name,omitempty"`
 	Mime                   string        `json:"content-type"`
 	Category               string        `json:"-"`
 	Bytes                  uint64        `json:"bytes"`
@@ -16,6 +20,7 @@ type File struct {
 	MD5                    string        `json:"md5"`
 	SHA256                 string        `json:"sha256"`
 	Downloads              uint64        `json:"-"`
+	DownloadLimit          uint64        `json:"download_limit,omitempty"`
 	Updates                uint64        `json:"-"`
 	InStorage              bool          `json:"-"`
 	IP                     string        `json:"-"`
@@ -30,11 +35,14 @@ type File struct {
 	BinDeletedAtRelative   string        `json:"-"`
 	BinExpiredAt           time.Time     `json:"-"`
 	BinExpiredAtRelative   string        `json:"-"`
+	BinPinned              bool          `json:"-"`
 	AvailableForDownload   bool          `json:"-"`
 	URL                    string        `json:"-"`
+	Thumbnail              bool          `json:"-"`
 	UploadDurationMs       int64         `json:"-"`
 	UploadDuration         time.Duration `json:"-"`
 	UploadDurationReadable string        `json:"-"`
+	Versions               []FileVersion `json:"versions,omitempty"`
 }
 
 func (f *File) IsReadable() bool {
@@ -50,6 +58,15 @@ func (f *File) IsDeleted() bool {
 	return f.DeletedAt.Valid && !f.DeletedAt.Time.IsZero()
 }
 
+// DownloadsLeft returns the number of downloads left before the file is
+// deleted, or 0 if the file has no download limit
+func (f *File) DownloadsLeft() uint64 {
+	if f.DownloadLimit == 0 || f.Downloads >= f.DownloadLimit {
+		return 0
+	}
+	return f.DownloadLimit - f.Downloads
+}
+
 type FileByChecksum struct {
 	SHA256                   string    `json:"sha256"`
 	Count                    int       `json:"count"`
@@ -1,20 +1,58 @@
 package ds
 
 import (
+	"database/sql"
 	"time"
 )
 
+// Scan states of content. Content that has not been scanned, such as
+// content uploaded while no scanner was configured, has no scan state.
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+	ScanError    = "error"
+)
+
+// Actions on uploads of images that are visually similar to blocked
+// content. Similar images are either rejected, or accepted into bins that
+// need to be approved before files can be downloaded from them.
+const (
+	SimilarReject = "reject"
+	SimilarReview = "review"
+)
+
 type FileContent struct {
-	SHA256                   string    `json:"sha256"`
-	Bytes                    uint64    `json:"bytes"`
-	BytesReadable            string    `json:"bytes_readable"`
-	MD5                      string    `json:"md5"`
-	Mime                     string    `json:"mime"`
-	PHash                    string    `json:"phash,omitempty"`
-	InStorage                bool      `json:"in_storage"`
-	Blocked                  bool      `json:"blocked"`
-	CreatedAt                time.Time `json:"created_at"`
-	CreatedAtRelative        string    `json:"created_at_relative"`
-	LastReferencedAt         time.Time `json:"last_referenced_at"`
-	LastReferencedAtRelative string    `json:"last_referenced_at_relative"`
+	SHA256                   string       `json:"sha256"`
+	Bytes                    uint64       `json:"bytes"`
+	BytesReadable            string       `json:"bytes_readable"`
+	MD5                      string       `json:"md5"`
+	Mime                     string       `json:"mime"`
+	PHash                    string       `json:"phash,omitempty"`
+	MimeVersion              int          `json:"-"`
+	PHashVersion             int          `json:"-"`
+	ThumbnailVersion         int          `json:"-"`
+	InStorage                bool         `json:"in_storage"`
+	Blocked                  bool         `json:"blocked"`
+	CreatedAt                time.Time    `json:"created_at"`
+	CreatedAtRelative        string       `json:"created_at_relative"`
+	LastReferencedAt         time.Time    `json:"last_referenced_at"`
+	LastReferencedAtRelative string       `json:"last_referenced_at_relative"`
+	ScanStatus               string       `json:"scan_status,omitempty"`
+	ScanSignature            string       `json:"scan_signature,omitempty"`
+	ScannedAt                sql.NullTime `json:"-"`
+	ScannedAtRelative        string       `json:"scanned_at_relative,omitempty"`
+}
+
+// IsScanned returns true if the content has a final verdict from a scan.
+// Content where the scan failed is not considered scanned.
+func (f *FileContent) IsScanned() bool {
+	return f.ScanStatus == ScanClean || f.ScanStatus == ScanInfected
+}
+
+// SimilarContent is content with a perceptual hash within some Hamming
+// distance of the perceptual hash of other content
+type SimilarContent struct {
+	FileContent
+	Distance int `json:"distance"`
 }
@@ -83,3 +83,27 @@ func TestFileIsReadable(t *testing.T) {
 		})
 	}
 }
+
+func TestFileDownloadsLeft(t *testing.T) {
+	tests := []struct {
+		name          string
+		downloads     uint64
+		downloadLimit uint64
+		want          uint64
+	}{
+		{name: "no limit", downloads: 10, downloadLimit: 0, want: 0},
+		{name: "not downloaded", downloads: 0, downloadLimit: 3, want: 3},
+		{name: "downloaded", downloads: 2, downloadLimit: 3, want: 1},
+		{name: "limit reached", downloads: 3, downloadLimit: 3, want: 0},
+		{name: "limit exceeded", downloads: 5, downloadLimit: 3, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := &File{Downloads: tt.downloads, DownloadLimit: tt.downloadLimit}
+			if got := file.DownloadsLeft(); got != tt.want {
+				t.Errorf("DownloadsLeft() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package ds
+
+import (
+	"time"
+)
+
+// FileVersion is content of a file that was replaced by a later upload of
+// the same filename
+type FileVersion struct {
+	Id                 int64     `json:"id"`
+	FileId             int       `json:"-"`
+	SHA256             string    `json:"sha256"`
+	MD5                string    `json:"md5"`
+	Mime               string    `json:"content-type"`
+	Bytes              uint64    `json:"bytes"`
+	BytesReadable      string    `json:"bytes_readable"`
+	InStorage          bool      `json:"-"`
+	IP                 string    `json:"-"`
+	UploadedAt         time.Time `json:"uploaded_at"`
+	UploadedAtRelative string    `json:"uploaded_at_relative"`
+	ReplacedAt         time.Time `json:"replaced_at"`
+	ReplacedAtRelative string    `json:"replaced_at_relative"`
+}
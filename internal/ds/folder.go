@@ -0,0 +1,73 @@
+package ds
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Folder is a directory in a bin. Folders are not stored on their own, but
+// follow from the relative paths in the filenames of the files in the bin.
+type Folder struct {
+	Path          string `json:"path"`
+	Name          string `json:"name"`
+	Files         int    `json:"files"`
+	Bytes         uint64 `json:"bytes"`
+	BytesReadable string `json:"bytes_readable"`
+}
+
+// FolderOf returns the folder of a filename, or an empty string if the file
+// is in the root of the bin
+func FolderOf(filename string) string {
+	folder := path.Dir(filename)
+	if folder == "." || folder == "/" {
+		return ""
+	}
+	return folder
+}
+
+// InFolder returns true if the file is in the folder or in one of its
+// subfolders. Every file is in the root folder, which is the empty string.
+func (f *File) InFolder(folder string) bool {
+	return folder == "" || strings.HasPrefix(f.Filename, folder+"/")
+}
+
+// Subfolders returns the folders directly below a folder, sorted by name.
+// The number and size of the files in each folder include the files in its
+// subfolders.
+func Subfolders(files []File, folder string) []Folder {
+	prefix := ""
+	if folder != "" {
+		prefix = folder + "/"
+	}
+	index := make(map[string]int)
+	var folders []Folder
+	for _, file := range files {
+		rest, ok := strings.CutPrefix(file.Filename, prefix)
+		if !ok {
+			continue
+		}
+		name, _, ok := strings.Cut(rest, "/")
+		if !ok {
+			// The file is directly in the folder
+			continue
+		}
+		i, found := index[name]
+		if !found {
+			i = len(folders)
+			index[name] = i
+			folders = append(folders, Folder{Path: prefix + name, Name: name})
+		}
+		folders[i].Files++
+		folders[i].Bytes += file.Bytes
+	}
+	for i := range folders {
+		folders[i].BytesReadable = humanize.Bytes(folders[i].Bytes)
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders
+}
@@ -0,0 +1,79 @@
+package ds
+
+import (
+	"testing"
+)
+
+func TestFolderOf(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"file.txt", ""},
+		{"src/main.go", "src"},
+		{"src/cmd/tool/main.go", "src/cmd/tool"},
+	}
+	for _, tt := range tests {
+		if got := FolderOf(tt.filename); got != tt.want {
+			t.Errorf("FolderOf(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestFileInFolder(t *testing.T) {
+	file := &File{Filename: "src/cmd/main.go"}
+	tests := []struct {
+		folder string
+		want   bool
+	}{
+		{"", true},
+		{"src", true},
+		{"src/cmd", true},
+		{"sr", false},
+		{"src/cmd/main.go", false},
+		{"docs", false},
+	}
+	for _, tt := range tests {
+		if got := file.InFolder(tt.folder); got != tt.want {
+			t.Errorf("InFolder(%q) = %v, want %v", tt.folder, got, tt.want)
+		}
+	}
+}
+
+func TestSubfolders(t *testing.T) {
+	files := []File{
+		{Filename: "readme.txt", Bytes: 1},
+		{Filename: "src/main.go", Bytes: 10},
+		{Filename: "src/cmd/tool/main.go", Bytes: 100},
+		{Filename: "docs/main.go", Bytes: 1000},
+		{Filename: "src-old/main.go", Bytes: 10000},
+	}
+
+	root := Subfolders(files, "")
+	if len(root) != 3 {
+		t.Fatalf("Expected 3 folders in the root, got %d: %v", len(root), root)
+	}
+	want := []Folder{
+		{Path: "docs", Name: "docs", Files: 1, Bytes: 1000},
+		{Path: "src", Name: "src", Files: 2, Bytes: 110},
+		{Path: "src-old", Name: "src-old", Files: 1, Bytes: 10000},
+	}
+	for i, folder := range want {
+		got := root[i]
+		if got.Path != folder.Path || got.Name != folder.Name || got.Files != folder.Files || got.Bytes != folder.Bytes {
+			t.Errorf("Expected folder %v, got %v", folder, got)
+		}
+		if got.BytesReadable == "" {
+			t.Errorf("Expected the readable size of folder %s", got.Path)
+		}
+	}
+
+	src := Subfolders(files, "src")
+	if len(src) != 1 || src[0].Path != "src/cmd" || src[0].Name != "cmd" {
+		t.Errorf("Expected folder src/cmd in src, got %v", src)
+	}
+
+	if got := Subfolders(files, "docs"); len(got) != 0 {
+		t.Errorf("Expected no folders in docs, got %v", got)
+	}
+}
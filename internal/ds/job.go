@@ -0,0 +1,54 @@
+package ds
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Job states. Jobs are retried a few times before they are failed.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Kinds of jobs
+const (
+	JobMime      = "mime"
+	JobPHash     = "phash"
+	JobThumbnail = "thumbnail"
+	JobHook      = "hook"
+)
+
+// Job is a processing step for content, such as computing the perceptual
+// hash of an image. Jobs for a file, such as running the post-upload hook,
+// have the id of the file as well.
+type Job struct {
+	Id                 int64        `json:"id"`
+	Kind               string       `json:"kind"`
+	SHA256             string       `json:"sha256"`
+	FileId             int          `json:"file_id,omitempty"`
+	Status             string       `json:"status"`
+	Attempts           int          `json:"attempts"`
+	LastError          string       `json:"last_error,omitempty"`
+	RunAt              time.Time    `json:"run_at"`
+	RunAtRelative      string       `json:"run_at_relative"`
+	CreatedAt          time.Time    `json:"created_at"`
+	CreatedAtRelative  string       `json:"created_at_relative"`
+	StartedAt          sql.NullTime `json:"-"`
+	FinishedAt         sql.NullTime `json:"-"`
+	FinishedAtRelative string       `json:"finished_at_relative,omitempty"`
+}
+
+// JobStats is the number of jobs of a kind in each state
+type JobStats struct {
+	Kind    string `json:"kind"`
+	Pending int64  `json:"pending"`
+	Running int64  `json:"running"`
+	Done    int64  `json:"done"`
+	Failed  int64  `json:"failed"`
+
+	// Content with missing or outdated data from this kind of job
+	Outdated int64 `json:"outdated"`
+}
@@ -13,22 +13,23 @@ type Metrics struct {
 	Id string `json:"-"`
 
 	// Database-sourced metrics (populated by UpdateMetrics)
-	CurrentLogEntries    int64  `json:"current_log_entries"`
-	LimitBytes           uint64 `json:"limit_bytes"`
-	CurrentBytes         int64  `json:"current_bytes"`
-	CurrentBytesReadable string `json:"current_bytes_readable"`
-	CurrentFiles         int64  `json:"current_files"`
-	CurrentFilesReadable string `json:"current_files_readable"`
-	CurrentBins          int64  `json:"current_bins"`
-	CurrentBinsReadable  string `json:"current_bins_readable"`
-	FreeBytes            int64  `json:"-"`
-	FreeBytesReadable    string `json:"-"`
-	TotalBytes           int64  `json:"total_bytes"`
-	TotalBytesReadable   string `json:"total_bytes_readable"`
-	TotalFiles           int64  `json:"total_files"`
-	TotalFilesReadable   string `json:"total_files_readable"`
-	TotalBins            int64  `json:"total_bins"`
-	TotalBinsReadable    string `json:"total_bins_readable"`
+	CurrentLogEntries    int64      `json:"current_log_entries"`
+	LimitBytes           uint64     `json:"limit_bytes"`
+	CurrentBytes         int64      `json:"current_bytes"`
+	CurrentBytesReadable string     `json:"current_bytes_readable"`
+	CurrentFiles         int64      `json:"current_files"`
+	CurrentFilesReadable string     `json:"current_files_readable"`
+	CurrentBins          int64      `json:"current_bins"`
+	CurrentBinsReadable  string     `json:"current_bins_readable"`
+	FreeBytes            int64      `json:"-"`
+	FreeBytesReadable    string     `json:"-"`
+	TotalBytes           int64      `json:"total_bytes"`
+	TotalBytesReadable   string     `json:"total_bytes_readable"`
+	TotalFiles           int64      `json:"total_files"`
+	TotalFilesReadable   string     `json:"total_files_readable"`
+	TotalBins            int64      `json:"total_bins"`
+	TotalBinsReadable    string     `json:"total_bins_readable"`
+	Jobs                 []JobStats `json:"-"`
 
 	// Prometheus metrics
 	dataTransferBytes    *prometheus.CounterVec
@@ -54,6 +55,11 @@ type Metrics struct {
 	DBQueryDuration *prometheus.HistogramVec
 	DBQueryErrors   *prometheus.CounterVec
 
+	// Content processing jobs
+	jobs        *prometheus.GaugeVec
+	jobOutcomes *prometheus.CounterVec
+	jobDuration *prometheus.HistogramVec
+
 	// Client-reported upload telemetry
 	clientUploadOutcomes            *prometheus.CounterVec
 	clientUploadDuration            *prometheus.HistogramVec
@@ -261,6 +267,40 @@ func NewMetrics(id string, registry *prometheus.Registry) *Metrics {
 		[]string{"operation"},
 	)
 
+	m.jobs = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "filebin_jobs",
+			Help: "Number of content processing jobs by kind and status",
+			ConstLabels: prometheus.Labels{
+				"id": id,
+			},
+		},
+		[]string{"kind", "status"},
+	)
+
+	m.jobOutcomes = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "filebin_job_outcomes_total",
+			Help: "Total content processing job runs by kind and outcome. outcome=done|retry|failed.",
+			ConstLabels: prometheus.Labels{
+				"id": id,
+			},
+		},
+		[]string{"kind", "outcome"},
+	)
+
+	m.jobDuration = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "filebin_job_duration_seconds",
+			Help:    "Duration of content processing job runs by kind",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+			ConstLabels: prometheus.Labels{
+				"id": id,
+			},
+		},
+		[]string{"kind"},
+	)
+
 	m.clientUploadOutcomes = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "filebin_client_upload_outcomes_total",
@@ -370,6 +410,11 @@ func (m *Metrics) UpdateGauges() {
 	m.storageBytes.WithLabelValues("limit").Set(float64(m.LimitBytes))
 	m.files.Set(float64(m.CurrentFiles))
 	m.bins.Set(float64(m.CurrentBins))
+	for _, stats := range m.Jobs {
+		m.jobs.WithLabelValues(stats.Kind, JobPending).Set(float64(stats.Pending))
+		m.jobs.WithLabelValues(stats.Kind, JobRunning).Set(float64(stats.Running))
+		m.jobs.WithLabelValues(stats.Kind, JobFailed).Set(float64(stats.Failed))
+	}
 }
 
 // Data transfer methods
@@ -381,6 +426,10 @@ func (m *Metrics) IncrBytesClientToFilebin(value uint64) {
 	m.dataTransferBytes.WithLabelValues("client_to_filebin").Add(float64(value))
 }
 
+func (m *Metrics) IncrBytesClientToStorage(value uint64) {
+	m.dataTransferBytes.WithLabelValues("client_to_storage").Add(float64(value))
+}
+
 func (m *Metrics) IncrBytesFilebinToStorage(value uint64) {
 	m.dataTransferBytes.WithLabelValues("filebin_to_storage").Add(float64(value))
 }
@@ -415,6 +464,14 @@ func (m *Metrics) IncrZipArchiveDownloadCount() {
 	m.archiveDownloads.WithLabelValues("zip").Inc()
 }
 
+func (m *Metrics) IncrTarGzArchiveDownloadCount() {
+	m.archiveDownloads.WithLabelValues("tar.gz").Inc()
+}
+
+func (m *Metrics) IncrTarZstArchiveDownloadCount() {
+	m.archiveDownloads.WithLabelValues("tar.zst").Inc()
+}
+
 // Page view methods
 func (m *Metrics) IncrFrontPageViewCount() {
 	m.pageViews.WithLabelValues("front").Inc()
@@ -485,6 +542,12 @@ func (m *Metrics) IncrS3OperationError(operation string) {
 	m.S3OperationErrors.WithLabelValues(operation).Inc()
 }
 
+// Content processing job metrics methods
+func (m *Metrics) ObserveJob(kind, outcome string, duration time.Duration) {
+	m.jobOutcomes.WithLabelValues(kind, outcome).Inc()
+	m.jobDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
 // Database query metrics methods
 func (m *Metrics) ObserveDBQuery(operation string, duration time.Duration) {
 	m.DBQueryDuration.WithLabelValues(operation).Observe(duration.Seconds())
@@ -63,11 +63,13 @@ func TestDataTransferMetrics(t *testing.T) {
 	metrics.IncrBytesFilebinToStorage(300)
 	metrics.IncrBytesStorageToFilebin(400)
 	metrics.IncrBytesStorageToClient(500)
+	metrics.IncrBytesClientToStorage(600)
 
 	expected := `
 		# HELP filebin_data_transfer_bytes Approximate data transfer in bytes
 		# TYPE filebin_data_transfer_bytes counter
 		filebin_data_transfer_bytes{direction="client_to_filebin",id="test"} 200
+		filebin_data_transfer_bytes{direction="client_to_storage",id="test"} 600
 		filebin_data_transfer_bytes{direction="filebin_to_client",id="test"} 100
 		filebin_data_transfer_bytes{direction="filebin_to_storage",id="test"} 300
 		filebin_data_transfer_bytes{direction="storage_to_client",id="test"} 500
@@ -0,0 +1,122 @@
+package ds
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What the rules of the access policy match clients by
+const (
+	// An IPv4 or IPv6 network in CIDR notation, such as 2001:db8::/64
+	PolicyMatchCIDR = "cidr"
+	// An autonomous system number
+	PolicyMatchASN = "asn"
+	// A country name as given by the geoip database
+	PolicyMatchCountry = "country"
+	// Clients that the geoip database flags as anonymous proxies
+	PolicyMatchProxy = "proxy"
+)
+
+// What the rules of the access policy do to the clients they match
+const (
+	PolicyDenyUpload      = "deny-upload"
+	PolicyDenyDownload    = "deny-download"
+	PolicyRequireApproval = "require-approval"
+	PolicyRequireCookie   = "require-cookie"
+	// Trusted clients are exempt from the other rules, from the approval
+	// and cookie requirements and from the per-client limits
+	PolicyAllow = "allow"
+)
+
+// Policy is a rule of the access policy, which applies an action to the
+// clients it matches until it expires
+type Policy struct {
+	Id                int64      `json:"id"`
+	Match             string     `json:"match"`
+	Value             string     `json:"value"`
+	Action            string     `json:"action"`
+	Reason            string     `json:"reason"`
+	CreatedBy         string     `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	CreatedAtRelative string     `json:"created_at_relative"`
+	ExpiredAt         *time.Time `json:"expired_at,omitempty"`
+	ExpiredAtRelative string     `json:"expired_at_relative,omitempty"`
+}
+
+// IsExpired returns true if the rule has an expiry that has passed. Rules
+// without expiry apply until they are removed.
+func (p *Policy) IsExpired() bool {
+	return p.ExpiredAt != nil && p.ExpiredAt.Before(time.Now())
+}
+
+// Matches returns true if the rule applies to the client
+func (p *Policy) Matches(client *Client) bool {
+	switch p.Match {
+	case PolicyMatchCIDR:
+		_, network, err := net.ParseCIDR(p.Value)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(client.IP)
+		return ip != nil && network.Contains(ip)
+	case PolicyMatchASN:
+		asn, err := strconv.Atoi(p.Value)
+		return err == nil && asn != 0 && asn == client.ASN
+	case PolicyMatchCountry:
+		return client.Country != "" && strings.EqualFold(p.Value, client.Country)
+	case PolicyMatchProxy:
+		return client.Proxy
+	}
+	return false
+}
+
+// PolicyDecision is the outcome of the access policy for a client
+type PolicyDecision struct {
+	Trusted         bool
+	DenyUpload      bool
+	DenyDownload    bool
+	RequireApproval bool
+	RequireCookie   bool
+
+	// The rules that decided the outcome
+	Rules []Policy
+}
+
+// EvaluatePolicies applies the rules that match the client. A matching
+// allow rule overrides all other rules.
+func EvaluatePolicies(policies []Policy, client *Client) (decision PolicyDecision) {
+	for _, policy := range policies {
+		if policy.IsExpired() || !policy.Matches(client) {
+			continue
+		}
+		if policy.Action == PolicyAllow {
+			return PolicyDecision{Trusted: true, Rules: []Policy{policy}}
+		}
+		switch policy.Action {
+		case PolicyDenyUpload:
+			decision.DenyUpload = true
+		case PolicyDenyDownload:
+			decision.DenyDownload = true
+		case PolicyRequireApproval:
+			decision.RequireApproval = true
+		case PolicyRequireCookie:
+			decision.RequireCookie = true
+		default:
+			continue
+		}
+		decision.Rules = append(decision.Rules, policy)
+	}
+	return decision
+}
+
+// Reason returns the reason of the first rule with the given action
+func (d *PolicyDecision) Reason(action string) string {
+	for _, policy := range d.Rules {
+		if policy.Action == action {
+			return policy.Reason
+		}
+	}
+	return ""
+}
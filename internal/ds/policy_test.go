@@ -0,0 +1,72 @@
+package ds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyMatches(t *testing.T) {
+	client := Client{IP: "2001:db8:1:2:3:4:5:6", ASN: 64500, Country: "Norway", Proxy: true}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   bool
+	}{
+		{"ipv6 /64", Policy{Match: PolicyMatchCIDR, Value: "2001:db8:1:2::/64"}, true},
+		{"other ipv6 /64", Policy{Match: PolicyMatchCIDR, Value: "2001:db8:1:3::/64"}, false},
+		{"ipv4 network", Policy{Match: PolicyMatchCIDR, Value: "192.0.2.0/24"}, false},
+		{"invalid network", Policy{Match: PolicyMatchCIDR, Value: "2001:db8::"}, false},
+		{"asn", Policy{Match: PolicyMatchASN, Value: "64500"}, true},
+		{"other asn", Policy{Match: PolicyMatchASN, Value: "64501"}, false},
+		{"country", Policy{Match: PolicyMatchCountry, Value: "norway"}, true},
+		{"other country", Policy{Match: PolicyMatchCountry, Value: "Sweden"}, false},
+		{"proxy", Policy{Match: PolicyMatchProxy}, true},
+		{"unknown match", Policy{Match: "city", Value: "Oslo"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Matches(&client); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Clients without geoip details do not match by ASN or country
+	unknown := Client{IP: "192.0.2.1"}
+	for _, policy := range []Policy{{Match: PolicyMatchASN, Value: "0"}, {Match: PolicyMatchCountry, Value: ""}, {Match: PolicyMatchProxy}} {
+		if policy.Matches(&unknown) {
+			t.Errorf("Expected %s rule to not match a client without geoip details", policy.Match)
+		}
+	}
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+	client := Client{IP: "192.0.2.1", ASN: 64500, Country: "Norway"}
+	past := time.Now().Add(-1 * time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	policies := []Policy{
+		{Match: PolicyMatchASN, Value: "64500", Action: PolicyDenyUpload, Reason: "Abuse from this network"},
+		{Match: PolicyMatchCountry, Value: "Norway", Action: PolicyRequireCookie, ExpiredAt: &future},
+		{Match: PolicyMatchCIDR, Value: "192.0.2.0/24", Action: PolicyDenyDownload, ExpiredAt: &past},
+		{Match: PolicyMatchCIDR, Value: "198.51.100.0/24", Action: PolicyRequireApproval},
+	}
+	decision := EvaluatePolicies(policies, &client)
+	if !decision.DenyUpload || !decision.RequireCookie || decision.DenyDownload || decision.RequireApproval || decision.Trusted {
+		t.Errorf("Unexpected decision: %+v", decision)
+	}
+	if len(decision.Rules) != 2 {
+		t.Errorf("Expected 2 rules to apply, got %d", len(decision.Rules))
+	}
+	if reason := decision.Reason(PolicyDenyUpload); reason != "Abuse from this network" {
+		t.Errorf("Unexpected reason: %q", reason)
+	}
+
+	// Allow rules override the other rules
+	policies = append(policies, Policy{Match: PolicyMatchCIDR, Value: "192.0.2.1/32", Action: PolicyAllow})
+	decision = EvaluatePolicies(policies, &client)
+	if !decision.Trusted || decision.DenyUpload || decision.RequireCookie {
+		t.Errorf("Expected the client to be trusted: %+v", decision)
+	}
+}
@@ -0,0 +1,18 @@
+package ds
+
+import (
+	"time"
+)
+
+// Scan is a malware scan of content, as recorded in the scan history
+type Scan struct {
+	Id                int64     `json:"-"`
+	SHA256            string    `json:"sha256"`
+	Scanner           string    `json:"scanner"`
+	Status            string    `json:"status"`
+	Signature         string    `json:"signature,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	DurationMs        int64     `json:"duration_ms"`
+	CreatedAt         time.Time `json:"created_at"`
+	CreatedAtRelative string    `json:"created_at_relative"`
+}
@@ -0,0 +1,25 @@
+package ds
+
+import (
+	"time"
+)
+
+// Thumbnail sizes, as the maximum width and height in pixels. The small
+// thumbnails are shown in the gallery, and the large ones in the lightbox.
+const (
+	ThumbnailSmall = 256
+	ThumbnailLarge = 1280
+)
+
+var ThumbnailSizes = []int{ThumbnailSmall, ThumbnailLarge}
+
+// Thumbnail is a downscaled JPEG version of image content, stored in S3
+// next to the content itself
+type Thumbnail struct {
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Bytes     uint64    `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package ds
+
+import "time"
+
+// Transfer is the part of a file that has been sent to a client by the
+// downloads that are streamed through filebin, and the number of downloads
+// that it was counted as. Range requests add to the transfer until it
+// expires.
+type Transfer struct {
+	IP        string    `json:"ip"`
+	FileId    int       `json:"file_id"`
+	SHA256    string    `json:"sha256"`
+	Bytes     uint64    `json:"bytes"`
+	Downloads uint64    `json:"downloads"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
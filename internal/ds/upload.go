@@ -0,0 +1,55 @@
+package ds
+
+import (
+	"fmt"
+	"time"
+)
+
+// Upload is a resumable upload in progress. The bytes received so far are
+// staged in S3 as the parts of a multipart upload, followed by the tail of
+// bytes that do not fill a part yet, until the upload is complete.
+type Upload struct {
+	Id                string    `json:"id"`
+	Bin               string    `json:"bin"`
+	Filename          string    `json:"filename"`
+	Bytes             uint64    `json:"bytes"`
+	BytesReadable     string    `json:"bytes_readable"`
+	Offset            uint64    `json:"offset"`
+	PartSize          uint64    `json:"part_size"`
+	ObjectKey         string    `json:"-"`
+	StorageUploadId   string    `json:"-"`
+	IP                string    `json:"-"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	UpdatedAtRelative string    `json:"updated_at_relative"`
+	CreatedAt         time.Time `json:"created_at"`
+	CreatedAtRelative string    `json:"created_at_relative"`
+	ExpiredAt         time.Time `json:"expired_at"`
+	ExpiredAtRelative string    `json:"expired_at_relative"`
+}
+
+func (u *Upload) IsComplete() bool {
+	return u.Offset >= u.Bytes
+}
+
+// TailBytes returns the number of bytes received after the last part that
+// has been stored.
+func (u *Upload) TailBytes() uint64 {
+	if u.IsComplete() || u.PartSize == 0 {
+		return 0
+	}
+	return u.Offset % u.PartSize
+}
+
+// TailKey returns the key of the object that holds the tail of the upload.
+// The key is specific to the offset, so that requests that lose the race
+// to update the offset do not overwrite the tail of the request that won.
+func (u *Upload) TailKey() string {
+	if u.TailBytes() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s.tail.%d", u.ObjectKey, u.Offset)
+}
+
+func (u *Upload) IsExpired() bool {
+	return u.ExpiredAt.Before(time.Now())
+}
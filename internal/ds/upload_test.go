@@ -0,0 +1,123 @@
+package ds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadIsComplete(t *testing.T) {
+	tests := []struct {
+		name   string
+		bytes  uint64
+		offset uint64
+		want   bool
+	}{
+		{
+			name:   "nothing received",
+			bytes:  100,
+			offset: 0,
+			want:   false,
+		},
+		{
+			name:   "partially received",
+			bytes:  100,
+			offset: 99,
+			want:   false,
+		},
+		{
+			name:   "fully received",
+			bytes:  100,
+			offset: 100,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := &Upload{Bytes: tt.bytes, Offset: tt.offset}
+			if got := upload.IsComplete(); got != tt.want {
+				t.Errorf("IsComplete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadIsExpired(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiredAt time.Time
+		want      bool
+	}{
+		{
+			name:      "expired upload",
+			expiredAt: time.Now().Add(-1 * time.Hour),
+			want:      true,
+		},
+		{
+			name:      "active upload",
+			expiredAt: time.Now().Add(1 * time.Hour),
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := &Upload{ExpiredAt: tt.expiredAt}
+			if got := upload.IsExpired(); got != tt.want {
+				t.Errorf("IsExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset uint64
+		bytes  uint64
+		key    string
+	}{
+		{
+			name:   "nothing received",
+			offset: 0,
+			bytes:  0,
+			key:    "",
+		},
+		{
+			name:   "within the first part",
+			offset: 4,
+			bytes:  4,
+			key:    "staging/upload.tail.4",
+		},
+		{
+			name:   "at the end of a part",
+			offset: 20,
+			bytes:  0,
+			key:    "",
+		},
+		{
+			name:   "within the last part",
+			offset: 23,
+			bytes:  3,
+			key:    "staging/upload.tail.23",
+		},
+		{
+			name:   "fully received",
+			offset: 25,
+			bytes:  0,
+			key:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := &Upload{Bytes: 25, PartSize: 10, Offset: tt.offset, ObjectKey: "staging/upload"}
+			if got := upload.TailBytes(); got != tt.bytes {
+				t.Errorf("TailBytes() = %d, want %d", got, tt.bytes)
+			}
+			if got := upload.TailKey(); got != tt.key {
+				t.Errorf("TailKey() = %q, want %q", got, tt.key)
+			}
+		})
+	}
+}
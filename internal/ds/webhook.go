@@ -0,0 +1,32 @@
+package ds
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Delivery states of webhooks. Deliveries are pending until they have been
+// delivered, or until the delivery has failed too many times.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookDelivery is the delivery of one webhook event to one webhook URL
+type WebhookDelivery struct {
+	Id                    int64        `json:"id"`
+	Event                 string       `json:"event"`
+	URL                   string       `json:"url"`
+	Payload               string       `json:"payload"`
+	Status                string       `json:"status"`
+	Attempts              int          `json:"attempts"`
+	LastStatusCode        int          `json:"last_status_code,omitempty"`
+	LastError             string       `json:"last_error,omitempty"`
+	NextAttemptAt         time.Time    `json:"next_attempt_at"`
+	NextAttemptAtRelative string       `json:"next_attempt_at_relative"`
+	CreatedAt             time.Time    `json:"created_at"`
+	CreatedAtRelative     string       `json:"created_at_relative"`
+	DeliveredAt           sql.NullTime `json:"-"`
+	DeliveredAtRelative   string       `json:"delivered_at_relative,omitempty"`
+}
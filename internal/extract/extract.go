@@ -0,0 +1,248 @@
+// Package extract reads the files in zip, tar and gzip compressed tar
+// archives. The number of files, the total size of the extracted files and
+// the compression ratio are limited to defeat archive bombs.
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Archive formats
+const (
+	Zip   = "zip"
+	Tar   = "tar"
+	TarGz = "tar.gz"
+)
+
+var (
+	ErrUnsupported  = errors.New("unsupported archive format")
+	ErrTooManyFiles = errors.New("the archive contains too many files")
+	ErrTooLarge     = errors.New("the files in the archive are too large when extracted")
+	ErrRatio        = errors.New("the compression ratio of the archive is too high")
+)
+
+// Limits of an extraction. A zero value disables the limit.
+type Limits struct {
+	// Number of files in the archive
+	Files int
+
+	// Total number of bytes extracted from the archive
+	Bytes uint64
+
+	// Number of bytes extracted per byte of the archive
+	Ratio uint64
+}
+
+// Entry is a regular file in an archive
+type Entry struct {
+	// The name of the file in the archive, which may include directories
+	Name string
+
+	// The size of the file, as given by the archive
+	Bytes uint64
+
+	ModTime time.Time
+}
+
+// Format returns the archive format of a content type, or an empty string
+// if archives of the content type can not be extracted. Gzip compressed
+// files are assumed to be tar archives, and fail to extract otherwise.
+func Format(mime string) string {
+	mime, _, _ = strings.Cut(mime, ";")
+	switch strings.TrimSpace(mime) {
+	case "application/zip":
+		return Zip
+	case "application/x-tar":
+		return Tar
+	case "application/gzip", "application/x-gzip":
+		return TarGz
+	}
+	return ""
+}
+
+// Check reads through the archive without extracting it, and returns an
+// error if the archive can not be read or exceeds the limits
+func Check(r io.ReaderAt, size int64, format string, limits Limits) error {
+	return Walk(r, size, format, limits, func(entry Entry, content io.Reader) error {
+		_, err := io.Copy(io.Discard, content)
+		return err
+	})
+}
+
+// Walk calls fn with the content of every regular file in the archive, in
+// the order of the archive. Directories, links and other special entries
+// are skipped. Walk stops at the first error, either from fn or when a
+// limit is exceeded while reading.
+func Walk(r io.ReaderAt, size int64, format string, limits Limits, fn func(entry Entry, content io.Reader) error) error {
+	c := &counter{limits: limits, size: uint64(size)}
+	switch format {
+	case Zip:
+		return walkZip(r, size, c, fn)
+	case Tar:
+		return walkTar(io.NewSectionReader(r, 0, size), c, fn)
+	case TarGz:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		return walkTar(gz, c, fn)
+	}
+	return ErrUnsupported
+}
+
+func walkZip(r io.ReaderAt, size int64, c *counter, fn func(Entry, io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	// The central directory gives the number and sizes of the files up
+	// front, so archives that are too large are rejected without
+	// decompressing them. The sizes are verified while reading.
+	declared := counter{limits: c.limits, size: c.size}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if err := declared.addFile(); err != nil {
+			return err
+		}
+		if err := declared.addContent(f.UncompressedSize64); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if err := c.addFile(); err != nil {
+			return err
+		}
+		content, err := f.Open()
+		if err != nil {
+			return err
+		}
+		entry := Entry{
+			Name:    f.Name,
+			Bytes:   f.UncompressedSize64,
+			ModTime: f.Modified,
+		}
+		err = fn(entry, &countingReader{r: content, add: c.addContent})
+		_ = content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, c *counter, fn func(Entry, io.Reader) error) error {
+	// Everything that is decompressed counts towards the compression
+	// ratio, including the headers of the entries
+	tr := tar.NewReader(&countingReader{r: r, add: c.addExpanded})
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The content of the entry counts towards the size limit even if
+		// it is skipped or not read to the end
+		content := &countingReader{r: tr, add: c.addBytes}
+		if header.Typeflag == tar.TypeReg {
+			if err := c.addFile(); err != nil {
+				return err
+			}
+			entry := Entry{
+				Name:    header.Name,
+				Bytes:   uint64(header.Size),
+				ModTime: header.ModTime,
+			}
+			if err := fn(entry, content); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			return err
+		}
+	}
+}
+
+// The compression ratio is not limited for archives that extract to less
+// than this. Small tar archives are padded with zeros, and compress well.
+const ratioThreshold = 1024 * 1024
+
+// counter keeps track of the number of files and bytes extracted
+type counter struct {
+	limits   Limits
+	size     uint64
+	files    int
+	bytes    uint64
+	expanded uint64
+}
+
+func (c *counter) addFile() error {
+	c.files++
+	if c.limits.Files > 0 && c.files > c.limits.Files {
+		return ErrTooManyFiles
+	}
+	return nil
+}
+
+// addBytes counts bytes of the files in the archive
+func (c *counter) addBytes(n uint64) error {
+	c.bytes += n
+	if c.limits.Bytes > 0 && c.bytes > c.limits.Bytes {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// addExpanded counts decompressed bytes
+func (c *counter) addExpanded(n uint64) error {
+	c.expanded += n
+	if c.limits.Ratio > 0 && c.expanded > ratioThreshold && c.expanded > c.limits.Ratio*c.size {
+		return ErrRatio
+	}
+	return nil
+}
+
+// addContent counts bytes of files that are decompressed individually
+func (c *counter) addContent(n uint64) error {
+	if err := c.addBytes(n); err != nil {
+		return err
+	}
+	return c.addExpanded(n)
+}
+
+// countingReader counts the bytes read, and fails when a limit is exceeded
+type countingReader struct {
+	r   io.Reader
+	add func(n uint64) error
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		if limitErr := cr.add(uint64(n)); limitErr != nil {
+			return n, limitErr
+		}
+	}
+	return n, err
+}
+
+// IsLimit returns true if the error is caused by a limit of the extraction
+func IsLimit(err error) bool {
+	return errors.Is(err, ErrTooManyFiles) || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrRatio)
+}
@@ -0,0 +1,203 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	name    string
+	content string
+}
+
+func zipArchive(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("dir/"); err != nil {
+		t.Fatalf("Unable to create directory: %s", err.Error())
+	}
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatalf("Unable to create file: %s", err.Error())
+		}
+		if _, err := io.WriteString(w, file.content); err != nil {
+			t.Fatalf("Unable to write file: %s", err.Error())
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Unable to close zip archive: %s", err.Error())
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("Unable to write directory: %s", err.Error())
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}); err != nil {
+		t.Fatalf("Unable to write link: %s", err.Error())
+	}
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Size: int64(len(file.content)), Mode: 0600, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Unable to write header: %s", err.Error())
+		}
+		if _, err := io.WriteString(tw, file.content); err != nil {
+			t.Fatalf("Unable to write file: %s", err.Error())
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Unable to close tar archive: %s", err.Error())
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(tarArchive(t, files)); err != nil {
+		t.Fatalf("Unable to compress tar archive: %s", err.Error())
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Unable to close gzip stream: %s", err.Error())
+	}
+	return buf.Bytes()
+}
+
+func archive(t *testing.T, format string, files []testFile) []byte {
+	switch format {
+	case Zip:
+		return zipArchive(t, files)
+	case Tar:
+		return tarArchive(t, files)
+	case TarGz:
+		return tarGzArchive(t, files)
+	}
+	t.Fatalf("Unknown format %s", format)
+	return nil
+}
+
+func TestWalk(t *testing.T) {
+	files := []testFile{
+		{"readme.txt", "hello"},
+		{"dir/notes.txt", "some notes"},
+		{"dir/sub/data.json", `{"a": 1}`},
+	}
+	for _, format := range []string{Zip, Tar, TarGz} {
+		t.Run(format, func(t *testing.T) {
+			content := archive(t, format, files)
+			var found []testFile
+			err := Walk(bytes.NewReader(content), int64(len(content)), format, Limits{}, func(entry Entry, r io.Reader) error {
+				b, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				if entry.Bytes != uint64(len(b)) {
+					t.Errorf("Expected %d bytes in %s, got %d", entry.Bytes, entry.Name, len(b))
+				}
+				found = append(found, testFile{entry.Name, string(b)})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if len(found) != len(files) {
+				t.Fatalf("Expected %d files, got %d: %v", len(files), len(found), found)
+			}
+			for i, file := range files {
+				if found[i] != file {
+					t.Errorf("Expected %v, got %v", file, found[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	files := []testFile{
+		{"a.txt", strings.Repeat("a", 1000)},
+		{"b.txt", strings.Repeat("b", 1000)},
+		{"c.txt", strings.Repeat("c", 1000)},
+	}
+	bomb := []testFile{
+		{"zeros.bin", strings.Repeat("\x00", 4*1024*1024)},
+	}
+	tests := []struct {
+		description string
+		files       []testFile
+		limits      Limits
+		expected    error
+	}{
+		{"within limits", files, Limits{Files: 3, Bytes: 3000, Ratio: 100}, nil},
+		{"too many files", files, Limits{Files: 2}, ErrTooManyFiles},
+		{"too large", files, Limits{Bytes: 2500}, ErrTooLarge},
+		{"small archives are not ratio limited", files, Limits{Ratio: 1}, nil},
+		{"compression ratio", bomb, Limits{Ratio: 100}, ErrRatio},
+	}
+	for _, format := range []string{Zip, Tar, TarGz} {
+		for _, test := range tests {
+			t.Run(format+" "+test.description, func(t *testing.T) {
+				content := archive(t, format, test.files)
+				err := Check(bytes.NewReader(content), int64(len(content)), format, test.limits)
+				// Plain tar archives are never smaller than their content
+				expected := test.expected
+				if format == Tar && expected == ErrRatio {
+					expected = nil
+				}
+				if !errors.Is(err, expected) {
+					t.Errorf("Expected error %v, got %v", expected, err)
+				}
+				if expected != nil && !IsLimit(err) {
+					t.Errorf("Expected %v to be a limit", err)
+				}
+			})
+		}
+	}
+}
+
+func TestWalkInvalid(t *testing.T) {
+	content := []byte("this is not an archive")
+	for _, format := range []string{Zip, Tar, TarGz} {
+		err := Check(bytes.NewReader(content), int64(len(content)), format, Limits{})
+		if err == nil {
+			t.Errorf("Expected an error for an invalid %s archive", format)
+		}
+		if IsLimit(err) {
+			t.Errorf("Expected %v not to be a limit", err)
+		}
+	}
+	if err := Check(bytes.NewReader(content), int64(len(content)), "rar", Limits{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		mime     string
+		expected string
+	}{
+		{"application/zip", Zip},
+		{"application/x-tar", Tar},
+		{"application/gzip", TarGz},
+		{"application/x-gzip; charset=binary", TarGz},
+		{"application/x-7z-compressed", ""},
+		{"text/plain; charset=utf-8", ""},
+	}
+	for _, test := range tests {
+		if got := Format(test.mime); got != test.expected {
+			t.Errorf("Expected format %q for %s, got %q", test.expected, test.mime, got)
+		}
+	}
+}
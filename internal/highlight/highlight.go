@@ -0,0 +1,389 @@
+// Package highlight splits text into lines of tokens for syntax
+// highlighting. The highlighting is intentionally simple, and recognizes
+// comments, strings, numbers and keywords of common languages, as well as
+// timestamps and levels in log files.
+package highlight
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Classes of tokens
+const (
+	Comment   = "comment"
+	String    = "string"
+	Number    = "number"
+	Keyword   = "keyword"
+	Timestamp = "timestamp"
+	Error     = "error"
+	Warning   = "warning"
+	Info      = "info"
+	Debug     = "debug"
+)
+
+// Token is a piece of a line of text. Text without syntax has an empty
+// class.
+type Token struct {
+	Class string
+	Text  string
+}
+
+// Line is a line of text, numbered from 1
+type Line struct {
+	Number int
+	Tokens []Token
+}
+
+// The log language is highlighted by levels and timestamps rather than by
+// syntax
+const logLanguage = "log"
+
+type syntax struct {
+	lineComments  []string
+	blockComments [][2]string
+	quotes        string
+	keywords      map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var syntaxes = map[string]syntax{
+	"go": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		keywords:      words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+	},
+	"c": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+		keywords:      words("auto break case char class const continue default delete do double else enum extern float for goto if inline int long namespace new private protected public register return short signed sizeof static struct switch template this typedef union unsigned virtual void volatile while NULL nullptr true false"),
+	},
+	"java": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+		keywords:      words("abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch synchronized this throw throws try void volatile while null true false"),
+	},
+	"javascript": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		keywords:      words("async await break case catch class const continue default delete do else export extends finally for function if import in instanceof interface let new of return static super switch this throw try type typeof var void while yield null undefined true false"),
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False"),
+	},
+	"ruby": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords:     words("begin break case class def do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield"),
+	},
+	"shell": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords:     words("case do done elif else esac exit export fi for function if in local return then until while"),
+	},
+	"rust": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"",
+		keywords:      words("as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self static struct super trait true type unsafe use where while"),
+	},
+	"sql": {
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "'\"",
+		keywords:      words("add all alter and as asc by create delete desc distinct drop exists from group having in index insert into is join key left limit not null on or order primary references right select set table union update values where with ADD ALL ALTER AND AS ASC BY CREATE DELETE DESC DISTINCT DROP EXISTS FROM GROUP HAVING IN INDEX INSERT INTO IS JOIN KEY LEFT LIMIT NOT NULL ON OR ORDER PRIMARY REFERENCES RIGHT SELECT SET TABLE UNION UPDATE VALUES WHERE WITH"),
+	},
+	"yaml": {
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keywords:     words("true false null yes no on off"),
+	},
+	"ini": {
+		lineComments: []string{"#", ";"},
+		quotes:       "\"'",
+		keywords:     words("true false"),
+	},
+	"json": {
+		quotes:   "\"",
+		keywords: words("true false null"),
+	},
+	"css": {
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+		keywords:      words("important inherit initial none auto"),
+	},
+	"markup": {
+		blockComments: [][2]string{{"<!--", "-->"}},
+		quotes:        "\"'",
+	},
+}
+
+var extensions = map[string]string{
+	".go":         "go",
+	".c":          "c",
+	".h":          "c",
+	".cc":         "c",
+	".cpp":        "c",
+	".hpp":        "c",
+	".cs":         "java",
+	".java":       "java",
+	".kt":         "java",
+	".js":         "javascript",
+	".mjs":        "javascript",
+	".jsx":        "javascript",
+	".ts":         "javascript",
+	".tsx":        "javascript",
+	".py":         "python",
+	".rb":         "ruby",
+	".sh":         "shell",
+	".bash":       "shell",
+	".zsh":        "shell",
+	".rs":         "rust",
+	".sql":        "sql",
+	".yml":        "yaml",
+	".yaml":       "yaml",
+	".ini":        "ini",
+	".cfg":        "ini",
+	".conf":       "ini",
+	".toml":       "ini",
+	".json":       "json",
+	".css":        "css",
+	".html":       "markup",
+	".htm":        "markup",
+	".xml":        "markup",
+	".svg":        "markup",
+	".log":        logLanguage,
+	"Dockerfile":  "shell",
+	"Makefile":    "shell",
+	".gitignore":  "shell",
+	".dockerfile": "shell",
+}
+
+var mimes = map[string]string{
+	"application/json":       "json",
+	"application/javascript": "javascript",
+	"application/xml":        "markup",
+	"application/x-sh":       "shell",
+	"text/html":              "markup",
+	"text/xml":               "markup",
+	"text/css":               "css",
+	"text/javascript":        "javascript",
+	"text/x-python":          "python",
+	"text/x-shellscript":     "shell",
+	"text/x-c":               "c",
+	"text/x-java":            "java",
+	"text/x-ruby":            "ruby",
+	"text/x-sql":             "sql",
+}
+
+// Language returns the language of a file, based on the extension of the
+// filename or the content type. An empty language is plain text.
+func Language(filename string, mime string) string {
+	base := path.Base(filename)
+	if language, ok := extensions[base]; ok {
+		return language
+	}
+	if language, ok := extensions[strings.ToLower(path.Ext(base))]; ok {
+		return language
+	}
+	mime, _, _ = strings.Cut(mime, ";")
+	if language, ok := mimes[strings.TrimSpace(mime)]; ok {
+		return language
+	}
+	return ""
+}
+
+// Highlight splits the text into numbered lines of tokens in the language
+func Highlight(text string, language string) []Line {
+	text = strings.TrimSuffix(text, "\n")
+	rows := strings.Split(text, "\n")
+	lines := make([]Line, len(rows))
+
+	s, ok := syntaxes[language]
+	blockEnd := ""
+	for i, row := range rows {
+		row = strings.TrimSuffix(row, "\r")
+		lines[i].Number = i + 1
+		switch {
+		case language == logLanguage:
+			lines[i].Tokens = highlightLog(row)
+		case ok:
+			lines[i].Tokens = s.highlight(row, &blockEnd)
+		default:
+			lines[i].Tokens = []Token{{Text: row}}
+		}
+	}
+	return lines
+}
+
+// tokens collects tokens, and merges tokens of the same class
+type tokens []Token
+
+func (t *tokens) add(class string, text string) {
+	if text == "" {
+		return
+	}
+	if n := len(*t); n > 0 && (*t)[n-1].Class == class {
+		(*t)[n-1].Text += text
+		return
+	}
+	*t = append(*t, Token{Class: class, Text: text})
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isWord(c byte) bool {
+	return isWordStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// highlight tokenizes a line. Block comments continue on the next line
+// while blockEnd is set.
+func (s syntax) highlight(line string, blockEnd *string) []Token {
+	var t tokens
+	i := 0
+	for i < len(line) {
+		if *blockEnd != "" {
+			end := strings.Index(line[i:], *blockEnd)
+			if end < 0 {
+				t.add(Comment, line[i:])
+				return t
+			}
+			end += i + len(*blockEnd)
+			t.add(Comment, line[i:end])
+			*blockEnd = ""
+			i = end
+			continue
+		}
+
+		rest := line[i:]
+		matched := false
+		for _, block := range s.blockComments {
+			if strings.HasPrefix(rest, block[0]) {
+				*blockEnd = block[1]
+				t.add(Comment, block[0])
+				i += len(block[0])
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, prefix := range s.lineComments {
+			if strings.HasPrefix(rest, prefix) {
+				t.add(Comment, rest)
+				return t
+			}
+		}
+
+		c := line[i]
+		switch {
+		case strings.IndexByte(s.quotes, c) >= 0:
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(line) {
+				end++
+			} else {
+				end = len(line)
+			}
+			t.add(String, line[i:end])
+			i = end
+		case isDigit(c) && (i == 0 || !isWord(line[i-1])):
+			end := i + 1
+			for end < len(line) && (isWord(line[end]) || line[end] == '.') {
+				end++
+			}
+			t.add(Number, line[i:end])
+			i = end
+		case isWordStart(c):
+			end := i + 1
+			for end < len(line) && isWord(line[end]) {
+				end++
+			}
+			word := line[i:end]
+			if s.keywords[word] {
+				t.add(Keyword, word)
+			} else {
+				t.add("", word)
+			}
+			i = end
+		default:
+			t.add("", line[i:i+1])
+			i++
+		}
+	}
+	return t
+}
+
+var logTimestamp = regexp.MustCompile(`^\[?\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}([.,]\d+)?(Z|[+-]\d{2}:?\d{2})?\]?`)
+
+var logLevels = map[string]string{
+	"FATAL":    Error,
+	"PANIC":    Error,
+	"CRITICAL": Error,
+	"ERROR":    Error,
+	"ERR":      Error,
+	"WARNING":  Warning,
+	"WARN":     Warning,
+	"NOTICE":   Info,
+	"INFO":     Info,
+	"DEBUG":    Debug,
+	"TRACE":    Debug,
+}
+
+// highlightLog tokenizes a line of a log file, with the timestamp at the
+// beginning of the line and the log levels highlighted
+func highlightLog(line string) []Token {
+	var t tokens
+	i := 0
+	if loc := logTimestamp.FindStringIndex(line); loc != nil {
+		t.add(Timestamp, line[:loc[1]])
+		i = loc[1]
+	}
+	for i < len(line) {
+		if isWordStart(line[i]) {
+			end := i + 1
+			for end < len(line) && isWord(line[end]) {
+				end++
+			}
+			// Levels are recognized in upper case, or in any case
+			// as the value of a level field
+			word := line[i:end]
+			class := logLevels[word]
+			if strings.HasSuffix(line[:i], "level=") {
+				class = logLevels[strings.ToUpper(word)]
+			}
+			t.add(class, word)
+			i = end
+			continue
+		}
+		t.add("", line[i:i+1])
+		i++
+	}
+	return t
+}
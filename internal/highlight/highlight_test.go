@@ -0,0 +1,122 @@
+package highlight
+
+import (
+	"testing"
+)
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		filename string
+		mime     string
+		expected string
+	}{
+		{"main.go", "text/plain; charset=utf-8", "go"},
+		{"SCRIPT.PY", "text/plain", "python"},
+		{"Dockerfile", "text/plain", "shell"},
+		{"server.log", "text/plain", "log"},
+		{"data", "application/json", "json"},
+		{"index", "text/html; charset=utf-8", "markup"},
+		{"notes.txt", "text/plain; charset=utf-8", ""},
+	}
+	for _, test := range tests {
+		if got := Language(test.filename, test.mime); got != test.expected {
+			t.Errorf("Expected language %q for %q (%s), got %q", test.expected, test.filename, test.mime, got)
+		}
+	}
+}
+
+func classes(tokens []Token) map[string]string {
+	m := make(map[string]string)
+	for _, token := range tokens {
+		if token.Class != "" {
+			m[token.Text] = token.Class
+		}
+	}
+	return m
+}
+
+func TestHighlight(t *testing.T) {
+	lines := Highlight("func main() {\n\tx := \"a \\\" b\" // note\n\treturn 42\n}\n", "go")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if line.Number != i+1 {
+			t.Errorf("Expected line number %d, got %d", i+1, line.Number)
+		}
+	}
+
+	got := classes(lines[0].Tokens)
+	if got["func"] != Keyword {
+		t.Errorf("Expected func to be a keyword, got %v", got)
+	}
+	if _, ok := got["main"]; ok {
+		t.Errorf("Expected main to have no class, got %v", got)
+	}
+
+	got = classes(lines[1].Tokens)
+	if got[`"a \" b"`] != String {
+		t.Errorf("Expected a string with an escaped quote, got %v", got)
+	}
+	if got["// note"] != Comment {
+		t.Errorf("Expected a line comment, got %v", got)
+	}
+
+	got = classes(lines[2].Tokens)
+	if got["42"] != Number {
+		t.Errorf("Expected a number, got %v", got)
+	}
+
+	// The tokens make up the whole line
+	text := ""
+	for _, token := range lines[1].Tokens {
+		text += token.Text
+	}
+	if text != "\tx := \"a \\\" b\" // note" {
+		t.Errorf("Unexpected text of tokens: %q", text)
+	}
+}
+
+func TestHighlightBlockComment(t *testing.T) {
+	lines := Highlight("a /* one\ntwo\nthree */ b", "c")
+	if got := classes(lines[0].Tokens); got["/* one"] != Comment {
+		t.Errorf("Expected the start of the block comment, got %v", got)
+	}
+	if got := classes(lines[1].Tokens); got["two"] != Comment {
+		t.Errorf("Expected the block comment to continue, got %v", got)
+	}
+	got := classes(lines[2].Tokens)
+	if got["three */"] != Comment {
+		t.Errorf("Expected the end of the block comment, got %v", got)
+	}
+	if _, ok := got[" b"]; ok {
+		t.Errorf("Expected no class after the block comment, got %v", got)
+	}
+}
+
+func TestHighlightLog(t *testing.T) {
+	lines := Highlight("2024-01-02T03:04:05Z ERROR failed\ntime=now level=warn retry\nno info here", "log")
+	got := classes(lines[0].Tokens)
+	if got["2024-01-02T03:04:05Z"] != Timestamp {
+		t.Errorf("Expected a timestamp, got %v", got)
+	}
+	if got["ERROR"] != Error {
+		t.Errorf("Expected an error level, got %v", got)
+	}
+	if got := classes(lines[1].Tokens); got["warn"] != Warning {
+		t.Errorf("Expected a warning level, got %v", got)
+	}
+	if got := classes(lines[2].Tokens); len(got) != 0 {
+		t.Errorf("Expected no levels in lower case text, got %v", got)
+	}
+}
+
+func TestHighlightPlain(t *testing.T) {
+	lines := Highlight("one\r\ntwo", "")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	if len(lines[0].Tokens) != 1 || lines[0].Tokens[0].Text != "one" || lines[0].Tokens[0].Class != "" {
+		t.Errorf("Expected a plain token, got %v", lines[0].Tokens)
+	}
+}
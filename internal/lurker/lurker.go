@@ -6,6 +6,7 @@ import (
 
 	"github.com/espebra/filebin2/internal/dbl"
 	"github.com/espebra/filebin2/internal/s3"
+	"github.com/espebra/filebin2/internal/webhook"
 	"github.com/espebra/filebin2/internal/workspace"
 )
 
@@ -13,6 +14,7 @@ type Lurker struct {
 	dao       *dbl.DAO
 	s3        *s3.S3AO
 	workspace *workspace.Manager
+	webhooks  *webhook.Dispatcher
 	interval  time.Duration
 	throttle  time.Duration
 	retention uint64
@@ -28,6 +30,11 @@ func New(dao *dbl.DAO, s3ao *s3.S3AO, wm *workspace.Manager) *Lurker {
 	}
 }
 
+// SetWebhooks sets the dispatcher that bin expiry events are sent to
+func (l *Lurker) SetWebhooks(d *webhook.Dispatcher) {
+	l.webhooks = d
+}
+
 func (l *Lurker) Init(interval int, throttle int, retention uint64) {
 	l.interval = time.Second * time.Duration(interval)
 	l.throttle = time.Millisecond * time.Duration(throttle)
@@ -59,9 +66,17 @@ func (l *Lurker) runOnce() {
 	}()
 	t0 := time.Now()
 	l.DeletePendingBins()
+	l.DeletePendingUploads()
+	l.DeletePendingDirectUploads()
+	l.DeleteExpiredAliases()
 	l.DeletePendingContent()
 	l.CleanTransactions()
 	l.CleanClients()
+	l.CleanClientUsage()
+	l.CleanTransfers()
+	l.CleanPolicies()
+	l.CleanWebhookDeliveries()
+	l.CleanJobs()
 	l.CleanWorkspaceFiles()
 	slog.Debug("lurker completed run", "duration_seconds", time.Since(t0).Seconds())
 }
@@ -90,10 +105,78 @@ func (l *Lurker) DeletePendingBins() {
 				return
 			}
 			slog.Info("marked bin as deleted", "bin", bin.Id)
+			l.webhooks.Enqueue(webhook.BinEvent(webhook.BinExpired, bin))
 		}
 	}
 }
 
+// DeletePendingUploads removes resumable uploads that were not completed
+// before they expired, or that target bins that no longer accept uploads,
+// together with their parts, staged object and tails in S3.
+func (l *Lurker) DeletePendingUploads() {
+	uploads, err := l.dao.Upload().GetPendingDelete()
+	if err != nil {
+		slog.Error("unable to get pending upload deletions", "error", err)
+		return
+	}
+	if len(uploads) > 0 {
+		slog.Info("found stale uploads pending removal", "count", len(uploads))
+		for _, upload := range uploads {
+			// The multipart upload no longer exists if the parts were
+			// assembled before the upload was abandoned.
+			_ = l.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+			if err := l.s3.RemoveKeysWithPrefix(upload.ObjectKey); err != nil {
+				slog.Error("unable to remove staged objects", "upload", upload.Id, "key", upload.ObjectKey, "error", err)
+				continue
+			}
+			if err := l.dao.Upload().Delete(&upload); err != nil {
+				slog.Error("unable to delete upload", "upload", upload.Id, "error", err)
+				continue
+			}
+			slog.Info("removed stale upload", "upload", upload.Id, "filename", upload.Filename, "bin", upload.Bin, "offset", upload.Offset, "bytes", upload.Bytes)
+		}
+	}
+}
+
+// DeletePendingDirectUploads removes direct uploads that were not finalized
+// before they expired, together with their parts or staged object in S3.
+func (l *Lurker) DeletePendingDirectUploads() {
+	uploads, err := l.dao.DirectUpload().GetPendingDelete()
+	if err != nil {
+		slog.Error("unable to get pending direct upload deletions", "error", err)
+		return
+	}
+	if len(uploads) > 0 {
+		slog.Info("found stale direct uploads pending removal", "count", len(uploads))
+		for _, upload := range uploads {
+			// The multipart upload no longer exists if the parts were
+			// assembled before the upload was abandoned.
+			_ = l.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+			if err := l.s3.RemoveKey(upload.ObjectKey); err != nil {
+				slog.Error("unable to remove staged object", "upload", upload.Id, "key", upload.ObjectKey, "error", err)
+				continue
+			}
+			if err := l.dao.DirectUpload().Delete(&upload); err != nil {
+				slog.Error("unable to delete direct upload", "upload", upload.Id, "error", err)
+				continue
+			}
+			slog.Info("removed stale direct upload", "upload", upload.Id, "filename", upload.Filename, "bin", upload.Bin, "bytes", upload.Bytes)
+		}
+	}
+}
+
+// DeleteExpiredAliases removes read-only bin aliases that have expired.
+func (l *Lurker) DeleteExpiredAliases() {
+	count, err := l.dao.BinAlias().DeleteExpired()
+	if err != nil {
+		slog.Error("unable to delete expired aliases", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed expired aliases", "count", count)
+	}
+}
+
 func (l *Lurker) DeletePendingContent() {
 	contents, err := l.dao.FileContent().GetPendingDelete()
 	if err != nil {
@@ -103,7 +186,7 @@ func (l *Lurker) DeletePendingContent() {
 	if len(contents) > 0 {
 		slog.Info("found content objects pending removal", "count", len(contents))
 		for _, content := range contents {
-			// Safety check: verify no files reference this content
+			// Safety check: verify no files or file versions reference this content
 			count, err := l.dao.File().CountBySHA256(content.SHA256)
 			if err != nil {
 				slog.Error("unable to count files for SHA256", "sha256", content.SHA256, "error", err)
@@ -120,6 +203,23 @@ func (l *Lurker) DeletePendingContent() {
 				return
 			}
 
+			// Delete the thumbnails of the content from S3
+			thumbnails, err := l.dao.Thumbnail().GetBySHA256(content.SHA256)
+			if err != nil {
+				slog.Error("unable to get thumbnails", "sha256", content.SHA256, "error", err)
+				return
+			}
+			for _, thumbnail := range thumbnails {
+				if err := l.s3.RemoveThumbnail(thumbnail.SHA256, thumbnail.Size); err != nil {
+					slog.Error("failed to remove thumbnail from S3", "sha256", thumbnail.SHA256, "size", thumbnail.Size, "error", err)
+					return
+				}
+			}
+			if err := l.dao.Thumbnail().DeleteBySHA256(content.SHA256); err != nil {
+				slog.Error("unable to delete thumbnails", "sha256", content.SHA256, "error", err)
+				return
+			}
+
 			// Mark as not in storage (or delete the record)
 			content.InStorage = false
 			if err := l.dao.FileContent().Update(&content); err != nil {
@@ -163,3 +263,63 @@ func (l *Lurker) CleanClients() {
 		slog.Info("removed client entries", "count", count)
 	}
 }
+
+// CleanClientUsage removes the client usage that no longer counts towards
+// the per-client limits.
+func (l *Lurker) CleanClientUsage() {
+	count, err := l.dao.ClientUsage().Cleanup()
+	if err != nil {
+		slog.Error("unable to cleanup client usage", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed client usage", "count", count)
+	}
+}
+
+// CleanTransfers removes the transfers of proxied downloads that range
+// requests no longer continue.
+func (l *Lurker) CleanTransfers() {
+	count, err := l.dao.Transfer().Cleanup()
+	if err != nil {
+		slog.Error("unable to cleanup transfers", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed transfers", "count", count)
+	}
+}
+
+// CleanPolicies removes the rules of the access policy that have expired.
+func (l *Lurker) CleanPolicies() {
+	count, err := l.dao.Policy().DeleteExpired()
+	if err != nil {
+		slog.Error("unable to remove expired access policy rules", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed expired access policy rules", "count", count)
+	}
+}
+
+func (l *Lurker) CleanWebhookDeliveries() {
+	count, err := l.dao.Webhook().Cleanup(l.retention)
+	if err != nil {
+		slog.Error("unable to cleanup webhook deliveries", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed webhook deliveries", "count", count)
+	}
+}
+
+func (l *Lurker) CleanJobs() {
+	count, err := l.dao.Job().Cleanup(l.retention)
+	if err != nil {
+		slog.Error("unable to cleanup jobs", "error", err)
+		return
+	}
+	if count > 0 {
+		slog.Info("removed finished jobs", "count", count)
+	}
+}
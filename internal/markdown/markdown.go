@@ -0,0 +1,363 @@
+// Package markdown renders a subset of Markdown to HTML. The text is
+// escaped before any markup is added, so the output only contains the
+// elements that the renderer produces itself: paragraphs, headings, lists,
+// block quotes, code, emphasis and links to http, https and mailto URLs.
+// Raw HTML in the text is shown as text, and line breaks within paragraphs
+// are kept.
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	headingLine     = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	ruleLine        = regexp.MustCompile(`^ {0,3}((\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$`)
+	unorderedItem   = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	orderedItem     = regexp.MustCompile(`^ {0,3}[0-9]{1,9}[.)][ \t]+(.*)$`)
+	quoteLine       = regexp.MustCompile(`^ {0,3}>[ \t]?(.*)$`)
+	fenceLine       = regexp.MustCompile("^ {0,3}(```|~~~)")
+	continuationRow = regexp.MustCompile(`^[ \t]+\S`)
+)
+
+// The attributes of links, which are not followed by search engines and do
+// not give the linked page access to the bin page
+const linkAttributes = ` rel="nofollow noopener noreferrer"`
+
+// Emphasis and links are looked for within this many bytes from where they
+// start, and block quotes are nested this deep at most, which keeps the
+// rendering time linear in the length of the text
+const (
+	maxSpan       = 2048
+	maxQuoteDepth = 8
+)
+
+// Render returns the text as HTML
+func Render(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return blocks(strings.Split(s, "\n"), 0)
+}
+
+// blocks renders the lines of text as paragraphs, headings, lists, block
+// quotes and code blocks
+func blocks(lines []string, depth int) string {
+	var b strings.Builder
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>")
+			b.WriteString(inline(strings.Join(paragraph, "\n")))
+			b.WriteString("</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+
+		// Fenced code blocks are kept as they are, up to the closing
+		// fence or the end of the text
+		if m := fenceLine.FindStringSubmatch(line); m != nil {
+			flush()
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+			continue
+		}
+
+		if m := headingLine.FindStringSubmatch(line); m != nil {
+			flush()
+			level := string('0' + rune(len(m[1])))
+			b.WriteString("<h" + level + ">")
+			b.WriteString(inline(m[2]))
+			b.WriteString("</h" + level + ">\n")
+			continue
+		}
+
+		if ruleLine.MatchString(line) {
+			flush()
+			b.WriteString("<hr>\n")
+			continue
+		}
+
+		if depth < maxQuoteDepth && quoteLine.MatchString(line) {
+			flush()
+			var quote []string
+			for ; i < len(lines); i++ {
+				m := quoteLine.FindStringSubmatch(lines[i])
+				if m == nil {
+					i--
+					break
+				}
+				quote = append(quote, m[1])
+			}
+			b.WriteString("<blockquote>\n")
+			b.WriteString(blocks(quote, depth+1))
+			b.WriteString("</blockquote>\n")
+			continue
+		}
+
+		if item, tag := listItem(line); tag != "" {
+			flush()
+			i = renderList(&b, lines, i, item, tag)
+			continue
+		}
+
+		paragraph = append(paragraph, strings.TrimSpace(line))
+	}
+	flush()
+	return b.String()
+}
+
+// listItem returns the text of a list item and the tag of the list that it
+// belongs to, or an empty tag if the line is not a list item
+func listItem(line string) (string, string) {
+	if ruleLine.MatchString(line) {
+		return "", ""
+	}
+	if m := unorderedItem.FindStringSubmatch(line); m != nil {
+		return m[1], "ul"
+	}
+	if m := orderedItem.FindStringSubmatch(line); m != nil {
+		return m[1], "ol"
+	}
+	return "", ""
+}
+
+// renderList renders the list that starts at line i, and returns the index
+// of the last line of the list. Indented lines continue the item before
+// them.
+func renderList(b *strings.Builder, lines []string, i int, item string, tag string) int {
+	items := []string{item}
+	for i+1 < len(lines) {
+		next := lines[i+1]
+		if text, nextTag := listItem(next); nextTag == tag {
+			items = append(items, text)
+		} else if nextTag == "" && continuationRow.MatchString(next) {
+			items[len(items)-1] += "\n" + strings.TrimSpace(next)
+		} else {
+			break
+		}
+		i++
+	}
+	b.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		b.WriteString("<li>")
+		b.WriteString(inline(item))
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// inline renders the code spans, links, emphasis and line breaks in a
+// block of text
+func inline(s string) string {
+	return render(s, true)
+}
+
+// render renders a block of text. Links are not rendered within the text of
+// other links.
+func render(s string, links bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunctuation(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			n := run(s[i:], '`')
+			fence := s[i : i+n]
+			if end := strings.Index(s[i+n:], fence); end >= 0 {
+				code := strings.TrimSpace(s[i+n : i+n+end])
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n + end + n
+				continue
+			}
+			b.WriteString(fence)
+			i += n
+			continue
+
+		case c == '[' && links:
+			if text, href, n, ok := link(s[i:]); ok {
+				if safeURL(href) {
+					b.WriteString(`<a href="` + html.EscapeString(href) + `"` + linkAttributes + ">" + render(text, false) + "</a>")
+				} else {
+					b.WriteString(render(text, links))
+				}
+				i += n
+				continue
+			}
+
+		case c == 'h' && links && (strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://")) && wordBoundary(s, i):
+			if href := autolink(s[i:]); safeURL(href) {
+				b.WriteString(`<a href="` + html.EscapeString(href) + `"` + linkAttributes + ">" + html.EscapeString(href) + "</a>")
+				i += len(href)
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if out, n, ok := emphasis(s, i, links); ok {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+
+		case c == '\n':
+			b.WriteString("<br>\n")
+			i++
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+	return b.String()
+}
+
+// emphasis renders the emphasis that starts at position i of the text, and
+// returns the length of the text that it covers
+func emphasis(s string, i int, links bool) (string, int, bool) {
+	marker := s[i]
+	n := min(run(s[i:], marker), 2)
+	if !wordBoundary(s, i) || i+n >= len(s) || unicode.IsSpace(rune(s[i+n])) {
+		return "", 0, false
+	}
+	delimiter := strings.Repeat(string(marker), n)
+	for j := i + n + 1; j < len(s) && j-i < maxSpan; j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if !strings.HasPrefix(s[j:], delimiter) || unicode.IsSpace(rune(s[j-1])) {
+			continue
+		}
+		// Underscores within words, such as in snake_case, are not
+		// emphasis
+		if end := j + n; marker == '_' && end < len(s) && isWordByte(s[end]) {
+			continue
+		}
+		tag := "em"
+		if n == 2 {
+			tag = "strong"
+		}
+		return "<" + tag + ">" + render(s[i+n:j], links) + "</" + tag + ">", j + n - i, true
+	}
+	return "", 0, false
+}
+
+// link parses a link like [text](url) at the start of the text, and returns
+// the length of the text that it covers
+func link(s string) (string, string, int, bool) {
+	depth := 0
+	for i := 0; i < len(s) && i < maxSpan; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(s[i+2:min(len(s), i+2+maxSpan)], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			href := strings.TrimSpace(s[i+2 : i+2+end])
+			if strings.ContainsAny(href, " \t\n") {
+				return "", "", 0, false
+			}
+			return s[1:i], href, i + 2 + end + 1, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// autolink returns the URL at the start of the text, without the
+// punctuation that ends the sentence it is in
+func autolink(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '<' || r == '>' || r == '"'
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	return strings.TrimRight(s[:end], ".,:;!?)'*_")
+}
+
+// safeURL returns true if the URL is a http, https or mailto URL, or a link
+// within the same site
+func safeURL(href string) bool {
+	if href == "" {
+		return false
+	}
+	for _, r := range href {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	case "":
+		return strings.HasPrefix(href, "/") || strings.HasPrefix(href, "#")
+	}
+	return false
+}
+
+// run returns the number of times the byte is repeated at the start of the
+// text
+func run(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// wordBoundary returns true if position i of the text is not preceded by a
+// letter or a digit
+func wordBoundary(s string, i int) bool {
+	return i == 0 || !isWordByte(s[i-1])
+}
+
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func isPunctuation(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
@@ -0,0 +1,80 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"empty", "", ""},
+		{"paragraphs", "first\nline\n\nsecond", "<p>first<br>\nline</p>\n<p>second</p>\n"},
+		{"heading", "## Photos ##", "<h2>Photos</h2>\n"},
+		{"emphasis", "*some* **bold** _text_", "<p><em>some</em> <strong>bold</strong> <em>text</em></p>\n"},
+		{"snake case", "a snake_case_name", "<p>a snake_case_name</p>\n"},
+		{"unmatched emphasis", "2 * 3 = 6", "<p>2 * 3 = 6</p>\n"},
+		{"code span", "run `rm -rf <dir>`", "<p>run <code>rm -rf &lt;dir&gt;</code></p>\n"},
+		{"code block", "```\n<b>bold</b>\n```", "<pre><code>&lt;b&gt;bold&lt;/b&gt;</code></pre>\n"},
+		{"unordered list", "- one\n- two\n  more", "<ul>\n<li>one</li>\n<li>two<br>\nmore</li>\n</ul>\n"},
+		{"ordered list", "1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"block quote", "> quoted\n> text", "<blockquote>\n<p>quoted<br>\ntext</p>\n</blockquote>\n"},
+		{"rule", "---", "<hr>\n"},
+		{"link", "[filebin](https://filebin.net/)", `<p><a href="https://filebin.net/" rel="nofollow noopener noreferrer">filebin</a></p>` + "\n"},
+		{"relative link", "[bin](/mybin)", `<p><a href="/mybin" rel="nofollow noopener noreferrer">bin</a></p>` + "\n"},
+		{"mailto link", "[mail](mailto:a@example.com)", `<p><a href="mailto:a@example.com" rel="nofollow noopener noreferrer">mail</a></p>` + "\n"},
+		{"autolink", "see https://filebin.net/mybin.", `<p>see <a href="https://filebin.net/mybin" rel="nofollow noopener noreferrer">https://filebin.net/mybin</a>.</p>` + "\n"},
+		{"escaped", `\*not emphasis\*`, "<p>*not emphasis*</p>\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Render(test.input); got != test.expected {
+				t.Errorf("Unexpected output for %q:\n got %q\nwant %q", test.input, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderUnsafe(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"javascript link", "[click](javascript:alert(1))", "<p>click)</p>\n"},
+		{"javascript link with case", "[click](JaVaScRiPt:alert)", "<p>click</p>\n"},
+		{"data link", "[click](data:text/html;base64,PHNjcmlwdD4=)", "<p>click</p>\n"},
+		{"attribute injection", `[click](https://x.com/"onmouseover="alert)`, `<p><a href="https://x.com/&#34;onmouseover=&#34;alert" rel="nofollow noopener noreferrer">click</a></p>` + "\n"},
+		{"html in link text", "[<img src=x onerror=alert(1)>](https://x.com)", `<p><a href="https://x.com" rel="nofollow noopener noreferrer">&lt;img src=x onerror=alert(1)&gt;</a></p>` + "\n"},
+		{"nested link", "[https://a.com](https://b.com)", `<p><a href="https://b.com" rel="nofollow noopener noreferrer">https://a.com</a></p>` + "\n"},
+		{"autolink quote", `https://x.com/"><script>`, `<p><a href="https://x.com/" rel="nofollow noopener noreferrer">https://x.com/</a>&#34;&gt;&lt;script&gt;</p>` + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Render(test.input); got != test.expected {
+				t.Errorf("Unexpected output for %q:\n got %q\nwant %q", test.input, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderLargeInput(t *testing.T) {
+	// Unmatched markers are looked up within a limited span only
+	inputs := []string{
+		strings.Repeat("*a ", 20000),
+		strings.Repeat("[", 50000),
+		strings.Repeat("> ", 5000),
+	}
+	for _, input := range inputs {
+		t0 := time.Now()
+		Render(input)
+		if elapsed := time.Since(t0); elapsed > 2*time.Second {
+			t.Errorf("Rendering %d bytes took %s", len(input), elapsed)
+		}
+	}
+}
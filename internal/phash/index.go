@@ -0,0 +1,138 @@
+package phash
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Parse decodes a hash as returned by Compute
+func Parse(hash string) (uint64, error) {
+	if len(hash) != 16 {
+		return 0, fmt.Errorf("invalid perceptual hash %q", hash)
+	}
+	value, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q", hash)
+	}
+	return value, nil
+}
+
+// Distance returns the Hamming distance between two hashes, which is the
+// number of bits that differ. Visually similar images have a short distance.
+func Distance(a string, b string) (int, error) {
+	x, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return bits.OnesCount64(x ^ y), nil
+}
+
+// Match is content found in the index, and its distance from the hash that
+// was searched for
+type Match struct {
+	SHA256   string `json:"sha256"`
+	PHash    string `json:"phash"`
+	Distance int    `json:"distance"`
+}
+
+// Index is a BK-tree of hashes. The children of a node are keyed by their
+// distance from it, so a search only visits the subtrees that can hold
+// hashes within the distance that is searched for, instead of comparing
+// with every hash. An index is safe for concurrent searches as long as no
+// hashes are added at the same time.
+type Index struct {
+	root *node
+	size int
+}
+
+type node struct {
+	hash     uint64
+	sha256s  []string
+	children map[int]*node
+}
+
+func NewIndex() *Index {
+	return &Index{}
+}
+
+// Len returns the number of hashes in the index
+func (idx *Index) Len() int {
+	return idx.size
+}
+
+// Add adds the hash of some content to the index. Content with identical
+// hashes share a node.
+func (idx *Index) Add(hash string, sha256 string) error {
+	value, err := Parse(hash)
+	if err != nil {
+		return err
+	}
+	idx.size = idx.size + 1
+	if idx.root == nil {
+		idx.root = &node{hash: value, sha256s: []string{sha256}}
+		return nil
+	}
+	n := idx.root
+	for {
+		distance := bits.OnesCount64(n.hash ^ value)
+		if distance == 0 {
+			n.sha256s = append(n.sha256s, sha256)
+			return nil
+		}
+		child, found := n.children[distance]
+		if !found {
+			if n.children == nil {
+				n.children = make(map[int]*node)
+			}
+			n.children[distance] = &node{hash: value, sha256s: []string{sha256}}
+			return nil
+		}
+		n = child
+	}
+}
+
+// Search returns the content with hashes within maxDistance of the hash,
+// closest first
+func (idx *Index) Search(hash string, maxDistance int) ([]Match, error) {
+	value, err := Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match
+	if idx.root == nil {
+		return matches, nil
+	}
+	queue := []*node{idx.root}
+	for len(queue) > 0 {
+		n := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		distance := bits.OnesCount64(n.hash ^ value)
+		if distance <= maxDistance {
+			for _, sha256 := range n.sha256s {
+				matches = append(matches, Match{SHA256: sha256, PHash: fmt.Sprintf("%016x", n.hash), Distance: distance})
+			}
+		}
+
+		// By the triangle inequality, hashes within maxDistance can only
+		// be found below the children at these distances
+		for d, child := range n.children {
+			if d >= distance-maxDistance && d <= distance+maxDistance {
+				queue = append(queue, child)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].SHA256 < matches[j].SHA256
+	})
+	return matches, nil
+}
@@ -0,0 +1,180 @@
+package phash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		distance int
+		wantErr  bool
+	}{
+		{"0000000000000000", "0000000000000000", 0, false},
+		{"0000000000000000", "0000000000000001", 1, false},
+		{"00000000000000ff", "0000000000000000", 8, false},
+		{"ffffffffffffffff", "0000000000000000", 64, false},
+		{"8f3a000000000000", "8f3a000000000001", 1, false},
+		{"", "0000000000000000", 0, true},
+		{"000000000000000g", "0000000000000000", 0, true},
+		{"00000000000000000", "0000000000000000", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"-"+tt.b, func(t *testing.T) {
+			distance, err := Distance(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Distance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if distance != tt.distance {
+				t.Errorf("Expected distance %d, got %d", tt.distance, distance)
+			}
+		})
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx := NewIndex()
+	if idx.Len() != 0 {
+		t.Fatalf("Expected an empty index")
+	}
+	matches, err := idx.Search("0000000000000000", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Fatalf("Expected no matches in an empty index, got %d", len(matches))
+	}
+
+	for _, entry := range []struct{ hash, sha256 string }{
+		{"0000000000000000", "a"},
+		{"0000000000000003", "b"},
+		{"0000000000000000", "c"},
+		{"ffffffffffffffff", "d"},
+		{"00000000000000ff", "e"},
+	} {
+		if err := idx.Add(entry.hash, entry.sha256); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.Len() != 5 {
+		t.Errorf("Expected 5 hashes in the index, got %d", idx.Len())
+	}
+	if err := idx.Add("invalid", "f"); err == nil {
+		t.Errorf("Expected an error when adding an invalid hash")
+	}
+
+	matches, err = idx.Search("0000000000000001", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Match{
+		{SHA256: "a", PHash: "0000000000000000", Distance: 1},
+		{SHA256: "b", PHash: "0000000000000003", Distance: 1},
+		{SHA256: "c", PHash: "0000000000000000", Distance: 1},
+	}
+	if fmt.Sprint(matches) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, matches)
+	}
+
+	if _, err := idx.Search("invalid", 2); err == nil {
+		t.Errorf("Expected an error when searching for an invalid hash")
+	}
+}
+
+func TestIndexSearchMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	idx := NewIndex()
+	var hashes []string
+	base := rng.Uint64()
+	for i := 0; i < 2000; i++ {
+		// Flip a few bits of the same hash, so that there are hashes at
+		// all distances close to it
+		value := base
+		for j := rng.Intn(24); j > 0; j-- {
+			value ^= 1 << uint(rng.Intn(64))
+		}
+		hash := fmt.Sprintf("%016x", value)
+		hashes = append(hashes, hash)
+		if err := idx.Add(hash, fmt.Sprintf("%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := fmt.Sprintf("%016x", base^1)
+	for _, maxDistance := range []int{0, 3, 8, 12} {
+		expected := 0
+		for _, hash := range hashes {
+			distance, _ := Distance(query, hash)
+			if distance <= maxDistance {
+				expected++
+			}
+		}
+		matches, err := idx.Search(query, maxDistance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != expected {
+			t.Errorf("Expected %d matches within distance %d, got %d", expected, maxDistance, len(matches))
+		}
+		for i := 1; i < len(matches); i++ {
+			if matches[i].Distance < matches[i-1].Distance {
+				t.Fatalf("Expected the matches to be sorted by distance")
+			}
+		}
+	}
+}
+
+// newPatternImage creates an image with a few shapes, scaled to the size
+func newPatternImage(w, h int, inverted bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx := float64(x) / float64(w)
+			fy := float64(y) / float64(h)
+			dark := (fx-0.3)*(fx-0.3)+(fy-0.35)*(fy-0.35) < 0.04 || (fx > 0.55 && fx < 0.9 && fy > 0.5 && fy < 0.85)
+			if dark != inverted {
+				img.Set(x, y, color.RGBA{R: 30, G: 40, B: 90, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 230, G: 220, B: 180, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestDistanceResizedImage(t *testing.T) {
+	original, err := Compute(bytes.NewReader(encodePNG(newPatternImage(256, 256, false))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resized, err := Compute(bytes.NewReader(encodeJPEG(newPatternImage(120, 120, false))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	distance, err := Distance(original, resized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance > 10 {
+		t.Errorf("Expected a re-encoded and resized copy to be similar, got distance %d", distance)
+	}
+
+	// A different image is not similar
+	different, err := Compute(bytes.NewReader(encodePNG(newPatternImage(256, 256, true))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	distance, err = Distance(original, different)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distance <= 10 {
+		t.Errorf("Expected a different image not to be similar, got distance %d", distance)
+	}
+}
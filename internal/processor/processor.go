@@ -0,0 +1,414 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/espebra/filebin2/internal/dbl"
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/phash"
+	"github.com/espebra/filebin2/internal/s3"
+	"github.com/espebra/filebin2/internal/thumbnail"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Versions of the processing done by each kind of job. Bump a version when
+// the processing changes, to make the backfill pick up content that was
+// processed by an earlier version.
+const (
+	MimeVersion      = 1
+	PHashVersion     = 1
+	ThumbnailVersion = 1
+)
+
+// Kinds are the kinds of jobs that the processor handles
+var Kinds = []string{ds.JobMime, ds.JobPHash, ds.JobThumbnail}
+
+// ImageKinds are the kinds of jobs that process image content
+var ImageKinds = []string{ds.JobPHash, ds.JobThumbnail}
+
+const (
+	// Time to sleep between each check for pending jobs when the queue
+	// is empty
+	pollInterval = 5 * time.Second
+
+	// Jobs that have been running for longer than this are assumed to
+	// belong to a worker that stopped, and are claimed again
+	staleAfter = 10 * time.Minute
+
+	// Number of attempts before a job is failed
+	maxAttempts = 3
+
+	// Delay before the first retry, doubled for each failed attempt
+	retryBase = time.Minute
+
+	// Number of bytes to read to detect the content type, the same as
+	// when the content is uploaded
+	mimeHeadBytes = 3072
+
+	// Limits of the images that thumbnails are generated for
+	maxThumbnailBytes  = 64 * 1024 * 1024
+	maxThumbnailPixels = 64 * 1000 * 1000
+)
+
+// MetricsObserver receives the outcome of each job
+type MetricsObserver interface {
+	ObserveJob(kind, outcome string, duration time.Duration)
+}
+
+type Processor struct {
+	dao         *dbl.DAO
+	s3          *s3.S3AO
+	metrics     MetricsObserver
+	concurrency int
+	hook        string
+	hookTimeout time.Duration
+	stopChan    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// New creates a processor of the content processing jobs in the queue
+func New(dao *dbl.DAO, s3ao *s3.S3AO) *Processor {
+	return &Processor{
+		dao: dao,
+		s3:  s3ao,
+	}
+}
+
+// Init sets the number of workers. Processing is disabled with zero
+// workers, in which case jobs are queued but not processed.
+func (p *Processor) Init(concurrency int) {
+	p.concurrency = concurrency
+}
+
+// SetPostUploadHook sets the command that is run for each uploaded file,
+// and the time it is allowed to run
+func (p *Processor) SetPostUploadHook(hook string, timeout time.Duration) {
+	p.hook = hook
+	p.hookTimeout = timeout
+}
+
+func (p *Processor) SetMetrics(m MetricsObserver) {
+	p.metrics = m
+}
+
+// Concurrency returns the number of workers
+func (p *Processor) Concurrency() int {
+	if p == nil {
+		return 0
+	}
+	return p.concurrency
+}
+
+// Version returns the current version of the kind of job, or zero if the
+// kind is unknown
+func Version(kind string) int {
+	switch kind {
+	case ds.JobMime:
+		return MimeVersion
+	case ds.JobPHash:
+		return PHashVersion
+	case ds.JobThumbnail:
+		return ThumbnailVersion
+	}
+	return 0
+}
+
+// Outdated reports whether the content is missing the data from the kind
+// of job, or has data from an earlier version of it
+func Outdated(content *ds.FileContent, kind string) bool {
+	switch kind {
+	case ds.JobMime:
+		return content.MimeVersion < MimeVersion
+	case ds.JobPHash:
+		return content.PHashVersion < PHashVersion
+	case ds.JobThumbnail:
+		return content.ThumbnailVersion < ThumbnailVersion
+	}
+	return false
+}
+
+func (p *Processor) Run() {
+	if p.concurrency <= 0 {
+		slog.Info("content processing is disabled")
+		return
+	}
+	slog.Info("starting content processor", "workers", p.concurrency)
+	p.stopChan = make(chan struct{})
+	for i := 0; i < p.concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				// Keep processing while there are jobs that are due
+				for p.RunOnce() {
+					select {
+					case <-p.stopChan:
+						return
+					default:
+					}
+				}
+				select {
+				case <-time.After(pollInterval):
+					// continue to next iteration
+				case <-p.stopChan:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Stop stops the workers, and waits for the jobs in progress to finish
+func (p *Processor) Stop() {
+	if p != nil && p.stopChan != nil {
+		close(p.stopChan)
+		p.wg.Wait()
+		slog.Info("content processor stopped")
+	}
+}
+
+// RunOnce claims and processes the next job that is due. It returns false
+// if there was no job to process.
+func (p *Processor) RunOnce() bool {
+	job, found, err := p.dao.Job().Claim(staleAfter)
+	if err != nil {
+		slog.Error("unable to claim job", "error", err)
+		return false
+	}
+	if !found {
+		return false
+	}
+
+	t0 := time.Now()
+	err = p.process(&job)
+	duration := time.Since(t0)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	job.RunAt = now
+	if err == nil {
+		job.Status = ds.JobDone
+		job.LastError = ""
+		slog.Debug("processed job", "id", job.Id, "kind", job.Kind, "sha256", job.SHA256, "duration_seconds", duration.Seconds())
+	} else {
+		job.LastError = err.Error()
+		if job.Attempts >= maxAttempts {
+			job.Status = ds.JobFailed
+			slog.Error("gave up processing job", "id", job.Id, "kind", job.Kind, "sha256", job.SHA256, "attempts", job.Attempts, "error", err)
+		} else {
+			job.Status = ds.JobPending
+			job.RunAt = now.Add(retryDelay(job.Attempts))
+			slog.Warn("failed to process job, retrying", "id", job.Id, "kind", job.Kind, "sha256", job.SHA256, "attempts", job.Attempts, "run_at", job.RunAt, "error", err)
+		}
+	}
+	if p.metrics != nil {
+		outcome := job.Status
+		if outcome == ds.JobPending {
+			outcome = "retry"
+		}
+		p.metrics.ObserveJob(job.Kind, outcome, duration)
+	}
+	if err := p.dao.Job().Finish(&job); err != nil {
+		slog.Error("unable to update job", "id", job.Id, "error", err)
+	}
+	return true
+}
+
+// retryDelay returns the delay before the next attempt of a job that has
+// failed the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return retryBase << (attempts - 1)
+}
+
+// process runs the job. Content that has been deleted from storage or
+// blocked since the job was queued is skipped.
+func (p *Processor) process(job *ds.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic: %v", r)
+		}
+	}()
+
+	content, err := p.dao.FileContent().GetBySHA256(job.SHA256)
+	if err != nil {
+		return err
+	}
+	if !content.InStorage || content.Blocked {
+		slog.Debug("skipping job for content that is no longer available", "id", job.Id, "kind", job.Kind, "sha256", job.SHA256)
+		return nil
+	}
+
+	switch job.Kind {
+	case ds.JobMime:
+		return p.detectMime(content)
+	case ds.JobPHash:
+		return p.computePHash(content)
+	case ds.JobThumbnail:
+		return p.generateThumbnails(content)
+	case ds.JobHook:
+		return p.runHook(job, content)
+	}
+	return fmt.Errorf("unknown job kind %q", job.Kind)
+}
+
+// detectMime detects the content type from the first bytes of the content.
+// Images that are missing up to date image data get jobs to process them.
+func (p *Processor) detectMime(content *ds.FileContent) error {
+	end := int64(mimeHeadBytes - 1)
+	if content.Bytes == 0 {
+		return p.dao.FileContent().SetMime(content.SHA256, mimetype.Detect(nil).String(), MimeVersion)
+	}
+	if content.Bytes <= mimeHeadBytes {
+		end = int64(content.Bytes) - 1
+	}
+	head, err := p.s3.GetObject(content.SHA256, 0, end)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = head.Close() }()
+	mime, err := mimetype.DetectReader(head)
+	if err != nil {
+		return err
+	}
+	if err := p.dao.FileContent().SetMime(content.SHA256, mime.String(), MimeVersion); err != nil {
+		return err
+	}
+	if strings.HasPrefix(mime.String(), "image/") {
+		for _, kind := range ImageKinds {
+			if !Outdated(content, kind) {
+				continue
+			}
+			if _, err := p.dao.Job().Enqueue(kind, content.SHA256); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// computePHash computes the perceptual hash of images. Images that can not
+// be decoded get an empty hash, and are not processed again until the
+// version changes.
+func (p *Processor) computePHash(content *ds.FileContent) error {
+	if !strings.HasPrefix(content.Mime, "image/") {
+		return nil
+	}
+	fp, err := p.s3.GetObject(content.SHA256, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fp.Close() }()
+	value, err := phash.Compute(fp)
+	if err != nil {
+		return err
+	}
+	return p.dao.FileContent().SetPHash(content.SHA256, value, PHashVersion)
+}
+
+// generateThumbnails generates the thumbnails of images in each of the
+// thumbnail sizes. Images that can not be decoded, or are too large, get no
+// thumbnails, and are not processed again until the version changes.
+func (p *Processor) generateThumbnails(content *ds.FileContent) error {
+	if !strings.HasPrefix(content.Mime, "image/") {
+		return nil
+	}
+	if content.Bytes > maxThumbnailBytes {
+		slog.Debug("skipping thumbnails of large image", "sha256", content.SHA256, "bytes", content.Bytes)
+		return p.dao.FileContent().SetThumbnailVersion(content.SHA256, ThumbnailVersion)
+	}
+
+	fp, err := p.s3.GetObject(content.SHA256, 0, 0)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(fp)
+	_ = fp.Close()
+	if err != nil {
+		return err
+	}
+
+	img, err := thumbnail.Decode(data, maxThumbnailPixels)
+	if err != nil {
+		slog.Debug("unable to decode image for thumbnails", "sha256", content.SHA256, "error", err)
+		return p.dao.FileContent().SetThumbnailVersion(content.SHA256, ThumbnailVersion)
+	}
+
+	// Scale down from the largest size to the smallest, using the
+	// previous thumbnail as the source of the next
+	sizes := append([]int(nil), ds.ThumbnailSizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	var src image.Image = img
+	for _, size := range sizes {
+		scaled := thumbnail.Scale(src, size)
+		var buf bytes.Buffer
+		if err := thumbnail.Encode(&buf, scaled); err != nil {
+			return err
+		}
+		n := int64(buf.Len())
+		if err := p.s3.PutThumbnail(content.SHA256, size, &buf, n); err != nil {
+			return err
+		}
+		t := ds.Thumbnail{
+			SHA256: content.SHA256,
+			Size:   size,
+			Width:  scaled.Bounds().Dx(),
+			Height: scaled.Bounds().Dy(),
+			Bytes:  uint64(n),
+		}
+		if err := p.dao.Thumbnail().Upsert(&t); err != nil {
+			return err
+		}
+		src = scaled
+	}
+	return p.dao.FileContent().SetThumbnailVersion(content.SHA256, ThumbnailVersion)
+}
+
+// runHook runs the post-upload hook for the uploaded file. The hook is a
+// notification, so its exit code and output are logged, but the job is not
+// retried when the hook fails. Files that have been deleted since the upload
+// are skipped.
+func (p *Processor) runHook(job *ds.Job, content *ds.FileContent) error {
+	if p.hook == "" {
+		slog.Debug("skipping post-upload hook that is not configured", "id", job.Id)
+		return nil
+	}
+	file, found, err := p.dao.File().GetByID(job.FileId)
+	if err != nil {
+		return err
+	}
+	if !found || file.DeletedAt.Valid {
+		slog.Debug("skipping post-upload hook for file that is no longer available", "id", job.Id, "file_id", job.FileId)
+		return nil
+	}
+
+	t0 := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), p.hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.hook,
+		"--bin-id", file.Bin,
+		"--filename", file.Filename,
+		"--content-type", content.Mime,
+		"--size", strconv.FormatUint(content.Bytes, 10),
+		"--sha256", content.SHA256,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Warn("post-upload hook returned an error", "filename", file.Filename, "bin", file.Bin, "error", err, "output", strings.TrimRight(string(output), "\n"))
+		return nil
+	}
+	slog.Debug("ran post-upload hook", "filename", file.Filename, "bin", file.Bin, "hook_seconds", time.Since(t0).Seconds())
+	return nil
+}
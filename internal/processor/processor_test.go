@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.expected {
+			t.Errorf("Expected a delay of %s after %d attempts, got %s", test.expected, test.attempts, got)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	if got := Version(ds.JobMime); got != MimeVersion {
+		t.Errorf("Expected version %d for %s, got %d", MimeVersion, ds.JobMime, got)
+	}
+	if got := Version(ds.JobPHash); got != PHashVersion {
+		t.Errorf("Expected version %d for %s, got %d", PHashVersion, ds.JobPHash, got)
+	}
+	if got := Version("unknown"); got != 0 {
+		t.Errorf("Expected version 0 for an unknown kind, got %d", got)
+	}
+	for _, kind := range Kinds {
+		if Version(kind) < 1 {
+			t.Errorf("Expected a version for kind %s", kind)
+		}
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	content := &ds.FileContent{MimeVersion: MimeVersion}
+	if Outdated(content, ds.JobMime) {
+		t.Error("Expected the content type to be up to date")
+	}
+	for _, kind := range ImageKinds {
+		if !Outdated(content, kind) {
+			t.Errorf("Expected %s to be outdated", kind)
+		}
+	}
+	content.PHashVersion = PHashVersion
+	content.ThumbnailVersion = ThumbnailVersion
+	for _, kind := range ImageKinds {
+		if Outdated(content, kind) {
+			t.Errorf("Expected %s to be up to date", kind)
+		}
+	}
+	if Outdated(content, "unknown") {
+		t.Error("Expected an unknown kind not to be outdated")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	var p *Processor
+	if got := p.Concurrency(); got != 0 {
+		t.Errorf("Expected no workers for a nil processor, got %d", got)
+	}
+	p = New(nil, nil)
+	p.Init(4)
+	if got := p.Concurrency(); got != 4 {
+		t.Errorf("Expected 4 workers, got %d", got)
+	}
+}
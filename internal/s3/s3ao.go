@@ -308,10 +308,12 @@ func (s S3AO) RemoveBucket() error {
 }
 
 func (s S3AO) GetObject(contentSHA256 string, start int64, end int64) (io.ReadCloser, error) {
-	t0 := time.Now()
-
 	// Use content SHA256 as the object key for content-addressable storage
-	objectKey := contentSHA256
+	return s.getObject(contentSHA256, start, end)
+}
+
+func (s S3AO) getObject(objectKey string, start int64, end int64) (io.ReadCloser, error) {
+	t0 := time.Now()
 
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
@@ -341,21 +343,44 @@ func (s S3AO) GetObject(contentSHA256 string, start int64, end int64) (io.ReadCl
 	return result.Body, nil
 }
 
-// PresignedGetObject generates a presigned URL for downloading an object.
-func (s S3AO) PresignedGetObject(contentSHA256 string, filename string, mime string) (presignedURL *url.URL, err error) {
-	// Use content SHA256 as the object key for content-addressable storage
-	objectKey := contentSHA256
-
-	var contentDisposition string
+// ContentDisposition returns the Content-Disposition response header value
+// used when serving a file with the given content type. Files in folders are
+// saved by their name, without the folders.
+func ContentDisposition(filename string, mime string) string {
+	filename = path.Base(filename)
 	switch {
 	case strings.HasPrefix(mime, "text/html"), strings.HasPrefix(mime, "application/pdf"):
 		// Tell browser to handle this as an attachment. For text/html, this
 		// is a small barrier to reduce phishing.
-		contentDisposition = fmt.Sprintf("attachment; filename=%q", filename)
+		return fmt.Sprintf("attachment; filename=%q", filename)
 	default:
 		// Browser to decide how to handle the rest of the content-types
-		contentDisposition = fmt.Sprintf("inline; filename=%q", filename)
+		return InlineDisposition(filename)
 	}
+}
+
+// InlineDisposition returns the Content-Disposition header value that shows
+// the content in the browser regardless of the content type. It is used
+// where the content is embedded in a page, such as PDF files in the
+// preview page.
+func InlineDisposition(filename string) string {
+	return fmt.Sprintf("inline; filename=%q", path.Base(filename))
+}
+
+// PresignedGetObject generates a presigned URL for downloading an object.
+func (s S3AO) PresignedGetObject(contentSHA256 string, filename string, mime string) (presignedURL *url.URL, err error) {
+	return s.presignedGetObject(contentSHA256, mime, ContentDisposition(filename, mime))
+}
+
+// PresignedGetObjectInline generates a presigned URL for an object that is
+// shown inline by the browser.
+func (s S3AO) PresignedGetObjectInline(contentSHA256 string, filename string, mime string) (presignedURL *url.URL, err error) {
+	return s.presignedGetObject(contentSHA256, mime, InlineDisposition(filename))
+}
+
+func (s S3AO) presignedGetObject(contentSHA256 string, mime string, contentDisposition string) (presignedURL *url.URL, err error) {
+	// Use content SHA256 as the object key for content-addressable storage
+	objectKey := contentSHA256
 
 	cacheControl := fmt.Sprintf("max-age=%.0f", s.expiry.Seconds())
 
@@ -448,6 +473,32 @@ func (s S3AO) PutObjectByHash(contentSHA256 string, data io.Reader, size int64)
 	return nil
 }
 
+// ThumbnailKey returns the object key of a thumbnail of the content, which
+// is derived from the key of the content itself
+func ThumbnailKey(contentSHA256 string, size int) string {
+	return fmt.Sprintf("%s.thumb-%d.jpg", contentSHA256, size)
+}
+
+// PutThumbnail uploads a thumbnail of the content
+func (s S3AO) PutThumbnail(contentSHA256 string, size int, data io.Reader, n int64) error {
+	key := ThumbnailKey(contentSHA256, size)
+	if err := s.upload(key, data, n); err != nil {
+		slog.Error("unable to put thumbnail", "key", key, "error", err)
+		return err
+	}
+	return nil
+}
+
+// GetThumbnail fetches a thumbnail of the content
+func (s S3AO) GetThumbnail(contentSHA256 string, size int) (io.ReadCloser, error) {
+	return s.getObject(ThumbnailKey(contentSHA256, size), 0, 0)
+}
+
+// RemoveThumbnail removes a thumbnail of the content
+func (s S3AO) RemoveThumbnail(contentSHA256 string, size int) error {
+	return s.RemoveKey(ThumbnailKey(contentSHA256, size))
+}
+
 // RemoveObjectByHash removes an object using content-addressable storage (SHA256 as key)
 func (s S3AO) RemoveObjectByHash(contentSHA256 string) error {
 	t0 := time.Now()
@@ -497,11 +548,325 @@ func (s S3AO) CopyObject(srcKey, dstKey string) error {
 	return err
 }
 
+// maxCopyObjectSize is the largest object that can be copied with a single
+// CopyObject request. Larger objects are copied in parts.
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// Part is a part of a multipart upload as reported by S3.
+type Part struct {
+	Number         int32
+	Size           int64
+	ETag           string
+	ChecksumSHA256 string // base64 encoded
+}
+
+// CreateMultipartUpload starts a multipart upload to the given key and
+// returns the upload id. Every part of the upload is required to carry a
+// SHA256 checksum, which S3 verifies when the part is received.
+func (s S3AO) CreateMultipartUpload(key string) (string, error) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	result, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		ContentType:       aws.String("application/octet-stream"),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveS3Operation("create_multipart", time.Since(t0))
+		if err != nil {
+			s.metrics.IncrS3OperationError("create_multipart")
+		}
+	}
+	if err != nil {
+		slog.Error("unable to create multipart upload", "key", key, "error", err)
+		return "", err
+	}
+	return aws.ToString(result.UploadId), nil
+}
+
+// PresignedUploadPart generates a presigned URL for uploading a part of a
+// multipart upload. The SHA256 checksum of the part (base64 encoded) is
+// part of the signature, so S3 rejects a part with any other content.
+func (s S3AO) PresignedUploadPart(key string, uploadId string, partNumber int32, checksumSHA256 string, expiry time.Duration) (presignedURL *url.URL, err error) {
+	t0 := time.Now()
+	request, err := s.presignClient.PresignUploadPart(context.Background(), &s3.UploadPartInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		UploadId:       aws.String(uploadId),
+		PartNumber:     aws.Int32(partNumber),
+		ChecksumSHA256: aws.String(checksumSHA256),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expiry
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveS3Operation("presign", time.Since(t0))
+		if err != nil {
+			s.metrics.IncrS3OperationError("presign")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	presignedURL, err = url.Parse(request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return presignedURL, nil
+}
+
+// ListParts returns the parts that S3 has received for a multipart upload,
+// ordered by part number.
+func (s S3AO) ListParts(key string, uploadId string) (parts []Part, err error) {
+	t0 := time.Now()
+	defer func() {
+		if s.metrics != nil {
+			s.metrics.ObserveS3Operation("list_parts", time.Since(t0))
+			if err != nil {
+				s.metrics.IncrS3OperationError("list_parts")
+			}
+		}
+	}()
+
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return parts, err
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, Part{
+				Number:         aws.ToInt32(part.PartNumber),
+				Size:           aws.ToInt64(part.Size),
+				ETag:           aws.ToString(part.ETag),
+				ChecksumSHA256: aws.ToString(part.ChecksumSHA256),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the given parts into the object.
+func (s S3AO) CompleteMultipartUpload(key string, uploadId string, parts []Part) error {
+	t0 := time.Now()
+
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber:     aws.Int32(part.Number),
+			ETag:           aws.String(part.ETag),
+			ChecksumSHA256: aws.String(part.ChecksumSHA256),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.transferTimeout)
+	defer cancel()
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveS3Operation("complete_multipart", time.Since(t0))
+		if err != nil {
+			s.metrics.IncrS3OperationError("complete_multipart")
+		}
+	}
+	if err != nil {
+		slog.Error("unable to complete multipart upload", "key", key, "error", err)
+		return err
+	}
+	slog.Debug("completed multipart upload", "key", key, "parts", len(parts), "duration_seconds", time.Since(t0).Seconds())
+	return nil
+}
+
+// AbortMultipartUpload aborts a multipart upload and discards the parts
+// that have been received.
+func (s S3AO) AbortMultipartUpload(key string, uploadId string) error {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveS3Operation("abort_multipart", time.Since(t0))
+		if err != nil {
+			s.metrics.IncrS3OperationError("abort_multipart")
+		}
+	}
+	return err
+}
+
+// UploadPart uploads a part of a multipart upload. The SHA256 checksum of
+// the part (base64 encoded) is verified by S3 when the part is received.
+func (s S3AO) UploadPart(key string, uploadId string, partNumber int32, data io.ReadSeeker, size int64, checksumSHA256 string) error {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.transferTimeout)
+	defer cancel()
+
+	_, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		UploadId:       aws.String(uploadId),
+		PartNumber:     aws.Int32(partNumber),
+		Body:           data,
+		ContentLength:  aws.Int64(size),
+		ChecksumSHA256: aws.String(checksumSHA256),
+	})
+	if s.metrics != nil {
+		s.metrics.ObserveS3Operation("upload_part", time.Since(t0))
+		if err != nil {
+			s.metrics.IncrS3OperationError("upload_part")
+		}
+	}
+	if err != nil {
+		slog.Error("unable to upload part", "key", key, "part", partNumber, "error", err)
+		return err
+	}
+	slog.Debug("uploaded part", "key", key, "part", partNumber, "bytes", size, "duration_seconds", time.Since(t0).Seconds())
+	return nil
+}
+
+// PutKey stores an object at the given key.
+func (s S3AO) PutKey(key string, data io.Reader, size int64) error {
+	if err := s.upload(key, data, size); err != nil {
+		slog.Error("unable to put object", "key", key, "error", err)
+		return err
+	}
+	return nil
+}
+
+// RemoveKeysWithPrefix removes the objects with keys that start with the
+// given prefix.
+func (s S3AO) RemoveKeysWithPrefix(prefix string) error {
+	objects, err := s.ListObjectsWithPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := s.RemoveKey(aws.ToString(object.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyObjectByHash copies an object to its content-addressable key (SHA256
+// as key). Objects larger than what a single CopyObject request supports
+// are copied in parts.
+func (s S3AO) CopyObjectByHash(srcKey string, contentSHA256 string, size int64) (err error) {
+	t0 := time.Now()
+	defer func() {
+		if s.metrics != nil {
+			s.metrics.ObserveS3Operation("copy", time.Since(t0))
+			if err != nil {
+				s.metrics.IncrS3OperationError("copy")
+			}
+		}
+	}()
+
+	if size <= maxCopyObjectSize {
+		err = s.CopyObject(srcKey, contentSHA256)
+	} else {
+		err = s.copyObjectInParts(srcKey, contentSHA256, size)
+	}
+	if err != nil {
+		slog.Error("unable to copy object", "key", srcKey, "sha256", contentSHA256, "error", err)
+		return err
+	}
+	slog.Debug("copied object", "key", srcKey, "sha256", contentSHA256, "duration_seconds", time.Since(t0).Seconds())
+	return nil
+}
+
+func (s S3AO) copyObjectInParts(srcKey string, dstKey string, size int64) error {
+	// S3 allows at most 10000 parts in a multipart upload
+	partSize := s.partSize
+	if minPartSize := (size + 9999) / 10000; partSize < minPartSize {
+		partSize = minPartSize
+	}
+
+	ctx := context.Background()
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(dstKey),
+		ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		return err
+	}
+
+	var completed []types.CompletedPart
+	var partNumber int32
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		partNumber++
+		result, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        created.UploadId,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(fmt.Sprintf("%s/%s", s.bucket, srcKey)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			_ = s.AbortMultipartUpload(dstKey, aws.ToString(created.UploadId))
+			return err
+		}
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(partNumber),
+			ETag:       result.CopyPartResult.ETag,
+		})
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(dstKey),
+		UploadId: created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	})
+	if err != nil {
+		_ = s.AbortMultipartUpload(dstKey, aws.ToString(created.UploadId))
+		return err
+	}
+	return nil
+}
+
 // GetClient returns the underlying S3 client (for advanced operations)
 func (s S3AO) GetClient() *s3.Client {
 	return s.client
 }
 
+// GetPartSize returns the multipart upload part size in bytes
+func (s S3AO) GetPartSize() int64 {
+	return s.partSize
+}
+
 // GetBucket returns the bucket name
 func (s S3AO) GetBucket() string {
 	return s.bucket
@@ -3,8 +3,10 @@ package s3
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -236,3 +238,149 @@ func TestMultipartUpload(t *testing.T) {
 		t.Error("Downloaded content does not match uploaded content")
 	}
 }
+
+func TestPresignedMultipartUpload(t *testing.T) {
+	s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(s3ao) }()
+
+	// All parts except the last one must be at least 5 MB
+	partSize := 5 * 1024 * 1024
+	content := make([]byte, partSize+1024)
+	for i := range content {
+		content[i] = byte(i % 256)
+	}
+	chunks := [][]byte{content[:partSize], content[partSize:]}
+
+	key := "staging/testupload"
+	uploadId, err := s3ao.CreateMultipartUpload(key)
+	if err != nil {
+		t.Fatalf("Unable to create multipart upload: %s", err)
+	}
+
+	for i, chunk := range chunks {
+		checksum := sha256.Sum256(chunk)
+		presignedURL, err := s3ao.PresignedUploadPart(key, uploadId, int32(i+1), base64.StdEncoding.EncodeToString(checksum[:]), time.Minute)
+		if err != nil {
+			t.Fatalf("Unable to presign part %d: %s", i+1, err)
+		}
+		req, err := http.NewRequest(http.MethodPut, presignedURL.String(), bytes.NewReader(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unable to upload part %d: %s", i+1, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status code %d when uploading part %d", resp.StatusCode, i+1)
+		}
+	}
+
+	parts, err := s3ao.ListParts(key, uploadId)
+	if err != nil {
+		t.Fatalf("Unable to list parts: %s", err)
+	}
+	if len(parts) != len(chunks) {
+		t.Fatalf("Was expecting %d parts, got %d", len(chunks), len(parts))
+	}
+	for i, part := range parts {
+		checksum := sha256.Sum256(chunks[i])
+		if part.ChecksumSHA256 != base64.StdEncoding.EncodeToString(checksum[:]) {
+			t.Errorf("Unexpected checksum of part %d: %s", part.Number, part.ChecksumSHA256)
+		}
+	}
+
+	if err := s3ao.CompleteMultipartUpload(key, uploadId, parts); err != nil {
+		t.Fatalf("Unable to complete multipart upload: %s", err)
+	}
+
+	contentSHA256 := fmt.Sprintf("%x", sha256.Sum256(content))
+	if err := s3ao.CopyObjectByHash(key, contentSHA256, int64(len(content))); err != nil {
+		t.Fatalf("Unable to copy object: %s", err)
+	}
+	if err := s3ao.RemoveKey(key); err != nil {
+		t.Errorf("Unable to remove staged object: %s", err)
+	}
+
+	fp, err := s3ao.GetObject(contentSHA256, 0, 0)
+	if err != nil {
+		t.Fatalf("Unable to get object: %s", err)
+	}
+	defer func() { _ = fp.Close() }()
+
+	downloaded, err := io.ReadAll(fp)
+	if err != nil {
+		t.Fatalf("Unable to read downloaded object: %s", err)
+	}
+	if !bytes.Equal(content, downloaded) {
+		t.Error("Downloaded content does not match uploaded content")
+	}
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(s3ao) }()
+
+	key := "staging/abortedupload"
+	uploadId, err := s3ao.CreateMultipartUpload(key)
+	if err != nil {
+		t.Fatalf("Unable to create multipart upload: %s", err)
+	}
+	if err := s3ao.AbortMultipartUpload(key, uploadId); err != nil {
+		t.Errorf("Unable to abort multipart upload: %s", err)
+	}
+	if _, err := s3ao.ListParts(key, uploadId); err == nil {
+		t.Error("Was expecting an error when listing parts of an aborted upload")
+	}
+}
+
+func TestUploadPart(t *testing.T) {
+	s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tearDown(s3ao) }()
+
+	content := []byte("the last and only part")
+	key := "staging/uploadedpart"
+	uploadId, err := s3ao.CreateMultipartUpload(key)
+	if err != nil {
+		t.Fatalf("Unable to create multipart upload: %s", err)
+	}
+	checksum := sha256.Sum256(content)
+	if err := s3ao.UploadPart(key, uploadId, 1, bytes.NewReader(content), int64(len(content)), base64.StdEncoding.EncodeToString(checksum[:])); err != nil {
+		t.Fatalf("Unable to upload part: %s", err)
+	}
+	parts, err := s3ao.ListParts(key, uploadId)
+	if err != nil {
+		t.Fatalf("Unable to list parts: %s", err)
+	}
+	if len(parts) != 1 || parts[0].Size != int64(len(content)) {
+		t.Fatalf("Was expecting one part of %d bytes, got %v", len(content), parts)
+	}
+	if err := s3ao.CompleteMultipartUpload(key, uploadId, parts); err != nil {
+		t.Fatalf("Unable to complete multipart upload: %s", err)
+	}
+
+	// Objects that share the prefix are removed together
+	if err := s3ao.PutKey(key+".tail.1", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Unable to put object: %s", err)
+	}
+	if err := s3ao.RemoveKeysWithPrefix(key); err != nil {
+		t.Fatalf("Unable to remove objects: %s", err)
+	}
+	objects, err := s3ao.ListObjectsWithPrefix(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Was expecting the objects to be removed, got %d", len(objects))
+	}
+}
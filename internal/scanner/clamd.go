@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the chunks that content is streamed to
+// clamd in. clamd rejects chunks larger than its StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// Clamd scans content using the INSTREAM command of clamd
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd returns a scanner that connects to clamd at the given address.
+// Unix sockets are given as unix:///path/to/clamd.sock, and TCP sockets as
+// host:port or tcp://host:port. The timeout applies to each scan.
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	c := &Clamd{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		c.network = "unix"
+		c.address = strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		c.network = "tcp"
+		c.address = strings.TrimPrefix(address, "tcp://")
+	default:
+		c.network = "tcp"
+		c.address = address
+	}
+	if c.address == "" {
+		return nil, errors.New("clamd address not specified")
+	}
+	return c, nil
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+// Scan streams the content to clamd and parses the reply, which is one of
+// "stream: OK", "stream: <signature> FOUND" or "<message> ERROR".
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var result Result
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return result, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// The z prefix means that the command and the reply are terminated
+	// by a null character
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return result, fmt.Errorf("send command to clamd: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return result, fmt.Errorf("stream to clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return result, fmt.Errorf("stream to clamd: %w", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("read content: %w", err)
+		}
+	}
+
+	// A zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return result, fmt.Errorf("stream to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return result, fmt.Errorf("read reply from clamd: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses the reply to the INSTREAM command
+func parseClamdReply(reply string) (Result, error) {
+	var result Result
+	reply = strings.TrimRight(reply, "\x00\n")
+	switch {
+	case strings.HasSuffix(reply, " ERROR"):
+		return result, fmt.Errorf("clamd: %s", strings.TrimSuffix(reply, " ERROR"))
+	case strings.HasSuffix(reply, " FOUND"):
+		result.Infected = true
+		result.Signature = strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return result, nil
+	case reply == "stream: OK":
+		return result, nil
+	}
+	return result, fmt.Errorf("unexpected reply from clamd: %q", reply)
+}
@@ -0,0 +1,249 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM commands like clamd does. Content that
+// contains "EICAR" is reported as infected, and content larger than
+// maxBytes is rejected.
+func fakeClamd(t *testing.T, l net.Listener, maxBytes int) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer func() { _ = conn.Close() }()
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var content bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(r, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, r, int64(n)); err != nil {
+						return
+					}
+				}
+				switch {
+				case content.Len() > maxBytes:
+					_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				case strings.Contains(content.String(), "EICAR"):
+					_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				default:
+					_, _ = conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestClamd(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tcp.Close() }()
+	fakeClamd(t, tcp, 1024*1024)
+
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	unix, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = unix.Close() }()
+	fakeClamd(t, unix, 1024*1024)
+
+	addresses := []string{
+		tcp.Addr().String(),
+		"tcp://" + tcp.Addr().String(),
+		"unix://" + socket,
+	}
+
+	tests := []struct {
+		name      string
+		content   string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{
+			name:    "clean content",
+			content: "harmless content",
+		},
+		{
+			name:    "empty content",
+			content: "",
+		},
+		{
+			name:    "content larger than a chunk",
+			content: strings.Repeat("a", clamdChunkSize*3+1),
+		},
+		{
+			name:      "infected content",
+			content:   "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*",
+			infected:  true,
+			signature: "Eicar-Test-Signature",
+		},
+		{
+			name:    "content too large",
+			content: strings.Repeat("a", 1024*1024+1),
+			wantErr: true,
+		},
+	}
+
+	for _, address := range addresses {
+		c, err := NewClamd(address, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			t.Run(address+"/"+tt.name, func(t *testing.T) {
+				result, err := c.Scan(context.Background(), strings.NewReader(tt.content))
+				if tt.wantErr {
+					if err == nil {
+						t.Error("Expected an error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if result.Infected != tt.infected {
+					t.Errorf("Expected infected to be %t, got %t", tt.infected, result.Infected)
+				}
+				if result.Signature != tt.signature {
+					t.Errorf("Expected signature %q, got %q", tt.signature, result.Signature)
+				}
+			})
+		}
+	}
+}
+
+func TestClamdUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	_ = l.Close()
+
+	c, err := NewClamd(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Error("Expected an error when clamd is not available")
+	}
+}
+
+func TestClamdTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	// Accept connections, but never reply
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	c, err := NewClamd(l.Addr().String(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Error("Expected an error when clamd does not reply in time")
+	}
+}
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		want    string
+		wantErr bool
+	}{
+		{address: "localhost:3310", network: "tcp", want: "localhost:3310"},
+		{address: "tcp://localhost:3310", network: "tcp", want: "localhost:3310"},
+		{address: "unix:///run/clamd.sock", network: "unix", want: "/run/clamd.sock"},
+		{address: "", wantErr: true},
+		{address: "unix://", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := NewClamd(tt.address, time.Second)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewClamd(%q): expected an error", tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewClamd(%q): unexpected error: %s", tt.address, err)
+			continue
+		}
+		if c.network != tt.network || c.address != tt.want {
+			t.Errorf("NewClamd(%q): got %s %s, want %s %s", tt.address, c.network, c.address, tt.network, tt.want)
+		}
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{reply: "stream: OK\x00"},
+		{reply: "stream: OK\n"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND\x00", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{reply: "INSTREAM size limit exceeded. ERROR\x00", wantErr: true},
+		{reply: "", wantErr: true},
+		{reply: "garbage", wantErr: true},
+	}
+	for _, tt := range tests {
+		result, err := parseClamdReply(tt.reply)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseClamdReply(%q): expected an error", tt.reply)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseClamdReply(%q): unexpected error: %s", tt.reply, err)
+			continue
+		}
+		if result.Infected != tt.infected || result.Signature != tt.signature {
+			t.Errorf("parseClamdReply(%q): got %+v", tt.reply, result)
+		}
+	}
+}
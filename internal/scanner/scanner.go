@@ -0,0 +1,26 @@
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Result is the verdict of a scan
+type Result struct {
+	// Infected is true if malware was found in the content
+	Infected bool
+
+	// Signature is the name of the malware that was found
+	Signature string
+}
+
+// Scanner scans content for malware. Implementations are expected to be
+// safe for concurrent use.
+type Scanner interface {
+	// Name identifies the scanner in the scan history
+	Name() string
+
+	// Scan reads the content from r and returns the verdict. An error is
+	// returned if the content could not be scanned.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
@@ -0,0 +1,120 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Quality of the JPEG encoded thumbnails
+const quality = 85
+
+// ErrTooLarge is returned for images with more pixels than allowed
+var ErrTooLarge = errors.New("image is too large")
+
+// Decode decodes an image, and rejects images with more than maxPixels
+// pixels before they are decoded, to avoid decoding images that expand to
+// more memory than the size of the content suggests
+func Decode(data []byte, maxPixels int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Dimensions returns the width and height of an image of the given width
+// and height when it is scaled down to fit within size by size pixels,
+// keeping the aspect ratio. Images that already fit are not scaled up.
+func Dimensions(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		h := height * size / width
+		if h < 1 {
+			h = 1
+		}
+		return size, h
+	}
+	w := width * size / height
+	if w < 1 {
+		w = 1
+	}
+	return w, size
+}
+
+// Scale scales the image down to fit within size by size pixels. Each
+// pixel in the thumbnail is the average of the pixels it covers in the
+// original image.
+func Scale(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := Dimensions(sw, sh, size)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		y0 := dy * sh / dh
+		y1 := (dy + 1) * sh / dh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for dx := 0; dx < dw; dx++ {
+			x0 := dx * sw / dw
+			x1 := (dx + 1) * sw / dw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// Encode encodes the thumbnail as JPEG. Transparent pixels are placed on a
+// white background, as JPEG does not support transparency.
+func Encode(w io.Writer, img *image.RGBA) error {
+	flat := image.NewRGBA(img.Bounds())
+	for i := 0; i < len(img.Pix); i += 4 {
+		// The colors are premultiplied with alpha, so adding the
+		// transparent part of white is enough
+		background := 255 - img.Pix[i+3]
+		flat.Pix[i] = img.Pix[i] + background
+		flat.Pix[i+1] = img.Pix[i+1] + background
+		flat.Pix[i+2] = img.Pix[i+2] + background
+		flat.Pix[i+3] = 255
+	}
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: quality})
+}
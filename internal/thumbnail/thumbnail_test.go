@@ -0,0 +1,109 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// newTestImage creates an image with a gradient pattern
+func newTestImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(x * 255 / w),
+				G: uint8(y * 255 / h),
+				B: 128,
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDimensions(t *testing.T) {
+	tests := []struct {
+		width, height, size  int
+		expectedW, expectedH int
+	}{
+		{1000, 500, 256, 256, 128},
+		{500, 1000, 256, 128, 256},
+		{256, 256, 256, 256, 256},
+		{100, 50, 256, 100, 50},
+		{10000, 1, 256, 256, 1},
+	}
+	for _, test := range tests {
+		w, h := Dimensions(test.width, test.height, test.size)
+		if w != test.expectedW || h != test.expectedH {
+			t.Errorf("Expected %dx%d scaled to %d to be %dx%d, got %dx%d", test.width, test.height, test.size, test.expectedW, test.expectedH, w, h)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	img := newTestImage(400, 200)
+	thumb := Scale(img, 100)
+	if thumb.Bounds().Dx() != 100 || thumb.Bounds().Dy() != 50 {
+		t.Fatalf("Expected a 100x50 thumbnail, got %v", thumb.Bounds())
+	}
+
+	// The gradient is kept
+	left := thumb.RGBAAt(0, 25)
+	right := thumb.RGBAAt(99, 25)
+	if left.R >= right.R {
+		t.Errorf("Expected the red gradient to be kept, got %v and %v", left, right)
+	}
+	if left.B != 128 || left.A != 255 {
+		t.Errorf("Expected the average of uniform channels to be unchanged, got %v", left)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := encodePNG(t, newTestImage(64, 32))
+
+	img, err := Decode(data, 64*32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 64 || img.Bounds().Dy() != 32 {
+		t.Errorf("Expected a 64x32 image, got %v", img.Bounds())
+	}
+
+	if _, err := Decode(data, 64*32-1); err != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+
+	if _, err := Decode([]byte("not an image"), 1000); err == nil {
+		t.Error("Expected an error for content that is not an image")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	// A transparent image is placed on a white background
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := decoded.At(4, 4).RGBA()
+	if r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("Expected a white pixel, got %d %d %d", r>>8, g>>8, b>>8)
+	}
+}
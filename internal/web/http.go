@@ -13,6 +13,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -22,7 +23,12 @@ import (
 	"github.com/espebra/filebin2/internal/dbl"
 	"github.com/espebra/filebin2/internal/ds"
 	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/markdown"
+	"github.com/espebra/filebin2/internal/phash"
+	"github.com/espebra/filebin2/internal/processor"
 	"github.com/espebra/filebin2/internal/s3"
+	"github.com/espebra/filebin2/internal/scanner"
+	"github.com/espebra/filebin2/internal/webhook"
 	"github.com/espebra/filebin2/internal/workspace"
 
 	"github.com/felixge/httpsnoop"
@@ -75,6 +81,39 @@ type HTTP struct {
 	clientUploadSuccesses      []ds.ClientUploadSuccess
 	clientUploadSuccessesMutex sync.Mutex
 
+	// Resumable and direct uploads that are currently being processed
+	uploadBusy      map[string]bool
+	uploadBusyMutex sync.Mutex
+
+	// Password attempts per client, passwords verified
+	// recently, and the key used to sign the sessions of password
+	// protected bins
+	passwordAttempts      map[string]*attemptWindow
+	verifiedPasswords     map[string]time.Time
+	passwordAttemptsMutex sync.Mutex
+	sessionKey            []byte
+
+	// Rules of the access policy that have not expired, reloaded from the
+	// database at an interval
+	policies         []ds.Policy
+	policiesLoadedAt time.Time
+	policiesMutex    sync.Mutex
+
+	// Index of the perceptual hashes of blocked content, reloaded from the
+	// database at an interval
+	blockedImages         *phash.Index
+	blockedImagesLoadedAt time.Time
+	blockedImagesMutex    sync.Mutex
+
+	// Scans uploaded content for malware, nil if scanning is disabled
+	scanner scanner.Scanner
+
+	// Outbox of webhook events, nil if webhooks are disabled
+	webhooks *webhook.Dispatcher
+
+	// Processes the queued content processing jobs
+	processor *processor.Processor
+
 	// Stop channel for graceful shutdown of background goroutines
 	stopChan chan struct{}
 }
@@ -95,6 +134,16 @@ func New(dao *dbl.DAO, s3ao *s3.S3AO, geodb *geoip.DAO, wm *workspace.Manager, c
 	}
 }
 
+// SetWebhooks sets the dispatcher that lifecycle events are sent to
+func (h *HTTP) SetWebhooks(d *webhook.Dispatcher) {
+	h.webhooks = d
+}
+
+// SetProcessor sets the processor of the content processing jobs
+func (h *HTTP) SetProcessor(p *processor.Processor) {
+	h.processor = p
+}
+
 // getCachedStorageBytes returns the cached storage bytes value
 func (h *HTTP) getCachedStorageBytes() uint64 {
 	h.storageBytesMutex.RLock()
@@ -207,6 +256,14 @@ func (h *HTTP) Init() error {
 	h.router = mux.NewRouter()
 	h.templates = h.ParseTemplates()
 
+	if err := h.initSessionKey(); err != nil {
+		return err
+	}
+
+	if err := h.initScanner(); err != nil {
+		return err
+	}
+
 	h.router.HandleFunc("/debug/pprof/cmdline", h.auth(pprof.Cmdline)).Methods(http.MethodGet)
 	h.router.HandleFunc("/debug/pprof/profile", h.auth(pprof.Profile)).Methods(http.MethodGet)
 	h.router.HandleFunc("/debug/pprof/symbol", h.auth(pprof.Symbol)).Methods(http.MethodGet)
@@ -234,41 +291,94 @@ func (h *HTTP) Init() error {
 	h.router.HandleFunc("/admin/bins/all", h.auth(h.viewAdminBinsAll)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/clients", h.auth(h.viewAdminClients)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/clients/all", h.auth(h.viewAdminClientsAll)).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/admin/clients/{ip:[A-Za-z0-9.:_-]+}/unban", h.log(h.auth(h.unbanClient))).Methods("POST")
+	h.router.HandleFunc("/admin/policies", h.log(h.auth(h.createPolicy))).Methods("POST")
+	h.router.HandleFunc("/admin/policies/{id:[0-9]+}/delete", h.log(h.auth(h.deletePolicy))).Methods("POST")
 	h.router.HandleFunc("/admin/files", h.auth(h.viewAdminFiles)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/filecontent", h.auth(h.viewAdminFileContent)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}", h.auth(h.viewAdminBin)).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}", h.log(h.auth(h.deleteBin))).Methods(http.MethodDelete)
 	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}/ban-uploaders", h.log(h.auth(h.banBinUploaders))).Methods("POST")
 	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}/ban-downloaders", h.log(h.auth(h.banBinDownloaders))).Methods("POST")
+	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}/pin", h.log(h.auth(h.pinBin))).Methods("POST")
+	h.router.HandleFunc("/admin/bin/{bin:[A-Za-z0-9_-]+}/unpin", h.log(h.auth(h.unpinBin))).Methods("POST")
 	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}", h.auth(h.viewAdminFile)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}/block", h.log(h.auth(h.blockFileContent))).Methods("POST")
 	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}/unblock", h.log(h.auth(h.unblockFileContent))).Methods("POST")
 	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}/delete", h.log(h.auth(h.deleteFileContent))).Methods("POST")
+	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}/scan", h.log(h.auth(h.scanFileContent))).Methods("POST")
+	h.router.HandleFunc("/admin/file/{sha256:[0-9a-z]+}/similar", h.auth(h.viewAdminSimilar)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/recent/uploads.txt", h.auth(h.viewAdminRecentUploadsText)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/recent/uploads", h.auth(h.viewAdminRecentUploads)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/telemetry/upload-failures", h.auth(h.viewAdminClientUploadFailures)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/telemetry/upload-successes", h.auth(h.viewAdminClientUploadSuccesses)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/message", h.auth(h.viewAdminSiteMessage)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/message", h.log(h.auth(h.updateSiteMessage))).Methods("POST")
+	h.router.HandleFunc("/admin/webhooks", h.auth(h.viewAdminWebhooks)).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/admin/webhooks/{id:[0-9]+}/replay", h.log(h.auth(h.replayWebhook))).Methods("POST")
+	h.router.HandleFunc("/admin/jobs", h.auth(h.viewAdminJobs)).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/admin/jobs/backfill/{kind:[a-z]+}", h.log(h.auth(h.backfillJobs))).Methods("POST")
 	h.router.HandleFunc("/admin", h.auth(h.viewAdminDashboard)).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/admin/approve/{bin:[A-Za-z0-9_-]+}", h.log(h.auth(h.approveBin))).Methods("PUT")
 	h.router.Handle("/static/{path:.*}", CacheControl(http.FileServer(http.FS(h.staticBox)))).Methods(http.MethodHead, http.MethodGet)
-	h.router.HandleFunc("/archive/{bin:[A-Za-z0-9_-]+}/{format:[a-z]+}", h.log(h.clientLookup(h.archive))).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}", h.tusOptions).Methods(http.MethodOptions)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.tusCreate))).Methods(http.MethodPost)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.tusOptions).Methods(http.MethodOptions)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.clientLookup(h.tusHead)).Methods(http.MethodHead)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.log(h.clientLookup(h.tusPatch))).Methods(http.MethodPatch)
+	h.router.HandleFunc("/tus/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.log(h.clientLookup(h.tusDelete))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/direct/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.directCreate))).Methods(http.MethodPost)
+	h.router.HandleFunc("/direct/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.clientLookup(h.directStatus)).Methods(http.MethodGet)
+	h.router.HandleFunc("/direct/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}/parts", h.log(h.clientLookup(h.directPresign))).Methods(http.MethodPost)
+	h.router.HandleFunc("/direct/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.log(h.clientLookup(h.directFinalize))).Methods(http.MethodPost)
+	h.router.HandleFunc("/direct/{bin:[A-Za-z0-9_-]+}/{upload:[a-f0-9]+}", h.log(h.clientLookup(h.directDelete))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/alias/{bin:[A-Za-z0-9_-]+}", h.clientLookup(h.binOwner(h.viewAliases))).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/alias/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.createAlias)))).Methods(http.MethodPost)
+	h.router.HandleFunc("/alias/{bin:[A-Za-z0-9_-]+}/{alias:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.deleteAlias)))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/unlock/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.unlockBin))).Methods(http.MethodPost)
+	h.router.HandleFunc("/password/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.setPassword)))).Methods("PUT")
+	h.router.HandleFunc("/password/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.deletePassword)))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/expiration/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.setExpiration)))).Methods("PUT")
+	h.router.HandleFunc("/metadata/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.setBinMetadata)))).Methods("PUT")
+	h.router.HandleFunc("/metadata/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.binOwner(h.setFileMetadata)))).Methods("PUT")
+	h.router.HandleFunc("/copy/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.binOwner(h.copyFile)))).Methods(http.MethodPost)
+	h.router.HandleFunc("/move/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.binOwner(h.moveFile)))).Methods(http.MethodPost)
+	h.router.HandleFunc("/clone/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.cloneBin)))).Methods(http.MethodPost)
+	h.router.HandleFunc("/thumbnail/{bin:[A-Za-z0-9_-]+}/{size:[0-9]+}/{filename:.+}", h.log(h.clientLookup(h.getThumbnail))).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/view/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.clientLookup(h.viewFile)).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/archive/{bin:[A-Za-z0-9_-]+}/{format:[a-z.]+}", h.log(h.clientLookup(h.archive))).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/folder/{bin:[A-Za-z0-9_-]+}", h.viewFolder).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/folder/{bin:[A-Za-z0-9_-]+}/{folder:.+}", h.viewFolder).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/folder/{bin:[A-Za-z0-9_-]+}/{folder:.+}", h.log(h.clientLookup(h.binOwner(h.deleteFolder)))).Methods(http.MethodDelete)
 	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}.txt", h.viewBinPlainText).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/sha256/{bin:[A-Za-z0-9_-]+}", h.viewBinSha256).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/qr/{bin:[A-Za-z0-9_-]+}", h.binQR).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/", h.viewBinRedirect).Methods(http.MethodHead, http.MethodGet)
 	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.viewBin).Methods(http.MethodHead, http.MethodGet)
-	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.deleteBin))).Methods(http.MethodDelete)
-	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.lockBin))).Methods("PUT")
+	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.deleteBin)))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.binOwner(h.lockBin)))).Methods("PUT")
 	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.banBin))).Methods("BAN")
-	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.getFile))).Methods(http.MethodGet)
-	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.deleteFile))).Methods(http.MethodDelete)
+	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}", h.log(h.clientLookup(h.uploadFile))).Methods(http.MethodPost)
+	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.getFile))).Methods(http.MethodHead, http.MethodGet)
+	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.binOwner(h.deleteFile)))).Methods(http.MethodDelete)
 	h.router.HandleFunc("/{bin:[A-Za-z0-9_-]+}/{filename:.+}", h.log(h.clientLookup(h.uploadFile))).Methods(http.MethodPost, http.MethodPut)
 
 	h.config.ExpirationDuration = time.Second * time.Duration(h.config.Expiration)
 
+	// The default expiration time is always within the range of expiration
+	// times that can be requested
+	if h.config.ExpirationMin <= 0 || h.config.ExpirationMin > h.config.Expiration {
+		h.config.ExpirationMin = h.config.Expiration
+	}
+	if h.config.ExpirationMax < h.config.Expiration {
+		h.config.ExpirationMax = h.config.Expiration
+	}
+	h.config.ExpirationMinDuration = time.Second * time.Duration(h.config.ExpirationMin)
+	h.config.ExpirationMaxDuration = time.Second * time.Duration(h.config.ExpirationMax)
+
 	// Start background updater for storage bytes cache
 	h.startStorageBytesUpdater()
+	h.startPasswordAttemptsPruner()
 
 	return nil
 }
@@ -289,8 +399,16 @@ func (h *HTTP) clientLookup(fn func(http.ResponseWriter, *http.Request)) http.Ha
 		}
 
 		if client.IsBanned() {
-			slog.Warn("rejecting request from banned client", "ip", client.IP, "banned_at", client.BannedAt.Time.Format("2006-01-02 15:04:05 UTC"), "banned_by", client.BannedBy)
-			http.Error(w, "This client IP address has been banned.", http.StatusForbidden)
+			slog.Warn("rejecting request from banned client", "ip", client.IP, "banned_at", client.BannedAt.Time.Format("2006-01-02 15:04:05 UTC"), "banned_by", client.BannedBy, "reason", client.BanReason)
+			message := "This client IP address has been banned."
+			if client.BanReason != "" {
+				message = fmt.Sprintf("%s Reason: %s", message, client.BanReason)
+			}
+			if client.BanExpiredAt.Valid {
+				message = fmt.Sprintf("%s The ban expires at %s.", message, client.BanExpiredAt.Time.Format("2006-01-02 15:04:05 UTC"))
+				setRetryAfter(w, time.Until(client.BanExpiredAt.Time))
+			}
+			http.Error(w, message, http.StatusForbidden)
 			return
 		}
 
@@ -298,7 +416,8 @@ func (h *HTTP) clientLookup(fn func(http.ResponseWriter, *http.Request)) http.Ha
 			slog.Debug("unable to look up geoip details", "remote_addr", r.RemoteAddr, "error", err)
 		}
 
-		// Check the client details against the ban filter here
+		// Check the client details against the access policy
+		r = h.withPolicy(r, &client)
 		fn(w, r)
 
 		_ = h.dao.Client().Update(&client)
@@ -559,6 +678,7 @@ func (h *HTTP) ParseTemplates() *template.Template {
 		"durationInSeconds": func(dur time.Duration) string {
 			return fmt.Sprintf("%.3f", dur.Seconds())
 		},
+		"expiration": formatExpiration,
 		"join": func(s ...string) string {
 			return path.Join(s...)
 		},
@@ -568,6 +688,9 @@ func (h *HTTP) ParseTemplates() *template.Template {
 		"unescapeHTML": func(s string) template.HTML {
 			return template.HTML(s)
 		},
+		"markdown": func(s string) template.HTML {
+			return template.HTML(markdown.Render(s))
+		},
 		"lowercase": func(s string) string {
 			return strings.ToLower(s)
 		},
@@ -612,6 +735,40 @@ func (h *HTTP) cookieVerify(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// cookieChallenge shows the verification page and sets the verification
+// cookie if cookies are required and the client did not provide one. It
+// returns false if the verification page was shown.
+func (h *HTTP) cookieChallenge(w http.ResponseWriter, r *http.Request, bin ds.Bin, alias *ds.BinAlias) bool {
+	if !h.cookieRequired(r) || h.cookieVerify(w, r) {
+		return true
+	}
+
+	// Set the cookie
+	h.setVerificationCookie(w, r)
+
+	// Show the warning
+	type Data struct {
+		ds.Common
+		Bin     ds.Bin `json:"bin"`
+		NextUrl string `json:"next_url"`
+	}
+	var data Data
+	data.Bin = bin
+	if alias != nil {
+		alias.Mask(&data.Bin, nil)
+	}
+	var nextUrl url.URL
+	nextUrl.Scheme = h.config.BaseUrl.Scheme
+	nextUrl.Host = h.config.BaseUrl.Host
+	nextUrl.Path = path.Join(h.config.BaseUrl.Path, r.URL.Path)
+	data.NextUrl = nextUrl.String()
+	if err := h.renderTemplate(w, "cookie", data); err != nil {
+		slog.Error("failed to execute template", "error", err)
+		http.Error(w, "Errno 303", http.StatusInternalServerError)
+	}
+	return false
+}
+
 func (h *HTTP) setVerificationCookie(w http.ResponseWriter, r *http.Request) {
 	// The cookie does not exist or its value was wrong
 	cookie := http.Cookie{}
@@ -3,12 +3,14 @@ package web
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -17,6 +19,7 @@ import (
 	"github.com/espebra/filebin2/internal/dbl"
 	"github.com/espebra/filebin2/internal/ds"
 	"github.com/espebra/filebin2/internal/s3"
+	"github.com/espebra/filebin2/internal/webhook"
 )
 
 func (h *HTTP) viewAdminDashboard(w http.ResponseWriter, r *http.Request) {
@@ -432,14 +435,17 @@ func (h *HTTP) viewAdminFile(w http.ResponseWriter, r *http.Request) {
 	inputSHA256 := params["sha256"]
 
 	type Data struct {
-		Files          []ds.File       `json:"files"`
-		FileContent    *ds.FileContent `json:"file_content,omitempty"`
-		SHA256         string          `json:"sha256"`
-		S3URL          string          `json:"s3_url"`
-		S3PresignedURL string          `json:"s3_presigned_url"`
+		Files           []ds.File       `json:"files"`
+		FileContent     *ds.FileContent `json:"file_content,omitempty"`
+		SHA256          string          `json:"sha256"`
+		S3URL           string          `json:"s3_url"`
+		S3PresignedURL  string          `json:"s3_presigned_url"`
+		Scans           []ds.Scan       `json:"scans"`
+		ScanningEnabled bool            `json:"-"`
 	}
 	var data Data
 	data.SHA256 = inputSHA256
+	data.ScanningEnabled = h.scanner != nil
 	data.S3URL = h.s3.GetObjectURL(inputSHA256)
 
 	// Get file content metadata (common across all files with this SHA256)
@@ -469,6 +475,13 @@ func (h *HTTP) viewAdminFile(w http.ResponseWriter, r *http.Request) {
 
 	data.Files = fileByChecksum
 
+	scans, err := h.dao.Scan().GetBySHA256(inputSHA256)
+	if err != nil {
+		slog.Error("unable to get scan history", "sha256", inputSHA256, "error", err)
+		// Don't fail completely, just log the error
+	}
+	data.Scans = scans
+
 	if r.Header.Get("accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
 		out, err := json.MarshalIndent(data, "", "    ")
@@ -501,6 +514,8 @@ func (h *HTTP) blockFileContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	slog.Info("blocked content", "sha256", sha256)
+	h.reloadBlockedImages()
+	h.webhooks.Enqueue(webhook.ContentEvent(webhook.ContentBlocked, sha256, "admin"))
 
 	// Redirect back to the file view page
 	http.Redirect(w, r, "/admin/file/"+sha256, http.StatusSeeOther)
@@ -519,6 +534,7 @@ func (h *HTTP) unblockFileContent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	slog.Info("unblocked content", "sha256", sha256)
+	h.reloadBlockedImages()
 
 	// Redirect back to the file view page
 	http.Redirect(w, r, "/admin/file/"+sha256, http.StatusSeeOther)
@@ -680,10 +696,39 @@ func (h *HTTP) viewAdminBin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// banFromForm returns the ban given in the form of the admin pages. The
+// expiration is given in hours, and bans without expiration apply until the
+// clients are unbanned.
+func (h *HTTP) banFromForm(r *http.Request) (ban ds.ClientBan, err error) {
+	if err := r.ParseForm(); err != nil {
+		return ban, err
+	}
+	ban.Reason = r.PostForm.Get("reason")
+	ban.Note = r.PostForm.Get("note")
+	ban.BannedBy = r.RemoteAddr
+	if expiration := strings.TrimSpace(r.PostForm.Get("expiration")); expiration != "" {
+		hours, err := strconv.Atoi(expiration)
+		if err != nil || hours < 1 {
+			return ban, errors.New("the expiration must be a positive number of hours")
+		}
+		_ = ban.ExpiredAt.Scan(time.Now().UTC().Add(time.Duration(hours) * time.Hour))
+	}
+	if err := h.dao.Client().ValidateBan(&ban); err != nil {
+		return ban, err
+	}
+	return ban, nil
+}
+
 func (h *HTTP) banBinUploaders(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	binID := params["bin"]
 
+	ban, err := h.banFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ips, err := h.dao.File().GetUploaderIPsByBin(binID)
 	if err != nil {
 		slog.Error("unable to get uploader IPs", "bin", binID, "error", err)
@@ -692,7 +737,7 @@ func (h *HTTP) banBinUploaders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(ips) > 0 {
-		err = h.dao.Client().Ban(ips, r.RemoteAddr)
+		err = h.dao.Client().Ban(ips, ban)
 		if err != nil {
 			slog.Error("unable to ban uploaders", "bin", binID, "error", err)
 			http.Error(w, "Failed to ban uploaders", http.StatusInternalServerError)
@@ -708,6 +753,12 @@ func (h *HTTP) banBinDownloaders(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	binID := params["bin"]
 
+	ban, err := h.banFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ips, err := h.dao.Transaction().GetDownloaderIPsByBin(binID)
 	if err != nil {
 		slog.Error("unable to get downloader IPs", "bin", binID, "error", err)
@@ -716,7 +767,7 @@ func (h *HTTP) banBinDownloaders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(ips) > 0 {
-		err = h.dao.Client().Ban(ips, r.RemoteAddr)
+		err = h.dao.Client().Ban(ips, ban)
 		if err != nil {
 			slog.Error("unable to ban downloaders", "bin", binID, "error", err)
 			http.Error(w, "Failed to ban downloaders", http.StatusInternalServerError)
@@ -728,6 +779,25 @@ func (h *HTTP) banBinDownloaders(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/bin/"+binID, http.StatusSeeOther)
 }
 
+func (h *HTTP) unbanClient(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	ip := params["ip"]
+
+	found, err := h.dao.Client().Unban(ip)
+	if err != nil {
+		slog.Error("unable to unban client", "ip", ip, "error", err)
+		http.Error(w, "Failed to unban client", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "The client is not banned", http.StatusNotFound)
+		return
+	}
+	slog.Info("unbanned client", "ip", ip, "unbanned_by", r.RemoteAddr)
+
+	http.Redirect(w, r, "/admin/clients#banned", http.StatusSeeOther)
+}
+
 func (h *HTTP) viewAdminBins(w http.ResponseWriter, r *http.Request) {
 	inputLimit := r.URL.Query().Get("limit")
 
@@ -940,12 +1010,23 @@ func (h *HTTP) viewAdminClients(w http.ResponseWriter, r *http.Request) {
 		ByASN           []ds.AutonomousSystem `json:"by-asn"`
 	}
 
+	// The bans to list, either all, active or expired
+	banFilter := r.URL.Query().Get("bans")
+	switch banFilter {
+	case ds.BanFilterActive, ds.BanFilterExpired:
+	default:
+		banFilter = ds.BanFilterAll
+	}
+
 	type Data struct {
-		Clients Clients `json:"clients"`
-		Limit   int     `json:"limit"`
+		Clients   Clients     `json:"clients"`
+		Policies  []ds.Policy `json:"policies"`
+		Limit     int         `json:"limit"`
+		BanFilter string      `json:"ban_filter"`
 	}
 	var data Data
 	data.Limit = limit
+	data.BanFilter = banFilter
 
 	clientsByLastActiveAt, err := h.dao.Client().GetByLastActiveAt(limit)
 	if err != nil {
@@ -961,7 +1042,7 @@ func (h *HTTP) viewAdminClients(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientsByBannedAt, err := h.dao.Client().GetByBannedAt(limit)
+	clientsByBannedAt, err := h.dao.Client().GetByBannedAt(limit, banFilter)
 	if err != nil {
 		slog.Error("unable to get by banned at", "error", err)
 		http.Error(w, "Errno 251", http.StatusInternalServerError)
@@ -1015,6 +1096,14 @@ func (h *HTTP) viewAdminClients(w http.ResponseWriter, r *http.Request) {
 
 	data.Clients = clients
 
+	policies, err := h.dao.Policy().GetAll()
+	if err != nil {
+		slog.Error("unable to get access policy", "error", err)
+		http.Error(w, "Errno 3506", http.StatusInternalServerError)
+		return
+	}
+	data.Policies = policies
+
 	if r.Header.Get("accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
 		out, err := json.MarshalIndent(data, "", "    ")
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// resolveAlias looks up inputBin as a read-only alias. If it is an alias, the
+// alias is returned together with the id of the bin it points to. Otherwise
+// the alias is nil and inputBin is returned unchanged. The error response is
+// written to the client if the alias has expired.
+func (h *HTTP) resolveAlias(w http.ResponseWriter, r *http.Request, inputBin string) (*ds.BinAlias, string, bool) {
+	alias, found, err := h.dao.BinAlias().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select alias by id %q: %s", inputBin, err.Error()), "Database error", 1801, http.StatusInternalServerError)
+		return nil, inputBin, false
+	}
+	if !found {
+		return nil, inputBin, true
+	}
+	if alias.IsExpired() {
+		h.Error(w, r, "", "This share URL has expired.", 1802, http.StatusNotFound)
+		return nil, inputBin, false
+	}
+	return &alias, alias.Bin, true
+}
+
+// rejectAlias rejects write operations to read-only aliases. It returns true
+// if the error response was written to the client.
+func (h *HTTP) rejectAlias(w http.ResponseWriter, r *http.Request, inputBin string) bool {
+	_, found, err := h.dao.BinAlias().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select alias by id %q: %s", inputBin, err.Error()), "Database error", 1803, http.StatusInternalServerError)
+		return true
+	}
+	if found {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.Error(w, r, fmt.Sprintf("Rejected %s request to read-only alias %q", r.Method, inputBin), "This is a read-only share URL", 1804, http.StatusMethodNotAllowed)
+		return true
+	}
+	return false
+}
+
+// aliasBin returns the bin that aliases are managed for. The error response
+// is written to the client if the bin is not available.
+func (h *HTTP) aliasBin(w http.ResponseWriter, r *http.Request, inputBin string) (ds.Bin, bool) {
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 1805, http.StatusInternalServerError)
+		return bin, false
+	}
+	if !found {
+		h.Error(w, r, "", "The bin does not exist.", 1806, http.StatusNotFound)
+		return bin, false
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "This bin is no longer available.", 1807, http.StatusNotFound)
+		return bin, false
+	}
+	return bin, true
+}
+
+// createAlias creates a read-only alias for a bin, with an optional expiry
+func (h *HTTP) createAlias(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, ok := h.aliasBin(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	// The request body is optional
+	var input struct {
+		ExpiredAt *time.Time `json:"expired_at"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		h.Error(w, r, fmt.Sprintf("Unable to parse alias request for bin %q: %s", inputBin, err.Error()), "Invalid request body", 1808, http.StatusBadRequest)
+		return
+	}
+	if input.ExpiredAt != nil && !input.ExpiredAt.After(time.Now()) {
+		h.Error(w, r, "", "The expiry of the alias must be in the future", 1809, http.StatusBadRequest)
+		return
+	}
+
+	id, err := h.dao.BinAlias().GenerateId()
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to generate alias id for bin %q: %s", inputBin, err.Error()), "Database error", 1810, http.StatusInternalServerError)
+		return
+	}
+	alias := ds.BinAlias{Id: id, Bin: bin.Id, ExpiredAt: input.ExpiredAt}
+	if err := h.dao.BinAlias().Insert(&alias); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to insert alias for bin %q: %s", inputBin, err.Error()), "Database error", 1811, http.StatusInternalServerError)
+		return
+	}
+	alias.GenerateURL(h.config.BaseUrl)
+	slog.Info("created alias", "bin", bin.Id, "alias", alias.Id)
+
+	out, err := json.MarshalIndent(alias, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 1812, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", path.Join("/alias", bin.Id, alias.Id))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+// viewAliases lists the read-only aliases of a bin
+func (h *HTTP) viewAliases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, ok := h.aliasBin(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	aliases, err := h.dao.BinAlias().GetByBin(bin.Id)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to select aliases for bin %q: %s", inputBin, err.Error()), "Database error", 1813, http.StatusInternalServerError)
+		return
+	}
+
+	type Data struct {
+		Aliases []ds.BinAlias `json:"aliases"`
+	}
+	var data Data
+	data.Aliases = []ds.BinAlias{}
+	for _, alias := range aliases {
+		alias.GenerateURL(h.config.BaseUrl)
+		data.Aliases = append(data.Aliases, alias)
+	}
+
+	out, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 1814, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// deleteAlias revokes a single read-only alias without touching the bin
+func (h *HTTP) deleteAlias(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputAlias := params["alias"]
+
+	alias, found, err := h.dao.BinAlias().GetByID(inputAlias)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select alias by id %q: %s", inputAlias, err.Error()), "Database error", 1815, http.StatusInternalServerError)
+		return
+	}
+	if !found || alias.Bin != inputBin {
+		h.Error(w, r, "", "The alias does not exist.", 1816, http.StatusNotFound)
+		return
+	}
+
+	if err := h.dao.BinAlias().Delete(&alias); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to delete alias %q of bin %q: %s", inputAlias, inputBin, err.Error()), "Database error", 1817, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("revoked alias", "bin", inputBin, "alias", inputAlias)
+	http.Error(w, "Alias revoked successfully", http.StatusOK)
+}
@@ -0,0 +1,219 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestBinAlias(t *testing.T) {
+	bin := "aliasbin01"
+	content := "content shared using an alias"
+
+	statusCode, body, err := httpRequest(TestCase{Method: "POST", Bin: bin, Filename: "shared.txt", UploadContent: content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, statusCode, body)
+	}
+
+	resp, out, err := directRequest("POST", "http://localhost:8080/alias/"+bin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d when creating alias, got %d: %s", http.StatusCreated, resp.StatusCode, out)
+	}
+	var alias ds.BinAlias
+	if err := json.Unmarshal(out, &alias); err != nil {
+		t.Fatal(err)
+	}
+	if alias.Id == "" || alias.Id == bin {
+		t.Fatalf("Unexpected alias id %q", alias.Id)
+	}
+
+	// The alias resolves to the files in the bin without revealing the bin
+	req, err := http.NewRequest("GET", "http://localhost:8080/"+alias.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Close = true
+	viewResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var view struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	err = json.NewDecoder(viewResp.Body).Decode(&view)
+	_ = viewResp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viewResp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d when viewing alias, got %d", http.StatusOK, viewResp.StatusCode)
+	}
+	if view.Bin.Id != alias.Id || !view.Bin.Readonly {
+		t.Errorf("Expected the bin to be presented as the read-only alias, got %+v", view.Bin)
+	}
+	if len(view.Files) != 1 {
+		t.Errorf("Expected 1 file, got %d", len(view.Files))
+	}
+
+	for _, p := range []string{"/" + alias.Id, "/" + alias.Id + ".txt", "/sha256/" + alias.Id} {
+		resp, out, err := ownerRequest("GET", "http://localhost:8080"+p, "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusOK, p, resp.StatusCode)
+		}
+		if strings.Contains(string(out), bin) {
+			t.Errorf("Did not expect the bin id in the response to %s", p)
+		}
+		if !strings.Contains(string(out), "shared.txt") {
+			t.Errorf("Expected the file in the response to %s", p)
+		}
+	}
+
+	resp, _, err = ownerRequest("GET", "http://localhost:8080/archive/"+alias.Id+"/zip", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d for archive, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); !strings.Contains(disposition, alias.Id+".zip") {
+		t.Errorf("Expected the archive to be named after the alias, got %q", disposition)
+	}
+
+	tcs := []TestCase{
+		{
+			Description:     "Download file using alias",
+			Method:          "GET",
+			Bin:             alias.Id,
+			Filename:        "shared.txt",
+			StatusCode:      200,
+			DownloadContent: content,
+		}, {
+			Description:   "Upload file using alias",
+			Method:        "POST",
+			Bin:           alias.Id,
+			Filename:      "other.txt",
+			UploadContent: "content",
+			StatusCode:    405,
+		}, {
+			Description: "Delete file using alias",
+			Method:      "DELETE",
+			Bin:         alias.Id,
+			Filename:    "shared.txt",
+			StatusCode:  405,
+		}, {
+			Description: "Lock bin using alias",
+			Method:      "PUT",
+			Bin:         alias.Id,
+			StatusCode:  405,
+		}, {
+			Description: "Delete bin using alias",
+			Method:      "DELETE",
+			Bin:         alias.Id,
+			StatusCode:  405,
+		},
+	}
+	runTests(tcs, t)
+
+	resp, out, err = directRequest("GET", "http://localhost:8080/alias/"+bin, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Aliases []ds.BinAlias `json:"aliases"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Aliases) != 1 || list.Aliases[0].Id != alias.Id {
+		t.Errorf("Expected the alias in the list, got %s", out)
+	}
+
+	// Revoke the alias without touching the bin
+	resp, out, err = directRequest("DELETE", "http://localhost:8080/alias/"+bin+"/"+alias.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d when revoking alias, got %d: %s", http.StatusOK, resp.StatusCode, out)
+	}
+
+	tcs = []TestCase{
+		{
+			Description: "Download file using revoked alias",
+			Method:      "GET",
+			Bin:         alias.Id,
+			Filename:    "shared.txt",
+			StatusCode:  404,
+		}, {
+			Description:     "Download file from the bin after revoking the alias",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        "shared.txt",
+			StatusCode:      200,
+			DownloadContent: content,
+		},
+	}
+	runTests(tcs, t)
+}
+
+func TestBinAliasInvalidRequests(t *testing.T) {
+	bin := "aliasbin02"
+	statusCode, body, err := httpRequest(TestCase{Method: "POST", Bin: bin, Filename: "file.txt", UploadContent: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, statusCode, body)
+	}
+
+	tcs := []struct {
+		name       string
+		url        string
+		body       interface{}
+		statusCode int
+	}{
+		{
+			name:       "expiry in the past",
+			url:        "http://localhost:8080/alias/" + bin,
+			body:       map[string]interface{}{"expired_at": time.Now().Add(-time.Hour)},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown field",
+			url:        "http://localhost:8080/alias/" + bin,
+			body:       map[string]interface{}{"unknown": true},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bin does not exist",
+			url:        "http://localhost:8080/alias/aliasbin99",
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, out, err := directRequest("POST", tc.url, tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, resp.StatusCode, out)
+			}
+		})
+	}
+}
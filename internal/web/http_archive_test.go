@@ -5,13 +5,18 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"path"
 	"strings"
 	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestArchiveDownload(t *testing.T) {
@@ -421,6 +426,312 @@ func TestArchiveContentType(t *testing.T) {
 	})
 }
 
+func TestArchiveCompressedFormats(t *testing.T) {
+	binID := "archivetest08"
+	files := map[string]string{
+		"file1.txt": strings.Repeat("content of file 1\n", 100),
+		"file2.txt": strings.Repeat("content of file 2\n", 100),
+	}
+	for filename, content := range files {
+		tc := TestCase{
+			Method:        "POST",
+			Bin:           binID,
+			Filename:      filename,
+			UploadContent: content,
+			StatusCode:    http.StatusCreated,
+		}
+		if statusCode, _, err := httpRequest(tc); err != nil || statusCode != http.StatusCreated {
+			t.Fatalf("Failed to upload test file %s: %d %v", filename, statusCode, err)
+		}
+	}
+
+	t.Run("tar.gz archive", func(t *testing.T) {
+		statusCode, body, err := downloadArchive(binID, "tar.gz")
+		if err != nil {
+			t.Fatalf("Failed to download tar.gz archive: %s", err.Error())
+		}
+		if statusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", statusCode)
+		}
+		gz, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error opening gzip stream: %s", err.Error())
+		}
+		found := readTarFiles(t, gz)
+		if len(found) != len(files) {
+			t.Errorf("Expected %d files in archive, found %d", len(files), len(found))
+		}
+		for filename, content := range files {
+			if found[filename] != content {
+				t.Errorf("File %s content mismatch", filename)
+			}
+		}
+	})
+
+	t.Run("tar.zst archive", func(t *testing.T) {
+		statusCode, body, err := downloadArchive(binID, "tar.zst")
+		if err != nil {
+			t.Fatalf("Failed to download tar.zst archive: %s", err.Error())
+		}
+		if statusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", statusCode)
+		}
+		// The padding of the tar headers compresses well
+		_, tarBody, err := downloadArchive(binID, "tar")
+		if err != nil {
+			t.Fatalf("Failed to download tar archive: %s", err.Error())
+		}
+		if len(body) >= len(tarBody) {
+			t.Errorf("Expected the tar.zst archive to be compressed, got %d bytes compared to %d bytes for tar", len(body), len(tarBody))
+		}
+
+		zr, err := zstd.NewReader(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Error opening zstd stream: %s", err.Error())
+		}
+		defer zr.Close()
+		found := readTarFiles(t, zr)
+		if len(found) != len(files) {
+			t.Errorf("Expected %d files in archive, found %d", len(files), len(found))
+		}
+		for filename, content := range files {
+			if found[filename] != content {
+				t.Errorf("File %s content mismatch", filename)
+			}
+		}
+	})
+
+	t.Run("zip archive deflates text files", func(t *testing.T) {
+		statusCode, body, err := downloadArchive(binID, "zip")
+		if err != nil {
+			t.Fatalf("Failed to download zip archive: %s", err.Error())
+		}
+		if statusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", statusCode)
+		}
+		zipReader, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatalf("Error opening zip archive: %s", err.Error())
+		}
+		for _, file := range zipReader.File {
+			if file.Method != zip.Deflate {
+				t.Errorf("Expected %s to be deflated, got method %d", file.Name, file.Method)
+			}
+		}
+	})
+}
+
+func TestArchiveSelection(t *testing.T) {
+	binID := "archivetest09"
+	files := map[string]string{
+		"notes.txt":  "notes",
+		"todo.txt":   "todo",
+		"readme.md":  "readme",
+		"script.sh":  "echo",
+		"other.conf": "conf",
+	}
+	for filename, content := range files {
+		tc := TestCase{
+			Method:        "POST",
+			Bin:           binID,
+			Filename:      filename,
+			UploadContent: content,
+			StatusCode:    http.StatusCreated,
+		}
+		if statusCode, _, err := httpRequest(tc); err != nil || statusCode != http.StatusCreated {
+			t.Fatalf("Failed to upload test file %s: %d %v", filename, statusCode, err)
+		}
+	}
+
+	tests := []struct {
+		description string
+		query       url.Values
+		statusCode  int
+		expected    []string
+	}{
+		{"filenames", url.Values{"file": {"notes.txt", "readme.md"}}, http.StatusOK, []string{"notes.txt", "readme.md"}},
+		{"glob", url.Values{"glob": {"*.txt"}}, http.StatusOK, []string{"notes.txt", "todo.txt"}},
+		{"filenames and glob", url.Values{"file": {"script.sh"}, "glob": {"*.txt"}}, http.StatusOK, []string{"notes.txt", "script.sh", "todo.txt"}},
+		{"missing filename", url.Values{"file": {"notes.txt", "missing.txt"}}, http.StatusNotFound, nil},
+		{"glob without matches", url.Values{"glob": {"*.jpg"}}, http.StatusNotFound, nil},
+		{"invalid glob", url.Values{"glob": {"[txt"}}, http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			statusCode, body, err := downloadArchiveWithQuery(binID, "tar", test.query)
+			if err != nil {
+				t.Fatalf("Failed to download archive: %s", err.Error())
+			}
+			if statusCode != test.statusCode {
+				t.Fatalf("Expected status %d, got %d", test.statusCode, statusCode)
+			}
+			if statusCode != http.StatusOK {
+				return
+			}
+			found := readTarFiles(t, strings.NewReader(body))
+			if len(found) != len(test.expected) {
+				t.Errorf("Expected %d files in archive, found %d", len(test.expected), len(found))
+			}
+			for _, filename := range test.expected {
+				if found[filename] != files[filename] {
+					t.Errorf("Expected file %s in the archive", filename)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveAlias(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/archivealiasbin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/alias/archivealiasbin", nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var alias ds.BinAlias
+	if err := json.Unmarshal(rr.Body.Bytes(), &alias); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/archive/"+alias.Id+"/tar", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if found := readTarFiles(t, rr.Body); found["file.txt"] != "some content" {
+		t.Errorf("Expected the file in the archive, got %v", found)
+	}
+
+	// The download is counted for the bin that the alias refers to
+	bin, found, err := h.dao.Bin().GetByID("archivealiasbin")
+	if err != nil || !found {
+		t.Fatalf("Unable to get bin: %v", err)
+	}
+	if bin.Downloads != 1 {
+		t.Errorf("Expected 1 download of the bin, got %d", bin.Downloads)
+	}
+}
+
+func TestArchiveEncryptedManifest(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	names := map[string]string{
+		"3f9a1c0e5b7d24680000000000000001": "q83vASNFZ4mrze8BI0VniavN7wEjRWeJ",
+		"3f9a1c0e5b7d24680000000000000002": "AAECAwQFBgcICQoLDA0ODxAREhMUFRYX",
+	}
+	for filename, encryptedName := range names {
+		req := uploadRequest("/archivemanifestbin/"+filename, "opaque ciphertext of "+filename)
+		req.Header.Set("Bin-Encrypted", "true")
+		req.Header.Set("Encrypted-Filename", encryptedName)
+		if strings.HasSuffix(filename, "2") {
+			req.Header.Set("Download-Limit", "1")
+		}
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+	bin, found, err := h.dao.Bin().GetByID("archivemanifestbin")
+	if err != nil || !found {
+		t.Fatalf("Unable to get bin: %v", err)
+	}
+	files, err := h.dao.File().GetByBin(bin.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The limited file uses its only download after the files of the
+	// archive are selected, so it is left out of the archive
+	if code := downloadStatus(h, "/archivemanifestbin/3f9a1c0e5b7d24680000000000000002"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	var buf bytes.Buffer
+	req := httptest.NewRequest(http.MethodGet, "/archive/archivemanifestbin/zip", nil)
+	if err := h.addFilesToArchive(httptest.NewRecorder(), req, bin, bin, files, newZipArchiveWriter(&buf, true), "zip"); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		fp, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(fp)
+		_ = fp.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(content)
+	}
+	if _, found := entries["3f9a1c0e5b7d24680000000000000002"]; found {
+		t.Error("Did not expect the limited file in the archive")
+	}
+	var manifest encryptedManifest
+	if err := json.Unmarshal([]byte(entries[encryptedManifestFilename]), &manifest); err != nil {
+		t.Fatalf("Unable to parse the manifest: %s", err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Filename != "3f9a1c0e5b7d24680000000000000001" {
+		t.Errorf("Expected the manifest to list the file in the archive only, got %+v", manifest.Files)
+	}
+}
+
+func TestZipMethod(t *testing.T) {
+	tests := []struct {
+		mime     string
+		expected uint16
+	}{
+		{"text/plain; charset=utf-8", zip.Deflate},
+		{"application/json", zip.Deflate},
+		{"application/octet-stream", zip.Deflate},
+		{"image/svg+xml", zip.Deflate},
+		{"image/jpeg", zip.Store},
+		{"video/mp4", zip.Store},
+		{"audio/mpeg", zip.Store},
+		{"application/zip", zip.Store},
+		{"application/gzip", zip.Store},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", zip.Store},
+	}
+	for _, test := range tests {
+		if got := zipMethod(test.mime); got != test.expected {
+			t.Errorf("Expected method %d for %s, got %d", test.expected, test.mime, got)
+		}
+	}
+}
+
+// Helper function to read the files in a tar archive
+func readTarFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	found := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %s", err.Error())
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Error reading tar file content: %s", err.Error())
+		}
+		found[header.Name] = string(content)
+	}
+	return found
+}
+
 // Helper function to download an archive
 func downloadArchive(binID, format string) (int, string, error) {
 	return downloadArchiveWithAcceptEncoding(binID, format, "")
@@ -428,11 +739,21 @@ func downloadArchive(binID, format string) (int, string, error) {
 
 // Helper function to download an archive with specific Accept-Encoding
 func downloadArchiveWithAcceptEncoding(binID, format, acceptEncoding string) (int, string, error) {
+	return requestArchive(binID, format, nil, acceptEncoding)
+}
+
+// Helper function to download an archive of the files selected by the query
+func downloadArchiveWithQuery(binID, format string, query url.Values) (int, string, error) {
+	return requestArchive(binID, format, query, "")
+}
+
+func requestArchive(binID, format string, query url.Values, acceptEncoding string) (int, string, error) {
 	u, err := url.Parse("http://localhost:8080")
 	if err != nil {
 		return -1, "", err
 	}
 	u.Path = path.Join("/archive", binID, format)
+	u.RawQuery = query.Encode()
 
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
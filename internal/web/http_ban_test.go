@@ -0,0 +1,109 @@
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestTemporaryBan(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.AdminUsername = "admin"
+	h.config.AdminPassword = "secret123"
+	auth := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte("admin:secret123")))
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/banbin/a.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Ban the uploader of the bin for a day
+	form := url.Values{"reason": {"Spam"}, "note": {"Reported by email"}, "expiration": {"24"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/bin/banbin/ban-uploaders", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", auth)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/banbin/b.txt", "some content"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Spam") {
+		t.Errorf("Expected the reason in the response, got: %s", rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a Retry-After header for a temporary ban")
+	}
+
+	bans, err := h.dao.Client().GetByBannedAt(10, ds.BanFilterActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].BanNote != "Reported by email" || !bans[0].BanExpiredAt.Valid {
+		t.Fatalf("Unexpected bans: %+v", bans)
+	}
+
+	// Unban the client
+	for _, statusCode := range []int{http.StatusSeeOther, http.StatusNotFound} {
+		req = httptest.NewRequest(http.MethodPost, "/admin/clients/"+bans[0].IP+"/unban", nil)
+		req.Header.Set("Authorization", auth)
+		rr = httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != statusCode {
+			t.Errorf("Expected status %d, got %d. Body: %s", statusCode, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/banbin/b.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Bans no longer apply when they expire
+	expired := ds.ClientBan{Reason: "Spam", BannedBy: "198.51.100.1"}
+	_ = expired.ExpiredAt.Scan(time.Now().Add(-1 * time.Minute))
+	if err := h.dao.Client().Ban([]string{bans[0].IP}, expired); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/banbin/c.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+func TestBanValidation(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.AdminUsername = "admin"
+	h.config.AdminPassword = "secret123"
+	auth := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte("admin:secret123")))
+
+	for _, form := range []url.Values{
+		{"expiration": {"0"}},
+		{"expiration": {"tomorrow"}},
+		{"reason": {strings.Repeat("a", 257)}},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/bin/banbin/ban-downloaders", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %v, got %d. Body: %s", http.StatusBadRequest, form, rr.Code, rr.Body.String())
+		}
+	}
+}
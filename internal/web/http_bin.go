@@ -3,19 +3,24 @@ package web
 import (
 	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"image/png"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/webhook"
 	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
@@ -50,11 +55,23 @@ func (h *HTTP) viewBin(w http.ResponseWriter, r *http.Request) {
 		Bin         ds.Bin          `json:"bin"`
 		Files       []ds.File       `json:"files"`
 		SiteMessage *ds.SiteMessage `json:"site_message,omitempty"`
+		Owner       bool            `json:"-"`
+		Gallery     bool            `json:"-"`
+		Rows        []binRow        `json:"-"`
+		Folders     bool            `json:"-"`
+
+		// The expiration time of the bin, and the range that the owner
+		// can choose from
+		Expiration    time.Duration `json:"-"`
+		ExpirationMin time.Duration `json:"-"`
+		ExpirationMax time.Duration `json:"-"`
 	}
 	var data Data
 	data.Page = "bin"
 	data.Contact = h.config.Contact
 	data.BaseUrl = h.config.BaseUrl.String()
+	data.ExpirationMin = h.config.ExpirationMinDuration
+	data.ExpirationMax = h.config.ExpirationMaxDuration
 
 	// Fetch site message if published for bin page
 	h.siteMessageMutex.RLock()
@@ -70,14 +87,22 @@ func (h *HTTP) viewBin(w http.ResponseWriter, r *http.Request) {
 	binURL.Path = path.Join(h.config.BaseUrl.Path, inputBin)
 	data.BinUrl = binURL.String()
 
-	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
 	if err != nil {
-		slog.Error("unable to get bin by ID", "bin", inputBin, "error", err)
+		slog.Error("unable to get bin by ID", "bin", binId, "error", err)
 		http.Error(w, "Errno 262", http.StatusInternalServerError)
 		return
 	}
 	if found {
-		files, err := h.dao.File().GetByBin(inputBin, true)
+		if !h.binUnlocked(w, r, inputBin, &bin) {
+			return
+		}
+		files, err := h.dao.File().GetByBin(binId, true)
 		if err != nil {
 			slog.Error("unable to get files by bin", "bin", inputBin, "error", err)
 			http.Error(w, "Not found", http.StatusNotFound)
@@ -85,6 +110,8 @@ func (h *HTTP) viewBin(w http.ResponseWriter, r *http.Request) {
 		}
 		if bin.IsReadable() {
 			data.Files = files
+			data.Gallery = h.markThumbnails(&bin, data.Files)
+			h.attachVersions(&bin, data.Files)
 		}
 	} else {
 		// Synthesize a bin without creating it. It will be created when a file is uploaded.
@@ -92,12 +119,21 @@ func (h *HTTP) viewBin(w http.ResponseWriter, r *http.Request) {
 		bin.Id = inputBin
 		bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
 		bin.ExpiredAtRelative = humanize.Time(bin.ExpiredAt)
+		bin.ExpirationPolicy = ds.ExpirationPolicyDefault
 
 		// Intentional slowdown to make crawling less efficient
 		time.Sleep(1 * time.Second)
 	}
 
+	// Visitors using a read-only alias do not get to see the bin id
+	data.Owner = alias == nil && h.isBinOwner(r, &bin)
+	if alias != nil {
+		alias.Mask(&bin, data.Files)
+	}
 	data.Bin = bin
+	data.Expiration = bin.Lifetime(h.config.ExpirationDuration)
+	data.Rows = binRows(data.Files)
+	data.Folders = hasFolders(data.Files)
 
 	code := 200
 	if !bin.IsReadable() {
@@ -140,14 +176,22 @@ func (h *HTTP) viewBinPlainText(w http.ResponseWriter, r *http.Request) {
 	}
 	var data Data
 
-	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
 	if err != nil {
-		slog.Error("unable to get bin by ID", "bin", inputBin, "error", err)
+		slog.Error("unable to get bin by ID", "bin", binId, "error", err)
 		http.Error(w, "Errno 264", http.StatusInternalServerError)
 		return
 	}
 	if found {
-		files, err := h.dao.File().GetByBin(inputBin, true)
+		if !h.binUnlocked(w, r, inputBin, &bin) {
+			return
+		}
+		files, err := h.dao.File().GetByBin(binId, true)
 		if err != nil {
 			slog.Error("unable to get files by bin", "bin", inputBin, "error", err)
 			http.Error(w, "Not found", http.StatusNotFound)
@@ -162,6 +206,7 @@ func (h *HTTP) viewBinPlainText(w http.ResponseWriter, r *http.Request) {
 		bin.Id = inputBin
 		bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
 		bin.ExpiredAtRelative = humanize.Time(bin.ExpiredAt)
+		bin.ExpirationPolicy = ds.ExpirationPolicyDefault
 
 		// Intentional slowdown to make crawling less efficient
 		time.Sleep(1 * time.Second)
@@ -172,17 +217,40 @@ func (h *HTTP) viewBinPlainText(w http.ResponseWriter, r *http.Request) {
 		code = 404
 	}
 
+	if alias != nil {
+		alias.Mask(&bin, data.Files)
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(code)
+	if bin.IsReadable() {
+		writeComment(w, bin.Title)
+		writeComment(w, bin.Description)
+	}
 	for _, file := range data.Files {
 		var u url.URL
 		u.Scheme = h.config.BaseUrl.Scheme
 		u.Host = h.config.BaseUrl.Host
 		u.Path = path.Join(h.config.BaseUrl.Path, file.URL)
+		writeComment(w, file.Description)
+		if len(file.Tags) > 0 {
+			writeComment(w, "Tags: "+strings.Join(file.Tags, ", "))
+		}
 		_, _ = fmt.Fprintf(w, "%s\n", u.String())
 	}
 }
 
+// writeComment writes text to a plain text listing as comment lines that
+// start with #, which keeps the lines without # a list of URLs
+func writeComment(w io.Writer, s string) {
+	if s == "" {
+		return
+	}
+	for _, line := range strings.Split(s, "\n") {
+		_, _ = fmt.Fprintf(w, "# %s\n", strings.TrimRight(line, " \t\r"))
+	}
+}
+
 // viewBinSha256 lists the files in a bin along with their SHA256
 // checksums in plain text, formatted like the output of sha256sum(1):
 // the checksum, two spaces, then the filename, one file per line.
@@ -195,14 +263,22 @@ func (h *HTTP) viewBinSha256(w http.ResponseWriter, r *http.Request) {
 
 	var files []ds.File
 
-	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	_, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
 	if err != nil {
-		slog.Error("unable to get bin by ID", "bin", inputBin, "error", err)
+		slog.Error("unable to get bin by ID", "bin", binId, "error", err)
 		http.Error(w, "Errno 271", http.StatusInternalServerError)
 		return
 	}
 	if found {
-		fs, err := h.dao.File().GetByBin(inputBin, true)
+		if !h.binUnlocked(w, r, inputBin, &bin) {
+			return
+		}
+		fs, err := h.dao.File().GetByBin(binId, true)
 		if err != nil {
 			slog.Error("unable to get files by bin", "bin", inputBin, "error", err)
 			http.Error(w, "Not found", http.StatusNotFound)
@@ -217,6 +293,7 @@ func (h *HTTP) viewBinSha256(w http.ResponseWriter, r *http.Request) {
 		bin.Id = inputBin
 		bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
 		bin.ExpiredAtRelative = humanize.Time(bin.ExpiredAt)
+		bin.ExpirationPolicy = ds.ExpirationPolicyDefault
 
 		// Intentional slowdown to make crawling less efficient
 		time.Sleep(1 * time.Second)
@@ -249,6 +326,24 @@ func (h *HTTP) binQR(w http.ResponseWriter, r *http.Request) {
 		size = 80
 	}
 
+	_, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 1918, http.StatusInternalServerError)
+		return
+	}
+	if found && bin.HasPassword() {
+		if !h.binUnlocked(w, r, inputBin, &bin) {
+			return
+		}
+		// Do not let shared caches keep the QR codes of protected bins
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	}
+
 	var binURL url.URL
 	binURL.Scheme = h.config.BaseUrl.Scheme
 	binURL.Host = h.config.BaseUrl.Host
@@ -274,39 +369,123 @@ func (h *HTTP) binQR(w http.ResponseWriter, r *http.Request) {
 // archiveWriter provides a common interface for creating archive files
 type archiveWriter interface {
 	addFile(file ds.File) (io.Writer, error)
+	addFolder(folder string, modified time.Time) error
 	close() error
 }
 
 // zipArchiveWriter wraps zip.Writer to implement archiveWriter
 type zipArchiveWriter struct {
 	writer *zip.Writer
+
+	// Store all files without compression, such as the ciphertext of
+	// encrypted bins
+	store bool
 }
 
-func newZipArchiveWriter(w io.Writer) *zipArchiveWriter {
-	return &zipArchiveWriter{writer: zip.NewWriter(w)}
+func newZipArchiveWriter(w io.Writer, store bool) *zipArchiveWriter {
+	return &zipArchiveWriter{writer: zip.NewWriter(w), store: store}
 }
 
+// addFile adds a file to the zip archive. Files that are compressed already
+// are stored as they are, as deflating them again costs CPU without making
+// the archive smaller. The zip writer switches to ZIP64 records on its own
+// when a file, the archive or the number of files exceeds the limits of
+// the original zip format, which large bins do.
 func (z *zipArchiveWriter) addFile(file ds.File) (io.Writer, error) {
 	header := &zip.FileHeader{}
 	header.Name = file.Filename
 	header.Modified = file.UpdatedAt
+	header.Method = zip.Store
+	if !z.store {
+		header.Method = zipMethod(file.Mime)
+	}
 	header.SetMode(0600) // Read-write for the file owner
 	return z.writer.CreateHeader(header)
 }
 
+// addFolder adds a directory entry to the zip archive
+func (z *zipArchiveWriter) addFolder(folder string, modified time.Time) error {
+	header := &zip.FileHeader{}
+	header.Name = folder + "/"
+	header.Modified = modified
+	header.Method = zip.Store
+	header.SetMode(fs.ModeDir | 0700) // Read, write and list for the owner
+	_, err := z.writer.CreateHeader(header)
+	return err
+}
+
 func (z *zipArchiveWriter) close() error {
 	return z.writer.Close()
 }
 
-// tarArchiveWriter wraps tar.Writer to implement archiveWriter
+// compressedMimes are content types of files that are compressed already
+var compressedMimes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"image/heic",
+	"video/",
+	"audio/mpeg",
+	"audio/mp4",
+	"audio/aac",
+	"audio/ogg",
+	"audio/opus",
+	"audio/webm",
+	"audio/flac",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/java-archive",
+	"application/epub+zip",
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/vnd.android.package-archive",
+	"application/x-compress",
+}
+
+// zipMethod returns the compression method of a file with the content type
+// in zip archives
+func zipMethod(mime string) uint16 {
+	for _, prefix := range compressedMimes {
+		if strings.HasPrefix(mime, prefix) {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+// tarArchiveWriter wraps tar.Writer to implement archiveWriter. The tar
+// stream is optionally compressed.
 type tarArchiveWriter struct {
-	writer *tar.Writer
+	writer     *tar.Writer
+	compressor io.WriteCloser
 }
 
 func newTarArchiveWriter(w io.Writer) *tarArchiveWriter {
 	return &tarArchiveWriter{writer: tar.NewWriter(w)}
 }
 
+func newTarGzArchiveWriter(w io.Writer) *tarArchiveWriter {
+	compressor := gzip.NewWriter(w)
+	return &tarArchiveWriter{writer: tar.NewWriter(compressor), compressor: compressor}
+}
+
+func newTarZstArchiveWriter(w io.Writer) *tarArchiveWriter {
+	// A single goroutine per archive keeps the memory use of concurrent
+	// downloads down. The options are valid, so no error is returned.
+	compressor, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	return &tarArchiveWriter{writer: tar.NewWriter(compressor), compressor: compressor}
+}
+
 func (t *tarArchiveWriter) addFile(file ds.File) (io.Writer, error) {
 	header := &tar.Header{}
 	header.Name = file.Filename
@@ -320,13 +499,107 @@ func (t *tarArchiveWriter) addFile(file ds.File) (io.Writer, error) {
 	return t.writer, nil
 }
 
+func (t *tarArchiveWriter) addFolder(folder string, modified time.Time) error {
+	header := &tar.Header{}
+	header.Name = folder + "/"
+	header.Typeflag = tar.TypeDir
+	header.ModTime = modified
+	header.Mode = 0700 // rwx access for the owner
+	return t.writer.WriteHeader(header)
+}
+
 func (t *tarArchiveWriter) close() error {
-	return t.writer.Close()
+	if err := t.writer.Close(); err != nil {
+		return err
+	}
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+// selectArchiveFiles returns the files that are selected by the file and
+// glob query parameters. All files are selected when neither is given. The
+// folder query parameter limits the selection to the files in a folder.
+func selectArchiveFiles(r *http.Request, files []ds.File) (selected []ds.File, missing string, err error) {
+	query := r.URL.Query()
+	if folder := strings.Trim(query.Get("folder"), "/"); folder != "" {
+		files = filesInFolder(files, folder)
+	}
+	names := query["file"]
+	glob := query.Get("glob")
+	if len(names) == 0 && glob == "" {
+		return files, "", nil
+	}
+
+	// Reject invalid patterns even if a filename matches first
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, "", err
+		}
+	}
+
+	requested := make(map[string]bool, len(names))
+	for _, name := range names {
+		requested[name] = true
+	}
+	for _, file := range files {
+		matched := false
+		if glob != "" {
+			matched, _ = path.Match(glob, file.Filename)
+		}
+		if requested[file.Filename] || matched {
+			selected = append(selected, file)
+			delete(requested, file.Filename)
+		}
+	}
+
+	// Report the first requested file that is not in the bin
+	for _, name := range names {
+		if requested[name] {
+			return nil, name, nil
+		}
+	}
+	return selected, "", nil
+}
+
+// addArchiveFolders adds directory entries for the folders of a file that
+// are not in the archive yet, starting with the outermost folder
+func addArchiveFolders(archiver archiveWriter, added map[string]bool, file ds.File) error {
+	var missing []string
+	for folder := ds.FolderOf(file.Filename); folder != "" && !added[folder]; folder = ds.FolderOf(folder) {
+		added[folder] = true
+		missing = append(missing, folder)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := archiver.addFolder(missing[i], file.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// addFilesToArchive adds files from S3 to an archive writer
-func (h *HTTP) addFilesToArchive(w http.ResponseWriter, r *http.Request, bin ds.Bin, files []ds.File, archiver archiveWriter, format string) error {
+// addFilesToArchive adds files from S3 to an archive writer. The downloads
+// are counted for the bin, while the archive refers to the bin as shown,
+// which is the alias if the archive is downloaded using one.
+func (h *HTTP) addFilesToArchive(w http.ResponseWriter, r *http.Request, bin ds.Bin, shown ds.Bin, files []ds.File, archiver archiveWriter, format string) error {
+	var included []ds.File
+	folders := make(map[string]bool)
 	for _, file := range files {
+		if err := addArchiveFolders(archiver, folders, file); err != nil {
+			return err
+		}
+		// Increment download counter for the file (tracks downloads per file).
+		// Files that reached their download limit since the archive was
+		// requested are left out.
+		if err := h.countDownload(bin, &file); err != nil {
+			if h.downloadLimit(file) > 0 {
+				slog.Warn("left file out of archive", "filename", file.Filename, "bin", bin.Id, "error", err)
+				continue
+			}
+			slog.Error("unable to increment download counter", "filename", file.Filename, "bin", bin.Id, "error", err)
+		}
+
 		writer, err := archiver.addFile(file)
 		if err != nil {
 			return err
@@ -338,11 +611,6 @@ func (h *HTTP) addFilesToArchive(w http.ResponseWriter, r *http.Request, bin ds.
 			return err
 		}
 
-		// Increment download counter for the file (tracks downloads per file)
-		if err := h.dao.File().RegisterDownload(&file); err != nil {
-			slog.Error("unable to increment download counter", "filename", file.Filename, "bin", bin.Id, "error", err)
-		}
-
 		h.metrics.IncrBytesStorageToFilebin(file.Bytes)
 
 		bytes, err := io.Copy(writer, fp)
@@ -352,6 +620,16 @@ func (h *HTTP) addFilesToArchive(w http.ResponseWriter, r *http.Request, bin ds.
 		}
 		h.metrics.IncrBytesFilebinToClient(uint64(bytes))
 		slog.Debug("added file to archive", "filename", file.Filename, "bytes", bytes, "format", format, "bin", bin.Id)
+		included = append(included, file)
+	}
+
+	// Archives of encrypted bins contain the encrypted files as they are,
+	// along with a manifest of the encrypted filenames. The manifest is
+	// added last, so that it lists the files that are in the archive.
+	if bin.Encrypted {
+		if err := addEncryptedManifest(archiver, shown, included); err != nil {
+			return err
+		}
 	}
 	return archiver.close()
 }
@@ -367,14 +645,21 @@ func (h *HTTP) archive(w http.ResponseWriter, r *http.Request) {
 	inputBin := params["bin"]
 	inputFormat := params["format"]
 
-	if inputFormat != "zip" && inputFormat != "tar" {
-		http.Error(w, "Supported formats: zip and tar", http.StatusNotFound)
+	switch inputFormat {
+	case "zip", "tar", "tar.gz", "tar.zst":
+	default:
+		http.Error(w, "Supported formats: zip, tar, tar.gz and tar.zst", http.StatusNotFound)
 		return
 	}
 
-	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
 	if err != nil {
-		slog.Error("unable to get bin by ID", "bin", inputBin, "error", err)
+		slog.Error("unable to get bin by ID", "bin", binId, "error", err)
 		http.Error(w, "Errno 265", http.StatusInternalServerError)
 		return
 	}
@@ -388,17 +673,23 @@ func (h *HTTP) archive(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If approvals are required, reject downloads from bins that are not approved
-	if h.config.RequireApproval {
-		if !bin.IsApproved() {
-			h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 522, http.StatusForbidden)
-			return
-		}
+	if !h.binUnlocked(w, r, inputBin, &bin) {
+		return
 	}
 
-	files, err := h.dao.File().GetByBin(inputBin, true)
+	// Reject downloads from bins that are not approved
+	if !bin.IsApproved() {
+		h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 522, http.StatusForbidden)
+		return
+	}
+
+	if !h.downloadPermitted(w, r) {
+		return
+	}
+
+	files, err := h.dao.File().GetByBin(binId, true)
 	if err != nil {
-		slog.Error("unable to get files by bin", "bin", inputBin, "error", err)
+		slog.Error("unable to get files by bin", "bin", binId, "error", err)
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
@@ -409,73 +700,81 @@ func (h *HTTP) archive(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Download the selected files only, if any are selected
+	files, missing, err := selectArchiveFiles(r, files)
+	if err != nil {
+		h.Error(w, r, "", fmt.Sprintf("Invalid glob pattern: %s", err.Error()), 2601, http.StatusBadRequest)
+		return
+	}
+	if missing != "" {
+		h.Error(w, r, "", fmt.Sprintf("The file %q does not exist in the bin.", missing), 2602, http.StatusNotFound)
+		return
+	}
+	if len(files) == 0 {
+		h.Error(w, r, "", "No files in the bin match the folder or glob pattern.", 2603, http.StatusNotFound)
+		return
+	}
+
 	// Filter out files that have exceeded the download limit
-	if h.config.LimitFileDownloads > 0 {
-		var allowed []ds.File
-		for _, file := range files {
-			if file.Downloads < h.config.LimitFileDownloads {
-				allowed = append(allowed, file)
-			}
-		}
-		files = allowed
-		if len(files) == 0 {
-			h.Error(w, r, "", "All files in this bin have exceeded the download limit.", 422, http.StatusForbidden)
-			return
+	var allowed []ds.File
+	for _, file := range files {
+		if !h.downloadLimitReached(file) {
+			allowed = append(allowed, file)
 		}
 	}
+	files = allowed
+	if len(files) == 0 {
+		h.Error(w, r, "", "All files in this bin have exceeded the download limit.", 422, http.StatusForbidden)
+		return
+	}
+
+	// The archive is named after the alias when downloaded using one,
+	// while the download is counted for the bin
+	shown := bin
+	if alias != nil {
+		alias.Mask(&shown, nil)
+	}
 
 	// The file is downloadable at this point
-	if h.config.RequireCookie {
-		if !h.cookieVerify(w, r) {
-			// Set the cookie
-			h.setVerificationCookie(w, r)
-
-			// Show the warning
-			type Data struct {
-				ds.Common
-				Bin     ds.Bin `json:"bin"`
-				NextUrl string `json:"next_url"`
-			}
-			var data Data
-			data.Bin = bin
-			var nextUrl url.URL
-			nextUrl.Scheme = h.config.BaseUrl.Scheme
-			nextUrl.Host = h.config.BaseUrl.Host
-			nextUrl.Path = path.Join(h.config.BaseUrl.Path, r.URL.Path)
-			data.NextUrl = nextUrl.String()
-			if err := h.renderTemplate(w, "cookie", data); err != nil {
-				slog.Error("failed to execute template", "error", err)
-				http.Error(w, "Errno 302", http.StatusInternalServerError)
-				return
-			}
-			return
-		}
+	if !h.cookieChallenge(w, r, bin, alias) {
+		return
 	}
 
 	var archiver archiveWriter
-	if inputFormat == "zip" {
+	switch inputFormat {
+	case "zip":
 		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bin.Id+".zip"))
-		archiver = newZipArchiveWriter(w)
-	} else {
+		archiver = newZipArchiveWriter(w, bin.Encrypted)
+	case "tar":
 		w.Header().Set("Content-Type", "application/x-tar")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bin.Id+".tar"))
 		archiver = newTarArchiveWriter(w)
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		archiver = newTarGzArchiveWriter(w)
+	case "tar.zst":
+		w.Header().Set("Content-Type", "application/zstd")
+		archiver = newTarZstArchiveWriter(w)
 	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", shown.Id+"."+inputFormat))
 
-	if err := h.addFilesToArchive(w, r, bin, files, archiver, inputFormat); err != nil {
+	if err := h.addFilesToArchive(w, r, bin, shown, files, archiver, inputFormat); err != nil {
 		slog.Error("failed to create archive", "bin", bin.Id, "format", inputFormat, "error", err)
 		return
 	}
 
 	if err := h.dao.Bin().RegisterDownload(&bin); err != nil {
-		slog.Error("unable to update bin", "bin", inputBin, "error", err)
+		slog.Error("unable to update bin", "bin", bin.Id, "error", err)
 	}
 
-	if inputFormat == "zip" {
+	switch inputFormat {
+	case "zip":
 		h.metrics.IncrZipArchiveDownloadCount()
-	} else {
+	case "tar":
 		h.metrics.IncrTarArchiveDownloadCount()
+	case "tar.gz":
+		h.metrics.IncrTarGzArchiveDownloadCount()
+	case "tar.zst":
+		h.metrics.IncrTarZstArchiveDownloadCount()
 	}
 }
 
@@ -492,6 +791,9 @@ func (h *HTTP) deleteBin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !found {
+		if h.rejectAlias(w, r, inputBin) {
+			return
+		}
 		http.Error(w, "Bin does not exist", http.StatusNotFound)
 		return
 	}
@@ -511,6 +813,7 @@ func (h *HTTP) deleteBin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.metrics.IncrBinDeleteCount()
+	h.webhooks.Enqueue(webhook.BinEvent(webhook.BinDeleted, bin))
 	http.Error(w, "Bin deleted successfully", http.StatusOK)
 }
 
@@ -527,6 +830,9 @@ func (h *HTTP) lockBin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !found {
+		if h.rejectAlias(w, r, inputBin) {
+			return
+		}
 		http.Error(w, "Bin does not exist", http.StatusNotFound)
 		return
 	}
@@ -550,6 +856,7 @@ func (h *HTTP) lockBin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.metrics.IncrBinLockCount()
+	h.webhooks.Enqueue(webhook.BinEvent(webhook.BinLocked, bin))
 	http.Error(w, "Bin locked successfully.", http.StatusOK)
 }
 
@@ -589,6 +896,7 @@ func (h *HTTP) approveBin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.webhooks.Enqueue(webhook.BinEvent(webhook.BinApproved, bin))
 	http.Error(w, "Bin approved successfully.", http.StatusOK)
 }
 
@@ -634,7 +942,7 @@ func (h *HTTP) banBin(w http.ResponseWriter, r *http.Request) {
 	for _, file := range files {
 		IPs = append(IPs, file.IP)
 	}
-	if err := h.dao.Client().Ban(IPs, r.RemoteAddr); err != nil {
+	if err := h.dao.Client().Ban(IPs, ds.ClientBan{BannedBy: r.RemoteAddr}); err != nil {
 		slog.Error("unable to ban client IPs", "ips", IPs, "error", err)
 		http.Error(w, "Unable to ban clients", http.StatusInternalServerError)
 		return
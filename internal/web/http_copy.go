@@ -0,0 +1,446 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/webhook"
+	"github.com/gorilla/mux"
+)
+
+// Files are copied and moved without moving any bytes, since the content of
+// files is stored once per checksum. A copy is a new file that refers to the
+// same content as the file it was copied from.
+
+// copyDestination is the request body of copies and moves. The file keeps
+// its bin or its filename if either is left out.
+type copyDestination struct {
+	Bin      string `json:"bin"`
+	Filename string `json:"filename"`
+}
+
+// decodeBody decodes an optional JSON request body
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// sourceReadable applies the checks of downloads to the bin that files are
+// copied from, since a copy makes the files available from another bin. The
+// error response is written to the client if the files can not be read.
+func (h *HTTP) sourceReadable(w http.ResponseWriter, r *http.Request, inputBin string, bin *ds.Bin) bool {
+	if !h.binUnlocked(w, r, inputBin, bin) {
+		return false
+	}
+	if !bin.IsApproved() {
+		h.Error(w, r, "", "This bin requires approval before files can be copied.", 3234, http.StatusForbidden)
+		return false
+	}
+	return h.downloadPermitted(w, r)
+}
+
+// copyFile copies a file to another filename, in the same bin or in another
+// bin
+func (h *HTTP) copyFile(w http.ResponseWriter, r *http.Request) {
+	h.transferFile(w, r, false)
+}
+
+// moveFile moves a file to another filename, in the same bin or in another
+// bin. Renaming a file is a move within the bin.
+func (h *HTTP) moveFile(w http.ResponseWriter, r *http.Request) {
+	h.transferFile(w, r, true)
+}
+
+func (h *HTTP) transferFile(w http.ResponseWriter, r *http.Request, move bool) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFilename := params["filename"]
+
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 3201, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		if !h.rejectAlias(w, r, inputBin) {
+			h.Error(w, r, "", "The bin does not exist", 3202, http.StatusNotFound)
+		}
+		return
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 3203, http.StatusNotFound)
+		return
+	}
+	if move && bin.Readonly {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.Error(w, r, fmt.Sprintf("Rejected move of filename %q from readonly bin %q", inputFilename, inputBin), "Files can not be moved out of locked bins", 3204, http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.sourceReadable(w, r, inputBin, &bin) {
+		return
+	}
+
+	// The server is not able to read the filenames or the content of
+	// encrypted bins, which are encrypted with a key per bin
+	if bin.Encrypted || encryptedUpload(r) {
+		h.Error(w, r, fmt.Sprintf("Rejected copy of filename %q in encrypted bin %q", inputFilename, inputBin), "Files in encrypted bins can not be copied or moved", 3205, http.StatusBadRequest)
+		return
+	}
+
+	file, found, err := h.dao.File().GetByName(inputBin, inputFilename)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select file %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Database error", 3206, http.StatusInternalServerError)
+		return
+	}
+	if !found || !file.IsReadable() {
+		h.Error(w, r, "", "The file does not exist", 3207, http.StatusNotFound)
+		return
+	}
+	if h.downloadLimitReached(file) {
+		h.Error(w, r, "", "The file has been requested too many times.", 3235, http.StatusForbidden)
+		return
+	}
+
+	// A copy of a file with a download limit of its own would be
+	// downloadable as many times again. A moved file keeps the downloads
+	// it has left.
+	source := file
+	if file.DownloadLimit > 0 {
+		if !move {
+			h.Error(w, r, fmt.Sprintf("Rejected copy of filename %q in bin %q with a download limit", inputFilename, inputBin), "Files with a download limit can not be copied", 3236, http.StatusForbidden)
+			return
+		}
+		source.DownloadLimit = file.DownloadsLeft()
+	}
+
+	var destination copyDestination
+	if err := decodeBody(r, &destination); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse copy request for file %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Invalid request body", 3208, http.StatusBadRequest)
+		return
+	}
+	if destination.Bin == "" {
+		destination.Bin = bin.Id
+	}
+	if destination.Filename == "" {
+		destination.Filename = file.Filename
+	}
+
+	// The destination filename is sanitized the same way as the filenames
+	// of uploads
+	target := ds.File{Filename: destination.Filename}
+	if err := h.dao.File().ValidateInput(&target); err != nil {
+		h.Error(w, r, "", err.Error(), 3209, http.StatusBadRequest)
+		return
+	}
+	if destination.Bin == bin.Id && target.Filename == file.Filename {
+		h.Error(w, r, "", "The destination is the file itself", 3210, http.StatusBadRequest)
+		return
+	}
+
+	// The destination bin is created if it does not exist, and is
+	// subject to the same checks as the bins of uploads
+	dst, ok := h.prepareUpload(w, r, destination.Bin, target.Filename)
+	if !ok {
+		return
+	}
+	if dst.Id == bin.Id {
+		// Keep the updated bin for the response
+		bin = dst
+	}
+
+	copied, ok := h.copyFileTo(w, r, &dst, source, target.Filename)
+	if !ok {
+		return
+	}
+
+	if move {
+		_ = file.DeletedAt.Scan(time.Now().UTC().Truncate(time.Microsecond))
+		if err := h.dao.File().Update(&file); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to delete filename %q in bin %q after moving it: %s", file.Filename, inputBin, err.Error()), "Database error", 3211, http.StatusInternalServerError)
+			return
+		}
+		h.metrics.IncrFileDeleteCount()
+		h.webhooks.Enqueue(webhook.FileEvent(webhook.FileDeleted, bin, file))
+		if dst.Id != bin.Id {
+			if err := h.dao.Bin().Update(&bin); err != nil {
+				h.Error(w, r, fmt.Sprintf("Unable to update bin %q: %s", inputBin, err.Error()), "Database error", 3212, http.StatusInternalServerError)
+				return
+			}
+		}
+		slog.Info("moved file", "filename", file.Filename, "bin", bin.Id, "to_filename", copied.Filename, "to_bin", dst.Id)
+	} else {
+		slog.Info("copied file", "filename", file.Filename, "bin", bin.Id, "to_filename", copied.Filename, "to_bin", dst.Id)
+	}
+
+	// The transaction of the request is logged for the bin that the file
+	// was in, and the destination bin gets a transaction of its own
+	if dst.Id != bin.Id {
+		h.registerTransaction(r, dst.Id, copied.Filename, http.StatusCreated)
+	}
+
+	type Data struct {
+		Bin  ds.Bin  `json:"bin"`
+		File ds.File `json:"file"`
+	}
+	out, err := json.MarshalIndent(Data{Bin: dst, File: copied}, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 3213, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+// copyFileTo stores a copy of a file in a bin, which replaces any file with
+// the same filename in the bin like an upload would. The copy refers to the
+// content of the file, and keeps its download limit, description and tags.
+// The error response is written to the client if the file could not be
+// copied.
+func (h *HTTP) copyFileTo(w http.ResponseWriter, r *http.Request, bin *ds.Bin, file ds.File, filename string) (ds.File, bool) {
+	content, err := h.dao.FileContent().GetBySHA256(file.SHA256)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to select content %s of filename %q in bin %q: %s", file.SHA256, file.Filename, file.Bin, err.Error()), "Database error", 3214, http.StatusInternalServerError)
+		return file, false
+	}
+	if content.Blocked {
+		h.Error(w, r, fmt.Sprintf("Rejecting copy of file %q to bin %q: content with SHA256 %s is blocked", file.Filename, bin.Id, file.SHA256), "This content has been blocked and cannot be uploaded", 3215, http.StatusForbidden)
+		return file, false
+	}
+	if !content.InStorage {
+		h.Error(w, r, "", "The file is not available", 3216, http.StatusNotFound)
+		return file, false
+	}
+
+	copied, found, err := h.dao.File().GetByName(bin.Id, filename)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select file %q in bin %q: %s", filename, bin.Id, err.Error()), "Database error", 3217, http.StatusInternalServerError)
+		return file, false
+	}
+	previous := copied
+	if found {
+		copied.Updates = copied.Updates + 1
+	}
+
+	dump, err := httputil.DumpRequest(r, false)
+	if err != nil {
+		h.Error(w, r, "Failed to dump request", "Parse error", 3218, http.StatusInternalServerError)
+		return file, false
+	}
+	ip, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		h.Error(w, r, "Failed to dump request", "Parse error", 3219, http.StatusInternalServerError)
+		return file, false
+	}
+
+	copied.Bin = bin.Id
+	copied.Filename = filename
+	copied.SHA256 = file.SHA256
+	copied.Bytes = file.Bytes
+	copied.Mime = file.Mime
+	copied.MD5 = file.MD5
+	copied.EncryptedName = file.EncryptedName
+	copied.DownloadLimit = file.DownloadLimit
+	copied.Description = file.Description
+	copied.Tags = file.Tags
+	copied.IP = ip
+	copied.Headers = string(dump)
+	_ = copied.DeletedAt.Scan(nil)
+
+	if found {
+		if !h.keepVersion(w, r, previous, copied.SHA256) {
+			return file, false
+		}
+		err = h.dao.File().Update(&copied)
+		if err == nil {
+			err = h.dao.File().UpdateMetadata(&copied)
+		}
+	} else {
+		var inserted bool
+		inserted, err = h.dao.File().Insert(&copied)
+		if err == nil && !inserted {
+			err = errors.New("a file with the same filename was created concurrently")
+		}
+	}
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to store copy of filename %q in bin %q as %q in bin %q: %s", file.Filename, file.Bin, filename, bin.Id, err.Error()), "Database error", 3220, http.StatusInternalServerError)
+		return file, false
+	}
+
+	if err := h.dao.FileContent().RegisterReference(file.SHA256); err != nil {
+		// The copy is complete either way
+		slog.Error("unable to update file_content", "sha256", file.SHA256, "error", err)
+	}
+
+	// The copy keeps the bin for its expiration time from now, like an
+	// upload does
+	if expiredAt := time.Now().UTC().Add(bin.Lifetime(h.config.ExpirationDuration)); expiredAt.After(bin.ExpiredAt) {
+		bin.ExpiredAt = expiredAt
+	}
+	if err := h.dao.Bin().Update(bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update bin %q: %s", bin.Id, err.Error()), "Database error", 3221, http.StatusInternalServerError)
+		return file, false
+	}
+
+	h.webhooks.Enqueue(webhook.FileEvent(webhook.FileUploaded, *bin, copied))
+	return copied, true
+}
+
+// registerTransaction logs a transaction for another bin than the bin of the
+// request
+func (h *HTTP) registerTransaction(r *http.Request, bin string, filename string, status int) {
+	now := time.Now()
+	if _, err := h.dao.Transaction().Register(r, bin, filename, now, now, status, 0); err != nil {
+		slog.Error("unable to register transaction", "bin", bin, "error", err)
+	}
+}
+
+// cloneBin copies a bin and its files to a new bin. The new bin gets its own
+// owner token, and keeps the title, description, password, expiration time
+// and download limit of the bin. Clones of locked bins are not locked. Bins
+// with files that have a download limit of their own can not be cloned.
+func (h *HTTP) cloneBin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 3222, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		if !h.rejectAlias(w, r, inputBin) {
+			h.Error(w, r, "", "The bin does not exist", 3223, http.StatusNotFound)
+		}
+		return
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 3224, http.StatusNotFound)
+		return
+	}
+	if !h.sourceReadable(w, r, inputBin, &bin) {
+		return
+	}
+
+	var destination struct {
+		Bin string `json:"bin"`
+	}
+	if err := decodeBody(r, &destination); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse clone request for bin %q: %s", inputBin, err.Error()), "Invalid request body", 3225, http.StatusBadRequest)
+		return
+	}
+	if destination.Bin == "" {
+		destination.Bin = h.dao.Bin().GenerateId()
+	}
+	if h.rejectAlias(w, r, destination.Bin) {
+		return
+	}
+
+	// Clones need storage like uploads do
+	if h.config.LimitStorageBytes > 0 && h.getCachedStorageBytes() >= h.config.LimitStorageBytes {
+		h.Error(w, r, fmt.Sprintf("Storage limit reached when trying to clone bin %q", inputBin), "Insufficient storage, please retry later", 3226, http.StatusInsufficientStorage)
+		return
+	}
+
+	all, err := h.dao.File().GetByBin(inputBin, true)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select files by bin %q: %s", inputBin, err.Error()), "Database error", 3227, http.StatusInternalServerError)
+		return
+	}
+	var files []ds.File
+	for _, file := range all {
+		if file.DownloadLimit > 0 {
+			h.Error(w, r, fmt.Sprintf("Rejected clone of bin %q with filename %q that has a download limit", inputBin, file.Filename), "Bins with files that have a download limit can not be cloned", 3237, http.StatusForbidden)
+			return
+		}
+
+		// Files that can not be downloaded again are left out
+		if h.downloadLimitReached(file) {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	clone := ds.Bin{
+		Id:                destination.Bin,
+		Title:             bin.Title,
+		Description:       bin.Description,
+		PasswordHash:      bin.PasswordHash,
+		Encrypted:         bin.Encrypted,
+		ExpirationSeconds: bin.ExpirationSeconds,
+		DownloadLimit:     bin.DownloadLimit,
+	}
+	if !h.approvalRequired(r) {
+		_ = clone.ApprovedAt.Scan(time.Now().UTC().Truncate(time.Microsecond))
+	}
+	clone.ExpiredAt = time.Now().UTC().Add(clone.Lifetime(h.config.ExpirationDuration))
+	if err := h.dao.Bin().ValidateInput(&clone); err != nil {
+		h.Error(w, r, "", err.Error(), 3228, http.StatusBadRequest)
+		return
+	}
+	if !h.uploadPermitted(w, r) || !h.clientBinsAllowed(w, r) {
+		return
+	}
+	if err := clone.GenerateOwnerToken(); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to generate owner token for bin %q: %s", clone.Id, err.Error()), "Internal error", 3229, http.StatusInternalServerError)
+		return
+	}
+	ownerToken := clone.OwnerToken
+	inserted, err := h.dao.Bin().Insert(&clone)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to insert bin %q: %s", clone.Id, err.Error()), "Database error", 3230, http.StatusInternalServerError)
+		return
+	}
+	if !inserted {
+		h.Error(w, r, "", "The bin already exists", 3231, http.StatusConflict)
+		return
+	}
+	h.metrics.IncrNewBinCount()
+	h.webhooks.Enqueue(webhook.BinEvent(webhook.BinCreated, clone))
+	h.registerClientUsage(r, ds.ClientUsageBin, 0)
+
+	// Files with blocked content are deleted when the content is blocked,
+	// and are not listed here
+	for _, file := range files {
+		if _, ok := h.copyFileTo(w, r, &clone, file, file.Filename); !ok {
+			return
+		}
+	}
+
+	clone, _, err = h.dao.Bin().GetByID(clone.Id)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to fetch bin %q after clone: %s", clone.Id, err.Error()), "Database error", 3232, http.StatusInternalServerError)
+		return
+	}
+	clone.OwnerToken = ownerToken
+	h.setOwnerToken(w, &clone)
+	if clone.HasPassword() {
+		h.setBinSession(w, clone.Id, &clone)
+	}
+	h.registerTransaction(r, clone.Id, "", http.StatusCreated)
+	slog.Info("cloned bin", "bin", bin.Id, "to_bin", clone.Id, "files", len(files))
+
+	out, err := json.MarshalIndent(clone, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 3233, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,293 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func copyRequest(h *HTTP, path string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Owner-Token", token)
+	}
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func downloadContent(t *testing.T, h *HTTP, path string) (int, string) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := io.ReadAll(rr.Body)
+	if err != nil {
+		t.Fatalf("Unable to read response: %s", err)
+	}
+	return rr.Code, string(body)
+}
+
+func TestCopyFile(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/copysourcebin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	rr = metadataRequest(h, "/metadata/copysourcebin/file.txt", "", `{"tags": ["notes"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	tests := []struct {
+		description string
+		body        string
+		statusCode  int
+
+		expectedBin      string
+		expectedFilename string
+	}{
+		{"to another filename", `{"filename": "copy.txt"}`, http.StatusCreated, "copysourcebin", "copy.txt"},
+		{"to another bin", `{"bin": "copytargetbin"}`, http.StatusCreated, "copytargetbin", "file.txt"},
+		{"without a body", ``, http.StatusBadRequest, "", ""},
+		{"to itself", `{"filename": "file.txt"}`, http.StatusBadRequest, "", ""},
+		{"to an invalid bin", `{"bin": "a"}`, http.StatusBadRequest, "", ""},
+		{"unknown field", `{"name": "copy.txt"}`, http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rr := copyRequest(h, "/copy/copysourcebin/file.txt", "", test.body)
+			if rr.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+			if test.statusCode != http.StatusCreated {
+				return
+			}
+
+			var data struct {
+				Bin  ds.Bin  `json:"bin"`
+				File ds.File `json:"file"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Unable to parse response: %s", err)
+			}
+			if data.Bin.Id != test.expectedBin || data.File.Filename != test.expectedFilename {
+				t.Errorf("Expected %s/%s, got %s/%s", test.expectedBin, test.expectedFilename, data.Bin.Id, data.File.Filename)
+			}
+			if strings.Join(data.File.Tags, ",") != "notes" {
+				t.Errorf("Expected the tags to be copied, got %q", data.File.Tags)
+			}
+
+			code, body := downloadContent(t, h, "/"+test.expectedBin+"/"+test.expectedFilename)
+			if code != http.StatusOK || body != "some content" {
+				t.Errorf("Expected the copy to be downloadable, got status %d and body %q", code, body)
+			}
+		})
+	}
+
+	// The original file is kept
+	if code, _ := downloadContent(t, h, "/copysourcebin/file.txt"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// Files can not be copied to locked bins
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/copytargetbin", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = copyRequest(h, "/copy/copysourcebin/file.txt", "", `{"bin": "copytargetbin", "filename": "other.txt"}`)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusMethodNotAllowed, rr.Code, rr.Body.String())
+	}
+
+	// Missing files can not be copied
+	rr = copyRequest(h, "/copy/copysourcebin/missing.txt", "", `{"filename": "copy.txt"}`)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.RequireOwnerToken = true
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/movesourcebin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	token := rr.Header().Get("Owner-Token")
+
+	rr = copyRequest(h, "/move/movesourcebin/file.txt", "", `{"filename": "renamed.txt"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	// Renaming is a move within the bin
+	rr = copyRequest(h, "/move/movesourcebin/file.txt", token, `{"filename": "docs/renamed.txt"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code, _ := downloadContent(t, h, "/movesourcebin/file.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for the moved file, got %d", http.StatusNotFound, code)
+	}
+	if code, body := downloadContent(t, h, "/movesourcebin/docs/renamed.txt"); code != http.StatusOK || body != "some content" {
+		t.Errorf("Expected the renamed file to be downloadable, got status %d and body %q", code, body)
+	}
+
+	rr = copyRequest(h, "/move/movesourcebin/docs/renamed.txt", token, `{"bin": "movetargetbin"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code, _ := downloadContent(t, h, "/movesourcebin/docs/renamed.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for the moved file, got %d", http.StatusNotFound, code)
+	}
+	if code, body := downloadContent(t, h, "/movetargetbin/docs/renamed.txt"); code != http.StatusOK || body != "some content" {
+		t.Errorf("Expected the moved file to be downloadable, got status %d and body %q", code, body)
+	}
+}
+
+func TestCloneBin(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	for _, filename := range []string{"a.txt", "docs/b.txt"} {
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, uploadRequest("/clonesourcebin/"+filename, "content of "+filename))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+	rr := metadataRequest(h, "/metadata/clonesourcebin", "", `{"title": "Documents"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr = copyRequest(h, "/clone/clonesourcebin", "", `{"bin": "clonetargetbin"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var bin ds.Bin
+	if err := json.Unmarshal(rr.Body.Bytes(), &bin); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if bin.Id != "clonetargetbin" || bin.Title != "Documents" || bin.Files != 2 {
+		t.Errorf("Unexpected clone: id %q, title %q, %d files", bin.Id, bin.Title, bin.Files)
+	}
+	if rr.Header().Get("Owner-Token") == "" {
+		t.Errorf("Expected an owner token for the clone")
+	}
+	for _, filename := range []string{"a.txt", "docs/b.txt"} {
+		if code, body := downloadContent(t, h, "/clonetargetbin/"+filename); code != http.StatusOK || body != "content of "+filename {
+			t.Errorf("Expected %s to be cloned, got status %d and body %q", filename, code, body)
+		}
+	}
+
+	// Bins are not cloned to existing bins
+	rr = copyRequest(h, "/clone/clonesourcebin", "", `{"bin": "clonetargetbin"}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusConflict, rr.Code, rr.Body.String())
+	}
+
+	// The clone gets a random id if none is given
+	rr = copyRequest(h, "/clone/clonesourcebin", "", ``)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &bin); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if bin.Id == "clonesourcebin" || bin.Id == "clonetargetbin" {
+		t.Errorf("Expected a new bin id, got %q", bin.Id)
+	}
+}
+
+func TestCopyProtectedSource(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/copyprotectedbin/file.txt", "some content")
+	req.Header.Set("Bin-Password", "secret")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	protectedRequest := func(path string, password string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if password != "" {
+			req.Header.Set("Bin-Password", password)
+		}
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The files of a password protected bin can not be copied out of it
+	// without the password
+	tests := []struct {
+		path     string
+		password string
+		body     string
+		status   int
+	}{
+		{"/copy/copyprotectedbin/file.txt", "", `{"bin": "copyunprotectedbin"}`, http.StatusUnauthorized},
+		{"/copy/copyprotectedbin/file.txt", "wrong", `{"bin": "copyunprotectedbin"}`, http.StatusUnauthorized},
+		{"/move/copyprotectedbin/file.txt", "", `{"bin": "copyunprotectedbin"}`, http.StatusUnauthorized},
+		{"/clone/copyprotectedbin", "", `{"bin": "cloneprotectedbin"}`, http.StatusUnauthorized},
+		{"/copy/copyprotectedbin/file.txt", "secret", `{"bin": "copyunprotectedbin"}`, http.StatusCreated},
+		{"/clone/copyprotectedbin", "secret", `{"bin": "cloneprotectedbin"}`, http.StatusCreated},
+	}
+	for _, test := range tests {
+		rr := protectedRequest(test.path, test.password, test.body)
+		if rr.Code != test.status {
+			t.Errorf("Expected status %d for %s with password %q, got %d. Body: %s", test.status, test.path, test.password, rr.Code, rr.Body.String())
+		}
+	}
+	if code, body := downloadContent(t, h, "/copyunprotectedbin/file.txt"); code != http.StatusOK || body != "some content" {
+		t.Errorf("Expected the copy to be downloadable, got status %d and body %q", code, body)
+	}
+}
+
+func TestCopyLimitedFile(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/copylimitbin/file.txt", "some content")
+	req.Header.Set("Download-Limit", "2")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code, _ := downloadContent(t, h, "/copylimitbin/file.txt"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// Copies would start the count of downloads over
+	rr = copyRequest(h, "/copy/copylimitbin/file.txt", "", `{"filename": "copy.txt"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+	rr = copyRequest(h, "/clone/copylimitbin", "", `{"bin": "clonelimitbin"}`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	// A moved file keeps the downloads it has left
+	rr = copyRequest(h, "/move/copylimitbin/file.txt", "", `{"filename": "moved.txt"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code, _ := downloadContent(t, h, "/copylimitbin/moved.txt"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code, _ := downloadContent(t, h, "/copylimitbin/moved.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d after the last download, got %d", http.StatusNotFound, code)
+	}
+}
@@ -0,0 +1,482 @@
+package web
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/s3"
+	"github.com/gorilla/mux"
+)
+
+// Direct uploads let the client upload the content straight to S3 using
+// presigned URLs, so that the bytes do not pass through filebin. The parts
+// are uploaded as an S3 multipart upload to a staging key, where every part
+// carries a SHA256 checksum that S3 verifies on arrival.
+//
+// When the client finalizes the upload, the part checksums reported by S3
+// are compared with the ones the client sent, and the parts are assembled.
+// The staged object is then read back once to compute the content checksums,
+// before it is copied to its content-addressable key (or deduplicated
+// against existing content) and the file is created in the bin.
+
+const (
+	// S3 requires all parts except the last one to be at least 5 MB, and
+	// allows at most 10000 parts in a multipart upload.
+	directUploadMinPartSize = 5 * 1024 * 1024
+	directUploadMaxParts    = 10000
+
+	// The largest object that S3 can store
+	directUploadMaxBytes = 5 * 1024 * 1024 * 1024 * 1024
+
+	// The largest accepted JSON request body
+	directUploadMaxRequestBytes = 1024 * 1024
+)
+
+var sha256HexPattern = regexp.MustCompile("^[a-f0-9]{64}$")
+
+// directUploadLayout returns the part size and the number of parts to use
+// for an upload of the given size.
+func directUploadLayout(bytes uint64, partSize uint64) (uint64, int) {
+	if partSize < directUploadMinPartSize {
+		partSize = directUploadMinPartSize
+	}
+	if minPartSize := (bytes + directUploadMaxParts - 1) / directUploadMaxParts; partSize < minPartSize {
+		partSize = minPartSize
+	}
+	parts := int((bytes + partSize - 1) / partSize)
+	return partSize, parts
+}
+
+// directUploadPart is a part in the direct upload requests and responses.
+// The checksum is the base64 encoded SHA256 checksum of the part, as in the
+// x-amz-checksum-sha256 header.
+type directUploadPart struct {
+	Number         int    `json:"number"`
+	Bytes          uint64 `json:"bytes,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	URL            string `json:"url,omitempty"`
+}
+
+// decodeDirectUploadRequest decodes the JSON request body into v. The error
+// response is written to the client if the request body is invalid.
+func (h *HTTP) decodeDirectUploadRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, directUploadMaxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse direct upload request: %s", err.Error()), "Invalid request body", 1501, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *HTTP) writeDirectUploadResponse(w http.ResponseWriter, r *http.Request, v interface{}, statusCode int) {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 1502, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(out)
+}
+
+func (h *HTTP) directCreate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	var input struct {
+		Filename string `json:"filename"`
+		Bytes    uint64 `json:"bytes"`
+		SHA256   string `json:"sha256"`
+	}
+	if !h.decodeDirectUploadRequest(w, r, &input) {
+		return
+	}
+
+	if input.Bytes == 0 {
+		h.Error(w, r, "", "Empty file uploads are not allowed", 1503, http.StatusBadRequest)
+		return
+	}
+	if input.Bytes > directUploadMaxBytes {
+		h.Error(w, r, fmt.Sprintf("Direct upload of %d bytes rejected", input.Bytes), "The file is too large", 1504, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if input.SHA256 != "" && !sha256HexPattern.MatchString(input.SHA256) {
+		h.Error(w, r, "", "The SHA256 checksum must be 64 lowercase hexadecimal characters", 1505, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the filename early to avoid receiving a large file that would
+	// be rejected anyway.
+	file := ds.File{Bin: inputBin, Filename: input.Filename}
+	if err := h.dao.File().ValidateInput(&file); err != nil {
+		h.Error(w, r, fmt.Sprintf("Input validation error on direct upload: %s", err.Error()), "Input validation failed", 1506, http.StatusBadRequest)
+		return
+	}
+
+	if !h.uploadSizeAllowed(w, r, file.Filename, input.Bytes) {
+		return
+	}
+
+	bin, ok := h.prepareUpload(w, r, inputBin, file.Filename)
+	if !ok {
+		return
+	}
+
+	ip, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		h.Error(w, r, "Failed to parse remote address", "Parse error", 1507, http.StatusInternalServerError)
+		return
+	}
+
+	upload := ds.DirectUpload{
+		Id:        h.dao.DirectUpload().GenerateId(),
+		Bin:       bin.Id,
+		Filename:  file.Filename,
+		Bytes:     input.Bytes,
+		SHA256:    input.SHA256,
+		IP:        ip,
+		ExpiredAt: time.Now().UTC().Add(h.config.ResumableUploadTTL),
+	}
+	upload.PartSize, upload.Parts = directUploadLayout(upload.Bytes, uint64(h.s3.GetPartSize()))
+	upload.ObjectKey = path.Join("staging", upload.Id)
+
+	upload.StorageUploadId, err = h.s3.CreateMultipartUpload(upload.ObjectKey)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to create multipart upload for filename %q in bin %q: %s", upload.Filename, bin.Id, err.Error()), "Storage error", 1508, http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.dao.DirectUpload().Insert(&upload); err != nil {
+		_ = h.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+		h.Error(w, r, fmt.Sprintf("Unable to insert direct upload for filename %q in bin %q: %s", upload.Filename, bin.Id, err.Error()), "Database error", 1509, http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("created direct upload", "upload", upload.Id, "filename", upload.Filename, "bin", bin.Id, "bytes", upload.Bytes, "parts", upload.Parts)
+
+	var location url.URL
+	location.Scheme = h.config.BaseUrl.Scheme
+	location.Host = h.config.BaseUrl.Host
+	location.Path = path.Join(h.config.BaseUrl.Path, "direct", bin.Id, upload.Id)
+	w.Header().Set("Location", location.String())
+	h.writeDirectUploadResponse(w, r, upload, http.StatusCreated)
+}
+
+// directLookup returns the direct upload that the request refers to. The
+// error response is written to the client if the upload does not exist or
+// is no longer available.
+func (h *HTTP) directLookup(w http.ResponseWriter, r *http.Request) (ds.DirectUpload, bool) {
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputUpload := params["upload"]
+
+	upload, found, err := h.dao.DirectUpload().GetByID(inputUpload)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select direct upload by id %q: %s", inputUpload, err.Error()), "Database error", 1510, http.StatusInternalServerError)
+		return upload, false
+	}
+	if !found || upload.Bin != inputBin {
+		h.Error(w, r, "", "The upload does not exist", 1511, http.StatusNotFound)
+		return upload, false
+	}
+	if upload.IsExpired() {
+		h.Error(w, r, "", "The upload has expired", 1512, http.StatusGone)
+		return upload, false
+	}
+	return upload, true
+}
+
+// directWritableBin returns the bin of the direct upload if it still
+// accepts uploads. The error response is written to the client otherwise.
+func (h *HTTP) directWritableBin(w http.ResponseWriter, r *http.Request, upload ds.DirectUpload) (ds.Bin, bool) {
+	bin, found, err := h.dao.Bin().GetByID(upload.Bin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", upload.Bin, err.Error()), "Database error", 1513, http.StatusInternalServerError)
+		return bin, false
+	}
+	if !found || !bin.IsWritable() {
+		h.Error(w, r, fmt.Sprintf("Direct upload %q rejected since bin %q is no longer writable", upload.Id, upload.Bin), "The bin is no longer available for uploads", 1514, http.StatusMethodNotAllowed)
+		return bin, false
+	}
+	return bin, true
+}
+
+// directStatus returns the upload together with the parts that S3 has
+// received so far, which lets the client resume an interrupted upload.
+func (h *HTTP) directStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	upload, ok := h.directLookup(w, r)
+	if !ok {
+		return
+	}
+
+	received, err := h.s3.ListParts(upload.ObjectKey, upload.StorageUploadId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to list parts of direct upload %q: %s", upload.Id, err.Error()), "Storage error", 1515, http.StatusInternalServerError)
+		return
+	}
+
+	type Data struct {
+		ds.DirectUpload
+		Received []directUploadPart `json:"received"`
+	}
+	data := Data{DirectUpload: upload, Received: []directUploadPart{}}
+	for _, part := range received {
+		data.Received = append(data.Received, directUploadPart{
+			Number:         int(part.Number),
+			Bytes:          uint64(part.Size),
+			ChecksumSHA256: part.ChecksumSHA256,
+		})
+	}
+	h.writeDirectUploadResponse(w, r, data, http.StatusOK)
+}
+
+// directPresign returns presigned URLs for the requested parts. The client
+// declares the checksum of each part up front, and S3 rejects a part with
+// content that does not match it.
+func (h *HTTP) directPresign(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	upload, ok := h.directLookup(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.directWritableBin(w, r, upload); !ok {
+		return
+	}
+
+	var input struct {
+		Parts []directUploadPart `json:"parts"`
+	}
+	if !h.decodeDirectUploadRequest(w, r, &input) {
+		return
+	}
+	if len(input.Parts) == 0 {
+		h.Error(w, r, "", "No parts were requested", 1516, http.StatusBadRequest)
+		return
+	}
+
+	// The presigned URLs are valid until the upload expires
+	expiry := time.Until(upload.ExpiredAt)
+
+	type Data struct {
+		Parts []directUploadPart `json:"parts"`
+	}
+	var data Data
+	for _, part := range input.Parts {
+		if part.Number < 1 || part.Number > upload.Parts {
+			h.Error(w, r, "", fmt.Sprintf("Part number %d is out of range, the upload has %d parts", part.Number, upload.Parts), 1517, http.StatusBadRequest)
+			return
+		}
+		checksum, err := base64.StdEncoding.DecodeString(part.ChecksumSHA256)
+		if err != nil || len(checksum) != sha256.Size {
+			h.Error(w, r, "", fmt.Sprintf("Part number %d has an invalid checksum, expected a base64 encoded SHA256 checksum", part.Number), 1518, http.StatusBadRequest)
+			return
+		}
+		presignedURL, err := h.s3.PresignedUploadPart(upload.ObjectKey, upload.StorageUploadId, int32(part.Number), part.ChecksumSHA256, expiry)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to presign part %d of direct upload %q: %s", part.Number, upload.Id, err.Error()), "Storage error", 1519, http.StatusInternalServerError)
+			return
+		}
+		data.Parts = append(data.Parts, directUploadPart{
+			Number:         part.Number,
+			Bytes:          upload.PartBytes(part.Number),
+			ChecksumSHA256: part.ChecksumSHA256,
+			URL:            presignedURL.String(),
+		})
+	}
+	h.writeDirectUploadResponse(w, r, data, http.StatusOK)
+}
+
+// verifyDirectUploadParts compares the parts that S3 has received with the
+// parts of the upload and the checksums that the client declared.
+func verifyDirectUploadParts(upload ds.DirectUpload, received []s3.Part, declared []directUploadPart) error {
+	if len(declared) != upload.Parts {
+		return fmt.Errorf("expected checksums for %d parts, got %d", upload.Parts, len(declared))
+	}
+	if len(received) != upload.Parts {
+		return fmt.Errorf("expected %d parts, storage has received %d", upload.Parts, len(received))
+	}
+	checksums := make(map[int]string)
+	for _, part := range declared {
+		checksums[part.Number] = part.ChecksumSHA256
+	}
+	for i, part := range received {
+		number := i + 1
+		if int(part.Number) != number {
+			return fmt.Errorf("part %d is missing", number)
+		}
+		if uint64(part.Size) != upload.PartBytes(number) {
+			return fmt.Errorf("part %d has %d bytes, expected %d", number, part.Size, upload.PartBytes(number))
+		}
+		if part.ChecksumSHA256 == "" || part.ChecksumSHA256 != checksums[number] {
+			return fmt.Errorf("part %d has checksum %q in storage, expected %q", number, part.ChecksumSHA256, checksums[number])
+		}
+	}
+	return nil
+}
+
+func (h *HTTP) directFinalize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	t0 := time.Now()
+
+	h.metrics.IncrFileUploadInProgress()
+	defer h.metrics.DecrFileUploadInProgress()
+
+	upload, ok := h.directLookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.acquireUpload(upload.Id) {
+		h.Error(w, r, "", "The upload is already being finalized in another request", 1520, http.StatusLocked)
+		return
+	}
+	defer h.releaseUpload(upload.Id)
+
+	bin, ok := h.directWritableBin(w, r, upload)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Parts []directUploadPart `json:"parts"`
+	}
+	if !h.decodeDirectUploadRequest(w, r, &input) {
+		return
+	}
+
+	received, err := h.s3.ListParts(upload.ObjectKey, upload.StorageUploadId)
+	if err != nil {
+		// The parts may have been assembled by an earlier finalize request
+		// that failed later on, in which case the staged object exists.
+		stat, statErr := h.s3.StatObject(upload.ObjectKey)
+		if statErr != nil || stat.ContentLength == nil || uint64(*stat.ContentLength) != upload.Bytes {
+			h.Error(w, r, fmt.Sprintf("Unable to list parts of direct upload %q: %s", upload.Id, err.Error()), "Storage error", 1521, http.StatusInternalServerError)
+			return
+		}
+		slog.Debug("direct upload is already assembled", "upload", upload.Id)
+	} else {
+		if err := verifyDirectUploadParts(upload, received, input.Parts); err != nil {
+			h.Error(w, r, fmt.Sprintf("Direct upload %q failed verification: %s", upload.Id, err.Error()), fmt.Sprintf("Verification failed: %s", err.Error()), 1522, http.StatusBadRequest)
+			return
+		}
+		if err := h.s3.CompleteMultipartUpload(upload.ObjectKey, upload.StorageUploadId, received); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to complete direct upload %q: %s", upload.Id, err.Error()), "Storage error", 1523, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	t1 := time.Now()
+
+	// Read the staged object back to compute the checksums of the entire
+	// content, which S3 does not provide for multipart uploads.
+	fp, err := h.s3.GetObject(upload.ObjectKey, 0, 0)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read staged object of direct upload %q: %s", upload.Id, err.Error()), "Storage error", 1524, http.StatusInternalServerError)
+		return
+	}
+	md5Checksum := md5.New()
+	sha256Checksum := sha256.New()
+	nBytes, err := io.Copy(io.MultiWriter(md5Checksum, sha256Checksum), fp)
+	_ = fp.Close()
+	h.metrics.IncrBytesStorageToFilebin(uint64(nBytes))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read staged object of direct upload %q: %s", upload.Id, err.Error()), "Storage error", 1525, http.StatusInternalServerError)
+		return
+	}
+	if uint64(nBytes) != upload.Bytes {
+		h.Error(w, r, fmt.Sprintf("Staged object of direct upload %q has %d bytes, expected %d", upload.Id, nBytes, upload.Bytes), "Storage error", 1526, http.StatusInternalServerError)
+		return
+	}
+
+	sha256ChecksumString := fmt.Sprintf("%x", sha256Checksum.Sum(nil))
+	if upload.SHA256 != "" && upload.SHA256 != sha256ChecksumString {
+		h.discardDirectUpload(upload)
+		h.Error(w, r, fmt.Sprintf("Rejecting direct upload %q for file %q to bin %q due to wrong SHA256 checksum (got %s and calculated %s)", upload.Id, upload.Filename, bin.Id, upload.SHA256, sha256ChecksumString), "SHA256 checksum did not match", 1527, http.StatusBadRequest)
+		return
+	}
+
+	slog.Debug("verified direct upload", "upload", upload.Id, "filename", upload.Filename, "bin", bin.Id, "bytes", nBytes, "assemble_seconds", t1.Sub(t0).Seconds(), "checksum_seconds", time.Since(t1).Seconds())
+
+	file, ok := h.storeFile(w, r, &bin, receivedFile{
+		staged:   upload.ObjectKey,
+		filename: upload.Filename,
+		bytes:    nBytes,
+		md5:      base64.StdEncoding.EncodeToString(md5Checksum.Sum(nil)),
+		sha256:   sha256ChecksumString,
+	}, t0)
+	if !ok {
+		return
+	}
+
+	if err := h.s3.RemoveKey(upload.ObjectKey); err != nil {
+		slog.Warn("unable to remove staged object", "upload", upload.Id, "key", upload.ObjectKey, "error", err)
+	}
+	if err := h.dao.DirectUpload().Delete(&upload); err != nil {
+		slog.Error("unable to delete finalized direct upload", "upload", upload.Id, "error", err)
+	}
+
+	type Data struct {
+		Bin  ds.Bin  `json:"bin"`
+		File ds.File `json:"file"`
+	}
+	var data Data
+	data.Bin = bin
+	data.File = file
+	h.writeDirectUploadResponse(w, r, data, http.StatusCreated)
+}
+
+// discardDirectUpload removes the parts or the staged object of a direct
+// upload from S3, and the upload from the database.
+func (h *HTTP) discardDirectUpload(upload ds.DirectUpload) bool {
+	// The multipart upload no longer exists if the parts were assembled, so
+	// an error here is expected in that case.
+	_ = h.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+	if err := h.s3.RemoveKey(upload.ObjectKey); err != nil {
+		return false
+	}
+	if err := h.dao.DirectUpload().Delete(&upload); err != nil {
+		slog.Error("unable to delete direct upload", "upload", upload.Id, "error", err)
+		return false
+	}
+	return true
+}
+
+func (h *HTTP) directDelete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := h.directLookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.acquireUpload(upload.Id) {
+		h.Error(w, r, "", "The upload is being finalized in another request", 1528, http.StatusLocked)
+		return
+	}
+	defer h.releaseUpload(upload.Id)
+
+	if !h.discardDirectUpload(upload) {
+		h.Error(w, r, fmt.Sprintf("Unable to discard direct upload %q", upload.Id), "Storage error", 1529, http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("aborted direct upload", "upload", upload.Id, "filename", upload.Filename, "bin", upload.Bin, "bytes", upload.Bytes)
+	w.WriteHeader(http.StatusNoContent)
+}
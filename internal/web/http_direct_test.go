@@ -0,0 +1,372 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/s3"
+)
+
+func directRequest(method, url string, body interface{}) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	content, err := io.ReadAll(resp.Body)
+	return resp, content, err
+}
+
+func partChecksum(content []byte) string {
+	checksum := sha256.Sum256(content)
+	return base64.StdEncoding.EncodeToString(checksum[:])
+}
+
+func TestDirectUpload(t *testing.T) {
+	bin := "directupload01"
+	content := []byte("content uploaded directly to storage")
+
+	resp, body, err := directRequest("POST", "http://localhost:8080/direct/"+bin, map[string]interface{}{
+		"filename": "direct.txt",
+		"bytes":    len(content),
+		"sha256":   fmt.Sprintf("%x", sha256.Sum256(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d when creating upload, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	var upload ds.DirectUpload
+	if err := json.Unmarshal(body, &upload); err != nil {
+		t.Fatal(err)
+	}
+	if upload.Parts != 1 {
+		t.Fatalf("Expected 1 part, got %d", upload.Parts)
+	}
+	uploadURL := "http://localhost:8080/direct/" + bin + "/" + upload.Id
+	if location := resp.Header.Get("Location"); !strings.HasSuffix(location, "/direct/"+bin+"/"+upload.Id) {
+		t.Errorf("Unexpected upload location %q", location)
+	}
+
+	parts := []directUploadPart{{Number: 1, ChecksumSHA256: partChecksum(content)}}
+
+	// Finalizing before the parts are uploaded is rejected
+	resp, body, err = directRequest("POST", uploadURL, map[string]interface{}{"parts": parts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d when finalizing without parts, got %d: %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	resp, body, err = directRequest("POST", uploadURL+"/parts", map[string]interface{}{"parts": parts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d when presigning parts, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	var presigned struct {
+		Parts []directUploadPart `json:"parts"`
+	}
+	if err := json.Unmarshal(body, &presigned); err != nil {
+		t.Fatal(err)
+	}
+	if len(presigned.Parts) != 1 || presigned.Parts[0].URL == "" {
+		t.Fatalf("Expected a presigned URL, got %s", body)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, presigned.Parts[0].URL, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = partResp.Body.Close()
+	if partResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d when uploading part to storage, got %d", http.StatusOK, partResp.StatusCode)
+	}
+
+	// The file is not available until the upload is finalized
+	statusCode, _, err := httpRequest(TestCase{Method: "GET", Bin: bin, Filename: "direct.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d before finalizing, got %d", http.StatusNotFound, statusCode)
+	}
+
+	// A wrong part checksum is rejected
+	wrong := []directUploadPart{{Number: 1, ChecksumSHA256: partChecksum([]byte("other content"))}}
+	resp, body, err = directRequest("POST", uploadURL, map[string]interface{}{"parts": wrong})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for wrong checksum, got %d: %s", http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	resp, body, err = directRequest("POST", uploadURL, map[string]interface{}{"parts": parts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d when finalizing, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	// The upload is removed once it is finalized
+	resp, _, err = directRequest("GET", uploadURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for finalized upload, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	tcs := []TestCase{
+		{
+			Description:     "Download the file uploaded with a direct upload",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        "direct.txt",
+			StatusCode:      200,
+			DownloadContent: string(content),
+		},
+	}
+	runTests(tcs, t)
+}
+
+func TestDirectUploadAbort(t *testing.T) {
+	bin := "directupload02"
+	resp, body, err := directRequest("POST", "http://localhost:8080/direct/"+bin, map[string]interface{}{
+		"filename": "aborted.txt",
+		"bytes":    10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d when creating upload, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	var upload ds.DirectUpload
+	if err := json.Unmarshal(body, &upload); err != nil {
+		t.Fatal(err)
+	}
+	uploadURL := "http://localhost:8080/direct/" + bin + "/" + upload.Id
+
+	resp, _, err = directRequest("DELETE", uploadURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, _, err = directRequest("POST", uploadURL+"/parts", map[string]interface{}{
+		"parts": []directUploadPart{{Number: 1, ChecksumSHA256: partChecksum([]byte("0123456789"))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for aborted upload, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestDirectUploadInvalidRequests(t *testing.T) {
+	tcs := []struct {
+		name       string
+		body       interface{}
+		statusCode int
+	}{
+		{
+			name:       "missing body",
+			body:       nil,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown field",
+			body:       map[string]interface{}{"filename": "a.txt", "bytes": 10, "unknown": true},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "empty upload",
+			body:       map[string]interface{}{"filename": "a.txt", "bytes": 0},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "missing filename",
+			body:       map[string]interface{}{"bytes": 10},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid checksum",
+			body:       map[string]interface{}{"filename": "a.txt", "bytes": 10, "sha256": "abc"},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "illegal extension",
+			body:       map[string]interface{}{"filename": "a.illegal1", "bytes": 10},
+			statusCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body, err := directRequest("POST", "http://localhost:8080/direct/directinvalid", tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestDirectUploadLayout(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name         string
+		bytes        uint64
+		partSize     uint64
+		wantPartSize uint64
+		wantParts    int
+	}{
+		{
+			name:         "single part",
+			bytes:        10,
+			partSize:     64 * mb,
+			wantPartSize: 64 * mb,
+			wantParts:    1,
+		},
+		{
+			name:         "exact multiple of the part size",
+			bytes:        128 * mb,
+			partSize:     64 * mb,
+			wantPartSize: 64 * mb,
+			wantParts:    2,
+		},
+		{
+			name:         "short last part",
+			bytes:        130 * mb,
+			partSize:     64 * mb,
+			wantPartSize: 64 * mb,
+			wantParts:    3,
+		},
+		{
+			name:         "part size below the storage minimum",
+			bytes:        12 * mb,
+			partSize:     1 * mb,
+			wantPartSize: 5 * mb,
+			wantParts:    3,
+		},
+		{
+			name:         "too many parts",
+			bytes:        100000 * mb,
+			partSize:     5 * mb,
+			wantPartSize: 10 * mb,
+			wantParts:    10000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partSize, parts := directUploadLayout(tt.bytes, tt.partSize)
+			if partSize != tt.wantPartSize {
+				t.Errorf("directUploadLayout() part size = %d, want %d", partSize, tt.wantPartSize)
+			}
+			if parts != tt.wantParts {
+				t.Errorf("directUploadLayout() parts = %d, want %d", parts, tt.wantParts)
+			}
+		})
+	}
+}
+
+func TestVerifyDirectUploadParts(t *testing.T) {
+	upload := ds.DirectUpload{Bytes: 25, PartSize: 10, Parts: 3}
+	declared := []directUploadPart{
+		{Number: 1, ChecksumSHA256: "a"},
+		{Number: 2, ChecksumSHA256: "b"},
+		{Number: 3, ChecksumSHA256: "c"},
+	}
+	received := []s3.Part{
+		{Number: 1, Size: 10, ChecksumSHA256: "a"},
+		{Number: 2, Size: 10, ChecksumSHA256: "b"},
+		{Number: 3, Size: 5, ChecksumSHA256: "c"},
+	}
+
+	tests := []struct {
+		name     string
+		received []s3.Part
+		declared []directUploadPart
+		wantErr  bool
+	}{
+		{
+			name:     "all parts match",
+			received: received,
+			declared: declared,
+		},
+		{
+			name:     "part missing in storage",
+			received: []s3.Part{received[0], received[2]},
+			declared: declared,
+			wantErr:  true,
+		},
+		{
+			name:     "checksum missing in request",
+			received: received,
+			declared: declared[:2],
+			wantErr:  true,
+		},
+		{
+			name:     "checksum mismatch",
+			received: received,
+			declared: []directUploadPart{declared[0], {Number: 2, ChecksumSHA256: "x"}, declared[2]},
+			wantErr:  true,
+		},
+		{
+			name:     "unexpected part size",
+			received: []s3.Part{received[0], {Number: 2, Size: 9, ChecksumSHA256: "b"}, received[2]},
+			declared: declared,
+			wantErr:  true,
+		},
+		{
+			name:     "part numbers out of sequence",
+			received: []s3.Part{received[0], received[2], {Number: 4, Size: 5, ChecksumSHA256: "c"}},
+			declared: declared,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDirectUploadParts(upload, tt.received, tt.declared)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyDirectUploadParts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/s3"
+)
+
+// Download modes. Files are either downloaded directly from S3 using a
+// presigned URL, or streamed through filebin for deployments where the
+// clients are not able to reach the S3 endpoint.
+const (
+	downloadRedirect = "redirect"
+	downloadProxy    = "proxy"
+)
+
+// proxyDownloads reports whether files are streamed through filebin
+func (h *HTTP) proxyDownloads() bool {
+	return h.config.DownloadMode == downloadProxy
+}
+
+// Range requests continue a transfer of a file to the client for this long
+// after the last request
+const transferWindow = time.Hour
+
+// A transfer is counted as a download once the client has requested this
+// percentage of the file, so that probes for the first bytes of a file are
+// not counted, and once more for each time the size of the file has been
+// requested after that
+const transferShare = 10
+
+// transfer is a request for the bytes of a file that are added to the
+// transfer of the file to the client before the bytes are sent
+type transfer struct {
+	ds.Transfer
+	requested uint64
+
+	// Downloads that the request was counted as
+	added uint64
+}
+
+// transferDownloads returns the number of downloads that a transfer of the
+// given number of bytes of a file amounts to
+func transferDownloads(bytes uint64, size uint64) uint64 {
+	threshold := (size*transferShare + 99) / 100
+	if bytes < threshold || size == 0 {
+		return 0
+	}
+	return (bytes-threshold)/size + 1
+}
+
+// requestedBytes returns the number of bytes of the file that the request
+// asks for. Requests without a valid range, and requests where the range is
+// ignored since the file has changed, ask for the whole file.
+func requestedBytes(r *http.Request, file ds.File) uint64 {
+	size := file.Bytes
+	ranges, found := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !found {
+		return size
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != "\""+file.SHA256+"\"" {
+		return size
+	}
+
+	var total uint64
+	for _, spec := range strings.Split(ranges, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return size
+		}
+		if first == "" {
+			// The suffix of the file
+			n, err := strconv.ParseUint(last, 10, 64)
+			if err != nil {
+				return size
+			}
+			total += min(n, size)
+			continue
+		}
+		start, err := strconv.ParseUint(first, 10, 64)
+		if err != nil {
+			return size
+		}
+		if start >= size {
+			// Not satisfiable
+			continue
+		}
+		end := size - 1
+		if last != "" {
+			n, err := strconv.ParseUint(last, 10, 64)
+			if err != nil || n < start {
+				return size
+			}
+			end = min(n, end)
+		}
+		total += end - start + 1
+	}
+	return min(total, size)
+}
+
+// countsAsDownload reports whether the request for a file is counted as a
+// download. Presigned URLs give access to the whole file whatever range is
+// requested, so every request counts in the redirect mode. Files that are
+// streamed through filebin may be fetched in parts, such as by a video
+// player seeking or a download manager, and the parts that a client
+// requests are added to its transfer of the file. The request counts if it
+// makes the transfer amount to another download. The transfer is returned
+// so that it can be corrected by the bytes that are sent.
+func (h *HTTP) countsAsDownload(w http.ResponseWriter, r *http.Request, file ds.File) (t *transfer, counted bool, ok bool) {
+	if r.Method == http.MethodHead {
+		return nil, false, true
+	}
+	if !h.proxyDownloads() || file.Bytes == 0 {
+		return nil, true, true
+	}
+
+	ip, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	t = &transfer{
+		Transfer: ds.Transfer{
+			IP:     ip,
+			FileId: file.Id,
+			SHA256: file.SHA256,
+		},
+		requested: requestedBytes(r, file),
+	}
+	downloads := func(bytes uint64) uint64 {
+		return transferDownloads(bytes, file.Bytes)
+	}
+	added, err := h.dao.Transfer().Reserve(&t.Transfer, t.requested, time.Now().Add(transferWindow), downloads)
+	if err != nil {
+		return nil, false, h.transferFailed(w, r, file, err)
+	}
+	t.added = added
+	return t, added > 0, true
+}
+
+// transferFailed writes the error response to the client if the transfer
+// of a file with a download limit can not be recorded. Other files are
+// sent without being counted.
+func (h *HTTP) transferFailed(w http.ResponseWriter, r *http.Request, file ds.File, err error) bool {
+	if h.downloadLimit(file) == 0 {
+		slog.Error("unable to record transfer", "filename", file.Filename, "bin", file.Bin, "error", err)
+		return true
+	}
+	h.Error(w, r, fmt.Sprintf("Unable to record transfer of filename %q in bin %q: %s", file.Filename, file.Bin, err.Error()), "Database error", 3005, http.StatusInternalServerError)
+	return false
+}
+
+// finishTransfer corrects the transfer by the bytes of the file that were
+// sent to the client, which are less than requested if the client closed
+// the connection early or the range was not satisfiable
+func (h *HTTP) finishTransfer(t *transfer, sent uint64) {
+	if t == nil || sent == t.requested {
+		return
+	}
+	if err := h.dao.Transfer().Adjust(&t.Transfer, int64(sent)-int64(t.requested), 0); err != nil {
+		slog.Error("unable to update transfer", "file_id", t.FileId, "error", err)
+	}
+}
+
+// cancelTransfer removes the request from the transfer when the file is not
+// sent, so that the next request is counted as if it had not been made
+func (h *HTTP) cancelTransfer(t *transfer) {
+	if t == nil {
+		return
+	}
+	if err := h.dao.Transfer().Adjust(&t.Transfer, -int64(t.requested), -int64(t.added)); err != nil {
+		slog.Error("unable to update transfer", "file_id", t.FileId, "error", err)
+	}
+}
+
+// inlinePreview reports whether the file is requested to be shown inline by
+// the preview page. Only PDF files are shown inline on request, as HTML
+// files are always downloaded to reduce phishing.
+func inlinePreview(r *http.Request, contentType string) bool {
+	_, inline := r.URL.Query()["inline"]
+	return inline && strings.HasPrefix(contentType, "application/pdf")
+}
+
+// objectReader reads an object from S3 as an io.ReadSeeker, which allows
+// the object to be served with http.ServeContent. Each read after a seek
+// fetches the object from the new offset to the end with a ranged request,
+// so only the ranges that are read are transferred from S3.
+type objectReader struct {
+	s3     *s3.S3AO
+	sha256 string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+
+	// Bytes read from S3
+	read uint64
+}
+
+func (o *objectReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.s3.GetObject(o.sha256, o.offset, o.size-1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.read += uint64(n)
+	return n, err
+}
+
+func (o *objectReader) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = o.offset + offset
+	case io.SeekEnd:
+		position = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if position < 0 {
+		return 0, errors.New("negative position")
+	}
+	if position != o.offset {
+		o.Close()
+		o.offset = position
+	}
+	return position, nil
+}
+
+func (o *objectReader) Close() {
+	if o.body != nil {
+		_ = o.body.Close()
+		o.body = nil
+	}
+}
+
+// countingWriter counts the bytes written to the response body
+type countingWriter struct {
+	http.ResponseWriter
+	written uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.written += uint64(n)
+	return n, err
+}
+
+// proxyFile streams the file from S3 to the client. Range, If-Range and
+// the other conditional request headers are handled by http.ServeContent,
+// with the content checksum as the entity tag. The transfer, if any, is
+// corrected by the bytes that were sent.
+func (h *HTTP) proxyFile(w http.ResponseWriter, r *http.Request, file ds.File, contentType string, t *transfer, t0 time.Time) {
+	w.Header().Set("Content-Type", contentType)
+	if inlinePreview(r, contentType) {
+		// The file is served from the same origin as filebin, so it is
+		// given an origin of its own
+		w.Header().Set("Content-Disposition", s3.InlineDisposition(file.Filename))
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts")
+	} else {
+		w.Header().Set("Content-Disposition", s3.ContentDisposition(file.Filename, contentType))
+	}
+	w.Header().Set("ETag", "\""+file.SHA256+"\"")
+
+	content := &objectReader{
+		s3:     h.s3,
+		sha256: file.SHA256,
+		size:   int64(file.Bytes),
+	}
+	defer content.Close()
+
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, file.Filename, file.UpdatedAt, content)
+	h.finishTransfer(t, content.read)
+
+	slog.Info("proxied download", "filename", file.Filename, "bytes", file.Bytes, "bytes_sent", cw.written, "range", r.Header.Get("Range"), "sha256", file.SHA256, "bin", file.Bin, "duration_seconds", time.Since(t0).Seconds(), "downloads", file.Downloads)
+
+	h.metrics.IncrBytesStorageToFilebin(content.read)
+	h.metrics.IncrBytesFilebinToClient(cw.written)
+}
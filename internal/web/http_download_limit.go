@@ -0,0 +1,115 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/webhook"
+)
+
+const (
+	// Uploaders limit the number of downloads of a file with this request
+	// header, or with the download_limit form field in multipart/form-data
+	// uploads. The file is deleted after its last download.
+	downloadLimitHeader = "Download-Limit"
+	downloadLimitField  = "download_limit"
+
+	// The download limit of the files in a new bin, for files that are
+	// uploaded without a download limit of their own
+	binDownloadLimitHeader = "Bin-Download-Limit"
+)
+
+// parseDownloadLimit parses a requested download limit, which is a positive
+// number of downloads. An empty value means no limit.
+func parseDownloadLimit(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || limit == 0 {
+		return 0, fmt.Errorf("invalid download limit %q, it must be a positive number of downloads", s)
+	}
+	return limit, nil
+}
+
+// downloadLimit returns the number of times a file can be downloaded, which
+// is the lowest of the download limit of the file and the configured limit
+// per file. 0 means no limit.
+func (h *HTTP) downloadLimit(file ds.File) uint64 {
+	limit := file.DownloadLimit
+	if h.config.LimitFileDownloads > 0 && (limit == 0 || h.config.LimitFileDownloads < limit) {
+		limit = h.config.LimitFileDownloads
+	}
+	return limit
+}
+
+// downloadLimitReached returns true if a file can not be downloaded again
+func (h *HTTP) downloadLimitReached(file ds.File) bool {
+	limit := h.downloadLimit(file)
+	return limit > 0 && file.Downloads >= limit
+}
+
+// previewable returns true if the file can be previewed and shown as a
+// thumbnail. Files with a download limit of their own, such as files that
+// are deleted after their first download, are only available as downloads,
+// so that every copy of the content that is sent to a client is counted.
+func previewable(file ds.File) bool {
+	return file.DownloadLimit == 0
+}
+
+// errDownloadLimitReached is returned when a concurrent download used the
+// last download of a file
+var errDownloadLimitReached = errors.New("the download limit is reached")
+
+// countDownload counts a download of a file. Files with a download limit are
+// counted atomically, so that concurrent downloads can not exceed the limit,
+// and files are deleted with the last download that their own download limit
+// allows.
+func (h *HTTP) countDownload(bin ds.Bin, file *ds.File) error {
+	limit := h.downloadLimit(*file)
+	if limit == 0 {
+		return h.dao.File().RegisterDownload(file)
+	}
+
+	registered, err := h.dao.File().RegisterLimitedDownload(file, limit)
+	if err != nil {
+		return err
+	}
+	if !registered {
+		return errDownloadLimitReached
+	}
+	if file.IsDeleted() {
+		slog.Info("deleted file after its last download", "filename", file.Filename, "bin", file.Bin, "downloads", file.Downloads)
+		h.metrics.IncrFileDeleteCount()
+		h.webhooks.Enqueue(webhook.FileEvent(webhook.FileDeleted, bin, *file))
+	}
+	return nil
+}
+
+// registerDownload counts a download of a file before the file is sent to
+// the client. The error response is written to the client if the file can
+// not be downloaded.
+func (h *HTTP) registerDownload(w http.ResponseWriter, r *http.Request, bin ds.Bin, file *ds.File) bool {
+	err := h.countDownload(bin, file)
+	if errors.Is(err, errDownloadLimitReached) {
+		h.Error(w, r, "", "The file has been requested too many times.", 3001, http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		// Downloads of files without a download limit are not held
+		// back by the download counter
+		if h.downloadLimit(*file) == 0 {
+			slog.Error("unable to update file", "filename", file.Filename, "bin", file.Bin, "error", err)
+			return true
+		}
+		h.Error(w, r, fmt.Sprintf("Unable to register download of filename %q in bin %q: %s", file.Filename, file.Bin, err.Error()), "Database error", 3002, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,422 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestParseDownloadLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+		valid    bool
+	}{
+		{"", 0, true},
+		{"1", 1, true},
+		{" 25 ", 25, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"once", 0, false},
+		{"1.5", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseDownloadLimit(test.input)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.input, err)
+			continue
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected an error parsing %q, got %d", test.input, got)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("Expected %d from %q, got %d", test.expected, test.input, got)
+		}
+	}
+}
+
+func TestDownloadLimit(t *testing.T) {
+	tests := []struct {
+		configured    uint64
+		downloadLimit uint64
+		expected      uint64
+	}{
+		{0, 0, 0},
+		{0, 3, 3},
+		{10, 0, 10},
+		{10, 3, 3},
+		{2, 3, 2},
+	}
+	for _, test := range tests {
+		h := &HTTP{config: &ds.Config{LimitFileDownloads: test.configured}}
+		file := ds.File{DownloadLimit: test.downloadLimit}
+		if got := h.downloadLimit(file); got != test.expected {
+			t.Errorf("Expected limit %d with %d configured and %d on the file, got %d", test.expected, test.configured, test.downloadLimit, got)
+		}
+	}
+}
+
+func downloadStatus(h *HTTP, path string) int {
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	return rr.Code
+}
+
+func TestBurnAfterReading(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/burnbin/secret.txt", "secret content")
+	req.Header.Set("Download-Limit", "1")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// HEAD requests do not count as downloads
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, "/burnbin/secret.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/burnbin/secret.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != "secret content" {
+		t.Errorf("Unexpected content %q", rr.Body.String())
+	}
+
+	// The file is gone after the first download
+	if code := downloadStatus(h, "/burnbin/secret.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d after the first download, got %d", http.StatusNotFound, code)
+	}
+	file, found, err := h.dao.File().GetByName("burnbin", "secret.txt")
+	if err != nil || !found {
+		t.Fatalf("Unable to get file: %v", err)
+	}
+	if !file.IsDeleted() {
+		t.Error("Expected the file to be deleted")
+	}
+
+	// Uploading the file again starts the count over
+	req = uploadRequest("/burnbin/secret.txt", "secret content")
+	req.Header.Set("Download-Limit", "1")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code := downloadStatus(h, "/burnbin/secret.txt"); code != http.StatusOK {
+		t.Errorf("Expected status %d after the upload, got %d", http.StatusOK, code)
+	}
+}
+
+func TestBinDownloadLimit(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/limitbin/a.txt", "content a")
+	req.Header.Set("Bin-Download-Limit", "2")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Files with a limit of their own do not use the limit of the bin
+	req = uploadRequest("/limitbin/b.txt", "content b")
+	req.Header.Set("Download-Limit", "3")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	for i := 1; i <= 3; i++ {
+		expected := http.StatusOK
+		if i > 2 {
+			expected = http.StatusNotFound
+		}
+		if code := downloadStatus(h, "/limitbin/a.txt"); code != expected {
+			t.Errorf("Download %d of a.txt: expected status %d, got %d", i, expected, code)
+		}
+		if code := downloadStatus(h, "/limitbin/b.txt"); code != http.StatusOK {
+			t.Errorf("Download %d of b.txt: expected status %d, got %d", i, http.StatusOK, code)
+		}
+	}
+	if code := downloadStatus(h, "/limitbin/b.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d after the last download of b.txt, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestConfiguredDownloadLimit(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitFileDownloads = 1
+
+	// The configured limit is lower than the limit of the file, so the
+	// file is kept when further downloads are rejected
+	req := uploadRequest("/configuredlimitbin/file.txt", "some content")
+	req.Header.Set("Download-Limit", "5")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code := downloadStatus(h, "/configuredlimitbin/file.txt"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := downloadStatus(h, "/configuredlimitbin/file.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+	file, found, err := h.dao.File().GetByName("configuredlimitbin", "file.txt")
+	if err != nil || !found {
+		t.Fatalf("Unable to get file: %v", err)
+	}
+	if file.IsDeleted() {
+		t.Error("Expected the file to remain")
+	}
+}
+
+func TestRangedDownloadLimit(t *testing.T) {
+	rangedStatus := func(h *HTTP, path string, ranges string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Range", ranges)
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	type request struct {
+		ranges string
+		status int
+	}
+	tests := []struct {
+		mode     string
+		bin      string
+		requests []request
+	}{
+		// Every request uses a download in the redirect mode
+		{downloadRedirect, "rangedlimitbin1", []request{{"bytes=1-", http.StatusFound}, {"bytes=1-", http.StatusForbidden}}},
+		{downloadRedirect, "rangedlimitbin2", []request{{"bytes=0-0", http.StatusFound}, {"bytes=1-", http.StatusForbidden}}},
+		// Proxied requests use a download once the client has requested
+		// a share of the file, so probes for the first byte do not
+		{downloadProxy, "rangedlimitbin3", []request{{"bytes=1-", http.StatusPartialContent}, {"bytes=1-", http.StatusForbidden}}},
+		{downloadProxy, "rangedlimitbin4", []request{{"bytes=0-0", http.StatusPartialContent}, {"bytes=1-", http.StatusPartialContent}, {"bytes=0-0", http.StatusForbidden}}},
+	}
+	for _, test := range tests {
+		t.Run(test.bin, func(t *testing.T) {
+			h := setupProxyDownloadHandler(t)
+			h.config.DownloadMode = test.mode
+			h.config.LimitFileDownloads = 1
+
+			path := "/" + test.bin + "/file.txt"
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, uploadRequest(path, "some content"))
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+			}
+
+			for i, req := range test.requests {
+				if code := rangedStatus(h, path, req.ranges); code != req.status {
+					t.Errorf("Expected status %d for request %d with %s, got %d", req.status, i+1, req.ranges, code)
+				}
+			}
+		})
+	}
+}
+
+func TestBurnAfterReadingProbe(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	content := strings.Repeat("secret ", 100)
+
+	req := uploadRequest("/burnprobebin/secret.txt", content)
+	req.Header.Set("Download-Limit", "1")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Link previews that probe the first byte do not use the download
+	for i := 0; i < 3; i++ {
+		req = httptest.NewRequest(http.MethodGet, "/burnprobebin/secret.txt", nil)
+		req.Header.Set("Range", "bytes=0-0")
+		rr = httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("Expected status %d for the probe, got %d", http.StatusPartialContent, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/burnprobebin/secret.txt", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != content {
+		t.Fatalf("Expected the file after the probes, got status %d", rr.Code)
+	}
+	if code := downloadStatus(h, "/burnprobebin/secret.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d after the download, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestConcurrentBurnAfterReading(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/concurrentburnbin/secret.txt", "secret content")
+	req.Header.Set("Download-Limit", "1")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	downloaded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if downloadStatus(h, "/concurrentburnbin/secret.txt") == http.StatusOK {
+				mu.Lock()
+				downloaded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if downloaded != 1 {
+		t.Errorf("Expected the file to be downloaded once, got %d downloads", downloaded)
+	}
+}
+
+func TestInvalidDownloadLimit(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	tests := []struct {
+		header string
+		path   string
+	}{
+		{"Download-Limit", "/invalidlimitbin/a.txt"},
+		{"Bin-Download-Limit", "/invalidlimitbin2/a.txt"},
+	}
+	for _, test := range tests {
+		req := uploadRequest(test.path, "some content")
+		req.Header.Set(test.header, "0")
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d with %s, got %d. Body: %s", http.StatusBadRequest, test.header, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestPreviewDownloadLimit(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitFileDownloads = 1
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/previewlimitbin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Previews that show more of the text count as downloads as well
+	if code := downloadStatus(h, "/view/previewlimitbin/file.txt?bytes=1048576"); code != http.StatusOK {
+		t.Errorf("Expected status %d for the first preview, got %d", http.StatusOK, code)
+	}
+	if code := downloadStatus(h, "/view/previewlimitbin/file.txt?bytes=1048576"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d for the second preview, got %d", http.StatusForbidden, code)
+	}
+	if code := downloadStatus(h, "/previewlimitbin/file.txt"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a download after the preview, got %d", http.StatusForbidden, code)
+	}
+}
+
+func TestBurnAfterReadingPreview(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	req := uploadRequest("/burnpreviewbin/secret.txt", "secret content")
+	req.Header.Set("Download-Limit", "1")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// The file is not previewed, so the preview page does not use the
+	// only download of the file
+	for _, path := range []string{"/view/burnpreviewbin/secret.txt", "/view/burnpreviewbin/secret.txt?bytes=1048576"} {
+		rr = httptest.NewRecorder()
+		h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d. Body: %s", http.StatusOK, path, rr.Code, rr.Body.String())
+		}
+		if strings.Contains(rr.Body.String(), "secret content") {
+			t.Errorf("Expected no preview of the content for %s", path)
+		}
+	}
+	if code := downloadStatus(h, "/burnpreviewbin/secret.txt"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := downloadStatus(h, "/burnpreviewbin/secret.txt"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d after the first download, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestThumbnailDownloadLimit(t *testing.T) {
+	h := setupJobHandler(t)
+	h.config.DownloadMode = downloadProxy
+	content := testImage(t)
+
+	req := uploadRequest("/thumbnaillimitbin/burn.png", content)
+	req.Header.Set("Download-Limit", "1")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/thumbnaillimitbin/image.png", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	for h.processor.RunOnce() {
+	}
+
+	// Files with a download limit of their own have no thumbnails
+	for _, size := range ds.ThumbnailSizes {
+		path := fmt.Sprintf("/thumbnail/thumbnaillimitbin/%d/burn.png", size)
+		if code := downloadStatus(h, path); code != http.StatusNotFound {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusNotFound, path, code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/thumbnaillimitbin", nil))
+	if strings.Contains(rr.Body.String(), "/thumbnail/thumbnaillimitbin/256/burn.png") {
+		t.Error("Expected the bin page not to link to the thumbnail of the limited file")
+	}
+	if code := downloadStatus(h, "/thumbnaillimitbin/burn.png"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// Thumbnails of other files are rejected when the configured limit
+	// is reached
+	h.config.LimitFileDownloads = 1
+	if code := downloadStatus(h, "/thumbnail/thumbnaillimitbin/1280/image.png"); code != http.StatusOK {
+		t.Errorf("Expected status %d before the download, got %d", http.StatusOK, code)
+	}
+	if code := downloadStatus(h, "/thumbnaillimitbin/image.png"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := downloadStatus(h, "/thumbnail/thumbnaillimitbin/1280/image.png"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d after the download, got %d", http.StatusForbidden, code)
+	}
+}
@@ -0,0 +1,364 @@
+package web
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/workspace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func setupProxyDownloadHandler(t *testing.T) *HTTP {
+	t.Helper()
+
+	dao, s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tearDown(dao) })
+
+	geodb, err := geoip.Init("../../mmdb/GeoLite2-ASN.mmdb", "../../mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatalf("Unable to load geoip database: %s", err)
+	}
+
+	wm, err := workspace.NewManager(os.TempDir(), 4.0)
+	if err != nil {
+		t.Fatalf("Unable to initialize workspace manager: %s", err)
+	}
+
+	c := ds.Config{
+		Expiration:   testExpiredAt,
+		DownloadMode: downloadProxy,
+	}
+
+	metricsRegistry := prometheus.NewRegistry()
+	metrics := ds.NewMetrics("test", metricsRegistry)
+
+	h := &HTTP{
+		staticBox:       &staticBox,
+		templateBox:     &templateBox,
+		dao:             &dao,
+		s3:              &s3ao,
+		geodb:           &geodb,
+		workspace:       wm,
+		config:          &c,
+		metrics:         metrics,
+		metricsRegistry: metricsRegistry,
+	}
+	if err := h.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { h.Stop() })
+
+	return h
+}
+
+func TestProxyDownload(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/proxybin/file.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	download := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/proxybin/file.txt", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The whole file is streamed through filebin
+	rr = download(http.MethodGet, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != content {
+		t.Errorf("Expected the content of the file, got %q", rr.Body.String())
+	}
+	if got := rr.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Expected Accept-Ranges bytes, got %q", got)
+	}
+	if got := rr.Header().Get("Content-Length"); got != "36" {
+		t.Errorf("Expected Content-Length 36, got %q", got)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `inline; filename="file.txt"` {
+		t.Errorf("Unexpected Content-Disposition %q", got)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag != "\""+contentSHA256(content)+"\"" {
+		t.Errorf("Expected the checksum as ETag, got %q", etag)
+	}
+
+	// Single range
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=10-15"})
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusPartialContent, rr.Code)
+	}
+	if rr.Body.String() != "abcdef" {
+		t.Errorf("Expected the range of the file, got %q", rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Range"); got != "bytes 10-15/36" {
+		t.Errorf("Expected Content-Range bytes 10-15/36, got %q", got)
+	}
+	if got := rr.Header().Get("Content-Length"); got != "6" {
+		t.Errorf("Expected Content-Length 6, got %q", got)
+	}
+
+	// Suffix range
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=-4"})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "wxyz" {
+		t.Errorf("Expected the last 4 bytes, got status %d and %q", rr.Code, rr.Body.String())
+	}
+
+	// Multiple ranges
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=0-1,30-31"})
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusPartialContent, rr.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("Expected multipart/byteranges, got %q", mediaType)
+	}
+	var parts []string
+	mr := multipart.NewReader(rr.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(body))
+	}
+	if strings.Join(parts, ",") != "01,uv" {
+		t.Errorf("Expected the parts 01 and uv, got %v", parts)
+	}
+
+	// Unsatisfiable range
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=100-200"})
+	if rr.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	}
+
+	// The range is served if the entity tag matches, and the whole file if
+	// the file has changed
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=0-3", "If-Range": etag})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "0123" {
+		t.Errorf("Expected the range with a matching If-Range, got status %d and %q", rr.Code, rr.Body.String())
+	}
+	rr = download(http.MethodGet, map[string]string{"Range": "bytes=0-3", "If-Range": `"outdated"`})
+	if rr.Code != http.StatusOK || rr.Body.String() != content {
+		t.Errorf("Expected the whole file with an outdated If-Range, got status %d and %q", rr.Code, rr.Body.String())
+	}
+
+	// HEAD returns the headers only
+	rr = download(http.MethodHead, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected no body for HEAD, got %q", rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Length"); got != "36" {
+		t.Errorf("Expected Content-Length 36, got %q", got)
+	}
+
+	// The requests are added to the transfer of the file to the client,
+	// and counted each time the transfer amounts to another download. The
+	// first request is counted, the single range makes the transfer exceed
+	// the size of the file and is counted again, and the whole file that
+	// is sent for the outdated If-Range is counted a third time. The small
+	// ranges in between and the HEAD request are not counted.
+	file, found, err := h.dao.File().GetByName("proxybin", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("Expected the file to exist")
+	}
+	if file.Downloads != 3 {
+		t.Errorf("Expected 3 downloads, got %d", file.Downloads)
+	}
+}
+
+func TestCountsAsDownload(t *testing.T) {
+	file := ds.File{Id: 1, SHA256: "abc", Bytes: 1000}
+	tests := []struct {
+		mode     string
+		method   string
+		ranges   string
+		expected bool
+	}{
+		{downloadRedirect, http.MethodGet, "", true},
+		{downloadRedirect, http.MethodGet, "bytes=0-", true},
+		{downloadRedirect, http.MethodGet, "bytes=0-0", true},
+		{downloadRedirect, http.MethodGet, "bytes=100-199", true},
+		{downloadRedirect, http.MethodGet, "bytes=-100", true},
+		{downloadRedirect, http.MethodHead, "", false},
+		{downloadProxy, http.MethodHead, "", false},
+	}
+	for _, test := range tests {
+		h := &HTTP{config: &ds.Config{DownloadMode: test.mode}}
+		req := httptest.NewRequest(test.method, "/bin/file.txt", nil)
+		if test.ranges != "" {
+			req.Header.Set("Range", test.ranges)
+		}
+		_, got, ok := h.countsAsDownload(httptest.NewRecorder(), req, file)
+		if !ok || got != test.expected {
+			t.Errorf("Expected %v for %s with range %q in %s mode, got %v", test.expected, test.method, test.ranges, test.mode, got)
+		}
+	}
+}
+
+func TestRequestedBytes(t *testing.T) {
+	file := ds.File{Id: 1, SHA256: "abc", Bytes: 1000}
+	tests := []struct {
+		ranges   string
+		ifRange  string
+		expected uint64
+	}{
+		{"", "", 1000},
+		{"bytes=0-", "", 1000},
+		{"bytes=0-0", "", 1},
+		{"bytes=100-199", "", 100},
+		{"bytes=900-2000", "", 100},
+		{"bytes=-100", "", 100},
+		{"bytes=-2000", "", 1000},
+		{"bytes=0-1, 500-", "", 502},
+		{"bytes=0-,0-", "", 1000},
+		{"bytes=1000-", "", 0},
+		{"bytes=0-0", `"abc"`, 1},
+		{"bytes=0-0", `"outdated"`, 1000},
+		{"bytes=abc", "", 1000},
+		{"bytes=5-1", "", 1000},
+		{"items=0-0", "", 1000},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/bin/file.txt", nil)
+		if test.ranges != "" {
+			req.Header.Set("Range", test.ranges)
+		}
+		if test.ifRange != "" {
+			req.Header.Set("If-Range", test.ifRange)
+		}
+		if got := requestedBytes(req, file); got != test.expected {
+			t.Errorf("Expected %d bytes for range %q with If-Range %q, got %d", test.expected, test.ranges, test.ifRange, got)
+		}
+	}
+}
+
+func TestTransferDownloads(t *testing.T) {
+	tests := []struct {
+		bytes    uint64
+		size     uint64
+		expected uint64
+	}{
+		{0, 1000, 0},
+		{1, 1000, 0},
+		{99, 1000, 0},
+		{100, 1000, 1},
+		{1000, 1000, 1},
+		{1099, 1000, 1},
+		{1100, 1000, 2},
+		{2100, 1000, 3},
+		{1, 5, 1},
+		{0, 0, 0},
+	}
+	for _, test := range tests {
+		if got := transferDownloads(test.bytes, test.size); got != test.expected {
+			t.Errorf("Expected %d downloads for %d bytes of %d, got %d", test.expected, test.bytes, test.size, got)
+		}
+	}
+}
+
+func TestTransferAcrossInstances(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	content := strings.Repeat("a", 1000)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/transferbin/video.mp4", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Another instance that shares the database
+	other := &HTTP{
+		staticBox:       h.staticBox,
+		templateBox:     h.templateBox,
+		dao:             h.dao,
+		s3:              h.s3,
+		geodb:           h.geodb,
+		workspace:       h.workspace,
+		config:          h.config,
+		metrics:         h.metrics,
+		metricsRegistry: prometheus.NewRegistry(),
+	}
+	if err := other.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { other.Stop() })
+
+	ranged := func(h *HTTP, remoteAddr string, ranges string) {
+		req := httptest.NewRequest(http.MethodGet, "/transferbin/video.mp4", nil)
+		req.Header.Set("Range", ranges)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPartialContent {
+			t.Fatalf("Expected status %d for %s, got %d", http.StatusPartialContent, ranges, rr.Code)
+		}
+	}
+	downloads := func() uint64 {
+		file, found, err := h.dao.File().GetByName("transferbin", "video.mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatal("Expected the file to exist")
+		}
+		return file.Downloads
+	}
+
+	// A probe for the first byte is not counted
+	ranged(h, "192.0.2.1:1234", "bytes=0-0")
+	if got := downloads(); got != 0 {
+		t.Errorf("Expected the probe not to be counted, got %d downloads", got)
+	}
+
+	// The parts of the file that a client fetches from any instance add up
+	// to a single download
+	ranged(h, "192.0.2.1:1234", "bytes=1-499")
+	ranged(other, "192.0.2.1:1234", "bytes=500-999")
+	if got := downloads(); got != 1 {
+		t.Errorf("Expected 1 download, got %d", got)
+	}
+
+	// The transfer is limited to the client
+	ranged(other, "198.51.100.1:1234", "bytes=500-999")
+	if got := downloads(); got != 2 {
+		t.Errorf("Expected 2 downloads, got %d", got)
+	}
+}
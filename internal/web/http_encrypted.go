@@ -0,0 +1,95 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// Files in encrypted bins are encrypted in the browser before they are
+// uploaded, and the key never leaves the client. The bin is created as
+// encrypted with the first of these request headers, and the second carries
+// the encrypted filename of each upload.
+const (
+	binEncryptedHeader      = "Bin-Encrypted"
+	encryptedFilenameHeader = "Encrypted-Filename"
+
+	// The server can not tell what the content of encrypted files is
+	encryptedContentType = "application/octet-stream"
+
+	// Name of the manifest that is added to archives of encrypted bins
+	encryptedManifestFilename = "filebin-manifest.json"
+)
+
+// encryptedUpload reports whether the upload request asks for an encrypted
+// bin
+func encryptedUpload(r *http.Request) bool {
+	v := r.Header.Get(binEncryptedHeader)
+	return v == "true" || v == "1" || v == "yes"
+}
+
+// encryptionMatches rejects uploads of plaintext files to encrypted bins,
+// and uploads of encrypted files to bins that are not encrypted. Mixing the
+// two would leave files in the bin that can not be displayed.
+func (h *HTTP) encryptionMatches(w http.ResponseWriter, r *http.Request, bin *ds.Bin, inputFilename string) bool {
+	if bin.Encrypted && !encryptedUpload(r) {
+		h.Error(w, r, fmt.Sprintf("Rejected plaintext upload of filename %q to encrypted bin %q", inputFilename, bin.Id), "Uploads to encrypted bins must be encrypted", 2001, http.StatusBadRequest)
+		return false
+	}
+	if !bin.Encrypted && encryptedUpload(r) {
+		h.Error(w, r, fmt.Sprintf("Rejected encrypted upload of filename %q to bin %q which is not encrypted", inputFilename, bin.Id), "The bin is not encrypted", 2002, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// encryptedManifestFile describes one of the encrypted files in an archive
+type encryptedManifestFile struct {
+	Filename      string `json:"filename"`
+	EncryptedName string `json:"encrypted_name"`
+	Bytes         uint64 `json:"bytes"`
+	SHA256        string `json:"sha256"`
+}
+
+// encryptedManifest lists the encrypted files in an archive, so that the
+// client holding the key is able to restore the original filenames.
+type encryptedManifest struct {
+	Bin       string                  `json:"bin"`
+	Encrypted bool                    `json:"encrypted"`
+	Files     []encryptedManifestFile `json:"files"`
+}
+
+// addEncryptedManifest adds the manifest of the encrypted files to the
+// archive
+func addEncryptedManifest(archiver archiveWriter, bin ds.Bin, files []ds.File) error {
+	manifest := encryptedManifest{
+		Bin:       bin.Id,
+		Encrypted: true,
+		Files:     make([]encryptedManifestFile, 0, len(files)),
+	}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, encryptedManifestFile{
+			Filename:      file.Filename,
+			EncryptedName: file.EncryptedName,
+			Bytes:         file.Bytes,
+			SHA256:        file.SHA256,
+		})
+	}
+	out, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+	writer, err := archiver.addFile(ds.File{
+		Filename:  encryptedManifestFilename,
+		Bytes:     uint64(len(out)),
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(out)
+	return err
+}
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// encryptedRequest sends an upload request with the encryption headers set
+// if encryptedName is not empty
+func encryptedRequest(method, url, content, encryptedName string) (*http.Response, []byte, error) {
+	var body io.Reader
+	if content != "" {
+		body = strings.NewReader(content)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if encryptedName != "" {
+		req.Header.Set("Bin-Encrypted", "true")
+		req.Header.Set("Encrypted-Filename", encryptedName)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Close = true
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, err := io.ReadAll(resp.Body)
+	return resp, out, err
+}
+
+func TestEncryptedBin(t *testing.T) {
+	bin := "encryptedbin01"
+	binURL := "http://localhost:8080/" + bin
+	filename := "3f9a1c0e5b7d24680000000000000001"
+	encryptedName := "q83vASNFZ4mrze8BI0VniavN7wEjRWeJ"
+	ciphertext := "\x8a\x01\xff\x00opaque ciphertext"
+
+	resp, body, err := encryptedRequest("POST", binURL+"/"+filename, ciphertext, encryptedName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	// Plaintext uploads to encrypted bins are rejected
+	resp, _, err = encryptedRequest("POST", binURL+"/plaintext.txt", "plaintext content", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a plaintext upload, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Encrypted filenames must be base64url encoded
+	resp, _, err = encryptedRequest("POST", binURL+"/3f9a1c0e5b7d24680000000000000002", ciphertext, "not/base64url")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid encrypted filename, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// The bin and the encrypted filename are part of the bin listing, and
+	// the content type is not detected
+	resp, body, err = encryptedRequest("GET", binURL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	var listing struct {
+		Bin struct {
+			Encrypted bool `json:"encrypted"`
+		} `json:"bin"`
+		Files []struct {
+			Filename      string `json:"filename"`
+			EncryptedName string `json:"encrypted_name"`
+			Mime          string `json:"content-type"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		t.Fatal(err)
+	}
+	if !listing.Bin.Encrypted {
+		t.Error("Expected the bin to be encrypted")
+	}
+	if len(listing.Files) != 1 {
+		t.Fatalf("Expected 1 file in the bin, got %d", len(listing.Files))
+	}
+	if listing.Files[0].Filename != filename {
+		t.Errorf("Expected filename %q, got %q", filename, listing.Files[0].Filename)
+	}
+	if listing.Files[0].EncryptedName != encryptedName {
+		t.Errorf("Expected encrypted filename %q, got %q", encryptedName, listing.Files[0].EncryptedName)
+	}
+	if listing.Files[0].Mime != "application/octet-stream" {
+		t.Errorf("Expected content type application/octet-stream, got %q", listing.Files[0].Mime)
+	}
+
+	// Downloads are served as opaque binary content
+	resp, _, err = encryptedRequest("GET", binURL+"/"+filename, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusFound, resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := location.Query().Get("response-content-type"); contentType != "application/octet-stream" {
+		t.Errorf("Expected the download to be served as application/octet-stream, got %q", contentType)
+	}
+
+	// Archives contain the encrypted files and the manifest
+	statusCode, archive, err := downloadArchive(bin, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, statusCode)
+	}
+	zr, err := zip.NewReader(bytes.NewReader([]byte(archive)), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		fp, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(fp)
+		_ = fp.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(content)
+	}
+	if entries[filename] != ciphertext {
+		t.Errorf("Expected the encrypted file in the archive, got %q", entries[filename])
+	}
+	manifest, found := entries["filebin-manifest.json"]
+	if !found {
+		t.Fatal("Expected the manifest in the archive")
+	}
+	if !strings.Contains(manifest, encryptedName) {
+		t.Errorf("Expected the encrypted filename in the manifest, got: %s", manifest)
+	}
+}
+
+func TestEncryptedUploadToPlaintextBin(t *testing.T) {
+	bin := "encryptedbin02"
+	statusCode, body, err := httpRequest(TestCase{Method: "POST", Bin: bin, Filename: "a.txt", UploadContent: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, statusCode, body)
+	}
+
+	resp, _, err := encryptedRequest("POST", "http://localhost:8080/"+bin+"/3f9a1c0e5b7d24680000000000000003", "ciphertext", "q83vASNFZ4mrze8B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an encrypted upload to a plaintext bin, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,214 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Uploaders ask for the expiration time of a new bin with this request
+	// header, or with the expiration form field in multipart/form-data
+	// uploads
+	binExpirationHeader = "Bin-Expiration"
+	binExpirationField  = "expiration"
+
+	// Expiration times beyond this are cut before they are converted to
+	// durations, which keeps them from overflowing. They are clamped to the
+	// configured maximum afterwards anyway.
+	maxExpirationSeconds = 100 * 365 * 24 * 60 * 60
+)
+
+// parseExpiration parses a requested expiration time, which is either a
+// number of seconds, a number of days like 7d, or a duration like 36h
+func parseExpiration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("the expiration time is empty")
+	}
+
+	unit := time.Second
+	number := s
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		unit = 24 * time.Hour
+		number = days
+	}
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		if n <= 0 {
+			return 0, errors.New("the expiration time must be positive")
+		}
+		if n > int64(maxExpirationSeconds*time.Second/unit) {
+			return maxExpirationSeconds * time.Second, nil
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiration time %q", s)
+	}
+	if d <= 0 {
+		return 0, errors.New("the expiration time must be positive")
+	}
+	return d, nil
+}
+
+// formatExpiration returns an expiration time in the largest whole unit
+// that fits, like 7 days or 12 hours
+func formatExpiration(d time.Duration) string {
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+	for _, unit := range units {
+		if d < unit.duration || d%unit.duration != 0 {
+			continue
+		}
+		n := int64(d / unit.duration)
+		if n == 1 {
+			return "1 " + unit.name
+		}
+		return fmt.Sprintf("%d %ss", n, unit.name)
+	}
+	return d.String()
+}
+
+// clampExpiration limits a requested expiration time to the range that the
+// operator allows
+func (h *HTTP) clampExpiration(d time.Duration) time.Duration {
+	if d < h.config.ExpirationMinDuration {
+		return h.config.ExpirationMinDuration
+	}
+	if d > h.config.ExpirationMaxDuration {
+		return h.config.ExpirationMaxDuration
+	}
+	return d
+}
+
+// requestedExpiration returns the expiration time in seconds that the
+// uploader asks for, clamped to the allowed range. Zero means that the
+// default expiration time applies.
+func (h *HTTP) requestedExpiration(r *http.Request) (int64, error) {
+	v := r.Header.Get(binExpirationHeader)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := parseExpiration(v)
+	if err != nil {
+		return 0, err
+	}
+	return int64(h.clampExpiration(d) / time.Second), nil
+}
+
+// setExpiration lets the owner of a bin choose how long the bin is kept
+// after the last update. The new expiration time is counted from now, and
+// it also applies to later uploads to the bin.
+func (h *HTTP) setExpiration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 2901, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		if h.rejectAlias(w, r, inputBin) {
+			return
+		}
+		h.Error(w, r, "", "The bin does not exist", 2902, http.StatusNotFound)
+		return
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 2903, http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		Expiration string `json:"expiration"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse expiration request for bin %q: %s", inputBin, err.Error()), "Invalid request body", 2904, http.StatusBadRequest)
+		return
+	}
+	d, err := parseExpiration(input.Expiration)
+	if err != nil {
+		h.Error(w, r, "", err.Error(), 2905, http.StatusBadRequest)
+		return
+	}
+	d = h.clampExpiration(d)
+
+	bin.ExpirationSeconds = int64(d / time.Second)
+	bin.ExpiredAt = time.Now().UTC().Add(d)
+	if err := h.dao.Bin().UpdateExpiration(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update expiration of bin %q: %s", inputBin, err.Error()), "Database error", 2906, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set bin expiration", "bin", inputBin, "expiration_seconds", bin.ExpirationSeconds, "expired_at", bin.ExpiredAt)
+
+	out, err := json.MarshalIndent(bin, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 2907, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// pinBin keeps a bin from expiring until it is unpinned
+func (h *HTTP) pinBin(w http.ResponseWriter, r *http.Request) {
+	h.updatePinned(w, r, true)
+}
+
+// unpinBin lets a pinned bin expire again. The bin is kept for its
+// expiration time from now.
+func (h *HTTP) unpinBin(w http.ResponseWriter, r *http.Request) {
+	h.updatePinned(w, r, false)
+}
+
+func (h *HTTP) updatePinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 2908, http.StatusInternalServerError)
+		return
+	}
+	if !found || bin.IsDeleted() {
+		h.Error(w, r, "", "The bin does not exist", 2909, http.StatusNotFound)
+		return
+	}
+
+	if pinned {
+		_ = bin.PinnedAt.Scan(time.Now().UTC())
+	} else {
+		bin.PinnedAt = sql.NullTime{}
+		bin.ExpiredAt = time.Now().UTC().Add(bin.Lifetime(h.config.ExpirationDuration))
+	}
+	if err := h.dao.Bin().UpdatePinned(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update pin of bin %q: %s", inputBin, err.Error()), "Database error", 2910, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("updated bin pin", "bin", inputBin, "pinned", pinned)
+
+	http.Redirect(w, r, "/admin/bin/"+inputBin, http.StatusSeeOther)
+}
@@ -0,0 +1,326 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func setupExpirationHandler(t *testing.T) *HTTP {
+	t.Helper()
+	h := setupProxyDownloadHandler(t)
+	h.config.ExpirationMinDuration = time.Minute
+	h.config.ExpirationMaxDuration = 24 * time.Hour
+	h.config.AdminUsername = "admin"
+	h.config.AdminPassword = "changeme"
+	return h
+}
+
+func TestParseExpiration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"3600", time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"36h", 36 * time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{" 1d ", 24 * time.Hour, true},
+		{"99999999999999d", maxExpirationSeconds * time.Second, true},
+		{"", 0, false},
+		{"0", 0, false},
+		{"-1d", 0, false},
+		{"-5m", 0, false},
+		{"forever", 0, false},
+		{"1w", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseExpiration(test.input)
+		if test.valid && err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.input, err)
+			continue
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected an error parsing %q, got %s", test.input, got)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("Expected %s from %q, got %s", test.expected, test.input, got)
+		}
+	}
+}
+
+func TestFormatExpiration(t *testing.T) {
+	tests := []struct {
+		input    time.Duration
+		expected string
+	}{
+		{7 * 24 * time.Hour, "7 days"},
+		{24 * time.Hour, "1 day"},
+		{36 * time.Hour, "36 hours"},
+		{90 * time.Minute, "90 minutes"},
+		{5 * time.Second, "5 seconds"},
+		{1500 * time.Millisecond, "1.5s"},
+	}
+	for _, test := range tests {
+		if got := formatExpiration(test.input); got != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, test.input, got)
+		}
+	}
+}
+
+func TestUploadExpiration(t *testing.T) {
+	h := setupExpirationHandler(t)
+
+	tests := []struct {
+		bin        string
+		expiration string
+		statusCode int
+		seconds    int64
+		policy     string
+	}{
+		{"expirationbin1", "", http.StatusCreated, 0, ds.ExpirationPolicyDefault},
+		{"expirationbin2", "2h", http.StatusCreated, 7200, ds.ExpirationPolicyCustom},
+		{"expirationbin3", "10", http.StatusCreated, 60, ds.ExpirationPolicyCustom},
+		{"expirationbin4", "30d", http.StatusCreated, 86400, ds.ExpirationPolicyCustom},
+		{"expirationbin5", "forever", http.StatusBadRequest, 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.bin, func(t *testing.T) {
+			req := uploadRequest("/"+test.bin+"/file.txt", "some content")
+			if test.expiration != "" {
+				req.Header.Set("Bin-Expiration", test.expiration)
+			}
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, req)
+			if rr.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+			if test.statusCode != http.StatusCreated {
+				return
+			}
+
+			var data struct {
+				Bin ds.Bin `json:"bin"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Unable to parse response: %s", err)
+			}
+			if data.Bin.ExpirationSeconds != test.seconds {
+				t.Errorf("Expected expiration of %d seconds, got %d", test.seconds, data.Bin.ExpirationSeconds)
+			}
+			if data.Bin.ExpirationPolicy != test.policy {
+				t.Errorf("Expected expiration policy %q, got %q", test.policy, data.Bin.ExpirationPolicy)
+			}
+			lifetime := data.Bin.Lifetime(h.config.ExpirationDuration)
+			if remaining := time.Until(data.Bin.ExpiredAt); remaining > lifetime || remaining < lifetime-time.Minute {
+				t.Errorf("Expected the bin to expire in %s, got %s", lifetime, remaining)
+			}
+		})
+	}
+
+	// The expiration time is only used when the bin is created
+	req := uploadRequest("/expirationbin2/other.txt", "other content")
+	req.Header.Set("Bin-Expiration", "1d")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	bin, _, err := h.dao.Bin().GetByID("expirationbin2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin.ExpirationSeconds != 7200 {
+		t.Errorf("Expected expiration of 7200 seconds, got %d", bin.ExpirationSeconds)
+	}
+}
+
+func TestFormUploadExpiration(t *testing.T) {
+	h := setupExpirationHandler(t)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("expiration", "3h"); err != nil {
+		t.Fatal(err)
+	}
+	part, err := writer.CreateFormFile("file", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write([]byte("form content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/expirationformbin", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	bin, _, err := h.dao.Bin().GetByID("expirationformbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin.ExpirationSeconds != 3*3600 {
+		t.Errorf("Expected expiration of %d seconds, got %d", 3*3600, bin.ExpirationSeconds)
+	}
+}
+
+func TestSetExpiration(t *testing.T) {
+	h := setupExpirationHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/setexpirationbin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	token := rr.Header().Get("Owner-Token")
+
+	tests := []struct {
+		description string
+		body        string
+		statusCode  int
+		seconds     int64
+	}{
+		{"extend", `{"expiration": "12h"}`, http.StatusOK, 12 * 3600},
+		{"beyond the maximum", `{"expiration": "30d"}`, http.StatusOK, 24 * 3600},
+		{"invalid expiration", `{"expiration": "soon"}`, http.StatusBadRequest, 0},
+		{"unknown field", `{"expires": "1h"}`, http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/expiration/setexpirationbin", strings.NewReader(test.body))
+			req.Header.Set("Owner-Token", token)
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, req)
+			if rr.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+			if test.statusCode != http.StatusOK {
+				return
+			}
+
+			var bin ds.Bin
+			if err := json.Unmarshal(rr.Body.Bytes(), &bin); err != nil {
+				t.Fatalf("Unable to parse response: %s", err)
+			}
+			if bin.ExpirationSeconds != test.seconds {
+				t.Errorf("Expected expiration of %d seconds, got %d", test.seconds, bin.ExpirationSeconds)
+			}
+			if bin.ExpirationPolicy != ds.ExpirationPolicyCustom {
+				t.Errorf("Expected expiration policy %q, got %q", ds.ExpirationPolicyCustom, bin.ExpirationPolicy)
+			}
+		})
+	}
+
+	// Later uploads do not shorten the expiration time
+	before, _, err := h.dao.Bin().GetByID("setexpirationbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/setexpirationbin/other.txt", "other content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	after, _, err := h.dao.Bin().GetByID("setexpirationbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.ExpiredAt.Before(before.ExpiredAt) {
+		t.Errorf("Expected the upload to keep the expiration time %s, got %s", before.ExpiredAt, after.ExpiredAt)
+	}
+}
+
+func TestPinBin(t *testing.T) {
+	h := setupExpirationHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/pinnedbin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	pin := func(action string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/bin/pinnedbin/"+action, nil)
+		req.SetBasicAuth("admin", "changeme")
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Only admins can pin bins
+	req := httptest.NewRequest(http.MethodPost, "/admin/bin/pinnedbin/pin", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	pin("pin")
+	bin, _, err := h.dao.Bin().GetByID("pinnedbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bin.IsPinned() {
+		t.Fatal("Expected the bin to be pinned")
+	}
+
+	// The bin outlives its expiration time
+	time.Sleep(h.config.ExpirationDuration + time.Second)
+	req = httptest.NewRequest(http.MethodGet, "/pinnedbin", nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var data struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if data.Bin.ExpirationPolicy != ds.ExpirationPolicyPinned {
+		t.Errorf("Expected expiration policy %q, got %q", ds.ExpirationPolicyPinned, data.Bin.ExpirationPolicy)
+	}
+	if len(data.Files) != 1 {
+		t.Errorf("Expected the file of the pinned bin, got %d files", len(data.Files))
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pinnedbin/file.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Unpinned bins get a new expiration time
+	pin("unpin")
+	bin, _, err = h.dao.Bin().GetByID("pinnedbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin.IsPinned() {
+		t.Error("Expected the bin to be unpinned")
+	}
+	if bin.IsExpired() {
+		t.Error("Expected the unpinned bin to not be expired")
+	}
+}
@@ -0,0 +1,161 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/extract"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const extractHeader = "X-Extract"
+
+// extractRequested returns true if the client asks for an uploaded archive
+// to be extracted into the bin, either with the X-Extract request header
+// or the extract query parameter
+func extractRequested(r *http.Request) bool {
+	v := r.Header.Get(extractHeader)
+	if v == "" {
+		v = r.URL.Query().Get("extract")
+	}
+	return v == "true" || v == "1" || v == "yes"
+}
+
+// errExtractAborted stops the extraction after the error response is
+// written to the client
+var errExtractAborted = errors.New("extraction aborted")
+
+// extractFiles stores every file in a received zip, tar or tar.gz archive as
+// a separate file in the bin, in the folders given by the archive. The
+// archive itself is not stored. The limits of the extraction and the paths
+// of the files are checked before any of the files are stored, and each
+// file is stored the same way as a regular upload. If one of the files is
+// rejected, the files before it remain in the bin.
+func (h *HTTP) extractFiles(w http.ResponseWriter, r *http.Request, bin *ds.Bin, rf receivedFile, t0 time.Time) {
+	if h.config.LimitExtractFiles <= 0 {
+		h.Error(w, r, "", "Extraction of archives is disabled", 2701, http.StatusForbidden)
+		return
+	}
+
+	// The content of encrypted bins is ciphertext, which can not be read
+	if bin.Encrypted {
+		h.Error(w, r, "", "Archives can not be extracted in encrypted bins", 2702, http.StatusBadRequest)
+		return
+	}
+
+	head, err := h.openReceivedFile(rf, 3072)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read filename %q in bin %q: %s", rf.filename, bin.Id, err.Error()), "Processing error", 2703, http.StatusInternalServerError)
+		return
+	}
+	mime, err := mimetype.DetectReader(head)
+	_ = head.Close()
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to detect mime type on filename %q in bin %q: %s", rf.filename, bin.Id, err.Error()), "Processing error", 2703, http.StatusInternalServerError)
+		return
+	}
+	format := extract.Format(mime.String())
+	if format == "" {
+		h.Error(w, r, "", "Only zip, tar and tar.gz archives can be extracted", 2704, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	limits := extract.Limits{
+		Files: h.config.LimitExtractFiles,
+		Bytes: h.config.LimitExtractBytes,
+		Ratio: h.config.LimitExtractRatio,
+	}
+	err = extract.Walk(rf.fp, rf.bytes, format, limits, func(entry extract.Entry, content io.Reader) error {
+		// Paths that escape the bin are rejected rather than sanitized
+		if err := h.dao.File().ValidateInput(&ds.File{Filename: entry.Name}); err != nil {
+			h.Error(w, r, fmt.Sprintf("Rejecting extraction of %q to bin %q: filename %q: %s", rf.filename, bin.Id, entry.Name, err.Error()), fmt.Sprintf("The archive contains an invalid filename: %s", entry.Name), 2710, http.StatusBadRequest)
+			return errExtractAborted
+		}
+		_, err := io.Copy(io.Discard, content)
+		return err
+	})
+	if errors.Is(err, errExtractAborted) {
+		return
+	}
+	if err != nil {
+		if extract.IsLimit(err) {
+			h.Error(w, r, fmt.Sprintf("Rejecting extraction of %q to bin %q: %s", rf.filename, bin.Id, err.Error()), fmt.Sprintf("The archive can not be extracted: %s", err.Error()), 2705, http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.Error(w, r, fmt.Sprintf("Unable to read archive %q in bin %q: %s", rf.filename, bin.Id, err.Error()), "The archive can not be read", 2706, http.StatusBadRequest)
+		return
+	}
+
+	ownerToken := bin.OwnerToken
+	files := []ds.File{}
+	err = extract.Walk(rf.fp, rf.bytes, format, limits, func(entry extract.Entry, content io.Reader) error {
+		// Empty files can not be uploaded
+		if entry.Bytes == 0 {
+			return nil
+		}
+
+		inputFilename := entry.Name
+		if !h.uploadSizeAllowed(w, r, inputFilename, entry.Bytes) {
+			return errExtractAborted
+		}
+
+		t1 := time.Now()
+		var ok bool
+		*bin, ok = h.prepareUpload(w, r, bin.Id, inputFilename)
+		if !ok {
+			return errExtractAborted
+		}
+		erf, ok := h.receiveFile(w, r, bin, inputFilename, content, int64(entry.Bytes), t1)
+		if !ok {
+			return errExtractAborted
+		}
+		slog.Debug("extracted file", "filename", inputFilename, "archive", rf.filename, "bin", bin.Id, "bytes", erf.bytes)
+
+		file, ok := h.storeFile(w, r, bin, erf, t1)
+		erf.remove()
+		if !ok {
+			return errExtractAborted
+		}
+		files = append(files, file)
+		return nil
+	})
+	if errors.Is(err, errExtractAborted) {
+		return
+	}
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to extract archive %q in bin %q: %s", rf.filename, bin.Id, err.Error()), "The archive can not be read", 2707, http.StatusBadRequest)
+		return
+	}
+
+	if len(files) == 0 {
+		h.Error(w, r, fmt.Sprintf("No files in archive %q uploaded to bin %q", rf.filename, bin.Id), "No files were found in the archive", 2708, http.StatusBadRequest)
+		return
+	}
+
+	slog.Info("extracted archive", "filename", rf.filename, "format", format, "files", len(files), "bin", bin.Id, "total_seconds", time.Since(t0).Seconds())
+
+	type Data struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	var data Data
+	data.Bin = *bin
+	data.Bin.OwnerToken = ownerToken
+	data.Files = files
+
+	out, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 2709, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,167 @@
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func zipContent(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatalf("Unable to create zip entry: %s", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Unable to write zip entry: %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Unable to close zip archive: %s", err)
+	}
+	return buf.String()
+}
+
+func setupExtractHandler(t *testing.T) *HTTP {
+	t.Helper()
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitExtractFiles = 10
+	h.config.LimitExtractBytes = 10 * 1024 * 1024
+	h.config.LimitExtractRatio = 100
+	return h
+}
+
+func TestExtractUpload(t *testing.T) {
+	h := setupExtractHandler(t)
+
+	files := map[string]string{
+		"readme.txt":       "hello",
+		"docs/manual.txt":  "a manual",
+		"docs/empty.txt":   "",
+		"src/main/code.go": "package main",
+	}
+	req := uploadRequest("/extractbin/files.zip", zipContent(t, files))
+	req.Header.Set("X-Extract", "true")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var data struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if data.Bin.OwnerToken == "" {
+		t.Error("Expected the owner token of the new bin")
+	}
+
+	// Empty files are skipped, the files are stored in their folders, and the
+	// archive itself is not stored
+	stored, err := h.dao.File().GetByBin("extractbin", true)
+	if err != nil {
+		t.Fatalf("Unable to get files: %s", err)
+	}
+	if len(data.Files) != 3 || len(stored) != 3 {
+		t.Fatalf("Expected 3 files, got %d in the response and %d in the bin", len(data.Files), len(stored))
+	}
+	for _, file := range stored {
+		var content string
+		switch file.Filename {
+		case "readme.txt", "docs/manual.txt", "src/main/code.go":
+			content = files[file.Filename]
+		default:
+			t.Errorf("Unexpected file %q in the bin", file.Filename)
+			continue
+		}
+		if file.SHA256 != contentSHA256(content) {
+			t.Errorf("Unexpected checksum of %s", file.Filename)
+		}
+	}
+}
+
+func TestExtractUploadRejected(t *testing.T) {
+	h := setupExtractHandler(t)
+
+	many := make(map[string]string)
+	for _, c := range "abcdefghijkl" {
+		many[string(c)+".txt"] = string(c)
+	}
+	bomb := map[string]string{"zeros.bin": strings.Repeat("\x00", 5*1024*1024)}
+	traversal := map[string]string{"readme.txt": "hello", "../escape.txt": "outside"}
+
+	tests := []struct {
+		description string
+		path        string
+		content     string
+		statusCode  int
+	}{
+		{"too many files", "/extractbin2/many.zip", zipContent(t, many), http.StatusRequestEntityTooLarge},
+		{"compression ratio", "/extractbin2/bomb.zip", zipContent(t, bomb), http.StatusRequestEntityTooLarge},
+		{"not an archive", "/extractbin2/notes.txt", "just some text", http.StatusUnsupportedMediaType},
+		{"corrupt archive", "/extractbin2/broken.zip", "PK\x03\x04" + strings.Repeat("x", 100), http.StatusBadRequest},
+		{"path traversal", "/extractbin2/traversal.zip", zipContent(t, traversal), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := uploadRequest(test.path+"?extract=true", test.content)
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, req)
+			if rr.Code != test.statusCode {
+				t.Errorf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Nothing is stored when the archive is rejected
+	files, err := h.dao.File().GetByBin("extractbin2", true)
+	if err != nil {
+		t.Fatalf("Unable to get files: %s", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no files in the bin, got %d", len(files))
+	}
+
+	// Archives are stored as they are unless extraction is requested
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/extractbin2/many.zip", zipContent(t, many)))
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+func TestExtractRequested(t *testing.T) {
+	tests := []struct {
+		header   string
+		query    string
+		expected bool
+	}{
+		{"", "", false},
+		{"true", "", true},
+		{"1", "", true},
+		{"false", "", false},
+		{"", "?extract=true", true},
+		{"", "?extract=no", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/bin/files.zip"+test.query, nil)
+		if test.header != "" {
+			req.Header.Set("X-Extract", test.header)
+		}
+		if got := extractRequested(req); got != test.expected {
+			t.Errorf("Expected %t for header %q and query %q, got %t", test.expected, test.header, test.query, got)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package web
 
 import (
-	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
@@ -13,7 +12,6 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
 	"strings"
@@ -21,7 +19,8 @@ import (
 
 	"github.com/dustin/go-humanize"
 	"github.com/espebra/filebin2/internal/ds"
-	"github.com/espebra/filebin2/internal/phash"
+	"github.com/espebra/filebin2/internal/processor"
+	"github.com/espebra/filebin2/internal/webhook"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gorilla/mux"
 )
@@ -39,9 +38,14 @@ func (h *HTTP) getFile(w http.ResponseWriter, r *http.Request) {
 	inputFilename := params["filename"]
 	// TODO: Input validation (inputFilename)
 
-	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
 	if err != nil {
-		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 112, http.StatusInternalServerError)
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 112, http.StatusInternalServerError)
 		return
 	}
 	if !found {
@@ -54,18 +58,25 @@ func (h *HTTP) getFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If approvals are required, then
-	if h.config.RequireApproval {
-		// Reject downloads from bins that are not approved
-		if !bin.IsApproved() {
-			h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 521, http.StatusForbidden)
-			return
-		}
+	if !h.binUnlocked(w, r, inputBin, &bin) {
+		return
 	}
 
-	file, found, err := h.dao.File().GetByName(inputBin, inputFilename)
+	// Reject downloads from bins that are not approved. Bins are approved
+	// when they are created, unless approval is required by the
+	// configuration or by the access policy.
+	if !bin.IsApproved() {
+		h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 521, http.StatusForbidden)
+		return
+	}
+
+	if !h.downloadPermitted(w, r) {
+		return
+	}
+
+	file, found, err := h.dao.File().GetByName(binId, inputFilename)
 	if err != nil {
-		h.Error(w, r, fmt.Sprintf("Failed to select file by bin %q and filename %q: %s", inputBin, inputFilename, err.Error()), "Database error", 115, http.StatusInternalServerError)
+		h.Error(w, r, fmt.Sprintf("Failed to select file by bin %q and filename %q: %s", binId, inputFilename, err.Error()), "Database error", 115, http.StatusInternalServerError)
 		return
 	}
 	if !found {
@@ -84,51 +95,56 @@ func (h *HTTP) getFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Download limit
-	// 0 disables the limit
-	// >= 1 enforces a limit
-	if h.config.LimitFileDownloads > 0 {
-		if file.Downloads >= h.config.LimitFileDownloads {
-			h.Error(w, r, "", "The file has been requested too many times.", 421, http.StatusForbidden)
-			return
-		}
+	// Earlier versions of the file are downloaded by their id
+	if !h.selectVersion(w, r, &file) {
+		return
+	}
+
+	// Download limit, either the limit of the file or the configured limit
+	// per file
+	if h.downloadLimitReached(file) {
+		h.Error(w, r, "", "The file has been requested too many times.", 421, http.StatusForbidden)
+		return
 	}
 
 	// The file is downloadable at this point
-	if h.config.RequireCookie {
-		if !h.cookieVerify(w, r) {
-			// Set the cookie
-			h.setVerificationCookie(w, r)
-
-			// Show the warning
-			type Data struct {
-				ds.Common
-				Bin     ds.Bin `json:"bin"`
-				NextUrl string `json:"next_url"`
-			}
-			var data Data
-			data.Bin = bin
-			var nextUrl url.URL
-			nextUrl.Scheme = h.config.BaseUrl.Scheme
-			nextUrl.Host = h.config.BaseUrl.Host
-			nextUrl.Path = path.Join(h.config.BaseUrl.Path, r.URL.Path)
-			data.NextUrl = nextUrl.String()
-			if err := h.renderTemplate(w, "cookie", data); err != nil {
-				slog.Error("failed to execute template", "error", err)
-				http.Error(w, "Errno 303", http.StatusInternalServerError)
-				return
-			}
+	if !h.cookieChallenge(w, r, bin, alias) {
+		return
+	}
+
+	// The download is counted before the file is sent, so that files
+	// with a download limit are not sent more often than the limit allows
+	t, counted, ok := h.countsAsDownload(w, r, file)
+	if !ok {
+		return
+	}
+	if counted {
+		if !h.registerDownload(w, r, bin, &file) {
+			h.cancelTransfer(t)
 			return
 		}
+		h.metrics.IncrFileDownloadCount()
 	}
 
-	if err := h.dao.File().RegisterDownload(&file); err != nil {
-		slog.Error("unable to update file", "filename", inputFilename, "bin", inputBin, "error", err)
+	// Encrypted files are served as opaque binary content
+	contentType := file.Mime
+	if bin.Encrypted {
+		contentType = encryptedContentType
+	}
+
+	if h.proxyDownloads() {
+		h.proxyFile(w, r, file, contentType, t, t0)
+		return
 	}
 
 	// Redirect the client to a presigned URL for this fetch, which is more efficient
 	// than proxying the request through filebin.
-	presignedURL, err := h.s3.PresignedGetObject(file.SHA256, file.Filename, file.Mime)
+	var presignedURL *url.URL
+	if inlinePreview(r, contentType) {
+		presignedURL, err = h.s3.PresignedGetObjectInline(file.SHA256, file.Filename, contentType)
+	} else {
+		presignedURL, err = h.s3.PresignedGetObject(file.SHA256, file.Filename, contentType)
+	}
 	if err != nil {
 		h.Error(w, r, fmt.Sprintf("Unable to generate presigned URL for bin %q and filename %q: %s", inputBin, inputFilename, err.Error()), "Unable to presign URL for object", 1351, http.StatusInternalServerError)
 		return
@@ -141,8 +157,9 @@ func (h *HTTP) getFile(w http.ResponseWriter, r *http.Request) {
 	// Increment the byte counter here
 	// Assume that the client will download the entire file from S3. This will
 	// not always be the case.
-	h.metrics.IncrBytesStorageToClient(file.Bytes)
-	h.metrics.IncrFileDownloadCount()
+	if counted {
+		h.metrics.IncrBytesStorageToClient(file.Bytes)
+	}
 }
 
 func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
@@ -157,33 +174,131 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	h.metrics.IncrFileUploadInProgress()
 	defer h.metrics.DecrFileUploadInProgress()
 
-	// Deprecated: This block is here to be compatible with the clients that
-	// are written for https://github.com/espebra/filebin, meaning clients that
-	// upload files to / with the request headers bin and filename set instead
-	// of /{bin}/{filename}
-	if inputBin == "" || inputFilename == "" {
+	release, ok := h.clientUploadStarted(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if inputFilename == "" {
+		// Deprecated: This block is here to be compatible with the clients that
+		// are written for https://github.com/espebra/filebin, meaning clients that
+		// upload files to / with the request headers bin and filename set instead
+		// of /{bin}/{filename}
+		if inputBin == "" {
+			inputBin = r.Header.Get("bin")
+			if inputBin == "" {
+				inputBin = h.dao.Bin().GenerateId()
+				slog.Debug("auto generated bin", "bin", inputBin)
+			}
+		}
+
+		// Uploads from HTML forms and curl -F carry the filenames in the
+		// request body
+		if isMultipartForm(r) {
+			h.uploadFormFiles(w, r, inputBin)
+			return
+		}
+
 		inputFilename = r.Header.Get("filename")
 		if inputFilename == "" {
 			h.Error(w, r, "Upload failed: missing filename request header", "Missing filename request header", 952, http.StatusBadRequest)
 			return
 		}
-
-		inputBin = r.Header.Get("bin")
-		if inputBin == "" {
-			inputBin = h.dao.Bin().GenerateId()
-			slog.Debug("auto generated bin", "bin", inputBin)
-		}
 	}
 
 	inputMD5 := r.Header.Get("Content-MD5")
 	inputSHA256 := r.Header.Get("Content-SHA256")
 
-	inputBytes, err := strconv.ParseUint(r.Header.Get("content-length"), 10, 64)
+	// The size is not known in advance for chunked uploads, which are
+	// received until the end of the stream or the upload size limit.
+	inputBytes := int64(-1)
+	if r.ContentLength >= 0 {
+		n, err := strconv.ParseUint(r.Header.Get("content-length"), 10, 64)
+		if err != nil {
+			h.Error(w, r, "Upload failed: Invalid content-length header", "Missing or invalid content-length header", 120, http.StatusLengthRequired)
+			return
+		}
+		if !h.uploadSizeAllowed(w, r, inputFilename, n) {
+			return
+		}
+		inputBytes = int64(n)
+	}
+
+	bin, ok := h.prepareUpload(w, r, inputBin, inputFilename)
+	if !ok {
+		return
+	}
+
+	t1 := time.Now()
+
+	rf, ok := h.receiveFile(w, r, &bin, inputFilename, r.Body, inputBytes, t0)
+	if !ok {
+		return
+	}
+	defer rf.remove()
+
+	t2 := time.Now()
+
+	// Checksums are already calculated from the write above
+	md5ChecksumString := rf.md5
+	if inputMD5 != "" {
+		if md5ChecksumString != inputMD5 {
+			h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q due to wrong MD5 checksum (got %s and calculated %s)", inputFilename, bin.Id, inputMD5, md5ChecksumString), "MD5 checksum did not match", 129, http.StatusBadRequest)
+			return
+		}
+	}
+
+	sha256ChecksumString := rf.sha256
+	if inputSHA256 != "" {
+		if sha256ChecksumString != inputSHA256 {
+			h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q due to wrong SHA256 checksum (got %s and calculated %s)", inputFilename, bin.Id, inputSHA256, sha256ChecksumString), "SHA256 checksum did not match", 130, http.StatusBadRequest)
+			return
+		}
+	}
+
+	slog.Debug("buffered upload", "filename", inputFilename, "bin", bin.Id, "bytes", rf.bytes, "db_seconds", t1.Sub(t0).Seconds(), "buffer_seconds", t2.Sub(t1).Seconds())
+
+	// Archives are extracted into the bin if the client asks for it
+	if extractRequested(r) {
+		h.extractFiles(w, r, &bin, rf, t0)
+		return
+	}
+
+	file, ok := h.storeFile(w, r, &bin, rf, t0)
+	if !ok {
+		return
+	}
+
+	type Data struct {
+		Bin  ds.Bin  `json:"bin"`
+		File ds.File `json:"file"`
+	}
+	var data Data
+	data.Bin = bin
+	data.File = file
+
+	out, err := json.MarshalIndent(data, "", "    ")
 	if err != nil {
-		h.Error(w, r, "Upload failed: Invalid content-length header", "Missing or invalid content-length header", 120, http.StatusLengthRequired)
+		slog.Error("failed to parse json", "error", err)
+		http.Error(w, "Errno 268", http.StatusInternalServerError)
 		return
 	}
-	// TODO: Input validation on content-length. Between min:max.
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
+
+// prepareUpload verifies that the filename is acceptable and that the bin
+// can receive the upload, creating the bin if it does not exist yet. The
+// error response is written to the client if the upload is rejected.
+func (h *HTTP) prepareUpload(w http.ResponseWriter, r *http.Request, inputBin string, inputFilename string) (ds.Bin, bool) {
+	var bin ds.Bin
+
+	if !h.uploadPermitted(w, r) {
+		return bin, false
+	}
 
 	// Reject file names with certain extensions
 	// Remove the . from the extension
@@ -192,7 +307,7 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		for _, extension := range h.config.RejectFileExtensions {
 			if "."+extension == thisExtension {
 				h.Error(w, r, fmt.Sprintf("Rejecting file name %s with illegal extension: %s", inputFilename, extension), "Illegal file extension", 992, http.StatusForbidden)
-				return
+				return bin, false
 			}
 		}
 	}
@@ -201,16 +316,21 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	bin, found, err := h.dao.Bin().GetByID(inputBin)
 	if err != nil {
 		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 128, http.StatusInternalServerError)
-		return
+		return bin, false
 	}
 
 	if !found {
+		// Aliases are read-only, and bins can not be created with their ids
+		if h.rejectAlias(w, r, inputBin) {
+			return bin, false
+		}
+
 		// Bin does not exist, so create it here
 		bin = ds.Bin{}
 		bin.Id = inputBin
 
 		// Since manual approval is not needed, then just set the approval time at the time of the upload
-		if !h.config.RequireApproval {
+		if !h.approvalRequired(r) {
 			now := time.Now().UTC().Truncate(time.Microsecond)
 			_ = bin.ApprovedAt.Scan(now)
 		}
@@ -218,45 +338,98 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		// Abort early if the bin is invalid
 		if err := h.dao.Bin().ValidateInput(&bin); err != nil {
 			h.Error(w, r, fmt.Sprintf("Input validation error on upload: %s", err.Error()), err.Error(), 623, http.StatusBadRequest)
-			return
+			return bin, false
 		}
 
-		bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
+		// Uploaders may ask for another expiration time than the default
+		expiration, err := h.requestedExpiration(r)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Invalid expiration time for bin %q: %s", inputBin, err.Error()), err.Error(), 2911, http.StatusBadRequest)
+			return bin, false
+		}
+		bin.ExpirationSeconds = expiration
+		bin.ExpiredAt = time.Now().UTC().Add(bin.Lifetime(h.config.ExpirationDuration))
+
+		// Uploaders may limit the number of downloads of the files in the bin
+		bin.DownloadLimit, err = parseDownloadLimit(r.Header.Get(binDownloadLimitHeader))
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Invalid download limit for bin %q: %s", inputBin, err.Error()), err.Error(), 3003, http.StatusBadRequest)
+			return bin, false
+		}
+
+		// The bin is encrypted if the first upload is encrypted
+		bin.Encrypted = encryptedUpload(r)
+
+		// The bin is password protected if a password is given when it is created
+		if password := r.Header.Get(binPasswordHeader); password != "" {
+			if len(password) > passwordMaxLength {
+				h.Error(w, r, "", fmt.Sprintf("The password must be between 1 and %d bytes long", passwordMaxLength), 1916, http.StatusBadRequest)
+				return bin, false
+			}
+			if err := bin.SetPassword(password); err != nil {
+				h.Error(w, r, fmt.Sprintf("Unable to hash password for bin %q: %s", inputBin, err.Error()), "Internal error", 1917, http.StatusInternalServerError)
+				return bin, false
+			}
+		}
+
+		if !h.clientBinsAllowed(w, r) {
+			return bin, false
+		}
+
+		if err := bin.GenerateOwnerToken(); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to generate owner token for bin %q: %s", inputBin, err.Error()), "Internal error", 1703, http.StatusInternalServerError)
+			return bin, false
+		}
+		ownerToken := bin.OwnerToken
 		inserted, err := h.dao.Bin().Insert(&bin)
 		if err != nil {
 			h.Error(w, r, fmt.Sprintf("Unable to insert bin %q: %s", inputBin, err), "Database error", 121, http.StatusInternalServerError)
-			return
+			return bin, false
 		}
 		bin, found, err = h.dao.Bin().GetByID(inputBin)
 		if err != nil || !found {
 			h.Error(w, r, fmt.Sprintf("Unable to fetch bin %q after insert: %s", inputBin, err), "Database error", 137, http.StatusInternalServerError)
-			return
+			return bin, false
 		}
 		if inserted {
-			// TODO: Execute new bin created trigger
 			h.metrics.IncrNewBinCount()
+			h.webhooks.Enqueue(webhook.BinEvent(webhook.BinCreated, bin))
+			h.registerClientUsage(r, ds.ClientUsageBin, 0)
+
+			// Only the client that created the bin gets the owner token
+			bin.OwnerToken = ownerToken
+			h.setOwnerToken(w, &bin)
+
+			// The client that set the password keeps access to the bin
+			if bin.HasPassword() {
+				h.setBinSession(w, inputBin, &bin)
+			}
 		}
 	}
 
 	if !bin.IsWritable() {
 		if bin.IsExpired() {
 			h.Error(w, r, fmt.Sprintf("Upload failed: Bin %q is expired", inputBin), "The bin is no longer available", 122, http.StatusMethodNotAllowed)
-			return
+			return bin, false
 		} else if bin.IsDeleted() {
 			// Reject uploads to deleted bins
 			h.Error(w, r, fmt.Sprintf("Upload failed: Bin %q is deleted", inputBin), "The bin is no longer available", 132, http.StatusMethodNotAllowed)
-			return
+			return bin, false
 		} else if bin.Readonly {
 			// Reject uploads to readonly bins
 			w.Header().Set("Allow", "GET, HEAD")
 			h.Error(w, r, fmt.Sprintf("Rejected upload of filename %q to readonly bin %q", inputFilename, inputBin), "Uploads to locked bins are not allowed", 123, http.StatusMethodNotAllowed)
-			return
+			return bin, false
 		} else {
 			h.Error(w, r, fmt.Sprintf("Rejected upload of filename %q to bin %q for unknown reason", inputFilename, inputBin), "Unexpected upload failure", 134, http.StatusInternalServerError)
-			return
+			return bin, false
 		}
 	}
 
+	if !h.encryptionMatches(w, r, &bin, inputFilename) {
+		return bin, false
+	}
+
 	// Storage limit
 	// 0 disables the limit
 	// >= 1 enforces a limit, in number of gigabytes stored
@@ -264,79 +437,201 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		totalBytesConsumed := h.getCachedStorageBytes()
 		if totalBytesConsumed >= h.config.LimitStorageBytes {
 			h.Error(w, r, fmt.Sprintf("Storage limit reached (currently consuming %s) when trying to upload file %q to bin %q", humanize.Bytes(totalBytesConsumed), inputFilename, inputBin), "Insufficient storage, please retry later", 633, http.StatusInsufficientStorage)
-			return
+			return bin, false
 		}
 	}
 
+	// Per-client limits
+	if !h.clientUploadsAllowed(w, r) {
+		return bin, false
+	}
+
+	return bin, true
+}
+
+// uploadSizeAllowed checks the size of an upload against the upload size
+// limit. The error response is written to the client if the upload is too
+// large.
+func (h *HTTP) uploadSizeAllowed(w http.ResponseWriter, r *http.Request, inputFilename string, inputBytes uint64) bool {
+	if h.config.LimitUploadBytes > 0 && inputBytes > h.config.LimitUploadBytes {
+		h.Error(w, r, fmt.Sprintf("Rejecting upload of file %q of %s, which exceeds the upload size limit of %s", inputFilename, humanize.Bytes(inputBytes), h.config.LimitUploadReadable), fmt.Sprintf("The file is too large, the limit is %s", h.config.LimitUploadReadable), 1601, http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+// receiveFile writes the content of an upload to a temporary file in the
+// workspace while computing the checksums. If inputBytes is negative, the
+// size is not known in advance and the upload size limit is enforced while
+// receiving. The temporary file is removed with receivedFile.remove. The
+// error response is written to the client if the upload was not received.
+func (h *HTTP) receiveFile(w http.ResponseWriter, r *http.Request, bin *ds.Bin, inputFilename string, body io.Reader, inputBytes int64, t0 time.Time) (receivedFile, bool) {
+	rf := receivedFile{filename: inputFilename}
+
 	// Add timestamp to the temporary file to make it easy to see when
 	// an upload was started.
-	fp, err := h.workspace.CreateTempFile(inputBytes, fmt.Sprintf("filebin-%s-", t0.Format("20060102-150405")))
-	// Defer removal of the tempfile to clean up partially uploaded files.
+	prefix := fmt.Sprintf("filebin-%s-", t0.Format("20060102-150405"))
+	var err error
+	if inputBytes < 0 {
+		rf.fp, err = h.workspace.CreateStreamTempFile(h.config.LimitUploadBytes, prefix)
+	} else {
+		rf.fp, err = h.workspace.CreateTempFile(uint64(inputBytes), prefix)
+	}
 	if err != nil {
 		h.Error(w, r, fmt.Sprintf("Failed to create temporary upload file: %s", err.Error()), "Storage error", 124, http.StatusInternalServerError)
-		return
+		return rf, false
 	}
-	defer func() { _ = os.Remove(fp.Name()) }()
-	defer func() { _ = fp.Close() }()
 
-	t1 := time.Now()
+	expected := "unknown size"
+	if inputBytes >= 0 {
+		expected = humanize.Bytes(uint64(inputBytes))
+	} else if h.config.LimitUploadBytes > 0 {
+		// Accept one byte more than the limit to be able to detect uploads
+		// that exceed it.
+		body = io.LimitReader(body, int64(h.config.LimitUploadBytes)+1)
+	}
 
 	// Compute MD5 and SHA256 checksums during the initial write to reduce disk IOPS
 	md5Checksum := md5.New()
 	sha256Checksum := sha256.New()
-	multiWriter := io.MultiWriter(fp, md5Checksum, sha256Checksum)
+	multiWriter := io.MultiWriter(rf.fp, md5Checksum, sha256Checksum)
 
-	nBytes, err := io.Copy(multiWriter, r.Body)
+	nBytes, err := io.Copy(multiWriter, body)
 	if err != nil {
-		h.Error(w, r, fmt.Sprintf("File upload of file %q bin %q aborted at %s of %s, upload started %s: %s (%s)", inputFilename, bin.Id, humanize.Bytes(uint64(nBytes)), humanize.Bytes(inputBytes), humanize.Time(t0), err.Error(), fp.Name()), "Storage error", 125, http.StatusInternalServerError)
-		return
+		rf.remove()
+		h.Error(w, r, fmt.Sprintf("File upload of file %q bin %q aborted at %s of %s, upload started %s: %s (%s)", inputFilename, bin.Id, humanize.Bytes(uint64(nBytes)), expected, humanize.Time(t0), err.Error(), rf.fp.Name()), "Storage error", 125, http.StatusInternalServerError)
+		return rf, false
+	}
+	if inputBytes < 0 && h.config.LimitUploadBytes > 0 && uint64(nBytes) > h.config.LimitUploadBytes {
+		rf.remove()
+		h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q since it exceeds the upload size limit of %s", inputFilename, bin.Id, h.config.LimitUploadReadable), fmt.Sprintf("The file is too large, the limit is %s", h.config.LimitUploadReadable), 1602, http.StatusRequestEntityTooLarge)
+		return rf, false
 	}
-	if uint64(nBytes) != inputBytes {
+	if inputBytes >= 0 && nBytes != inputBytes {
+		rf.remove()
 		h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q since we got %d bytes and should have received %d bytes", inputFilename, bin.Id, nBytes, inputBytes), "Content-length did not match the request body length", 126, http.StatusBadRequest)
-		return
+		return rf, false
 	}
 	if nBytes == 0 {
+		rf.remove()
 		h.Error(w, r, "", "Empty file uploads are not allowed", 127, http.StatusBadRequest)
-		return
+		return rf, false
 	}
 
-	t2 := time.Now()
+	rf.bytes = nBytes
+	rf.md5 = base64.StdEncoding.EncodeToString(md5Checksum.Sum(nil))
+	rf.sha256 = fmt.Sprintf("%x", sha256Checksum.Sum(nil))
+	return rf, true
+}
 
-	// Checksums are already calculated from the write above
-	md5ChecksumString := base64.StdEncoding.EncodeToString(md5Checksum.Sum(nil))
-	if inputMD5 != "" {
-		if md5ChecksumString != inputMD5 {
-			h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q due to wrong MD5 checksum (got %s and calculated %s)", inputFilename, bin.Id, inputMD5, md5ChecksumString), "MD5 checksum did not match", 129, http.StatusBadRequest)
-			return
-		}
+// acquireUpload marks a resumable or direct upload as busy to prevent
+// concurrent requests from processing the same upload. It returns false if
+// the upload is already busy.
+func (h *HTTP) acquireUpload(id string) bool {
+	h.uploadBusyMutex.Lock()
+	defer h.uploadBusyMutex.Unlock()
+	if h.uploadBusy == nil {
+		h.uploadBusy = make(map[string]bool)
 	}
-
-	sha256ChecksumString := fmt.Sprintf("%x", sha256Checksum.Sum(nil))
-	if inputSHA256 != "" {
-		if sha256ChecksumString != inputSHA256 {
-			h.Error(w, r, fmt.Sprintf("Rejecting upload for file %q to bin %q due to wrong SHA256 checksum (got %s and calculated %s)", inputFilename, bin.Id, inputSHA256, sha256ChecksumString), "SHA256 checksum did not match", 130, http.StatusBadRequest)
-			return
-		}
+	if h.uploadBusy[id] {
+		return false
 	}
-	_, _ = fp.Seek(0, 0)
+	h.uploadBusy[id] = true
+	return true
+}
 
-	mime, err := mimetype.DetectReader(fp)
-	if err != nil {
-		h.Error(w, r, fmt.Sprintf("Unable to detect mime type on filename %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Processing error", 131, http.StatusInternalServerError)
+func (h *HTTP) releaseUpload(id string) {
+	h.uploadBusyMutex.Lock()
+	defer h.uploadBusyMutex.Unlock()
+	delete(h.uploadBusy, id)
+}
+
+// receivedFile is an upload that has been written to a temporary file in
+// the workspace and checksummed. Uploads that the client sent directly to
+// S3 have no temporary file, and refer to the staged object instead.
+type receivedFile struct {
+	fp       *os.File
+	staged   string
+	filename string
+	bytes    int64
+	md5      string
+	sha256   string
+}
+
+// remove closes and removes the temporary file of the received file.
+func (rf receivedFile) remove() {
+	if rf.fp == nil {
 		return
 	}
-	_, _ = fp.Seek(0, 0)
+	_ = rf.fp.Close()
+	_ = os.Remove(rf.fp.Name())
+}
+
+// openReceivedFile returns a reader for the first limit bytes of the received file, or
+// the entire file if limit is 0.
+func (h *HTTP) openReceivedFile(rf receivedFile, limit int64) (io.ReadCloser, error) {
+	if rf.staged != "" {
+		var end int64
+		if limit > 0 {
+			end = limit - 1
+		}
+		return h.s3.GetObject(rf.staged, 0, end)
+	}
+	if _, err := rf.fp.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	if limit > 0 {
+		return io.NopCloser(io.LimitReader(rf.fp, limit)), nil
+	}
+	return io.NopCloser(rf.fp), nil
+}
 
-	var pHashValue string
-	var pHashDuration time.Duration
-	if strings.HasPrefix(mime.String(), "image/") {
-		tPhash := time.Now()
-		pHashValue, err = phash.Compute(fp)
-		pHashDuration = time.Since(tPhash)
+// storeFile stores a received file in the bin. It detects the content
+// type, deduplicates the content against what is already in storage,
+// uploads it to S3 if needed and creates or updates the file. The error
+// response is written to the client if the file could not be stored.
+func (h *HTTP) storeFile(w http.ResponseWriter, r *http.Request, bin *ds.Bin, rf receivedFile, t0 time.Time) (ds.File, bool) {
+	inputBin := bin.Id
+	inputFilename := rf.filename
+	nBytes := rf.bytes
+	md5ChecksumString := rf.md5
+	sha256ChecksumString := rf.sha256
+
+	var file ds.File
+
+	// The content of encrypted bins is ciphertext, so there is no mime
+	// type to detect. The perceptual hash of images is computed by the
+	// content processor after the upload, unless it is needed during the
+	// upload to compare the image with blocked content.
+	contentType := encryptedContentType
+	if !bin.Encrypted {
+		head, err := h.openReceivedFile(rf, 3072)
 		if err != nil {
-			slog.Warn("failed to compute phash", "filename", inputFilename, "error", err)
+			h.Error(w, r, fmt.Sprintf("Unable to read filename %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Processing error", 131, http.StatusInternalServerError)
+			return file, false
 		}
-		_, _ = fp.Seek(0, 0)
+		mime, err := mimetype.DetectReader(head)
+		_ = head.Close()
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to detect mime type on filename %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Processing error", 131, http.StatusInternalServerError)
+			return file, false
+		}
+		contentType = mime.String()
+	}
+
+	var encryptedName string
+	if bin.Encrypted {
+		encryptedName = r.Header.Get(encryptedFilenameHeader)
+	}
+
+	// The download limit of the file, or of the files in the bin
+	downloadLimit, err := parseDownloadLimit(r.Header.Get(downloadLimitHeader))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Invalid download limit for filename %q in bin %q: %s", inputFilename, inputBin, err.Error()), err.Error(), 3004, http.StatusBadRequest)
+		return file, false
+	}
+	if downloadLimit == 0 {
+		downloadLimit = bin.DownloadLimit
 	}
 
 	// Check if file exists
@@ -344,9 +639,11 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		slog.Error("unable to load file", "filename", file.Filename, "bin", bin.Id, "error", err)
 		http.Error(w, "Errno 106", http.StatusInternalServerError)
-		return
+		return file, false
 	}
 
+	// The file as it was before the upload, which is kept as a version
+	previous := file
 	if found {
 		// Increment the update counter if the file exists.
 		file.Updates = file.Updates + 1
@@ -355,7 +652,7 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	dump, err := httputil.DumpRequest(r, false)
 	if err != nil {
 		h.Error(w, r, "Failed to dump request", "Parse error", 135, http.StatusInternalServerError)
-		return
+		return file, false
 	}
 	file.Headers = string(dump)
 
@@ -363,7 +660,7 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	ip, err := extractIP(r.RemoteAddr)
 	if err != nil {
 		h.Error(w, r, "Failed to dump request", "Parse error", 136, http.StatusInternalServerError)
-		return
+		return file, false
 	}
 	file.IP = ip
 
@@ -375,14 +672,16 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 	// earlier
 	_ = file.DeletedAt.Scan(nil)
 
-	file.Bytes = inputBytes
-	file.Mime = mime.String()
+	file.Bytes = uint64(nBytes)
+	file.Mime = contentType
+	file.EncryptedName = encryptedName
+	file.DownloadLimit = downloadLimit
 	file.SHA256 = sha256ChecksumString
 	file.MD5 = md5ChecksumString
 	if err := h.dao.File().ValidateInput(&file); err != nil {
 		slog.Warn("rejected upload due to failed input validation", "filename", inputFilename, "bin", bin.Id, "error", err)
 		http.Error(w, "Input validation failed", http.StatusBadRequest)
-		return
+		return file, false
 	}
 
 	// Check if content already exists in storage (deduplication)
@@ -392,10 +691,7 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		// Check if content is blocked
 		if existingContent.Blocked {
 			h.Error(w, r, fmt.Sprintf("Rejecting upload of file %q to bin %q: content with SHA256 %s is blocked", inputFilename, bin.Id, sha256ChecksumString), "This content has been blocked and cannot be uploaded", 993, http.StatusForbidden)
-			return
-		}
-		if existingContent.PHash != "" && pHashValue == "" {
-			pHashValue = existingContent.PHash
+			return file, false
 		}
 		if existingContent.InStorage {
 			// Content already in S3, skip upload
@@ -404,6 +700,53 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Scan the content for malware before it is stored, unless it has
+	// been scanned already. The content of encrypted bins is ciphertext,
+	// which can not be scanned.
+	var scan *ds.Scan
+	if h.scanner != nil && !bin.Encrypted && (existingContent == nil || !existingContent.IsScanned()) {
+		content, err := h.openReceivedFile(rf, 0)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to read filename %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Processing error", 2101, http.StatusInternalServerError)
+			return file, false
+		}
+		verdict := h.scanContent(r.Context(), sha256ChecksumString, content)
+		_ = content.Close()
+		scan = &verdict
+
+		if scan.Status == ds.ScanInfected {
+			// The content is tracked and blocked, so that later uploads
+			// of the same content are rejected without a new scan
+			infectedContent := ds.FileContent{
+				SHA256:        file.SHA256,
+				Bytes:         file.Bytes,
+				MD5:           file.MD5,
+				Mime:          file.Mime,
+				InStorage:     existingContent != nil && existingContent.InStorage,
+				ScanStatus:    scan.Status,
+				ScanSignature: scan.Signature,
+			}
+			if err := h.dao.FileContent().InsertOrIncrement(&infectedContent); err != nil {
+				slog.Error("unable to update file_content", "sha256", file.SHA256, "error", err)
+				http.Error(w, "Failed to update content tracking", http.StatusInternalServerError)
+				return file, false
+			}
+			if err := h.recordScan(scan); err != nil {
+				slog.Error("unable to record scan", "sha256", file.SHA256, "error", err)
+				http.Error(w, "Failed to record scan", http.StatusInternalServerError)
+				return file, false
+			}
+			h.Error(w, r, fmt.Sprintf("Rejecting upload of file %q to bin %q: content with SHA256 %s is infected with %s", inputFilename, bin.Id, sha256ChecksumString, scan.Signature), "This content has been identified as malware and cannot be uploaded", 2102, http.StatusForbidden)
+			return file, false
+		}
+	}
+
+	// Compare images with the perceptual hashes of blocked content
+	imageHash, imageHashVersion, ok := h.checkSimilarImage(w, r, bin, rf, file, existingContent)
+	if !ok {
+		return file, false
+	}
+
 	t3 := time.Now()
 
 	// Upload to S3 only if content doesn't already exist
@@ -416,8 +759,15 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		defer h.metrics.DecrStorageUploadInProgress()
 
 		for {
-			_, _ = fp.Seek(0, 0)
-			err := h.s3.PutObjectByHash(file.SHA256, fp, nBytes)
+			var err error
+			if rf.staged != "" {
+				// The content is already in S3, so a server side copy
+				// is sufficient.
+				err = h.s3.CopyObjectByHash(rf.staged, file.SHA256, nBytes)
+			} else {
+				_, _ = rf.fp.Seek(0, 0)
+				err = h.s3.PutObjectByHash(file.SHA256, rf.fp, nBytes)
+			}
 			if err == nil {
 				// Completed successfully
 				break
@@ -427,7 +777,7 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 					// Give up after a few attempts
 					slog.Error("gave up uploading to S3", "attempt", retryCounter, "max_attempts", retryLimit, "error", err)
 					http.Error(w, "Failed to store the object in S3, please try again later", http.StatusInternalServerError)
-					return
+					return file, false
 				}
 				slog.Warn("failed attempt to upload to S3, retrying", "attempt", retryCounter, "max_attempts", retryLimit, "error", err)
 
@@ -446,30 +796,70 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 		Bytes:     file.Bytes,
 		MD5:       file.MD5,
 		Mime:      file.Mime,
-		PHash:     pHashValue,
 		InStorage: true,
 	}
+	if !bin.Encrypted {
+		fileContent.MimeVersion = processor.MimeVersion
+	}
+	if imageHashVersion > 0 {
+		fileContent.PHash = imageHash
+		fileContent.PHashVersion = imageHashVersion
+	}
+	if scan != nil {
+		fileContent.ScanStatus = scan.Status
+	}
 	if err := h.dao.FileContent().InsertOrIncrement(&fileContent); err != nil {
 		slog.Error("unable to update file_content", "sha256", file.SHA256, "error", err)
 		http.Error(w, "Failed to update content tracking", http.StatusInternalServerError)
-		return
+		return file, false
+	}
+	if scan != nil {
+		if err := h.dao.Scan().Insert(scan); err != nil {
+			// The content is stored either way, so only the history
+			// of the scan is lost
+			slog.Error("unable to record scan", "sha256", file.SHA256, "error", err)
+		}
+	}
+
+	// Queue the processing of new images, such as the perceptual hash and
+	// the thumbnails, and of images that were processed by an earlier
+	// version
+	if !bin.Encrypted && strings.HasPrefix(file.Mime, "image/") {
+		for _, kind := range processor.ImageKinds {
+			if existingContent != nil && !processor.Outdated(existingContent, kind) {
+				continue
+			}
+			// The perceptual hash of new content may have been
+			// computed during the upload
+			if existingContent == nil && !processor.Outdated(&fileContent, kind) {
+				continue
+			}
+			if _, err := h.dao.Job().Enqueue(kind, file.SHA256); err != nil {
+				// The upload is complete either way, and the
+				// backfill picks up the content later
+				slog.Error("unable to queue job", "kind", kind, "sha256", file.SHA256, "error", err)
+			}
+		}
 	}
 
 	// Record upload duration
 	file.UploadDurationMs = time.Since(t0).Milliseconds()
 
 	if found {
+		if !h.keepVersion(w, r, previous, file.SHA256) {
+			return file, false
+		}
 		if err := h.dao.File().Update(&file); err != nil {
 			slog.Error("unable to update filename", "filename", file.Filename, "file_id", file.Id, "bin", bin.Id, "error", err)
 			http.Error(w, "Errno 107", http.StatusInternalServerError)
-			return
+			return file, false
 		}
 	} else {
 		inserted, err := h.dao.File().Insert(&file)
 		if err != nil {
 			slog.Error("unable to insert file", "filename", file.Filename, "bin", bin.Id, "error", err)
 			http.Error(w, "Errno 108", http.StatusInternalServerError)
-			return
+			return file, false
 		}
 		if !inserted {
 			// A concurrent upload of the same filename already inserted the row.
@@ -478,9 +868,14 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				slog.Error("unable to load file after insert conflict", "filename", inputFilename, "bin", bin.Id, "error", err)
 				http.Error(w, "Errno 138", http.StatusInternalServerError)
-				return
+				return file, false
+			}
+			if !h.keepVersion(w, r, file, sha256ChecksumString) {
+				return file, false
 			}
 			file.SHA256 = sha256ChecksumString
+			file.EncryptedName = encryptedName
+			file.DownloadLimit = downloadLimit
 			file.Updates = file.Updates + 1
 			file.IP = ip
 			file.Headers = string(dump)
@@ -488,70 +883,50 @@ func (h *HTTP) uploadFile(w http.ResponseWriter, r *http.Request) {
 			if err := h.dao.File().Update(&file); err != nil {
 				slog.Error("unable to update file after insert conflict", "filename", file.Filename, "file_id", file.Id, "bin", bin.Id, "error", err)
 				http.Error(w, "Errno 139", http.StatusInternalServerError)
-				return
+				return file, false
 			}
 		}
-		// TODO: Execute new file created trigger
 	}
 
-	// Update bin to set the correct updated timestamp
-	bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
-	if err := h.dao.Bin().Update(&bin); err != nil {
+	// Update bin to set the correct updated timestamp. The upload keeps the
+	// bin for its expiration time from now, but does not shorten an
+	// expiration time that the owner has set.
+	if expiredAt := time.Now().UTC().Add(bin.Lifetime(h.config.ExpirationDuration)); expiredAt.After(bin.ExpiredAt) {
+		bin.ExpiredAt = expiredAt
+	}
+	if err := h.dao.Bin().Update(bin); err != nil {
 		slog.Error("unable to update bin", "bin", bin.Id, "error", err)
 		http.Error(w, "Errno 109", http.StatusInternalServerError)
-		return
+		return file, false
 	}
 
 	// Metrics
 	h.metrics.IncrFileUploadCount()
-	h.metrics.IncrBytesClientToFilebin(file.Bytes)
-	if !skipS3Upload {
-		h.metrics.IncrBytesFilebinToStorage(file.Bytes)
-	}
-
-	// Execute post-upload hook if configured. The hook runs after the upload
-	// has been persisted and is treated as a notification: its exit code and
-	// output are logged but do not affect the response to the client.
-	var hookDuration time.Duration
-	if h.config.PostUploadHook != "" {
-		hookStart := time.Now()
-		hookCtx, hookCancel := context.WithTimeout(r.Context(), h.config.PostUploadHookTimeout)
-		hookCmd := exec.CommandContext(hookCtx, h.config.PostUploadHook,
-			"--bin-id", bin.Id,
-			"--filename", inputFilename,
-			"--content-type", mime.String(),
-			"--size", strconv.FormatInt(nBytes, 10),
-			"--sha256", sha256ChecksumString,
-		)
-		hookOutput, hookErr := hookCmd.CombinedOutput()
-		hookDuration = time.Since(hookStart)
-		hookCancel()
-		if hookErr != nil {
-			slog.Warn("post-upload hook returned an error", "filename", inputFilename, "bin", bin.Id, "error", hookErr, "output", strings.TrimRight(string(hookOutput), "\n"))
+	if rf.staged != "" {
+		h.metrics.IncrBytesClientToStorage(file.Bytes)
+	} else {
+		h.metrics.IncrBytesClientToFilebin(file.Bytes)
+		if !skipS3Upload {
+			h.metrics.IncrBytesFilebinToStorage(file.Bytes)
 		}
 	}
 
-	type Data struct {
-		Bin  ds.Bin  `json:"bin"`
-		File ds.File `json:"file"`
-	}
-	var data Data
-	data.Bin = bin
-	data.File = file
+	h.webhooks.Enqueue(webhook.FileEvent(webhook.FileUploaded, *bin, file))
+	h.registerClientUsage(r, ds.ClientUsageUpload, file.Bytes)
 
-	out, err := json.MarshalIndent(data, "", "    ")
-	if err != nil {
-		slog.Error("failed to parse json", "error", err)
-		http.Error(w, "Errno 268", http.StatusInternalServerError)
-		return
+	// Queue the post-upload hook if configured. The hook runs after the
+	// upload has been persisted and is treated as a notification, so the
+	// response to the client does not wait for it.
+	if h.config.PostUploadHook != "" {
+		if err := h.dao.Job().EnqueueFile(ds.JobHook, file.Id, file.SHA256); err != nil {
+			slog.Error("unable to queue post-upload hook", "filename", file.Filename, "bin", bin.Id, "error", err)
+		}
 	}
 
 	t5 := time.Now()
-	slog.Info("uploaded file", "filename", file.Filename, "bytes", file.Bytes, "sha256", file.SHA256, "bin", bin.Id, "db_seconds", t1.Sub(t0).Seconds(), "buffer_seconds", fmt.Sprintf("%f", t2.Sub(t1).Seconds()), "phash_seconds", pHashDuration.Seconds(), "hook_seconds", hookDuration.Seconds(), "store_seconds", t4.Sub(t3).Seconds(), "total_seconds", t5.Sub(t0).Seconds())
+	slog.Info("uploaded file", "filename", file.Filename, "bytes", file.Bytes, "sha256", file.SHA256, "bin", bin.Id, "store_seconds", t4.Sub(t3).Seconds(), "total_seconds", t5.Sub(t0).Seconds())
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write(out)
+	return file, true
 }
 
 func (h *HTTP) deleteFile(w http.ResponseWriter, r *http.Request) {
@@ -568,6 +943,9 @@ func (h *HTTP) deleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !found {
+		if h.rejectAlias(w, r, inputBin) {
+			return
+		}
 		http.Error(w, "The bin does not exist", http.StatusNotFound)
 		return
 	}
@@ -613,5 +991,6 @@ func (h *HTTP) deleteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.metrics.IncrFileDeleteCount()
+	h.webhooks.Enqueue(webhook.FileEvent(webhook.FileDeleted, bin, file))
 	http.Error(w, "File deleted successfully", http.StatusOK)
 }
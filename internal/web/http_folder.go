@@ -0,0 +1,208 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/webhook"
+	"github.com/gorilla/mux"
+)
+
+// binRow is a row in the file listing of the bin page, either a folder or a
+// file. The rows of the subfolders and files in a folder follow the row of
+// the folder itself.
+type binRow struct {
+	// The folder of folder rows
+	Folder *ds.Folder
+
+	// The index of the file in the files of the bin, for file rows
+	Index int
+
+	// The name of the folder or file, without the folder it is in
+	Name string
+
+	// The folder that the row is in, and how deep it is
+	Parent string
+	Depth  int
+}
+
+// binRows returns the rows of the file listing of a bin, with the folders
+// before the files on every level
+func binRows(files []ds.File) []binRow {
+	var rows []binRow
+	var add func(folder string, depth int)
+	add = func(folder string, depth int) {
+		for _, subfolder := range ds.Subfolders(files, folder) {
+			rows = append(rows, binRow{Folder: &subfolder, Name: subfolder.Name, Parent: folder, Depth: depth})
+			add(subfolder.Path, depth+1)
+		}
+		for i, file := range files {
+			if file.Folder == folder {
+				rows = append(rows, binRow{Index: i, Name: path.Base(file.Filename), Parent: folder, Depth: depth})
+			}
+		}
+	}
+	add("", 0)
+	return rows
+}
+
+// hasFolders returns true if any of the files are in a folder
+func hasFolders(files []ds.File) bool {
+	for _, file := range files {
+		if file.Folder != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// filesInFolder returns the files in a folder, including the files in its
+// subfolders
+func filesInFolder(files []ds.File, folder string) (selected []ds.File) {
+	for _, file := range files {
+		if file.InFolder(folder) {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
+
+// viewFolder lists the files and subfolders directly in a folder of a bin.
+// The root folder of the bin is listed if no folder is given.
+func (h *HTTP) viewFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFolder := strings.Trim(params["folder"], "/")
+
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 2801, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The bin does not exist", 2802, http.StatusNotFound)
+		return
+	}
+	if !h.binUnlocked(w, r, inputBin, &bin) {
+		return
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 2803, http.StatusNotFound)
+		return
+	}
+
+	files, err := h.dao.File().GetByBin(binId, true)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select files by bin %q: %s", binId, err.Error()), "Database error", 2804, http.StatusInternalServerError)
+		return
+	}
+	files = filesInFolder(files, inputFolder)
+	if inputFolder != "" && len(files) == 0 {
+		h.Error(w, r, "", "The folder does not exist", 2805, http.StatusNotFound)
+		return
+	}
+
+	type Data struct {
+		Bin     ds.Bin      `json:"bin"`
+		Folder  string      `json:"folder"`
+		Folders []ds.Folder `json:"folders"`
+		Files   []ds.File   `json:"files"`
+	}
+	var data Data
+	data.Folder = inputFolder
+	data.Folders = ds.Subfolders(files, inputFolder)
+	data.Files = []ds.File{}
+	for _, file := range files {
+		if file.Folder == inputFolder {
+			data.Files = append(data.Files, file)
+		}
+	}
+	if data.Folders == nil {
+		data.Folders = []ds.Folder{}
+	}
+	if alias != nil {
+		alias.Mask(&bin, data.Files)
+	}
+	data.Bin = bin
+
+	out, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 2806, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// deleteFolder deletes every file in a folder of a bin, including the files
+// in its subfolders
+func (h *HTTP) deleteFolder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFolder := strings.Trim(params["folder"], "/")
+
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 2807, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		if h.rejectAlias(w, r, inputBin) {
+			return
+		}
+		h.Error(w, r, "", "The bin does not exist", 2808, http.StatusNotFound)
+		return
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 2809, http.StatusNotFound)
+		return
+	}
+
+	files, err := h.dao.File().GetByBin(inputBin, true)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select files by bin %q: %s", inputBin, err.Error()), "Database error", 2810, http.StatusInternalServerError)
+		return
+	}
+	files = filesInFolder(files, inputFolder)
+	if inputFolder == "" || len(files) == 0 {
+		h.Error(w, r, "", "The folder does not exist", 2811, http.StatusNotFound)
+		return
+	}
+
+	// Flag the files as deleted
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	for _, file := range files {
+		_ = file.DeletedAt.Scan(now)
+		if err := h.dao.File().Update(&file); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to delete filename %q in bin %q: %s", file.Filename, inputBin, err.Error()), "Database error", 2812, http.StatusInternalServerError)
+			return
+		}
+		h.metrics.IncrFileDeleteCount()
+		h.webhooks.Enqueue(webhook.FileEvent(webhook.FileDeleted, bin, file))
+	}
+
+	// Update the updated timestamp of the bin
+	if err := h.dao.Bin().Update(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update bin %q: %s", inputBin, err.Error()), "Database error", 2813, http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("deleted folder", "folder", inputFolder, "files", len(files), "bin", bin.Id)
+	http.Error(w, "Folder deleted successfully", http.StatusOK)
+}
@@ -0,0 +1,319 @@
+package web
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func uploadFolderFiles(t *testing.T, h *HTTP, bin string, files map[string]string) {
+	t.Helper()
+	for filename, content := range files {
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, uploadRequest("/"+bin+"/"+filename, content))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d uploading %s, got %d. Body: %s", http.StatusCreated, filename, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestFolderUpload(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	// Files with the same name in different folders do not collide
+	uploadFolderFiles(t, h, "folderbin1", map[string]string{
+		"src/main.go":  "package main",
+		"docs/main.go": "package docs",
+	})
+
+	files, err := h.dao.File().GetByBin("folderbin1", true)
+	if err != nil {
+		t.Fatalf("Unable to get files: %s", err)
+	}
+	expected := map[string]string{
+		"src/main.go":  "src",
+		"docs/main.go": "docs",
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected %d files, got %d", len(expected), len(files))
+	}
+	for _, file := range files {
+		folder, ok := expected[file.Filename]
+		if !ok {
+			t.Errorf("Unexpected file %q in the bin", file.Filename)
+			continue
+		}
+		if file.Folder != folder {
+			t.Errorf("Expected folder %q of %s, got %q", folder, file.Filename, file.Folder)
+		}
+	}
+
+	// Files in folders are downloaded by their path
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/folderbin1/docs/main.go", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != "package docs" {
+		t.Errorf("Unexpected content %q", rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `inline; filename="main.go"` {
+		t.Errorf("Unexpected Content-Disposition %q", got)
+	}
+}
+
+func TestFolderUploadTraversal(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	// The path is cleaned before routing, so the traversal is sent with the
+	// deprecated filename header
+	req := uploadRequest("/", "secret")
+	req.Header.Set("bin", "folderbin2")
+	req.Header.Set("filename", "../../etc/passwd")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+	}
+}
+
+func TestViewFolder(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	uploadFolderFiles(t, h, "folderbin3", map[string]string{
+		"readme.txt":          "readme",
+		"src/main.go":         "package main",
+		"src/cmd/tool/app.go": "package tool",
+		"docs/manual.txt":     "manual",
+	})
+
+	type listing struct {
+		Bin     ds.Bin      `json:"bin"`
+		Folder  string      `json:"folder"`
+		Folders []ds.Folder `json:"folders"`
+		Files   []ds.File   `json:"files"`
+	}
+	tests := []struct {
+		path    string
+		folders []string
+		files   []string
+	}{
+		{"/folder/folderbin3", []string{"docs", "src"}, []string{"readme.txt"}},
+		{"/folder/folderbin3/src", []string{"src/cmd"}, []string{"src/main.go"}},
+		{"/folder/folderbin3/src/cmd/tool", []string{}, []string{"src/cmd/tool/app.go"}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			var data listing
+			if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+				t.Fatalf("Unable to parse response: %s", err)
+			}
+			if len(data.Folders) != len(test.folders) {
+				t.Fatalf("Expected folders %v, got %v", test.folders, data.Folders)
+			}
+			for i, folder := range test.folders {
+				if data.Folders[i].Path != folder {
+					t.Errorf("Expected folder %s, got %s", folder, data.Folders[i].Path)
+				}
+			}
+			if len(data.Files) != len(test.files) {
+				t.Fatalf("Expected files %v, got %d files", test.files, len(data.Files))
+			}
+			for i, file := range test.files {
+				if data.Files[i].Filename != file {
+					t.Errorf("Expected file %s, got %s", file, data.Files[i].Filename)
+				}
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/folder/folderbin3/missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing folder, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestDeleteFolder(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	uploadFolderFiles(t, h, "folderbin4", map[string]string{
+		"src/main.go":     "package main",
+		"src/cmd/app.go":  "package cmd",
+		"src-old/main.go": "package old",
+		"readme.txt":      "readme",
+	})
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/folder/folderbin4/src", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	files, err := h.dao.File().GetByBin("folderbin4", true)
+	if err != nil {
+		t.Fatalf("Unable to get files: %s", err)
+	}
+	var remaining []string
+	for _, file := range files {
+		remaining = append(remaining, file.Filename)
+	}
+	if len(remaining) != 2 || remaining[0] != "readme.txt" || remaining[1] != "src-old/main.go" {
+		t.Errorf("Expected readme.txt and src-old/main.go to remain, got %v", remaining)
+	}
+
+	// The folder is gone
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/folder/folderbin4/src", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestViewFileNamedView(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	uploadFolderFiles(t, h, "folderbin5", map[string]string{
+		"docs/view": "not a preview",
+	})
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/folderbin5/docs/view", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != "not a preview" {
+		t.Errorf("Expected the content of the file, got %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/view/folderbin5/docs/view", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "not a preview") {
+		t.Error("Expected the preview to show the content of the file")
+	}
+}
+
+func TestArchiveFolders(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	uploadFolderFiles(t, h, "folderbin6", map[string]string{
+		"readme.txt":       "readme",
+		"src/main.go":      "package main",
+		"src/cmd/app.go":   "package cmd",
+		"docs/manual.txt":  "manual",
+		"docs/extra/a.txt": "a",
+	})
+
+	// Every folder has a directory entry before the files in it
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/archive/folderbin6/tar", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	seen := make(map[string]bool)
+	tr := tar.NewReader(rr.Body)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error reading tar: %s", err)
+		}
+		if folder := ds.FolderOf(strings.TrimSuffix(header.Name, "/")); folder != "" && !seen[folder+"/"] {
+			t.Errorf("Entry %s comes before its folder", header.Name)
+		}
+		if isDir := strings.HasSuffix(header.Name, "/"); isDir != (header.Typeflag == tar.TypeDir) {
+			t.Errorf("Unexpected type %c of %s", header.Typeflag, header.Name)
+		}
+		seen[header.Name] = true
+	}
+	for _, name := range []string{"docs/", "docs/extra/", "src/", "src/cmd/", "src/cmd/app.go", "readme.txt"} {
+		if !seen[name] {
+			t.Errorf("Expected %s in the archive", name)
+		}
+	}
+
+	// Only the files in the folder are selected
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/archive/folderbin6/zip?folder=src", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Unable to read zip archive: %s", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "src/" && !f.Mode().IsDir() {
+			t.Errorf("Expected src/ to be a directory")
+		}
+	}
+	expected := []string{"src/", "src/cmd/", "src/cmd/app.go", "src/main.go"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v in the archive, got %v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("Expected %s, got %s", name, names[i])
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/archive/folderbin6/zip?folder=missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestBinRows(t *testing.T) {
+	files := []ds.File{
+		{Filename: "b.txt"},
+		{Filename: "src/main.go", Folder: "src"},
+		{Filename: "a.txt"},
+		{Filename: "src/cmd/app.go", Folder: "src/cmd"},
+	}
+	rows := binRows(files)
+
+	type row struct {
+		name   string
+		parent string
+		depth  int
+		folder bool
+	}
+	expected := []row{
+		{"src", "", 0, true},
+		{"cmd", "src", 1, true},
+		{"app.go", "src/cmd", 2, false},
+		{"main.go", "src", 1, false},
+		{"b.txt", "", 0, false},
+		{"a.txt", "", 0, false},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(rows))
+	}
+	for i, e := range expected {
+		got := row{rows[i].Name, rows[i].Parent, rows[i].Depth, rows[i].Folder != nil}
+		if got != e {
+			t.Errorf("Expected row %d to be %v, got %v", i, e, got)
+		}
+		if !e.folder && files[rows[i].Index].Filename != path.Join(e.parent, e.name) {
+			t.Errorf("Row %d points to the wrong file %s", i, files[rows[i].Index].Filename)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// isMultipartForm returns true if the request body is multipart/form-data,
+// as sent by HTML forms and curl -F.
+func isMultipartForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "multipart/form-data"
+}
+
+// formFieldHeaders are the form fields that are accepted in place of upload
+// request headers, by the header they replace
+var formFieldHeaders = map[string]string{
+	binExpirationField: binExpirationHeader,
+	downloadLimitField: downloadLimitHeader,
+}
+
+// partFilename returns the filename of a multipart/form-data part as sent
+// by the client. Unlike part.FileName, the folders in the filename are
+// kept, as sent by browsers when uploading a directory.
+func partFilename(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// uploadFormFiles stores every file in a multipart/form-data request body as
+// a separate file in the bin. The files are read from the request body one
+// at a time, and form fields that are not files are ignored, except for the
+// expiration time of the bin and the download limit of the files. If one of
+// the files is rejected, the files before it remain in the bin.
+func (h *HTTP) uploadFormFiles(w http.ResponseWriter, r *http.Request, inputBin string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read multipart/form-data request body: %s", err.Error()), "Invalid multipart/form-data request body", 1603, http.StatusBadRequest)
+		return
+	}
+
+	var bin ds.Bin
+	var ownerToken string
+	files := []ds.File{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to read multipart/form-data request body: %s", err.Error()), "Invalid multipart/form-data request body", 1604, http.StatusBadRequest)
+			return
+		}
+
+		// The expiration time of a new bin and the download limit of the
+		// files can be given in form fields before the files. The request
+		// headers take precedence.
+		if header, ok := formFieldHeaders[part.FormName()]; ok && partFilename(part) == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 64))
+			_ = part.Close()
+			if err != nil {
+				h.Error(w, r, fmt.Sprintf("Unable to read multipart/form-data request body: %s", err.Error()), "Invalid multipart/form-data request body", 1607, http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get(header) == "" {
+				r.Header.Set(header, string(value))
+			}
+			continue
+		}
+
+		// Browsers send a part without a filename for file inputs where
+		// no file was selected.
+		inputFilename := partFilename(part)
+		if inputFilename == "" {
+			_ = part.Close()
+			continue
+		}
+
+		// Paths that escape the bin are rejected before the file is read
+		if err := h.dao.File().ValidateInput(&ds.File{Filename: inputFilename}); err != nil {
+			h.Error(w, r, fmt.Sprintf("Rejecting multipart/form-data upload to bin %q: filename %q: %s", inputBin, inputFilename, err.Error()), fmt.Sprintf("Invalid filename: %s", inputFilename), 1608, http.StatusBadRequest)
+			return
+		}
+
+		t0 := time.Now()
+
+		var ok bool
+		bin, ok = h.prepareUpload(w, r, inputBin, inputFilename)
+		if !ok {
+			return
+		}
+		if bin.OwnerToken != "" {
+			ownerToken = bin.OwnerToken
+		}
+
+		rf, ok := h.receiveFile(w, r, &bin, inputFilename, part, -1, t0)
+		if !ok {
+			return
+		}
+		slog.Debug("buffered upload", "filename", inputFilename, "bin", bin.Id, "bytes", rf.bytes, "buffer_seconds", time.Since(t0).Seconds())
+
+		file, ok := h.storeFile(w, r, &bin, rf, t0)
+		rf.remove()
+		if !ok {
+			return
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		h.Error(w, r, fmt.Sprintf("No files in multipart/form-data upload to bin %q", inputBin), "No files were found in the request body", 1605, http.StatusBadRequest)
+		return
+	}
+
+	// Send browsers that submitted an HTML form to the bin
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		binURL := h.config.BaseUrl
+		binURL.Path = path.Join(h.config.BaseUrl.Path, bin.Id)
+		http.Redirect(w, r, binURL.String(), http.StatusSeeOther)
+		return
+	}
+
+	type Data struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	var data Data
+	data.Bin = bin
+	data.Bin.OwnerToken = ownerToken
+	data.Files = files
+
+	out, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 1606, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(out)
+}
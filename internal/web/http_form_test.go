@@ -0,0 +1,273 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// formRequest posts the given files as multipart/form-data to the given URL
+func formRequest(url string, accept string, files map[string]string) (*http.Response, []byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("comment", "not a file"); err != nil {
+		return nil, nil, err
+	}
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	req.Close = true
+
+	// Do not follow the redirect that is sent to browsers
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	content, err := io.ReadAll(resp.Body)
+	return resp, content, err
+}
+
+// chunkedRequest uploads the content without a Content-Length request header
+func chunkedRequest(url string, content string) (*http.Response, error) {
+	// Hide the reader type from net/http to prevent it from computing the
+	// content length.
+	req, err := http.NewRequest("POST", url, io.MultiReader(strings.NewReader(content)))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = -1
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp, nil
+}
+
+func TestFormUpload(t *testing.T) {
+	bin := "formupload01"
+	files := map[string]string{
+		"first.txt":  "content of the first file",
+		"second.txt": "content of the second file",
+	}
+
+	resp, body, err := formRequest("http://localhost:8080/"+bin, "application/json", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var data struct {
+		Bin   ds.Bin    `json:"bin"`
+		Files []ds.File `json:"files"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Bin.Id != bin {
+		t.Errorf("Expected bin %s, got %s", bin, data.Bin.Id)
+	}
+	if len(data.Files) != len(files) {
+		t.Fatalf("Expected %d files, got %d", len(files), len(data.Files))
+	}
+
+	tcs := []TestCase{}
+	for filename, content := range files {
+		tcs = append(tcs, TestCase{
+			Description:     "Download file uploaded with a form",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        filename,
+			StatusCode:      200,
+			DownloadContent: content,
+		})
+	}
+	runTests(tcs, t)
+}
+
+func TestFormUploadFolders(t *testing.T) {
+	bin := "formupload03"
+	files := map[string]string{
+		"photos/2024/nested.txt": "content of the nested file",
+		"photos/top.txt":         "content of the file in the folder",
+	}
+
+	resp, body, err := formRequest("http://localhost:8080/"+bin, "application/json", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	var data struct {
+		Files []ds.File `json:"files"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range data.Files {
+		if _, ok := files[file.Filename]; !ok {
+			t.Errorf("Unexpected filename %q, the folders were not kept", file.Filename)
+		}
+	}
+
+	tcs := []TestCase{}
+	for filename, content := range files {
+		tcs = append(tcs, TestCase{
+			Description:     "Download file uploaded to a folder with a form",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        filename,
+			StatusCode:      200,
+			DownloadContent: content,
+		})
+	}
+	runTests(tcs, t)
+}
+
+func TestFormUploadRedirectsBrowsers(t *testing.T) {
+	bin := "formupload02"
+	resp, body, err := formRequest("http://localhost:8080/"+bin, "text/html,application/xhtml+xml", map[string]string{
+		"browser.txt": "content uploaded from a browser",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusSeeOther, resp.StatusCode, body)
+	}
+	if location := resp.Header.Get("Location"); !strings.HasSuffix(location, "/"+bin) {
+		t.Errorf("Expected redirect to the bin, got %q", location)
+	}
+}
+
+func TestFormUploadInvalid(t *testing.T) {
+	tcs := []struct {
+		name       string
+		files      map[string]string
+		statusCode int
+	}{
+		{
+			name:       "no files",
+			files:      map[string]string{},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "empty file",
+			files:      map[string]string{"empty.txt": ""},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "illegal extension",
+			files:      map[string]string{"a.illegal1": "content"},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "path escaping the bin",
+			files:      map[string]string{"../escape.txt": "content"},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "file exceeding the upload size limit",
+			files:      map[string]string{"large.txt": strings.Repeat("a", testLimitUpload+1)},
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body, err := formRequest("http://localhost:8080/forminvalid", "", tc.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	bin := "chunkedupload01"
+	content := "content streamed without a content length"
+
+	resp, err := chunkedRequest("http://localhost:8080/"+bin+"/streamed.txt", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	tcs := []TestCase{
+		{
+			Description:     "Download file uploaded without a content length",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        "streamed.txt",
+			StatusCode:      200,
+			DownloadContent: content,
+		},
+	}
+	runTests(tcs, t)
+}
+
+func TestChunkedUploadLimit(t *testing.T) {
+	bin := "chunkedupload02"
+
+	resp, err := chunkedRequest("http://localhost:8080/"+bin+"/large.txt", strings.Repeat("a", testLimitUpload+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+
+	// Uploads with a content length exceeding the limit are rejected up front
+	statusCode, _, err := httpRequest(TestCase{
+		Method:        "POST",
+		Bin:           bin,
+		Filename:      "large.txt",
+		UploadContent: strings.Repeat("a", testLimitUpload+1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, statusCode)
+	}
+}
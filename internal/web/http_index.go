@@ -35,6 +35,7 @@ func (h *HTTP) index(w http.ResponseWriter, r *http.Request) {
 	bin := &ds.Bin{}
 	bin.ExpiredAt = time.Now().UTC().Add(h.config.ExpirationDuration)
 	bin.ExpiredAtRelative = humanize.Time(bin.ExpiredAt)
+	bin.ExpirationPolicy = ds.ExpirationPolicyDefault
 	bin.Id = h.dao.Bin().GenerateId()
 	bin.GenerateURL(h.config.BaseUrl)
 	data.Bin = *bin
@@ -167,10 +168,12 @@ func (h *HTTP) apiSpec(w http.ResponseWriter, r *http.Request) {
 		ds.Common
 		Bin           ds.Bin `json:"bin"`
 		RequireCookie bool   `json:"-"`
+		ProxyDownload bool   `json:"-"`
 	}
 	var data Data
 	data.Page = "api"
 	data.RequireCookie = h.config.RequireCookie
+	data.ProxyDownload = h.proxyDownloads()
 
 	w.Header().Set("Content-Type", "text/plain")
 	if err := h.renderTemplate(w, "apispec", data); err != nil {
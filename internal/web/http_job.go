@@ -0,0 +1,102 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/processor"
+	"github.com/gorilla/mux"
+)
+
+// viewAdminJobs shows the progress of the content processing jobs, and the
+// jobs that have failed
+func (h *HTTP) viewAdminJobs(w http.ResponseWriter, r *http.Request) {
+	type Data struct {
+		Page    string        `json:"-"`
+		Workers int           `json:"workers"`
+		Stats   []ds.JobStats `json:"stats"`
+		Failed  []ds.Job      `json:"failed"`
+	}
+	data := Data{
+		Page:    "jobs",
+		Workers: h.processor.Concurrency(),
+	}
+
+	stats, err := h.dao.Job().Stats()
+	if err != nil {
+		slog.Error("unable to get job stats", "error", err)
+		http.Error(w, "Errno 2301", http.StatusInternalServerError)
+		return
+	}
+
+	// List every kind of job, including the kinds that have no jobs yet
+	for _, kind := range processor.Kinds {
+		s := ds.JobStats{Kind: kind}
+		for _, stat := range stats {
+			if stat.Kind == kind {
+				s = stat
+			}
+		}
+		s.Outdated, err = h.dao.Job().CountOutdated(kind, processor.Version(kind))
+		if err != nil {
+			slog.Error("unable to count outdated content", "kind", kind, "error", err)
+			http.Error(w, "Errno 2302", http.StatusInternalServerError)
+			return
+		}
+		data.Stats = append(data.Stats, s)
+	}
+
+	failed, err := h.dao.Job().GetFailed(100)
+	if err != nil {
+		slog.Error("unable to get failed jobs", "error", err)
+		http.Error(w, "Errno 2303", http.StatusInternalServerError)
+		return
+	}
+	data.Failed = failed
+
+	if r.Header.Get("accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		out, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			slog.Error("failed to parse json", "error", err)
+			http.Error(w, "Errno 2304", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write(out)
+		return
+	}
+
+	if err := h.renderTemplate(w, "admin_jobs", data); err != nil {
+		slog.Error("failed to execute template", "error", err)
+		http.Error(w, "Errno 2305", http.StatusInternalServerError)
+		return
+	}
+}
+
+// backfillJobs queues jobs of a kind for all content with missing data, or
+// data from an earlier version of the job
+func (h *HTTP) backfillJobs(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	kind := params["kind"]
+
+	version := processor.Version(kind)
+	if version == 0 {
+		http.Error(w, "Unknown job kind", http.StatusNotFound)
+		return
+	}
+
+	count, err := h.dao.Job().EnqueueBackfill(kind, version)
+	if err != nil {
+		slog.Error("unable to queue backfill jobs", "kind", kind, "error", err)
+		http.Error(w, "Errno 2306", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("queued backfill jobs", "kind", kind, "version", version, "count", count)
+
+	// Redirect back to the jobs page
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
@@ -0,0 +1,281 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/processor"
+	"github.com/espebra/filebin2/internal/workspace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func setupJobHandler(t *testing.T) *HTTP {
+	t.Helper()
+
+	dao, s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tearDown(dao) })
+
+	geodb, err := geoip.Init("../../mmdb/GeoLite2-ASN.mmdb", "../../mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatalf("Unable to load geoip database: %s", err)
+	}
+
+	wm, err := workspace.NewManager(os.TempDir(), 4.0)
+	if err != nil {
+		t.Fatalf("Unable to initialize workspace manager: %s", err)
+	}
+
+	c := ds.Config{
+		Expiration:    testExpiredAt,
+		AdminUsername: "admin",
+		AdminPassword: "changeme",
+	}
+
+	metricsRegistry := prometheus.NewRegistry()
+	metrics := ds.NewMetrics("test", metricsRegistry)
+
+	// The jobs are processed by the test, one at a time
+	p := processor.New(&dao, &s3ao)
+	p.Init(1)
+	p.SetMetrics(metrics)
+
+	h := &HTTP{
+		staticBox:       &staticBox,
+		templateBox:     &templateBox,
+		dao:             &dao,
+		s3:              &s3ao,
+		geodb:           &geodb,
+		workspace:       wm,
+		config:          &c,
+		metrics:         metrics,
+		metricsRegistry: metricsRegistry,
+		processor:       p,
+	}
+	if err := h.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { h.Stop() })
+
+	return h
+}
+
+// testImage returns a small PNG image with a gradient, which has a
+// perceptual hash
+func testImage(t *testing.T) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestImageUploadQueuesPHash(t *testing.T) {
+	h := setupJobHandler(t)
+	content := testImage(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/jobbin/image.png", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// The perceptual hash is not computed during the upload
+	fileContent, err := h.dao.FileContent().GetBySHA256(contentSHA256(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.PHash != "" || fileContent.PHashVersion != 0 {
+		t.Errorf("Expected no perceptual hash before the job is processed, got %q", fileContent.PHash)
+	}
+	if fileContent.MimeVersion != processor.MimeVersion {
+		t.Errorf("Expected mime version %d, got %d", processor.MimeVersion, fileContent.MimeVersion)
+	}
+
+	// Each kind of image processing is a separate job
+	for range processor.ImageKinds {
+		if !h.processor.RunOnce() {
+			t.Fatal("Expected a job to process")
+		}
+	}
+	if h.processor.RunOnce() {
+		t.Error("Expected no more jobs to process")
+	}
+
+	fileContent, err = h.dao.FileContent().GetBySHA256(contentSHA256(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.PHash == "" || fileContent.PHashVersion != processor.PHashVersion {
+		t.Errorf("Expected the perceptual hash to be computed, got %q with version %d", fileContent.PHash, fileContent.PHashVersion)
+	}
+
+	// Uploading the same content again does not queue another job
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/jobbin/copy.png", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if h.processor.RunOnce() {
+		t.Error("Expected no job for content that is already hashed")
+	}
+}
+
+func TestMimeJob(t *testing.T) {
+	h := setupJobHandler(t)
+	content := "mime job content"
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/mimejobbin/file.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Pretend that the content type was detected by an earlier version
+	sha256 := contentSHA256(content)
+	if err := h.dao.FileContent().SetMime(sha256, "application/octet-stream", 0); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := h.dao.Job().Enqueue(ds.JobMime, sha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Fatal("Expected the job to be queued")
+	}
+
+	if !h.processor.RunOnce() {
+		t.Fatal("Expected a job to process")
+	}
+	if h.processor.RunOnce() {
+		t.Error("Expected no more jobs to process")
+	}
+
+	stats, err := h.dao.Job().Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stats {
+		if s.Kind == ds.JobMime && (s.Done != 1 || s.Pending != 0 || s.Failed != 0) {
+			t.Errorf("Expected the job to be done, got %+v", s)
+		}
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(sha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.Mime != "text/plain; charset=utf-8" || fileContent.MimeVersion != processor.MimeVersion {
+		t.Errorf("Expected the detected content type with version %d, got %q with version %d", processor.MimeVersion, fileContent.Mime, fileContent.MimeVersion)
+	}
+
+	// The files get the content type from the content
+	file, found, err := h.dao.File().GetByName("mimejobbin", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("Expected to find the file")
+	}
+	if file.Mime != fileContent.Mime {
+		t.Errorf("Expected the file to have content type %q, got %q", fileContent.Mime, file.Mime)
+	}
+}
+
+func TestAdminJobsBackfill(t *testing.T) {
+	h := setupJobHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/jobbackfillbin/file.txt", "backfill content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Pretend that the content was processed by an earlier version
+	if err := h.dao.FileContent().SetMime(contentSHA256("backfill content"), "application/octet-stream", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	viewStats := func() map[string]ds.JobStats {
+		req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+		req.SetBasicAuth("admin", "changeme")
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		var view struct {
+			Workers int           `json:"workers"`
+			Stats   []ds.JobStats `json:"stats"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+			t.Fatal(err)
+		}
+		if view.Workers != 1 {
+			t.Errorf("Expected 1 worker, got %d", view.Workers)
+		}
+		stats := make(map[string]ds.JobStats)
+		for _, s := range view.Stats {
+			stats[s.Kind] = s
+		}
+		return stats
+	}
+
+	if stats := viewStats(); stats[ds.JobMime].Outdated != 1 {
+		t.Errorf("Expected 1 outdated content, got %+v", stats[ds.JobMime])
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/jobs/backfill/"+ds.JobMime, nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, rr.Code)
+	}
+	if stats := viewStats(); stats[ds.JobMime].Pending != 1 {
+		t.Errorf("Expected 1 pending job, got %+v", stats[ds.JobMime])
+	}
+
+	if !h.processor.RunOnce() {
+		t.Fatal("Expected a job to process")
+	}
+	stats := viewStats()
+	if stats[ds.JobMime].Done != 1 || stats[ds.JobMime].Outdated != 0 {
+		t.Errorf("Expected the job to be done and no outdated content, got %+v", stats[ds.JobMime])
+	}
+	fileContent, err := h.dao.FileContent().GetBySHA256(contentSHA256("backfill content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.Mime != "text/plain; charset=utf-8" {
+		t.Errorf("Expected the content type to be detected again, got %q", fileContent.Mime)
+	}
+
+	// Unknown kinds of jobs can not be backfilled
+	req = httptest.NewRequest(http.MethodPost, "/admin/jobs/backfill/unknown", nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// The size limit of metadata request bodies, which leaves room for the
+// longest bin description after JSON encoding
+const maxMetadataBodySize = 65536
+
+// metadataBin returns the bin of a metadata request if its metadata can be
+// changed. The error response is written to the client if it can not.
+func (h *HTTP) metadataBin(w http.ResponseWriter, r *http.Request, inputBin string) (ds.Bin, bool) {
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 3101, http.StatusInternalServerError)
+		return bin, false
+	}
+	if !found {
+		if !h.rejectAlias(w, r, inputBin) {
+			h.Error(w, r, "", "The bin does not exist", 3102, http.StatusNotFound)
+		}
+		return bin, false
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "The bin is no longer available", 3103, http.StatusNotFound)
+		return bin, false
+	}
+	if bin.Readonly {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.Error(w, r, fmt.Sprintf("Rejected metadata update of readonly bin %q", inputBin), "Locked bins can not be modified", 3104, http.StatusMethodNotAllowed)
+		return bin, false
+	}
+	return bin, true
+}
+
+// setBinMetadata changes the title and the description of a bin. Fields that
+// are left out of the request are kept as they are.
+func (h *HTTP) setBinMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, ok := h.metadataBin(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxMetadataBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse metadata request for bin %q: %s", inputBin, err.Error()), "Invalid request body", 3105, http.StatusBadRequest)
+		return
+	}
+	if input.Title != nil {
+		bin.Title = *input.Title
+	}
+	if input.Description != nil {
+		bin.Description = *input.Description
+	}
+	if err := h.dao.Bin().ValidateInput(&bin); err != nil {
+		h.Error(w, r, "", err.Error(), 3106, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dao.Bin().UpdateMetadata(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update metadata of bin %q: %s", inputBin, err.Error()), "Database error", 3107, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set bin metadata", "bin", inputBin, "title", bin.Title, "description_bytes", len(bin.Description))
+
+	out, err := json.MarshalIndent(bin, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 3108, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+// setFileMetadata changes the description and the tags of a file. Fields
+// that are left out of the request are kept as they are.
+func (h *HTTP) setFileMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFilename := params["filename"]
+
+	if _, ok := h.metadataBin(w, r, inputBin); !ok {
+		return
+	}
+
+	file, found, err := h.dao.File().GetByName(inputBin, inputFilename)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select file %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Database error", 3109, http.StatusInternalServerError)
+		return
+	}
+	if !found || !file.IsReadable() {
+		h.Error(w, r, "", "The file does not exist", 3110, http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		Description *string   `json:"description"`
+		Tags        *[]string `json:"tags"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxMetadataBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse metadata request for file %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Invalid request body", 3111, http.StatusBadRequest)
+		return
+	}
+	if input.Description != nil {
+		file.Description = *input.Description
+	}
+	if input.Tags != nil {
+		file.Tags = *input.Tags
+	}
+	if err := h.dao.File().ValidateInput(&file); err != nil {
+		h.Error(w, r, "", err.Error(), 3112, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dao.File().UpdateMetadata(&file); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update metadata of file %q in bin %q: %s", inputFilename, inputBin, err.Error()), "Database error", 3113, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set file metadata", "bin", inputBin, "filename", inputFilename, "tags", file.Tags, "description_bytes", len(file.Description))
+
+	out, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to parse json: %s", err.Error()), "Parse error", 3114, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
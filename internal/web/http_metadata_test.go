@@ -0,0 +1,154 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func metadataRequest(h *HTTP, path string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Owner-Token", token)
+	}
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSetBinMetadata(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.RequireOwnerToken = true
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/metadatabin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	token := rr.Header().Get("Owner-Token")
+
+	tests := []struct {
+		description string
+		token       string
+		body        string
+		statusCode  int
+
+		expectedTitle       string
+		expectedDescription string
+	}{
+		{"without owner token", "", `{"title": "Photos"}`, http.StatusForbidden, "", ""},
+		{"title and description", token, `{"title": " Photos ", "description": "From the *trip*"}`, http.StatusOK, "Photos", "From the *trip*"},
+		{"title only", token, `{"title": "Trip"}`, http.StatusOK, "Trip", "From the *trip*"},
+		{"remove description", token, `{"description": ""}`, http.StatusOK, "Trip", ""},
+		{"title with newline", token, `{"title": "two\nlines"}`, http.StatusBadRequest, "", ""},
+		{"unknown field", token, `{"name": "Trip"}`, http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rr := metadataRequest(h, "/metadata/metadatabin", test.token, test.body)
+			if rr.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+			if test.statusCode != http.StatusOK {
+				return
+			}
+
+			var bin ds.Bin
+			if err := json.Unmarshal(rr.Body.Bytes(), &bin); err != nil {
+				t.Fatalf("Unable to parse response: %s", err)
+			}
+			if bin.Title != test.expectedTitle {
+				t.Errorf("Expected title %q, got %q", test.expectedTitle, bin.Title)
+			}
+			if bin.Description != test.expectedDescription {
+				t.Errorf("Expected description %q, got %q", test.expectedDescription, bin.Description)
+			}
+		})
+	}
+
+	// The bin page shows the description as sanitized HTML
+	rr = metadataRequest(h, "/metadata/metadatabin", token, `{"description": "**bold** <script>alert(1)</script>"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metadatabin", nil))
+	if !strings.Contains(rr.Body.String(), "<strong>bold</strong> &lt;script&gt;") {
+		t.Errorf("Expected the rendered description on the bin page")
+	}
+	if strings.Contains(rr.Body.String(), "<script>alert(1)") {
+		t.Errorf("Expected the description to be sanitized")
+	}
+}
+
+func TestSetFileMetadata(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/filemetadatabin/photos/photo.jpg", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	rr = metadataRequest(h, "/metadata/filemetadatabin/photos/photo.jpg", "", `{"description": "The view", "tags": ["Holiday", "#mountains"]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var file ds.File
+	if err := json.Unmarshal(rr.Body.Bytes(), &file); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if file.Description != "The view" {
+		t.Errorf("Expected description %q, got %q", "The view", file.Description)
+	}
+	if strings.Join(file.Tags, ",") != "holiday,mountains" {
+		t.Errorf("Expected tags holiday and mountains, got %q", file.Tags)
+	}
+
+	tests := []struct {
+		description string
+		path        string
+		body        string
+		statusCode  int
+	}{
+		{"invalid tag", "/metadata/filemetadatabin/photos/photo.jpg", `{"tags": ["two words"]}`, http.StatusBadRequest},
+		{"missing file", "/metadata/filemetadatabin/missing.jpg", `{"tags": ["holiday"]}`, http.StatusNotFound},
+		{"missing bin", "/metadata/missingmetadatabin/photo.jpg", `{"tags": ["holiday"]}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rr := metadataRequest(h, test.path, "", test.body)
+			if rr.Code != test.statusCode {
+				t.Errorf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// The plain text listing includes the metadata as comments
+	rr = metadataRequest(h, "/metadata/filemetadatabin", "", `{"title": "Holiday"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/filemetadatabin.txt", nil))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	expected := []string{"# Holiday", "# The view", "# Tags: holiday, mountains"}
+	if len(lines) != 4 || strings.Join(lines[:3], "\n") != strings.Join(expected, "\n") || !strings.HasSuffix(lines[3], "/filemetadatabin/photos/photo.jpg") {
+		t.Errorf("Unexpected plain text listing:\n%s", rr.Body.String())
+	}
+
+	// Locked bins can not be modified
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/filemetadatabin", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = metadataRequest(h, "/metadata/filemetadatabin/photos/photo.jpg", "", `{"tags": []}`)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusMethodNotAllowed, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// The owner token of a bin is handed out to the client that creates the bin,
+// and is accepted in this request header or cookie.
+const (
+	ownerTokenHeader = "Owner-Token"
+	ownerTokenCookie = "owner-token"
+)
+
+// ownerTokenCookieName returns the name of the owner token cookie of a bin.
+// The cookie is sent with every request to the service, including the ones
+// that do not have the bin in the path, such as /password/{bin}, so each bin
+// has a cookie of its own.
+func ownerTokenCookieName(bin string) string {
+	return ownerTokenCookie + "-" + bin
+}
+
+// setOwnerToken hands out the owner token of a newly created bin to the
+// client, in a response header for API clients and in a cookie for browsers.
+func (h *HTTP) setOwnerToken(w http.ResponseWriter, bin *ds.Bin) {
+	w.Header().Set(ownerTokenHeader, bin.OwnerToken)
+
+	cookie := http.Cookie{}
+	cookie.Name = ownerTokenCookieName(bin.Id)
+	cookie.Value = bin.OwnerToken
+	cookie.Expires = bin.ExpiredAt
+	cookie.Secure = h.config.BaseUrl.Scheme == "https"
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteStrictMode
+	cookie.Path = path.Join("/", h.config.BaseUrl.Path)
+	http.SetCookie(w, &cookie)
+}
+
+// isBinOwner returns true if the request is allowed to perform destructive
+// operations on the bin.
+func (h *HTTP) isBinOwner(r *http.Request, bin *ds.Bin) bool {
+	if !h.config.RequireOwnerToken {
+		return true
+	}
+
+	// Bins created before owner tokens were introduced have no owner
+	if !bin.HasOwner() {
+		return true
+	}
+
+	token := r.Header.Get(ownerTokenHeader)
+	if token == "" {
+		if cookie, err := r.Cookie(ownerTokenCookieName(bin.Id)); err == nil {
+			token = cookie.Value
+		} else if cookie, err := r.Cookie(ownerTokenCookie); err == nil {
+			// Cookies handed out before each bin had a cookie of its
+			// own are scoped to the path of the bin
+			token = cookie.Value
+		}
+	}
+	return bin.IsOwnerToken(token)
+}
+
+// binOwner rejects requests that do not carry the owner token of the bin.
+// Requests to bins that do not exist are passed on to the handler.
+func (h *HTTP) binOwner(fn func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.config.RequireOwnerToken {
+			fn(w, r)
+			return
+		}
+
+		params := mux.Vars(r)
+		inputBin := params["bin"]
+
+		bin, found, err := h.dao.Bin().GetByID(inputBin)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 1701, http.StatusInternalServerError)
+			return
+		}
+		if found && !h.isBinOwner(r, &bin) {
+			h.Error(w, r, fmt.Sprintf("Rejected %s request to bin %q without a valid owner token", r.Method, inputBin), "Only the owner of the bin is allowed to do this", 1702, http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
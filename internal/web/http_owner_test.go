@@ -0,0 +1,255 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func ownerRequest(method, url, content, token string, cookie *http.Cookie) (*http.Response, []byte, error) {
+	var body io.Reader
+	if content != "" {
+		body = strings.NewReader(content)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token != "" {
+		req.Header.Set("Owner-Token", token)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, err := io.ReadAll(resp.Body)
+	return resp, out, err
+}
+
+func TestOwnerToken(t *testing.T) {
+	testConfig.RequireOwnerToken = true
+	defer func() { testConfig.RequireOwnerToken = false }()
+
+	bin := "ownertoken01"
+	binURL := "http://localhost:8080/" + bin
+
+	resp, body, err := ownerRequest("POST", binURL+"/first.txt", "first file", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	token := resp.Header.Get("Owner-Token")
+	if token == "" {
+		t.Fatal("Expected an owner token when creating the bin")
+	}
+	var data struct {
+		Bin ds.Bin `json:"bin"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Bin.OwnerToken != token {
+		t.Errorf("Expected owner token %q in the response body, got %q", token, data.Bin.OwnerToken)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "owner-token-"+bin {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("Expected an owner token cookie when creating the bin")
+	}
+	if cookie.Value != token || cookie.Path != "/" {
+		t.Errorf("Unexpected owner token cookie: %s", cookie.String())
+	}
+
+	// Uploads to the existing bin do not get the owner token
+	resp, body, err = ownerRequest("POST", binURL+"/second.txt", "second file", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	if resp.Header.Get("Owner-Token") != "" {
+		t.Error("Did not expect an owner token when uploading to an existing bin")
+	}
+	if strings.Contains(string(body), token) {
+		t.Error("Did not expect the owner token in the response body when uploading to an existing bin")
+	}
+
+	tcs := []struct {
+		description string
+		method      string
+		url         string
+		token       string
+		cookie      *http.Cookie
+		statusCode  int
+	}{
+		{
+			description: "Delete file without owner token",
+			method:      "DELETE",
+			url:         binURL + "/first.txt",
+			statusCode:  http.StatusForbidden,
+		},
+		{
+			description: "Delete file with wrong owner token",
+			method:      "DELETE",
+			url:         binURL + "/first.txt",
+			token:       strings.Repeat("0", len(token)),
+			statusCode:  http.StatusForbidden,
+		},
+		{
+			description: "Delete file with owner token cookie",
+			method:      "DELETE",
+			url:         binURL + "/first.txt",
+			cookie:      &http.Cookie{Name: "owner-token-" + bin, Value: token},
+			statusCode:  http.StatusOK,
+		},
+		{
+			description: "Lock bin without owner token",
+			method:      "PUT",
+			url:         binURL,
+			statusCode:  http.StatusForbidden,
+		},
+		{
+			description: "Lock bin with owner token",
+			method:      "PUT",
+			url:         binURL,
+			token:       token,
+			statusCode:  http.StatusOK,
+		},
+		{
+			description: "Delete bin without owner token",
+			method:      "DELETE",
+			url:         binURL,
+			statusCode:  http.StatusForbidden,
+		},
+		{
+			description: "Delete bin with owner token",
+			method:      "DELETE",
+			url:         binURL,
+			token:       token,
+			statusCode:  http.StatusOK,
+		},
+	}
+	for i, tc := range tcs {
+		resp, body, err := ownerRequest(tc.method, tc.url, "", tc.token, tc.cookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.statusCode {
+			t.Errorf("Test case %d (%s): Expected status %d, got %d: %s", i, tc.description, tc.statusCode, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestOwnerTokenAdminDelete(t *testing.T) {
+	testConfig.RequireOwnerToken = true
+	defer func() { testConfig.RequireOwnerToken = false }()
+
+	bin := "ownertoken02"
+	resp, body, err := ownerRequest("POST", "http://localhost:8080/"+bin+"/file.txt", "content", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	// Administrators can delete bins without the owner token
+	statusCode, body2, err := httpAdminRequest("DELETE", "/admin/bin/"+bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, statusCode, body2)
+	}
+}
+
+func TestOwnerTokenDisabled(t *testing.T) {
+	bin := "ownertoken03"
+	resp, body, err := ownerRequest("POST", "http://localhost:8080/"+bin+"/file.txt", "content", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	// Anyone can delete the bin when owner tokens are not required
+	resp, body, err = ownerRequest("DELETE", "http://localhost:8080/"+bin, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+}
+
+func TestOwnerTokenCookie(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.RequireOwnerToken = true
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/ownercookiebin/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	cookies := rr.Result().Cookies()
+
+	// The bin page, and the pages that change the bin, get the cookie that
+	// the upload sets, as they would in a browser
+	ownerRequest := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, cookie := range cookies {
+			if strings.HasPrefix(path, cookie.Path) {
+				req.AddCookie(cookie)
+			}
+		}
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, "/expiration/ownercookiebin", `{"expiration": "2h"}`, http.StatusOK},
+		{http.MethodPut, "/metadata/ownercookiebin", `{"title": "Title"}`, http.StatusOK},
+		{http.MethodPost, "/copy/ownercookiebin/file.txt", `{"filename": "copy.txt"}`, http.StatusCreated},
+		{http.MethodPost, "/move/ownercookiebin/copy.txt", `{"filename": "moved.txt"}`, http.StatusCreated},
+		{http.MethodDelete, "/ownercookiebin/moved.txt", "", http.StatusOK},
+		{http.MethodPut, "/password/ownercookiebin", `{"password": "secret"}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		if code := ownerRequest(test.method, test.path, test.body); code != test.status {
+			t.Errorf("Expected status %d for %s %s, got %d", test.status, test.method, test.path, code)
+		}
+	}
+
+	// The cookie of one bin is not accepted by another bin
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/ownercookiebin2/file.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if code := ownerRequest(http.MethodPut, "/expiration/ownercookiebin2", `{"expiration": "2h"}`); code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, code)
+	}
+}
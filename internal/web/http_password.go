@@ -0,0 +1,456 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// Password protected bins are unlocked either with the password in this
+// request header, or with a session cookie that browsers get when the
+// password is entered in the password prompt.
+const (
+	binPasswordHeader      = "Bin-Password"
+	binSessionCookiePrefix = "bin-session-"
+	binSessionLifetime     = 24 * time.Hour
+
+	// Password attempts are counted within this window, and the attempts
+	// and verified passwords that have expired are pruned at this interval
+	passwordAttemptWindow = 15 * time.Minute
+
+	// Passwords that are verified are accepted without deriving the hash
+	// again for this long, so that API clients sending the password with
+	// every request do not run the key derivation for each of them
+	passwordVerifiedLifetime = 15 * time.Minute
+
+	// Upper limit of the password length, to bound the hashing cost
+	passwordMaxLength = 1024
+)
+
+// attemptWindow counts password attempts since start
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// initSessionKey sets the key used to sign bin sessions. A random key is
+// used if no secret is configured, in which case sessions do not survive
+// restarts.
+func (h *HTTP) initSessionKey() error {
+	if h.config.SessionSecret != "" {
+		h.sessionKey = []byte(h.config.SessionSecret)
+		return nil
+	}
+	h.sessionKey = make([]byte, 32)
+	_, err := rand.Read(h.sessionKey)
+	return err
+}
+
+// binSessionSignature signs a session for the bin. The password hash is
+// part of the signature, so changing the password ends existing sessions.
+func (h *HTTP) binSessionSignature(bin *ds.Bin, expires int64) string {
+	mac := hmac.New(sha256.New, h.sessionKey)
+	_, _ = fmt.Fprintf(mac, "%s\n%d\n%s", bin.Id, expires, bin.PasswordHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setBinSession hands out a signed session cookie for a password protected
+// bin. The cookie is named after inputBin, which is the id the client used
+// to request the bin and may be an alias.
+func (h *HTTP) setBinSession(w http.ResponseWriter, inputBin string, bin *ds.Bin) {
+	expiresAt := time.Now().Add(binSessionLifetime)
+	if bin.ExpiredAt.Before(expiresAt) {
+		expiresAt = bin.ExpiredAt
+	}
+	expires := expiresAt.Unix()
+
+	cookie := http.Cookie{}
+	cookie.Name = binSessionCookiePrefix + inputBin
+	cookie.Value = fmt.Sprintf("%d.%s", expires, h.binSessionSignature(bin, expires))
+	cookie.Expires = expiresAt
+	cookie.Secure = h.config.BaseUrl.Scheme == "https"
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.Path = path.Join("/", h.config.BaseUrl.Path)
+	http.SetCookie(w, &cookie)
+}
+
+// hasBinSession returns true if the request carries a valid session for the
+// bin
+func (h *HTTP) hasBinSession(r *http.Request, inputBin string, bin *ds.Bin) bool {
+	cookie, err := r.Cookie(binSessionCookiePrefix + inputBin)
+	if err != nil {
+		return false
+	}
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(h.binSessionSignature(bin, expires)))
+}
+
+// passwordRetryAfter returns how long to wait before the client can try a
+// password again. Zero means that an attempt is allowed.
+func (h *HTTP) passwordRetryAfter(ip string) time.Duration {
+	if h.config.LimitPasswordAttempts <= 0 {
+		return 0
+	}
+
+	h.passwordAttemptsMutex.Lock()
+	defer h.passwordAttemptsMutex.Unlock()
+
+	attempts, ok := h.passwordAttempts[ip]
+	if !ok || attempts.count < h.config.LimitPasswordAttempts {
+		return 0
+	}
+	return max(passwordAttemptWindow-time.Since(attempts.start), 0)
+}
+
+// registerPasswordAttempt counts a password attempt for the client. The
+// attempts are counted from the start of the window, and start over once
+// the window has passed.
+func (h *HTTP) registerPasswordAttempt(ip string) {
+	if h.config.LimitPasswordAttempts <= 0 {
+		return
+	}
+
+	h.passwordAttemptsMutex.Lock()
+	defer h.passwordAttemptsMutex.Unlock()
+
+	if h.passwordAttempts == nil {
+		h.passwordAttempts = make(map[string]*attemptWindow)
+	}
+
+	now := time.Now()
+	attempts, ok := h.passwordAttempts[ip]
+	if !ok || now.Sub(attempts.start) >= passwordAttemptWindow {
+		attempts = &attemptWindow{start: now}
+		h.passwordAttempts[ip] = attempts
+	}
+	attempts.count++
+}
+
+// prunePasswordAttempts forgets the password attempts and the verified
+// passwords that have expired
+func (h *HTTP) prunePasswordAttempts() {
+	h.passwordAttemptsMutex.Lock()
+	defer h.passwordAttemptsMutex.Unlock()
+
+	now := time.Now()
+	for ip, attempts := range h.passwordAttempts {
+		if now.Sub(attempts.start) >= passwordAttemptWindow {
+			delete(h.passwordAttempts, ip)
+		}
+	}
+	for key, verifiedAt := range h.verifiedPasswords {
+		if now.Sub(verifiedAt) >= passwordVerifiedLifetime {
+			delete(h.verifiedPasswords, key)
+		}
+	}
+}
+
+// startPasswordAttemptsPruner starts a background goroutine that prunes the
+// password attempts at an interval
+func (h *HTTP) startPasswordAttemptsPruner() {
+	go func() {
+		ticker := time.NewTicker(passwordAttemptWindow)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.prunePasswordAttempts()
+			case <-h.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// passwordVerifiedKey identifies a password that is verified for a bin. The
+// password hash of the bin is part of the key, so changing the password
+// ends the verification, and the password itself is not kept.
+func (h *HTTP) passwordVerifiedKey(bin *ds.Bin, password string) string {
+	mac := hmac.New(sha256.New, h.sessionKey)
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s", bin.Id, bin.PasswordHash, password)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// passwordVerified returns true if the password was verified for the bin
+// recently
+func (h *HTTP) passwordVerified(key string) bool {
+	h.passwordAttemptsMutex.Lock()
+	defer h.passwordAttemptsMutex.Unlock()
+	verifiedAt, ok := h.verifiedPasswords[key]
+	return ok && time.Since(verifiedAt) < passwordVerifiedLifetime
+}
+
+// registerPasswordVerified remembers that the password was verified for the
+// bin
+func (h *HTTP) registerPasswordVerified(key string) {
+	h.passwordAttemptsMutex.Lock()
+	defer h.passwordAttemptsMutex.Unlock()
+
+	if h.verifiedPasswords == nil {
+		h.verifiedPasswords = make(map[string]time.Time)
+	}
+	h.verifiedPasswords[key] = time.Now()
+}
+
+// verifyBinPassword checks a password against the bin, unless the client
+// has too many attempts. In that case the time to wait is returned.
+// Passwords that were verified recently are accepted without deriving the
+// hash again. Every other check counts as an attempt for the client, as the
+// derivation is expensive by design. Attempts are not limited per bin, as
+// anyone could then lock the visitors out of a bin by failing on purpose.
+func (h *HTTP) verifyBinPassword(r *http.Request, bin *ds.Bin, password string) (bool, time.Duration) {
+	ip, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	key := h.passwordVerifiedKey(bin, password)
+	if h.passwordVerified(key) {
+		return true, 0
+	}
+	if wait := h.passwordRetryAfter(ip); wait > 0 {
+		slog.Warn("too many password attempts", "bin", bin.Id, "ip", ip)
+		return false, wait
+	}
+	h.registerPasswordAttempt(ip)
+	if bin.IsPassword(password) {
+		h.registerPasswordVerified(key)
+		return true, 0
+	}
+	slog.Warn("incorrect bin password", "bin", bin.Id, "ip", ip)
+	return false, 0
+}
+
+// setRetryAfter tells the client when to retry the request
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// binUnlocked returns true if the client is allowed to access the contents
+// of the bin. That is the case if the bin is not password protected, or if
+// the client provides the password or a session for the bin. Otherwise,
+// browsers get the password prompt and other clients get an error.
+func (h *HTTP) binUnlocked(w http.ResponseWriter, r *http.Request, inputBin string, bin *ds.Bin) bool {
+	if !bin.HasPassword() {
+		return true
+	}
+	if h.hasBinSession(r, inputBin, bin) {
+		return true
+	}
+
+	password := r.Header.Get(binPasswordHeader)
+	if password == "" {
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			h.passwordPrompt(w, r, inputBin, "", "", http.StatusUnauthorized)
+		} else {
+			h.Error(w, r, "", "This bin is password protected", 1901, http.StatusUnauthorized)
+		}
+		return false
+	}
+
+	ok, wait := h.verifyBinPassword(r, bin, password)
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		h.Error(w, r, "", "Too many failed password attempts, please retry later", 1902, http.StatusTooManyRequests)
+		return false
+	}
+	if !ok {
+		h.Error(w, r, "", "Incorrect password", 1903, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// passwordPrompt asks the client for the password of a bin. The client is
+// sent to next when the bin is unlocked.
+func (h *HTTP) passwordPrompt(w http.ResponseWriter, r *http.Request, inputBin string, next string, text string, statusCode int) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	type Data struct {
+		ds.Common
+		BinId   string
+		Action  string
+		NextUrl string
+		Text    string
+	}
+	var data Data
+	data.Contact = h.config.Contact
+	data.BaseUrl = h.config.BaseUrl.String()
+	data.BinId = inputBin
+	data.Action = path.Join("/", h.config.BaseUrl.Path, "unlock", inputBin)
+	data.NextUrl = next
+	if data.NextUrl == "" {
+		data.NextUrl = path.Join("/", h.config.BaseUrl.Path, r.URL.Path)
+		if r.URL.RawQuery != "" {
+			data.NextUrl += "?" + r.URL.RawQuery
+		}
+	}
+	data.Text = text
+
+	// Disregard any request body there is
+	_, _ = io.Copy(io.Discard, r.Body)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := h.renderTemplate(w, "password", data); err != nil {
+		slog.Error("failed to execute template", "error", err)
+	}
+}
+
+// unlockBin handles the password prompt, and hands out a session cookie for
+// the bin if the password is correct
+func (h *HTTP) unlockBin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	password := r.PostFormValue("password")
+
+	// Only redirect to local paths
+	next := r.PostFormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = path.Join("/", h.config.BaseUrl.Path, inputBin)
+	}
+
+	_, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 1904, http.StatusInternalServerError)
+		return
+	}
+	if !found || !bin.IsReadable() {
+		h.Error(w, r, "", "The bin does not exist.", 1905, http.StatusNotFound)
+		return
+	}
+
+	if bin.HasPassword() {
+		ok, wait := h.verifyBinPassword(r, &bin, password)
+		if wait > 0 {
+			setRetryAfter(w, wait)
+			h.passwordPrompt(w, r, inputBin, next, "Too many failed attempts. Please retry later.", http.StatusTooManyRequests)
+			return
+		}
+		if !ok {
+			h.passwordPrompt(w, r, inputBin, next, "Incorrect password.", http.StatusUnauthorized)
+			return
+		}
+		h.setBinSession(w, inputBin, &bin)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// passwordBin returns the bin to manage the password of. The error response
+// is written to the client if the bin is not available.
+func (h *HTTP) passwordBin(w http.ResponseWriter, r *http.Request, inputBin string) (ds.Bin, bool) {
+	bin, found, err := h.dao.Bin().GetByID(inputBin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", inputBin, err.Error()), "Database error", 1906, http.StatusInternalServerError)
+		return bin, false
+	}
+	if !found {
+		h.Error(w, r, "", "The bin does not exist.", 1907, http.StatusNotFound)
+		return bin, false
+	}
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "This bin is no longer available.", 1908, http.StatusNotFound)
+		return bin, false
+	}
+	return bin, true
+}
+
+// setPassword protects a bin with a password, or changes the password
+func (h *HTTP) setPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, ok := h.passwordBin(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 4096))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to parse password request for bin %q: %s", inputBin, err.Error()), "Invalid request body", 1909, http.StatusBadRequest)
+		return
+	}
+	if input.Password == "" || len(input.Password) > passwordMaxLength {
+		h.Error(w, r, "", fmt.Sprintf("The password must be between 1 and %d bytes long", passwordMaxLength), 1910, http.StatusBadRequest)
+		return
+	}
+
+	if err := bin.SetPassword(input.Password); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to hash password for bin %q: %s", inputBin, err.Error()), "Internal error", 1911, http.StatusInternalServerError)
+		return
+	}
+	if err := h.dao.Bin().UpdatePassword(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update password of bin %q: %s", inputBin, err.Error()), "Database error", 1912, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("set bin password", "bin", inputBin)
+
+	// The client that set the password keeps access to the bin
+	h.setBinSession(w, inputBin, &bin)
+	http.Error(w, "Password set successfully", http.StatusOK)
+}
+
+// deletePassword removes the password protection of a bin
+func (h *HTTP) deletePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	bin, ok := h.passwordBin(w, r, inputBin)
+	if !ok {
+		return
+	}
+	if !bin.HasPassword() {
+		h.Error(w, r, "", "The bin is not password protected", 1913, http.StatusNotFound)
+		return
+	}
+
+	if err := bin.SetPassword(""); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to remove password of bin %q: %s", inputBin, err.Error()), "Internal error", 1914, http.StatusInternalServerError)
+		return
+	}
+	if err := h.dao.Bin().UpdatePassword(&bin); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to update password of bin %q: %s", inputBin, err.Error()), "Database error", 1915, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("removed bin password", "bin", inputBin)
+	http.Error(w, "Password removed successfully", http.StatusOK)
+}
@@ -0,0 +1,429 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// passwordRequest sends a request with the given bin password, session
+// cookie and accept header, without following redirects
+func passwordRequest(method, url, content, password string, cookie *http.Cookie, accept string) (*http.Response, []byte, error) {
+	var body io.Reader
+	if content != "" {
+		body = strings.NewReader(content)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if password != "" {
+		req.Header.Set("Bin-Password", password)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	req.Close = true
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	out, err := io.ReadAll(resp.Body)
+	return resp, out, err
+}
+
+// binSessionCookie returns the session cookie of the bin set in the response
+func binSessionCookie(resp *http.Response, bin string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == "bin-session-"+bin {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestBinPassword(t *testing.T) {
+	bin := "passwordbin01"
+	binURL := "http://localhost:8080/" + bin
+
+	resp, body, err := passwordRequest("POST", binURL+"/secret.txt", "confidential content", "s3cret", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	if binSessionCookie(resp, bin) == nil {
+		t.Error("Expected the client that created the bin to get a session")
+	}
+
+	// The contents of the bin are not available without the password
+	urls := []string{
+		binURL,
+		binURL + "/secret.txt",
+		binURL + ".txt",
+		"http://localhost:8080/sha256/" + bin,
+		"http://localhost:8080/qr/" + bin,
+		"http://localhost:8080/archive/" + bin + "/zip",
+	}
+	for _, u := range urls {
+		resp, body, err := passwordRequest("GET", u, "", "", nil, "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d from %s without password, got %d", http.StatusUnauthorized, u, resp.StatusCode)
+		}
+		if strings.Contains(string(body), "confidential content") || strings.Contains(string(body), "secret.txt") {
+			t.Errorf("Did not expect %s to reveal the contents of the bin: %s", u, body)
+		}
+
+		resp, _, err = passwordRequest("GET", u, "", "wrong", nil, "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d from %s with the wrong password, got %d", http.StatusUnauthorized, u, resp.StatusCode)
+		}
+
+		resp, _, err = passwordRequest("GET", u, "", "s3cret", nil, "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusFound {
+			t.Errorf("Expected access to %s with the password, got %d", u, resp.StatusCode)
+		}
+	}
+
+	// Browsers get the password prompt
+	resp, body, err = passwordRequest("GET", binURL, "", "", nil, "text/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "/unlock/"+bin) {
+		t.Errorf("Expected the password prompt, got: %s", body)
+	}
+
+	// The prompt rejects the wrong password
+	form := url.Values{"password": {"wrong"}, "next": {"/" + bin}}
+	req, err := http.NewRequest("POST", "http://localhost:8080/unlock/"+bin, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Close = true
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with the wrong password, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if binSessionCookie(resp, bin) != nil {
+		t.Error("Did not expect a session with the wrong password")
+	}
+
+	// The prompt hands out a session with the correct password, and only
+	// redirects to local paths
+	form = url.Values{"password": {"s3cret"}, "next": {"https://example.com/"}}
+	req, err = http.NewRequest("POST", "http://localhost:8080/unlock/"+bin, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Close = true
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected status %d with the correct password, got %d", http.StatusSeeOther, resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "/"+bin {
+		t.Errorf("Expected redirect to the bin, got %q", location)
+	}
+	session := binSessionCookie(resp, bin)
+	if session == nil {
+		t.Fatal("Expected a session with the correct password")
+	}
+
+	resp, body, err = passwordRequest("GET", binURL, "", "", session, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "secret.txt") {
+		t.Errorf("Expected access to the bin with the session, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Tampered sessions are rejected
+	tampered := &http.Cookie{Name: session.Name, Value: "9999999999." + strings.Repeat("0", 64)}
+	resp, _, err = passwordRequest("GET", binURL, "", "", tampered, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with a tampered session, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Changing the password ends existing sessions
+	resp, body, err = ownerRequest("PUT", "http://localhost:8080/password/"+bin, `{"password": "n3w"}`, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d when changing the password, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	resp, _, err = passwordRequest("GET", binURL, "", "", session, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with a session for the old password, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	resp, _, err = passwordRequest("GET", binURL, "", "n3w", nil, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected access to the bin with the new password, got %d", resp.StatusCode)
+	}
+
+	// Removing the password opens the bin to everyone
+	resp, body, err = ownerRequest("DELETE", "http://localhost:8080/password/"+bin, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d when removing the password, got %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	resp, _, err = passwordRequest("GET", binURL, "", "", nil, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected access to the bin without password, got %d", resp.StatusCode)
+	}
+	resp, _, err = ownerRequest("DELETE", "http://localhost:8080/password/"+bin, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d when removing a missing password, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestBinPasswordInvalidRequests(t *testing.T) {
+	bin := "passwordbin02"
+	statusCode, body, err := httpRequest(TestCase{Method: "POST", Bin: bin, Filename: "a.txt", UploadContent: "content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, statusCode, body)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		content string
+		want    int
+	}{
+		{
+			name:    "missing body",
+			url:     "http://localhost:8080/password/" + bin,
+			content: "",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "empty password",
+			url:     "http://localhost:8080/password/" + bin,
+			content: `{"password": ""}`,
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "unknown field",
+			url:     "http://localhost:8080/password/" + bin,
+			content: `{"passphrase": "secret"}`,
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "missing bin",
+			url:     "http://localhost:8080/password/passwordmissing",
+			content: `{"password": "secret"}`,
+			want:    http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body, err := ownerRequest("PUT", tt.url, tt.content, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestBinPasswordAttempts(t *testing.T) {
+	testConfig.LimitPasswordAttempts = 2
+	defer func() { testConfig.LimitPasswordAttempts = 0 }()
+
+	bin := "passwordbin03"
+	binURL := "http://localhost:8080/" + bin
+
+	resp, body, err := passwordRequest("POST", binURL+"/a.txt", "content", "s3cret", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, _, err := passwordRequest("GET", binURL, "", "wrong", nil, "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d on attempt %d, got %d", http.StatusUnauthorized, i+1, resp.StatusCode)
+		}
+	}
+
+	// Further attempts are rejected, also with the correct password
+	for _, password := range []string{"wrong", "s3cret"} {
+		resp, _, err := passwordRequest("GET", binURL, "", password, nil, "application/json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Error("Expected the Retry-After response header")
+		}
+	}
+
+	// Uploads do not require the password
+	resp, _, err = passwordRequest("POST", binURL+"/b.txt", "content", "", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected uploads to be allowed, got %d", resp.StatusCode)
+	}
+}
+
+func TestBinPasswordDerivations(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitPasswordAttempts = 2
+
+	for _, bin := range []string{"derivationbin1", "derivationbin2", "derivationbin3"} {
+		req := uploadRequest("/"+bin+"/file.txt", "some content")
+		req.Header.Set("Bin-Password", "s3cret")
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+	passwordStatus := func(bin string) int {
+		req := httptest.NewRequest(http.MethodGet, "/"+bin+"/file.txt", nil)
+		req.Header.Set("Bin-Password", "s3cret")
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The verified password is accepted again without another check
+	for i := 0; i < 5; i++ {
+		if code := passwordStatus("derivationbin1"); code != http.StatusOK {
+			t.Errorf("Expected status %d on request %d, got %d", http.StatusOK, i+1, code)
+		}
+	}
+
+	// Every check counts for the client, also when the password is correct
+	if code := passwordStatus("derivationbin2"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := passwordStatus("derivationbin3"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// Passwords that were verified are still accepted
+	if code := passwordStatus("derivationbin1"); code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+	}
+}
+
+func TestBinPasswordLockout(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitPasswordAttempts = 2
+
+	req := uploadRequest("/lockoutbin/file.txt", "some content")
+	req.Header.Set("Bin-Password", "s3cret")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	passwordStatus := func(remoteAddr string, password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/lockoutbin/file.txt", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Bin-Password", password)
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := passwordStatus("192.0.2.10:1234", "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d on attempt %d, got %d", http.StatusUnauthorized, i+1, code)
+		}
+	}
+	if code := passwordStatus("192.0.2.10:1234", "s3cret"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	// Failed attempts from one client do not lock other clients out
+	if code := passwordStatus("192.0.2.11:1234", "s3cret"); code != http.StatusOK {
+		t.Errorf("Expected status %d for another client, got %d", http.StatusOK, code)
+	}
+
+	// Attempts that have expired are pruned
+	h.passwordAttemptsMutex.Lock()
+	h.passwordAttempts["192.0.2.10"].start = time.Now().Add(-passwordAttemptWindow)
+	h.passwordAttemptsMutex.Unlock()
+	h.prunePasswordAttempts()
+	if _, ok := h.passwordAttempts["192.0.2.10"]; ok {
+		t.Error("Expected the expired attempts to be pruned")
+	}
+	if code := passwordStatus("192.0.2.10:1234", "s3cret"); code != http.StatusOK {
+		t.Errorf("Expected status %d once the attempts have expired, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,196 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// The rules of the access policy are kept in the database, and each instance
+// reloads them at this interval. Changes made from the admin pages apply
+// immediately on the instance that made them.
+const policyReloadInterval = 30 * time.Second
+
+// policyContextKey is the key of the access policy decision in the request
+// context
+type policyContextKey struct{}
+
+// activePolicies returns the rules of the access policy that have not
+// expired, reloading them from the database when they are outdated. The
+// previous rules are kept if the reload fails.
+func (h *HTTP) activePolicies() []ds.Policy {
+	h.policiesMutex.Lock()
+	defer h.policiesMutex.Unlock()
+
+	if time.Since(h.policiesLoadedAt) < policyReloadInterval {
+		return h.policies
+	}
+	policies, err := h.dao.Policy().GetActive()
+	if err != nil {
+		slog.Error("unable to load the access policy", "error", err)
+		return h.policies
+	}
+	h.policies = policies
+	h.policiesLoadedAt = time.Now()
+	return h.policies
+}
+
+// reloadPolicies makes the next request reload the access policy
+func (h *HTTP) reloadPolicies() {
+	h.policiesMutex.Lock()
+	defer h.policiesMutex.Unlock()
+	h.policiesLoadedAt = time.Time{}
+}
+
+// withPolicy evaluates the access policy for the client, and stores the
+// decision in the request context
+func (h *HTTP) withPolicy(r *http.Request, client *ds.Client) *http.Request {
+	decision := ds.EvaluatePolicies(h.activePolicies(), client)
+	for _, policy := range decision.Rules {
+		slog.Debug("access policy applies to client", "ip", client.IP, "policy", policy.Id, "match", policy.Match, "value", policy.Value, "action", policy.Action)
+	}
+	return r.WithContext(context.WithValue(r.Context(), policyContextKey{}, decision))
+}
+
+// policyFrom returns the access policy decision of the client. Requests that
+// have not been through clientLookup get the empty decision.
+func policyFrom(r *http.Request) ds.PolicyDecision {
+	decision, _ := r.Context().Value(policyContextKey{}).(ds.PolicyDecision)
+	return decision
+}
+
+// policyMessage returns the message shown to clients that are denied by the
+// access policy
+func policyMessage(decision ds.PolicyDecision, action string, message string) string {
+	if reason := decision.Reason(action); reason != "" {
+		return fmt.Sprintf("%s Reason: %s", message, reason)
+	}
+	return message
+}
+
+// uploadPermitted rejects uploads and new bins from clients that the access
+// policy denies uploads from. The error response is written to the client if
+// the upload is rejected.
+func (h *HTTP) uploadPermitted(w http.ResponseWriter, r *http.Request) bool {
+	decision := policyFrom(r)
+	if !decision.DenyUpload {
+		return true
+	}
+	h.Error(w, r, fmt.Sprintf("Rejecting upload from %s by access policy", r.RemoteAddr), policyMessage(decision, ds.PolicyDenyUpload, "Uploads from this client are not allowed."), 3501, http.StatusForbidden)
+	return false
+}
+
+// downloadPermitted rejects downloads from clients that the access policy
+// denies downloads from. The error response is written to the client if the
+// download is rejected.
+func (h *HTTP) downloadPermitted(w http.ResponseWriter, r *http.Request) bool {
+	decision := policyFrom(r)
+	if !decision.DenyDownload {
+		return true
+	}
+	h.Error(w, r, fmt.Sprintf("Rejecting download from %s by access policy", r.RemoteAddr), policyMessage(decision, ds.PolicyDenyDownload, "Downloads from this client are not allowed."), 3502, http.StatusForbidden)
+	return false
+}
+
+// approvalRequired returns true if new bins from the client need to be
+// approved before files can be downloaded from them
+func (h *HTTP) approvalRequired(r *http.Request) bool {
+	decision := policyFrom(r)
+	if decision.Trusted {
+		return false
+	}
+	return h.config.RequireApproval || decision.RequireApproval
+}
+
+// cookieRequired returns true if the client needs the verification cookie
+// to download files
+func (h *HTTP) cookieRequired(r *http.Request) bool {
+	decision := policyFrom(r)
+	if decision.Trusted {
+		return false
+	}
+	return h.config.RequireCookie || decision.RequireCookie
+}
+
+// createPolicy adds a rule to the access policy from the admin clients page
+func (h *HTTP) createPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	if err := r.ParseForm(); err != nil {
+		slog.Error("unable to parse form", "error", err)
+		http.Error(w, "Errno 3503", http.StatusBadRequest)
+		return
+	}
+
+	createdBy, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		createdBy = r.RemoteAddr
+	}
+	policy := ds.Policy{
+		Match:     r.PostForm.Get("match"),
+		Value:     r.PostForm.Get("value"),
+		Action:    r.PostForm.Get("action"),
+		Reason:    r.PostForm.Get("reason"),
+		CreatedBy: createdBy,
+	}
+
+	// The expiration is given in hours, and rules without expiration apply
+	// until they are removed
+	if expiration := strings.TrimSpace(r.PostForm.Get("expiration")); expiration != "" {
+		hours, err := strconv.Atoi(expiration)
+		if err != nil || hours < 1 {
+			http.Error(w, "The expiration must be a positive number of hours", http.StatusBadRequest)
+			return
+		}
+		expiredAt := time.Now().UTC().Add(time.Duration(hours) * time.Hour)
+		policy.ExpiredAt = &expiredAt
+	}
+
+	if err := h.dao.Policy().ValidateInput(&policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.dao.Policy().Insert(&policy); err != nil {
+		slog.Error("unable to insert access policy rule", "error", err)
+		http.Error(w, "Errno 3504", http.StatusInternalServerError)
+		return
+	}
+	h.reloadPolicies()
+	slog.Info("access policy rule created", "policy", policy.Id, "match", policy.Match, "value", policy.Value, "action", policy.Action, "created_by", policy.CreatedBy)
+
+	http.Redirect(w, r, "/admin/clients#policies", http.StatusSeeOther)
+}
+
+// deletePolicy removes a rule from the access policy
+func (h *HTTP) deletePolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	params := mux.Vars(r)
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid policy id", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.dao.Policy().Delete(id)
+	if err != nil {
+		slog.Error("unable to delete access policy rule", "policy", id, "error", err)
+		http.Error(w, "Errno 3505", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Policy not found", http.StatusNotFound)
+		return
+	}
+	h.reloadPolicies()
+	slog.Info("access policy rule deleted", "policy", id)
+
+	http.Redirect(w, r, "/admin/clients#policies", http.StatusSeeOther)
+}
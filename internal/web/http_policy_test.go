@@ -0,0 +1,166 @@
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func addPolicy(t *testing.T, h *HTTP, policy ds.Policy) {
+	t.Helper()
+	if err := h.dao.Policy().ValidateInput(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.dao.Policy().Insert(&policy); err != nil {
+		t.Fatal(err)
+	}
+	h.reloadPolicies()
+}
+
+func TestPolicyDenyUpload(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	addPolicy(t, h, ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.0/24", Action: ds.PolicyDenyUpload, Reason: "Spam"})
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/policybin/a.txt", "some content"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Spam") {
+		t.Errorf("Expected the reason in the response, got: %s", rr.Body.String())
+	}
+
+	// Clients outside the network are not affected
+	req := uploadRequest("/policybin/a.txt", "some content")
+	req.RemoteAddr = "198.51.100.1:1234"
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Downloads are still allowed
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/policybin/a.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+func TestPolicyDenyDownload(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/policybin/a.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	addPolicy(t, h, ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.1", Action: ds.PolicyDenyDownload})
+	for _, path := range []string{"/policybin/a.txt", "/archive/policybin/zip"} {
+		rr = httptest.NewRecorder()
+		h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d for %s, got %d. Body: %s", http.StatusForbidden, path, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestPolicyRequireApproval(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	addPolicy(t, h, ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.0/24", Action: ds.PolicyRequireApproval})
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/policybin/a.txt", "some content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Bins created by the client are pending approval for everyone
+	req := httptest.NewRequest(http.MethodGet, "/policybin/a.txt", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+}
+
+func TestPolicyAllow(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitClientHourFiles = 1
+	h.config.RequireApproval = true
+	addPolicy(t, h, ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.0/24", Action: ds.PolicyDenyUpload})
+	addPolicy(t, h, ds.Policy{Match: ds.PolicyMatchCIDR, Value: "192.0.2.1", Action: ds.PolicyAllow})
+
+	// Trusted clients are exempt from the other rules and the per-client limits
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, uploadRequest("/policybin/"+filename, "some content"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Bins created by trusted clients are approved
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/policybin/a.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminPolicies(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.AdminUsername = "admin"
+	h.config.AdminPassword = "secret123"
+	auth := fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte("admin:secret123")))
+
+	tests := []struct {
+		description string
+		form        url.Values
+		statusCode  int
+	}{
+		{"ipv6 network", url.Values{"match": {"cidr"}, "value": {"2001:db8::/64"}, "action": {"deny-upload"}, "reason": {"Spam"}}, http.StatusSeeOther},
+		{"asn with expiration", url.Values{"match": {"asn"}, "value": {"64500"}, "action": {"require-cookie"}, "expiration": {"24"}}, http.StatusSeeOther},
+		{"invalid network", url.Values{"match": {"cidr"}, "value": {"2001:db8::/129"}, "action": {"deny-upload"}}, http.StatusBadRequest},
+		{"invalid action", url.Values{"match": {"proxy"}, "action": {"ban"}}, http.StatusBadRequest},
+		{"invalid expiration", url.Values{"match": {"proxy"}, "action": {"deny-upload"}, "expiration": {"0"}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/policies", strings.NewReader(test.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Authorization", auth)
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, req)
+			if rr.Code != test.statusCode {
+				t.Errorf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	policies, err := h.dao.Policy().GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(policies))
+	}
+
+	for _, statusCode := range []int{http.StatusSeeOther, http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/policies/%d/delete", policies[0].Id), nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != statusCode {
+			t.Errorf("Expected status %d, got %d. Body: %s", statusCode, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/espebra/filebin2/internal/ds"
 	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/processor"
 	"github.com/espebra/filebin2/internal/workspace"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -44,6 +45,11 @@ func setupPostUploadHookHandler(t *testing.T, postUploadHook string, postUploadH
 	metricsRegistry := prometheus.NewRegistry()
 	metrics := ds.NewMetrics("test", metricsRegistry)
 
+	// The hooks are run by the test, one job at a time
+	p := processor.New(&dao, &s3ao)
+	p.Init(1)
+	p.SetPostUploadHook(postUploadHook, postUploadHookTimeout)
+
 	h := &HTTP{
 		staticBox:       &staticBox,
 		templateBox:     &templateBox,
@@ -54,6 +60,7 @@ func setupPostUploadHookHandler(t *testing.T, postUploadHook string, postUploadH
 		config:          &c,
 		metrics:         metrics,
 		metricsRegistry: metricsRegistry,
+		processor:       p,
 	}
 	if err := h.Init(); err != nil {
 		t.Fatalf("Failed to initialize HTTP handler: %v", err)
@@ -88,6 +95,24 @@ func uploadRequest(path, content string) *http.Request {
 	return req
 }
 
+// runHookJob processes the queued post-upload hook, and verifies that the
+// job is done rather than retried, regardless of the outcome of the hook
+func runHookJob(t *testing.T, h *HTTP) {
+	t.Helper()
+	if !h.processor.RunOnce() {
+		t.Fatal("Expected the hook to be queued")
+	}
+	stats, err := h.dao.Job().Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stats {
+		if s.Kind == ds.JobHook && (s.Done != 1 || s.Pending != 0 || s.Failed != 0) {
+			t.Errorf("Expected the hook job to be done, got %+v", s)
+		}
+	}
+}
+
 func TestPostUploadHookAccept(t *testing.T) {
 	hook := writeHookScript(t, `#!/bin/sh
 exit 0
@@ -101,6 +126,7 @@ exit 0
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
+	runHookJob(t, h)
 }
 
 func TestPostUploadHookFailureDoesNotBlockUpload(t *testing.T) {
@@ -117,6 +143,7 @@ exit 1
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
+	runHookJob(t, h)
 }
 
 func TestPostUploadHookNotConfigured(t *testing.T) {
@@ -129,6 +156,9 @@ func TestPostUploadHookNotConfigured(t *testing.T) {
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
+	if h.processor.RunOnce() {
+		t.Error("Expected no hook to be queued")
+	}
 }
 
 func TestPostUploadHookTimeoutDoesNotBlockUpload(t *testing.T) {
@@ -145,6 +175,7 @@ exit 0
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
+	runHookJob(t, h)
 }
 
 func TestPostUploadHookReceivesArguments(t *testing.T) {
@@ -171,6 +202,8 @@ exit 0
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
 	}
+	for h.processor.RunOnce() {
+	}
 
 	argsContent, err := os.ReadFile(argsFilePath)
 	if err != nil {
@@ -203,8 +236,9 @@ exit 0
 	}
 }
 
-// TestPostUploadHookRunsAfterPersistence verifies that when the hook executes,
-// the file has already been persisted to the database.
+// TestPostUploadHookRunsAfterPersistence verifies that the hook is queued
+// rather than run during the upload, and that when the hook executes, the
+// file has already been persisted to the database.
 func TestPostUploadHookRunsAfterPersistence(t *testing.T) {
 	marker, err := os.CreateTemp("", "hook-marker-*.txt")
 	if err != nil {
@@ -239,6 +273,14 @@ exit 0
 		t.Error("Expected file to be marked as stored before hook returned")
 	}
 
+	// The response does not wait for the hook
+	if data, err := os.ReadFile(markerPath); err != nil || len(data) != 0 {
+		t.Errorf("Expected the hook to not have run before the job is processed: %q", string(data))
+	}
+	if !h.processor.RunOnce() {
+		t.Fatal("Expected the hook to be queued")
+	}
+
 	data, err := os.ReadFile(markerPath)
 	if err != nil {
 		t.Fatalf("Failed to read hook marker: %v", err)
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// The per-client limits are counted in the database rather than in memory,
+// so that they apply across all instances. Uploads and new bins count
+// towards the limits for a rolling hour or day, and uploads in progress
+// count until they end. Clients that the access policy trusts are exempt
+// from the limits.
+const (
+	// Uploads in progress count towards the concurrency limit until this
+	// lifetime has passed, in case the instance handling the upload stops
+	// before the upload ends
+	clientUploadLifetime = time.Hour
+
+	// How long clients with too many uploads in progress are asked to wait
+	clientUploadRetryAfter = 10 * time.Second
+)
+
+// clientWindow is a per-client limit on the uploads within a window of time
+type clientWindow struct {
+	name     string
+	duration time.Duration
+	files    int
+	bytes    uint64
+	readable string
+}
+
+func (h *HTTP) clientWindows() []clientWindow {
+	return []clientWindow{
+		{"hour", time.Hour, h.config.LimitClientHourFiles, h.config.LimitClientHourBytes, h.config.LimitClientHourReadable},
+		{"day", 24 * time.Hour, h.config.LimitClientDayFiles, h.config.LimitClientDayBytes, h.config.LimitClientDayReadable},
+	}
+}
+
+// clientUploadLimits returns true if any of the per-client limits on uploads
+// within a window of time are enabled
+func (h *HTTP) clientUploadLimits() bool {
+	for _, window := range h.clientWindows() {
+		if window.files > 0 || window.bytes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// usageClient returns the client that sent the request, with the geoip
+// details that the usage is counted by
+func (h *HTTP) usageClient(r *http.Request) (client ds.Client, err error) {
+	client.IP, err = extractIP(r.RemoteAddr)
+	if err != nil {
+		return client, err
+	}
+	if h.config.LimitClientScope != ds.ClientScopeIP && h.geodb != nil {
+		if err := h.geodb.Lookup(r.RemoteAddr, &client); err != nil {
+			slog.Debug("unable to look up geoip details", "remote_addr", r.RemoteAddr, "error", err)
+		}
+	}
+	return client, nil
+}
+
+// retryAfter returns how long it takes until the oldest usage leaves the
+// window
+func retryAfter(summary ds.ClientUsageSummary, window time.Duration) time.Duration {
+	wait := time.Until(summary.OldestAt.Add(window))
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// clientUploadsAllowed checks the files and bytes that the client has
+// uploaded against the per-client limits. The upload that reaches a limit
+// is completed, and later uploads are rejected until the oldest uploads
+// leave the window. The error response is written to the client if the
+// upload is rejected.
+func (h *HTTP) clientUploadsAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if !h.clientUploadLimits() || policyFrom(r).Trusted {
+		return true
+	}
+	client, err := h.usageClient(r)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to identify the client: %s", err.Error()), "Parse error", 3401, http.StatusInternalServerError)
+		return false
+	}
+	for _, window := range h.clientWindows() {
+		if window.files <= 0 && window.bytes == 0 {
+			continue
+		}
+		summary, err := h.dao.ClientUsage().Summarize(h.config.LimitClientScope, client, ds.ClientUsageUpload, time.Now().Add(-window.duration))
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to select the uploads of client %s: %s", client.IP, err.Error()), "Database error", 3402, http.StatusInternalServerError)
+			return false
+		}
+		if window.files > 0 && summary.Count >= window.files {
+			setRetryAfter(w, retryAfter(summary, window.duration))
+			h.Error(w, r, fmt.Sprintf("Rejecting upload from client %s, which has uploaded %d files within the last %s", client.IP, summary.Count, window.name), fmt.Sprintf("Too many files uploaded, the limit is %d files per %s. Please retry later.", window.files, window.name), 3403, http.StatusTooManyRequests)
+			return false
+		}
+		if window.bytes > 0 && summary.Bytes >= window.bytes {
+			setRetryAfter(w, retryAfter(summary, window.duration))
+			h.Error(w, r, fmt.Sprintf("Rejecting upload from client %s, which has uploaded %s within the last %s", client.IP, humanize.Bytes(summary.Bytes), window.name), fmt.Sprintf("Too much uploaded, the limit is %s per %s. Please retry later.", window.readable, window.name), 3404, http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// clientBinsAllowed checks the bins that the client has created against the
+// per-client limit. The error response is written to the client if the new
+// bin is rejected.
+func (h *HTTP) clientBinsAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if h.config.LimitClientDayBins <= 0 || policyFrom(r).Trusted {
+		return true
+	}
+	client, err := h.usageClient(r)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to identify the client: %s", err.Error()), "Parse error", 3405, http.StatusInternalServerError)
+		return false
+	}
+	window := 24 * time.Hour
+	summary, err := h.dao.ClientUsage().Summarize(h.config.LimitClientScope, client, ds.ClientUsageBin, time.Now().Add(-window))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to select the bins of client %s: %s", client.IP, err.Error()), "Database error", 3406, http.StatusInternalServerError)
+		return false
+	}
+	if summary.Count >= h.config.LimitClientDayBins {
+		setRetryAfter(w, retryAfter(summary, window))
+		h.Error(w, r, fmt.Sprintf("Rejecting new bin from client %s, which has created %d bins within the last day", client.IP, summary.Count), fmt.Sprintf("Too many bins created, the limit is %d bins per day. Please retry later.", h.config.LimitClientDayBins), 3407, http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// registerClientUsage records an upload or a new bin of the client, if the
+// limits that it counts towards are enabled. The upload or the bin is
+// complete either way, so errors are only logged.
+func (h *HTTP) registerClientUsage(r *http.Request, kind string, bytes uint64) {
+	switch kind {
+	case ds.ClientUsageUpload:
+		if !h.clientUploadLimits() {
+			return
+		}
+	case ds.ClientUsageBin:
+		if h.config.LimitClientDayBins <= 0 {
+			return
+		}
+	}
+	client, err := h.usageClient(r)
+	if err != nil {
+		slog.Error("unable to identify the client", "remote_addr", r.RemoteAddr, "error", err)
+		return
+	}
+	usage := ds.ClientUsage{
+		IP:        client.IP,
+		ASN:       client.ASN,
+		Network:   client.Network,
+		Kind:      kind,
+		Bytes:     bytes,
+		ExpiredAt: time.Now().Add(24 * time.Hour),
+	}
+	if err := h.dao.ClientUsage().Insert(&usage); err != nil {
+		slog.Error("unable to register client usage", "ip", client.IP, "kind", kind, "error", err)
+	}
+}
+
+// clientUploadStarted counts the upload towards the per-client limit of
+// uploads in progress. The returned function ends the upload, and needs to
+// be called when the upload is done. The error response is written to the
+// client if there are too many uploads in progress.
+func (h *HTTP) clientUploadStarted(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	if h.config.LimitClientConcurrent <= 0 || policyFrom(r).Trusted {
+		return func() {}, true
+	}
+	client, err := h.usageClient(r)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to identify the client: %s", err.Error()), "Parse error", 3408, http.StatusInternalServerError)
+		return nil, false
+	}
+	usage := ds.ClientUsage{
+		IP:        client.IP,
+		ASN:       client.ASN,
+		Network:   client.Network,
+		ExpiredAt: time.Now().Add(clientUploadLifetime),
+	}
+	acquired, err := h.dao.ClientUsage().Acquire(h.config.LimitClientScope, &usage, h.config.LimitClientConcurrent)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to count the uploads in progress of client %s: %s", client.IP, err.Error()), "Database error", 3409, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !acquired {
+		setRetryAfter(w, clientUploadRetryAfter)
+		h.Error(w, r, fmt.Sprintf("Rejecting upload from client %s, which has %d uploads in progress", client.IP, h.config.LimitClientConcurrent), fmt.Sprintf("Too many uploads in progress, the limit is %d concurrent uploads. Please retry later.", h.config.LimitClientConcurrent), 3410, http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() {
+		if err := h.dao.ClientUsage().Release(&usage); err != nil {
+			// The upload stops counting when it expires
+			slog.Error("unable to end upload in progress", "ip", client.IP, "error", err)
+		}
+	}, true
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestClientUploadLimits(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitClientHourFiles = 2
+	h.config.LimitClientConcurrent = 1
+
+	// Uploads in progress end when the upload does
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, uploadRequest("/quotabin/"+filename, "some content"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/quotabin/c.txt", "some content"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusTooManyRequests, rr.Code, rr.Body.String())
+	}
+	wait, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || wait <= 0 || wait > 3600 {
+		t.Errorf("Expected Retry-After within the hour, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own limits
+	req := uploadRequest("/quotabin/c.txt", "some content")
+	req.RemoteAddr = "198.51.100.1:1234"
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+func TestClientBinLimit(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+	h.config.LimitClientDayBins = 1
+	h.config.LimitClientDayBytes = 1024 * 1024
+	h.config.LimitClientDayReadable = "1.0 MB"
+
+	tests := []struct {
+		description string
+		path        string
+		statusCode  int
+	}{
+		{"first bin", "/quotafirstbin/a.txt", http.StatusCreated},
+		{"existing bin", "/quotafirstbin/b.txt", http.StatusCreated},
+		{"second bin", "/quotasecondbin/a.txt", http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.router.ServeHTTP(rr, uploadRequest(test.path, "some content"))
+			if rr.Code != test.statusCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", test.statusCode, rr.Code, rr.Body.String())
+			}
+			if test.statusCode == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+				t.Errorf("Expected a Retry-After header")
+			}
+		})
+	}
+
+	// Clones are new bins as well
+	rr := copyRequest(h, "/clone/quotafirstbin", "", `{"bin": "quotaclonebin"}`)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusTooManyRequests, rr.Code, rr.Body.String())
+	}
+}
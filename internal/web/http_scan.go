@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/scanner"
+	"github.com/espebra/filebin2/internal/webhook"
+	"github.com/gorilla/mux"
+)
+
+// initScanner sets up the malware scanner if one is configured. Scanning
+// is disabled if no scanner is configured.
+func (h *HTTP) initScanner() error {
+	if h.scanner != nil || h.config.ClamdAddress == "" {
+		return nil
+	}
+	clamd, err := scanner.NewClamd(h.config.ClamdAddress, h.config.ClamdTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to set up clamd: %w", err)
+	}
+	h.scanner = clamd
+	slog.Info("malware scanning enabled", "scanner", clamd.Name(), "address", h.config.ClamdAddress)
+	return nil
+}
+
+// scanContent scans the content for malware. Failing to scan the content
+// is not an error, but gives a scan with the error status.
+func (h *HTTP) scanContent(ctx context.Context, sha256 string, content io.Reader) ds.Scan {
+	scan := ds.Scan{
+		SHA256:  sha256,
+		Scanner: h.scanner.Name(),
+	}
+	t0 := time.Now()
+	result, err := h.scanner.Scan(ctx, content)
+	scan.DurationMs = time.Since(t0).Milliseconds()
+	switch {
+	case err != nil:
+		scan.Status = ds.ScanError
+		scan.Error = err.Error()
+		slog.Warn("unable to scan content for malware", "sha256", sha256, "scanner", scan.Scanner, "error", err)
+	case result.Infected:
+		scan.Status = ds.ScanInfected
+		scan.Signature = result.Signature
+		slog.Warn("malware found in content", "sha256", sha256, "scanner", scan.Scanner, "signature", result.Signature)
+	default:
+		scan.Status = ds.ScanClean
+	}
+	return scan
+}
+
+// recordScan adds the scan to the scan history of the content, and blocks
+// the content if it is infected. The content must exist in file_content.
+func (h *HTTP) recordScan(scan *ds.Scan) error {
+	if err := h.dao.Scan().Insert(scan); err != nil {
+		return err
+	}
+	if scan.Status == ds.ScanInfected {
+		if err := h.dao.FileContent().BlockContent(scan.SHA256); err != nil {
+			return err
+		}
+		slog.Info("blocked infected content", "sha256", scan.SHA256, "signature", scan.Signature)
+		h.reloadBlockedImages()
+		h.webhooks.Enqueue(webhook.ContentEvent(webhook.ContentBlocked, scan.SHA256, "malware"))
+	}
+	return nil
+}
+
+// scanFileContent scans content that is already in storage again, for
+// example after the malware signatures have been updated.
+func (h *HTTP) scanFileContent(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	sha256 := params["sha256"]
+
+	if h.scanner == nil {
+		http.Error(w, "Malware scanning is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(sha256)
+	if err != nil {
+		slog.Error("unable to get file content", "sha256", sha256, "error", err)
+		http.Error(w, "File content not found", http.StatusNotFound)
+		return
+	}
+	if !fileContent.InStorage {
+		http.Error(w, "The content is not in storage", http.StatusConflict)
+		return
+	}
+
+	if err := h.dao.FileContent().SetScanStatus(fileContent, ds.ScanPending, ""); err != nil {
+		slog.Error("unable to update scan status", "sha256", sha256, "error", err)
+		http.Error(w, "Failed to update scan status", http.StatusInternalServerError)
+		return
+	}
+
+	content, err := h.s3.GetObject(sha256, 0, 0)
+	if err != nil {
+		slog.Error("unable to get content from storage", "sha256", sha256, "error", err)
+		http.Error(w, "Failed to read the content from storage", http.StatusInternalServerError)
+		return
+	}
+	scan := h.scanContent(r.Context(), sha256, content)
+	_ = content.Close()
+
+	if err := h.dao.FileContent().SetScanStatus(fileContent, scan.Status, scan.Signature); err != nil {
+		slog.Error("unable to update scan status", "sha256", sha256, "error", err)
+		http.Error(w, "Failed to update scan status", http.StatusInternalServerError)
+		return
+	}
+	if err := h.recordScan(&scan); err != nil {
+		slog.Error("unable to record scan", "sha256", sha256, "error", err)
+		http.Error(w, "Failed to record scan", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("scanned content", "sha256", sha256, "status", scan.Status)
+
+	// Redirect back to the file view page
+	http.Redirect(w, r, "/admin/file/"+sha256, http.StatusSeeOther)
+}
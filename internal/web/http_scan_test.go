@@ -0,0 +1,294 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/scanner"
+	"github.com/espebra/filebin2/internal/workspace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeScanner reports content containing the marker as infected, and fails
+// all scans if failing is set
+type fakeScanner struct {
+	marker  string
+	failing bool
+
+	mu    sync.Mutex
+	scans int
+}
+
+func (s *fakeScanner) Name() string {
+	return "fake"
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	s.mu.Lock()
+	s.scans++
+	s.mu.Unlock()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return scanner.Result{}, err
+	}
+	if s.failing {
+		return scanner.Result{}, errors.New("scanner unavailable")
+	}
+	if strings.Contains(string(content), s.marker) {
+		return scanner.Result{Infected: true, Signature: "Fake-Test-Signature"}, nil
+	}
+	return scanner.Result{}, nil
+}
+
+func (s *fakeScanner) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scans
+}
+
+func setupScanHandler(t *testing.T, s scanner.Scanner) *HTTP {
+	t.Helper()
+
+	dao, s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tearDown(dao) })
+
+	geodb, err := geoip.Init("../../mmdb/GeoLite2-ASN.mmdb", "../../mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatalf("Unable to load geoip database: %s", err)
+	}
+
+	wm, err := workspace.NewManager(os.TempDir(), 4.0)
+	if err != nil {
+		t.Fatalf("Unable to initialize workspace manager: %s", err)
+	}
+
+	c := ds.Config{
+		Expiration:    testExpiredAt,
+		AdminUsername: "admin",
+		AdminPassword: "changeme",
+	}
+
+	metricsRegistry := prometheus.NewRegistry()
+	metrics := ds.NewMetrics("test", metricsRegistry)
+
+	h := &HTTP{
+		staticBox:       &staticBox,
+		templateBox:     &templateBox,
+		dao:             &dao,
+		s3:              &s3ao,
+		geodb:           &geodb,
+		workspace:       wm,
+		config:          &c,
+		metrics:         metrics,
+		metricsRegistry: metricsRegistry,
+		scanner:         s,
+	}
+	if err := h.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { h.Stop() })
+
+	return h
+}
+
+func contentSHA256(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+func TestScanCleanUpload(t *testing.T) {
+	s := &fakeScanner{marker: "MALWARE"}
+	h := setupScanHandler(t, s)
+
+	content := "clean content"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scancleanbin/clean.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(contentSHA256(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.ScanStatus != ds.ScanClean {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanClean, fileContent.ScanStatus)
+	}
+
+	// Content that has been scanned already is not scanned again
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scancleanbin/copy.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if s.count() != 1 {
+		t.Errorf("Expected the content to be scanned once, got %d scans", s.count())
+	}
+
+	scans, err := h.dao.Scan().GetBySHA256(fileContent.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 1 || scans[0].Scanner != "fake" || scans[0].Status != ds.ScanClean {
+		t.Errorf("Expected one clean scan in the history, got %+v", scans)
+	}
+}
+
+func TestScanInfectedUpload(t *testing.T) {
+	s := &fakeScanner{marker: "MALWARE"}
+	h := setupScanHandler(t, s)
+
+	content := "some MALWARE content"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scaninfectedbin/infected.txt", content))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(contentSHA256(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileContent.Blocked {
+		t.Error("Expected infected content to be blocked")
+	}
+	if fileContent.InStorage {
+		t.Error("Expected infected content to not be stored")
+	}
+	if fileContent.ScanStatus != ds.ScanInfected {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanInfected, fileContent.ScanStatus)
+	}
+	if fileContent.ScanSignature != "Fake-Test-Signature" {
+		t.Errorf("Expected signature Fake-Test-Signature, got %q", fileContent.ScanSignature)
+	}
+
+	if _, found, err := h.dao.File().GetByName("scaninfectedbin", "infected.txt"); err != nil || found {
+		t.Errorf("Expected no file to be created for infected content (found %t, err %v)", found, err)
+	}
+
+	// Later uploads of the same content are rejected as blocked
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scaninfectedbin/again.txt", content))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+	if s.count() != 1 {
+		t.Errorf("Expected the content to be scanned once, got %d scans", s.count())
+	}
+}
+
+func TestScanErrorAcceptsUpload(t *testing.T) {
+	s := &fakeScanner{marker: "MALWARE", failing: true}
+	h := setupScanHandler(t, s)
+
+	content := "content that could not be scanned"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scanerrorbin/file.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(contentSHA256(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.ScanStatus != ds.ScanError {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanError, fileContent.ScanStatus)
+	}
+
+	scans, err := h.dao.Scan().GetBySHA256(fileContent.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 1 || scans[0].Error == "" {
+		t.Errorf("Expected one failed scan with an error in the history, got %+v", scans)
+	}
+
+	// Content with a failed scan is scanned again on the next upload
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scanerrorbin/again.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if s.count() != 2 {
+		t.Errorf("Expected the content to be scanned twice, got %d scans", s.count())
+	}
+}
+
+func TestScanRescan(t *testing.T) {
+	s := &fakeScanner{marker: "MALWARE"}
+	h := setupScanHandler(t, s)
+
+	content := "content that turns out to be MALWARE"
+	sha := contentSHA256(content)
+
+	// Store the content while the scanner does not know the malware
+	s.marker = "UNKNOWN"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/scanrescanbin/file.txt", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// Rescanning requires admin credentials
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/file/"+sha+"/scan", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// Rescan after the signatures have been updated
+	s.marker = "MALWARE"
+	req := httptest.NewRequest(http.MethodPost, "/admin/file/"+sha+"/scan", nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileContent.ScanStatus != ds.ScanInfected {
+		t.Errorf("Expected scan status %q, got %q", ds.ScanInfected, fileContent.ScanStatus)
+	}
+	if !fileContent.Blocked {
+		t.Error("Expected infected content to be blocked")
+	}
+
+	file, found, err := h.dao.File().GetByName("scanrescanbin", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || !file.IsDeleted() {
+		t.Error("Expected the file with infected content to be deleted")
+	}
+
+	// The admin view shows the scan history
+	req = httptest.NewRequest(http.MethodGet, "/admin/file/"+sha, nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Scan History") || !strings.Contains(rr.Body.String(), "Fake-Test-Signature") {
+		t.Error("Expected the scan history in the admin file view")
+	}
+}
@@ -0,0 +1,221 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/phash"
+	"github.com/espebra/filebin2/internal/processor"
+	"github.com/gorilla/mux"
+)
+
+// The perceptual hashes of blocked content are kept in an index that each
+// instance rebuilds from the database at this interval. Content that is
+// blocked or unblocked on the instance applies immediately.
+const blockedImagesReloadInterval = 30 * time.Second
+
+// The distance used on the admin page of similar content if the comparison
+// with blocked content is disabled
+const defaultSimilarDistance = 10
+
+// blockedImageIndex returns the index of the perceptual hashes of blocked
+// content, rebuilding it when it is outdated. The previous index is kept if
+// the rebuild fails. The index is not modified after it is built, so it can
+// be searched without holding the lock.
+func (h *HTTP) blockedImageIndex() *phash.Index {
+	h.blockedImagesMutex.Lock()
+	defer h.blockedImagesMutex.Unlock()
+
+	if h.blockedImages != nil && time.Since(h.blockedImagesLoadedAt) < blockedImagesReloadInterval {
+		return h.blockedImages
+	}
+	contents, err := h.dao.FileContent().GetBlockedPHashes()
+	if err != nil {
+		slog.Error("unable to load the perceptual hashes of blocked content", "error", err)
+		if h.blockedImages == nil {
+			return phash.NewIndex()
+		}
+		return h.blockedImages
+	}
+	index := phash.NewIndex()
+	for _, content := range contents {
+		if err := index.Add(content.PHash, content.SHA256); err != nil {
+			slog.Warn("skipping invalid perceptual hash of blocked content", "sha256", content.SHA256, "error", err)
+		}
+	}
+	h.blockedImages = index
+	h.blockedImagesLoadedAt = time.Now()
+	return h.blockedImages
+}
+
+// reloadBlockedImages makes the next upload of an image rebuild the index
+// of the perceptual hashes of blocked content
+func (h *HTTP) reloadBlockedImages() {
+	h.blockedImagesMutex.Lock()
+	defer h.blockedImagesMutex.Unlock()
+	h.blockedImagesLoadedAt = time.Time{}
+}
+
+// checkSimilarImage compares uploaded images with blocked content by their
+// perceptual hash, which catches copies of blocked images that have been
+// re-encoded or resized. Depending on the configuration, similar images
+// are rejected, or the bin is changed to require approval. The error
+// response is written to the client if the upload is rejected.
+//
+// The perceptual hash is returned with the version of its computation if
+// it was computed, so that it can be kept with the content.
+func (h *HTTP) checkSimilarImage(w http.ResponseWriter, r *http.Request, bin *ds.Bin, rf receivedFile, file ds.File, existingContent *ds.FileContent) (string, int, bool) {
+	if h.config.SimilarDistance <= 0 || bin.Encrypted || !strings.HasPrefix(file.Mime, "image/") {
+		return "", 0, true
+	}
+	index := h.blockedImageIndex()
+	if index.Len() == 0 {
+		return "", 0, true
+	}
+
+	// The hash of content that has been processed already is reused
+	var hash string
+	var version int
+	if existingContent != nil && !processor.Outdated(existingContent, ds.JobPHash) {
+		hash = existingContent.PHash
+	} else {
+		fp, err := h.openReceivedFile(rf, 0)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to read filename %q in bin %q: %s", file.Filename, bin.Id, err.Error()), "Processing error", 3601, http.StatusInternalServerError)
+			return "", 0, false
+		}
+		hash, err = phash.Compute(fp)
+		_ = fp.Close()
+		if err != nil {
+			// The content processor computes the hash again later
+			slog.Warn("unable to compute perceptual hash", "filename", file.Filename, "bin", bin.Id, "sha256", file.SHA256, "error", err)
+			return "", 0, true
+		}
+		version = processor.PHashVersion
+	}
+	if hash == "" {
+		// Images that can not be decoded have no hash to compare
+		return hash, version, true
+	}
+
+	matches, err := index.Search(hash, h.config.SimilarDistance)
+	if err != nil {
+		slog.Warn("unable to search for similar blocked content", "sha256", file.SHA256, "phash", hash, "error", err)
+		return hash, version, true
+	}
+	if len(matches) == 0 {
+		return hash, version, true
+	}
+	match := matches[0]
+
+	if h.config.SimilarAction == ds.SimilarReview {
+		slog.Warn("upload of image similar to blocked content requires approval", "filename", file.Filename, "bin", bin.Id, "sha256", file.SHA256, "similar_to", match.SHA256, "distance", match.Distance)
+		_ = bin.ApprovedAt.Scan(nil)
+		return hash, version, true
+	}
+	h.Error(w, r, fmt.Sprintf("Rejecting upload of file %q to bin %q: content with SHA256 %s is similar to blocked content with SHA256 %s at distance %d", file.Filename, bin.Id, file.SHA256, match.SHA256, match.Distance), "This content is similar to content that has been blocked and cannot be uploaded", 3602, http.StatusForbidden)
+	return hash, version, false
+}
+
+// viewAdminSimilar lists the content that is visually similar to some
+// content, by the Hamming distance between their perceptual hashes. The
+// distance can be given with ?distance=, and defaults to the distance used
+// for comparisons with blocked content.
+func (h *HTTP) viewAdminSimilar(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	inputSHA256 := params["sha256"]
+
+	type Data struct {
+		SHA256      string              `json:"sha256"`
+		FileContent *ds.FileContent     `json:"file_content"`
+		Distance    int                 `json:"distance"`
+		Similar     []ds.SimilarContent `json:"similar"`
+	}
+	var data Data
+	data.SHA256 = inputSHA256
+	data.Similar = []ds.SimilarContent{}
+
+	data.Distance = h.config.SimilarDistance
+	if data.Distance <= 0 {
+		data.Distance = defaultSimilarDistance
+	}
+	if v := r.URL.Query().Get("distance"); v != "" {
+		distance, err := strconv.Atoi(v)
+		if err != nil || distance < 0 || distance > 64 {
+			http.Error(w, "The distance must be a number between 0 and 64", http.StatusBadRequest)
+			return
+		}
+		data.Distance = distance
+	}
+
+	fileContent, err := h.dao.FileContent().GetBySHA256(inputSHA256)
+	if err != nil {
+		slog.Error("unable to get file content", "sha256", inputSHA256, "error", err)
+		http.Error(w, "File content not found", http.StatusNotFound)
+		return
+	}
+	data.FileContent = fileContent
+
+	// Content without a perceptual hash can not be compared
+	if fileContent.PHash != "" {
+		contents, err := h.dao.FileContent().GetPHashes()
+		if err != nil {
+			slog.Error("unable to get perceptual hashes", "error", err)
+			http.Error(w, "Errno 3603", http.StatusInternalServerError)
+			return
+		}
+		// The page is viewed rarely, so a single pass over the hashes is
+		// cheaper than building an index for each view
+		target, err := phash.Parse(fileContent.PHash)
+		if err != nil {
+			slog.Error("unable to search for similar content", "sha256", inputSHA256, "phash", fileContent.PHash, "error", err)
+			http.Error(w, "Errno 3604", http.StatusInternalServerError)
+			return
+		}
+		for _, content := range contents {
+			if content.SHA256 == fileContent.SHA256 {
+				continue
+			}
+			value, err := phash.Parse(content.PHash)
+			if err != nil {
+				slog.Warn("skipping invalid perceptual hash", "sha256", content.SHA256, "error", err)
+				continue
+			}
+			if distance := bits.OnesCount64(value ^ target); distance <= data.Distance {
+				data.Similar = append(data.Similar, ds.SimilarContent{FileContent: content, Distance: distance})
+			}
+		}
+		sort.Slice(data.Similar, func(i, j int) bool {
+			if data.Similar[i].Distance != data.Similar[j].Distance {
+				return data.Similar[i].Distance < data.Similar[j].Distance
+			}
+			return data.Similar[i].SHA256 < data.Similar[j].SHA256
+		})
+	}
+
+	if r.Header.Get("accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		out, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			slog.Error("failed to parse json", "error", err)
+			http.Error(w, "Errno 3605", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write(out)
+	} else {
+		if err := h.renderTemplate(w, "admin_similar", data); err != nil {
+			slog.Error("failed to execute template", "error", err)
+			http.Error(w, "Errno 3606", http.StatusInternalServerError)
+			return
+		}
+	}
+}
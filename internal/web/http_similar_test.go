@@ -0,0 +1,210 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/phash"
+	"github.com/espebra/filebin2/internal/processor"
+)
+
+// testPatternImage returns an image with a few shapes, encoded as png or
+// jpeg. Images of different sizes are visually similar.
+func testPatternImage(t *testing.T, size int, format string, inverted bool) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			fx := float64(x) / float64(size)
+			fy := float64(y) / float64(size)
+			dark := (fx-0.3)*(fx-0.3)+(fy-0.35)*(fy-0.35) < 0.04 || (fx > 0.55 && fx < 0.9 && fy > 0.5 && fy < 0.85)
+			if dark != inverted {
+				img.Set(x, y, color.RGBA{R: 30, G: 40, B: 90, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 230, G: 220, B: 180, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// uploadBlockedImage uploads an image, computes its perceptual hash as the
+// content processor would, and blocks it
+func uploadBlockedImage(t *testing.T, h *HTTP, auth string) string {
+	t.Helper()
+	content := testPatternImage(t, 256, "png", false)
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin1/original.png", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var uploaded struct {
+		File ds.File `json:"file"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := phash.Compute(bytes.NewReader([]byte(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.dao.FileContent().SetPHash(uploaded.File.SHA256, hash, processor.PHashVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/file/"+uploaded.File.SHA256+"/block", nil)
+	req.Header.Set("Authorization", auth)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+	}
+	return uploaded.File.SHA256
+}
+
+func setupSimilarHandler(t *testing.T, action string) (*HTTP, string) {
+	t.Helper()
+	h := setupProxyDownloadHandler(t)
+	h.config.SimilarDistance = 10
+	h.config.SimilarAction = action
+	h.config.AdminUsername = "admin"
+	h.config.AdminPassword = "secret123"
+	return h, fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte("admin:secret123")))
+}
+
+func TestSimilarImageReject(t *testing.T) {
+	h, auth := setupSimilarHandler(t, ds.SimilarReject)
+	uploadBlockedImage(t, h, auth)
+
+	// A re-encoded and resized copy of the blocked image is rejected
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin2/copy.jpg", testPatternImage(t, 120, "jpeg", false)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	// Other images are accepted, and their perceptual hash is kept
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin2/other.png", testPatternImage(t, 256, "png", true)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var uploaded struct {
+		File ds.File `json:"file"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	content, err := h.dao.FileContent().GetBySHA256(uploaded.File.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.PHash == "" || content.PHashVersion != processor.PHashVersion {
+		t.Errorf("Expected the perceptual hash to be computed during the upload, got %q version %d", content.PHash, content.PHashVersion)
+	}
+
+	// The comparison is disabled with a distance of 0
+	h.config.SimilarDistance = 0
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin2/copy.jpg", testPatternImage(t, 120, "jpeg", false)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+}
+
+func TestSimilarImageReview(t *testing.T) {
+	h, auth := setupSimilarHandler(t, ds.SimilarReview)
+	uploadBlockedImage(t, h, auth)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin2/copy.jpg", testPatternImage(t, 120, "jpeg", false)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// The bin is held for review
+	bin, found, err := h.dao.Bin().GetByID("similarbin2")
+	if err != nil || !found {
+		t.Fatalf("Expected the bin to exist: %v", err)
+	}
+	if bin.IsApproved() {
+		t.Errorf("Expected the bin to require approval")
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/similarbin2/copy.jpg", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d. Body: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminSimilar(t *testing.T) {
+	h, auth := setupSimilarHandler(t, ds.SimilarReview)
+	sha256 := uploadBlockedImage(t, h, auth)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/similarbin2/copy.jpg", testPatternImage(t, 120, "jpeg", false)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/file/"+sha256+"/similar", nil)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("accept", "application/json")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var data struct {
+		Distance int                 `json:"distance"`
+		Similar  []ds.SimilarContent `json:"similar"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Distance != 10 {
+		t.Errorf("Expected distance 10, got %d", data.Distance)
+	}
+	if len(data.Similar) != 1 || data.Similar[0].Mime != "image/jpeg" || data.Similar[0].Distance > 10 {
+		t.Errorf("Expected the resized copy to be similar, got %+v", data.Similar)
+	}
+
+	tests := []struct {
+		path       string
+		statusCode int
+	}{
+		{"/admin/file/" + sha256 + "/similar", http.StatusOK},
+		{"/admin/file/" + sha256 + "/similar?distance=0", http.StatusOK},
+		{"/admin/file/" + sha256 + "/similar?distance=65", http.StatusBadRequest},
+		{"/admin/file/0000/similar", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, req)
+		if rr.Code != test.statusCode {
+			t.Errorf("Expected status %d for %s, got %d. Body: %s", test.statusCode, test.path, rr.Code, rr.Body.String())
+		}
+	}
+}
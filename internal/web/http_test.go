@@ -22,6 +22,7 @@ import (
 const (
 	testLimitFileDownloads = 2
 	testLimitStorage       = 10000000
+	testLimitUpload        = 1000000
 	testExpiredAt          = 5
 	testHTTPHost           = "localhost"
 	testHTTPPort           = 8080
@@ -39,6 +40,10 @@ const (
 
 var (
 	waitForServer sync.WaitGroup
+
+	// testConfig is the configuration of the running test server, for tests
+	// that need to toggle features.
+	testConfig *ds.Config
 )
 
 func tearUp() (dao dbl.DAO, s3ao s3.S3AO, err error) {
@@ -100,13 +105,18 @@ func TestMain(m *testing.M) {
 	c := ds.Config{
 		LimitFileDownloads:   testLimitFileDownloads,
 		LimitStorageBytes:    testLimitStorage,
+		LimitUploadBytes:     testLimitUpload,
+		LimitUploadReadable:  "1.0 MB",
 		Expiration:           testExpiredAt,
 		HttpHost:             testHTTPHost,
 		HttpPort:             testHTTPPort,
 		RejectFileExtensions: []string{"illegal1", "illegal2"},
 		AdminUsername:        "admin",
 		AdminPassword:        "changeme",
+		ResumableUploadTTL:   time.Hour,
 	}
+	testConfig = &c
+
 	// Create Prometheus registry and metrics
 	metricsRegistry := prometheus.NewRegistry()
 	metrics := ds.NewMetrics("test", metricsRegistry)
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// getThumbnail serves a thumbnail of an image in a bin. The thumbnail is
+// subject to the same checks as the file itself, but is not counted as a
+// download. Files with a download limit of their own have no thumbnails.
+func (h *HTTP) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	// Thumbnails should never be indexed
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	t0 := time.Now()
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFilename := params["filename"]
+
+	size, err := strconv.Atoi(params["size"])
+	if err != nil || !slices.Contains(ds.ThumbnailSizes, size) {
+		h.Error(w, r, "", "The thumbnail size is not supported.", 2401, http.StatusNotFound)
+		return
+	}
+
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 2402, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The bin does not exist.", 2403, http.StatusNotFound)
+		return
+	}
+
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "This bin is no longer available.", 2404, http.StatusNotFound)
+		return
+	}
+
+	if !h.binUnlocked(w, r, inputBin, &bin) {
+		return
+	}
+
+	if !bin.IsApproved() {
+		h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 2405, http.StatusForbidden)
+		return
+	}
+
+	if !h.downloadPermitted(w, r) {
+		return
+	}
+
+	// Thumbnails are never generated for encrypted content
+	if bin.Encrypted {
+		h.Error(w, r, "", "The thumbnail does not exist.", 2406, http.StatusNotFound)
+		return
+	}
+
+	file, found, err := h.dao.File().GetByName(binId, inputFilename)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select file by bin %q and filename %q: %s", binId, inputFilename, err.Error()), "Database error", 2407, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The file does not exist.", 2408, http.StatusNotFound)
+		return
+	}
+
+	available, err := h.dao.File().IsAvailableForDownload(file.Id)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to check file availability: %s", err.Error()), "Database error", 2409, http.StatusInternalServerError)
+		return
+	}
+	if !available {
+		h.Error(w, r, "", "The file is not available for download.", 2410, http.StatusNotFound)
+		return
+	}
+
+	if !h.cookieChallenge(w, r, bin, alias) {
+		return
+	}
+
+	if !previewable(file) {
+		h.Error(w, r, "", "The thumbnail does not exist.", 2415, http.StatusNotFound)
+		return
+	}
+
+	if h.downloadLimitReached(file) {
+		h.Error(w, r, "", "The file has been requested too many times.", 2416, http.StatusForbidden)
+		return
+	}
+
+	thumbnail, found, err := h.dao.Thumbnail().Get(file.SHA256, size)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select thumbnail of %q: %s", file.SHA256, err.Error()), "Database error", 2411, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The thumbnail does not exist.", 2412, http.StatusNotFound)
+		return
+	}
+
+	fp, err := h.s3.GetThumbnail(thumbnail.SHA256, thumbnail.Size)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to get thumbnail of %q in size %d: %s", thumbnail.SHA256, thumbnail.Size, err.Error()), "Storage error", 2413, http.StatusInternalServerError)
+		return
+	}
+	data, err := io.ReadAll(fp)
+	_ = fp.Close()
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read thumbnail of %q in size %d: %s", thumbnail.SHA256, thumbnail.Size, err.Error()), "Storage error", 2414, http.StatusInternalServerError)
+		return
+	}
+	h.metrics.IncrBytesStorageToFilebin(uint64(len(data)))
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", fmt.Sprintf("\"%s-%d\"", thumbnail.SHA256, thumbnail.Size))
+
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "", thumbnail.CreatedAt, bytes.NewReader(data))
+	h.metrics.IncrBytesFilebinToClient(cw.written)
+
+	slog.Debug("served thumbnail", "filename", file.Filename, "size", size, "bytes", len(data), "sha256", file.SHA256, "bin", inputBin, "duration_seconds", time.Since(t0).Seconds())
+}
+
+// markThumbnails flags the files in the bin that can be previewed and have
+// a small thumbnail, and reports whether any of them do
+func (h *HTTP) markThumbnails(bin *ds.Bin, files []ds.File) bool {
+	if bin.Encrypted || len(files) == 0 {
+		return false
+	}
+	thumbnails, err := h.dao.Thumbnail().GetByBin(bin.Id)
+	if err != nil {
+		// The bin is usable without the gallery
+		slog.Error("unable to get thumbnails by bin", "bin", bin.Id, "error", err)
+		return false
+	}
+	small := make(map[string]bool)
+	for _, thumbnail := range thumbnails {
+		if thumbnail.Size == ds.ThumbnailSmall {
+			small[thumbnail.SHA256] = true
+		}
+	}
+	gallery := false
+	for i := range files {
+		if small[files[i].SHA256] && previewable(files[i]) {
+			files[i].Thumbnail = true
+			gallery = true
+		}
+	}
+	return gallery
+}
@@ -0,0 +1,120 @@
+package web
+
+import (
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestThumbnail(t *testing.T) {
+	h := setupJobHandler(t)
+	content := testImage(t)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/thumbnailbin/image.png", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	// The thumbnails do not exist until the jobs are processed
+	req := httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/256/image.png", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d before the thumbnails are generated, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	for h.processor.RunOnce() {
+	}
+
+	for _, size := range ds.ThumbnailSizes {
+		thumbnail, found, err := h.dao.Thumbnail().Get(contentSHA256(content), size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatalf("Expected a thumbnail in size %d", size)
+		}
+
+		// The image is smaller than the thumbnails, so it is not
+		// scaled up
+		if thumbnail.Width != 64 || thumbnail.Height != 64 {
+			t.Errorf("Expected a 64x64 thumbnail, got %dx%d", thumbnail.Width, thumbnail.Height)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/256/image.png", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Expected content type image/jpeg, got %q", ct)
+	}
+	if _, err := jpeg.Decode(rr.Body); err != nil {
+		t.Errorf("Expected a JPEG thumbnail: %s", err)
+	}
+
+	// Conditional requests are supported
+	req = httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/256/image.png", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d, got %d", http.StatusNotModified, rr.Code)
+	}
+
+	// Thumbnails are behind the cookie challenge like the files
+	h.config.RequireCookie = true
+	h.config.ExpectedCookieValue = "thumbnailcookie"
+	req = httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/256/image.png", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if ct := rr.Header().Get("Content-Type"); ct == "image/jpeg" {
+		t.Error("Expected the cookie challenge instead of the thumbnail")
+	}
+	if rr.Header().Get("Set-Cookie") == "" {
+		t.Error("Expected the cookie challenge to set the cookie")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/256/image.png", nil)
+	req.AddCookie(&http.Cookie{Name: "verified", Value: "thumbnailcookie"})
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if ct := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || ct != "image/jpeg" {
+		t.Errorf("Expected the thumbnail with the cookie, got status %d and content type %q", rr.Code, ct)
+	}
+	h.config.RequireCookie = false
+
+	// Thumbnails are not downloads
+	file, found, err := h.dao.File().GetByName("thumbnailbin", "image.png")
+	if err != nil || !found {
+		t.Fatalf("Expected to find the file: %v", err)
+	}
+	if file.Downloads != 0 {
+		t.Errorf("Expected no downloads, got %d", file.Downloads)
+	}
+
+	// Unsupported sizes are rejected
+	req = httptest.NewRequest(http.MethodGet, "/thumbnail/thumbnailbin/100/image.png", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unsupported size, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// The bin page shows the gallery
+	req = httptest.NewRequest(http.MethodGet, "/thumbnailbin", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "/thumbnail/thumbnailbin/256/image.png") {
+		t.Error("Expected the bin page to link to the thumbnail")
+	}
+}
@@ -0,0 +1,565 @@
+package web
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/s3"
+	"github.com/gorilla/mux"
+)
+
+// Resumable uploads implement the core protocol of tus 1.0 together with the
+// creation, termination and expiration extensions. See https://tus.io/protocols/resumable-upload
+//
+// The bytes received are staged in S3 as the parts of a multipart upload,
+// like direct uploads, and the offset is tracked in the upload table. The
+// bytes that do not fill a part yet are kept as the tail of the upload in a
+// separate object, which the next request continues from. Any instance can
+// therefore resume an upload. Concurrent requests to the same upload are
+// kept apart by the offset, which is only moved from the value that the
+// request started from. When the last byte has been received, the parts are
+// assembled, checksummed and stored the same way as a direct upload.
+
+const tusVersion = "1.0.0"
+
+func (h *HTTP) tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// tusVersionSupported checks the Tus-Resumable request header. The OPTIONS
+// request is the only one that is allowed to omit it.
+func (h *HTTP) tusVersionSupported(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		h.Error(w, r, "", "Unsupported tus version", 1401, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func (h *HTTP) tusOptions(w http.ResponseWriter, r *http.Request) {
+	h.tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata parses the Upload-Metadata request header, which is a
+// comma separated list of keys and base64 encoded values.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		if key == "" {
+			return metadata, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return metadata, fmt.Errorf("invalid value for metadata key %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func (h *HTTP) tusCreate(w http.ResponseWriter, r *http.Request) {
+	h.tusHeaders(w)
+	if !h.tusVersionSupported(w, r) {
+		return
+	}
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		h.Error(w, r, "", "Deferred upload length is not supported", 1402, http.StatusBadRequest)
+		return
+	}
+
+	inputBytes, err := strconv.ParseUint(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		h.Error(w, r, "Resumable upload failed: Invalid Upload-Length header", "Missing or invalid Upload-Length header", 1403, http.StatusBadRequest)
+		return
+	}
+	if inputBytes == 0 {
+		h.Error(w, r, "", "Empty file uploads are not allowed", 1404, http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Resumable upload failed: %s", err.Error()), "Invalid Upload-Metadata header", 1405, http.StatusBadRequest)
+		return
+	}
+	inputFilename := metadata["filename"]
+	if inputFilename == "" {
+		// tus-js-client and Uppy use name instead of filename
+		inputFilename = metadata["name"]
+	}
+	if inputFilename == "" {
+		h.Error(w, r, "Resumable upload failed: missing filename metadata", "Missing filename in Upload-Metadata", 1406, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the filename early to avoid receiving a large file that would
+	// be rejected anyway.
+	file := ds.File{Bin: inputBin, Filename: inputFilename}
+	if err := h.dao.File().ValidateInput(&file); err != nil {
+		h.Error(w, r, fmt.Sprintf("Input validation error on resumable upload: %s", err.Error()), "Input validation failed", 1407, http.StatusBadRequest)
+		return
+	}
+
+	if !h.uploadSizeAllowed(w, r, file.Filename, inputBytes) {
+		return
+	}
+
+	bin, ok := h.prepareUpload(w, r, inputBin, file.Filename)
+	if !ok {
+		return
+	}
+
+	ip, err := extractIP(r.RemoteAddr)
+	if err != nil {
+		h.Error(w, r, "Failed to parse remote address", "Parse error", 1408, http.StatusInternalServerError)
+		return
+	}
+
+	upload := ds.Upload{
+		Id:        h.dao.Upload().GenerateId(),
+		Bin:       bin.Id,
+		Filename:  file.Filename,
+		Bytes:     inputBytes,
+		IP:        ip,
+		ExpiredAt: time.Now().UTC().Add(h.config.ResumableUploadTTL),
+	}
+	upload.PartSize, _ = directUploadLayout(upload.Bytes, uint64(h.s3.GetPartSize()))
+	upload.ObjectKey = path.Join("staging", upload.Id)
+	upload.StorageUploadId, err = h.s3.CreateMultipartUpload(upload.ObjectKey)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to create multipart upload for resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1409, http.StatusInternalServerError)
+		return
+	}
+	if err := h.dao.Upload().Insert(&upload); err != nil {
+		_ = h.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+		h.Error(w, r, fmt.Sprintf("Unable to insert upload for filename %q in bin %q: %s", upload.Filename, bin.Id, err.Error()), "Database error", 1410, http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("created resumable upload", "upload", upload.Id, "filename", upload.Filename, "bin", bin.Id, "bytes", upload.Bytes)
+
+	var location url.URL
+	location.Scheme = h.config.BaseUrl.Scheme
+	location.Host = h.config.BaseUrl.Host
+	location.Path = path.Join(h.config.BaseUrl.Path, "tus", bin.Id, upload.Id)
+	w.Header().Set("Location", location.String())
+	w.Header().Set("Upload-Expires", upload.ExpiredAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusLookup returns the upload that the request refers to. The error response
+// is written to the client if the upload does not exist or is no longer
+// available.
+func (h *HTTP) tusLookup(w http.ResponseWriter, r *http.Request) (ds.Upload, bool) {
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputUpload := params["upload"]
+
+	upload, found, err := h.dao.Upload().GetByID(inputUpload)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select upload by id %q: %s", inputUpload, err.Error()), "Database error", 1411, http.StatusInternalServerError)
+		return upload, false
+	}
+	if !found || upload.Bin != inputBin {
+		h.Error(w, r, "", "The upload does not exist", 1412, http.StatusNotFound)
+		return upload, false
+	}
+	if upload.IsExpired() {
+		h.Error(w, r, "", "The upload has expired", 1413, http.StatusGone)
+		return upload, false
+	}
+	return upload, true
+}
+
+func (h *HTTP) tusHead(w http.ResponseWriter, r *http.Request) {
+	h.tusHeaders(w)
+	if !h.tusVersionSupported(w, r) {
+		return
+	}
+
+	upload, ok := h.tusLookup(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatUint(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatUint(upload.Bytes, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiredAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HTTP) tusPatch(w http.ResponseWriter, r *http.Request) {
+	h.tusHeaders(w)
+	if !h.tusVersionSupported(w, r) {
+		return
+	}
+
+	t0 := time.Now()
+
+	h.metrics.IncrFileUploadInProgress()
+	defer h.metrics.DecrFileUploadInProgress()
+
+	release, ok := h.clientUploadStarted(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		h.Error(w, r, "", "Content-Type must be application/offset+octet-stream", 1414, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	inputOffset, err := strconv.ParseUint(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		h.Error(w, r, "", "Missing or invalid Upload-Offset header", 1415, http.StatusBadRequest)
+		return
+	}
+
+	upload, ok := h.tusLookup(w, r)
+	if !ok {
+		return
+	}
+
+	if inputOffset != upload.Offset {
+		h.Error(w, r, fmt.Sprintf("Resumable upload %q got offset %d, expected %d", upload.Id, inputOffset, upload.Offset), "Upload-Offset does not match the current offset", 1417, http.StatusConflict)
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(upload.Bin)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", upload.Bin, err.Error()), "Database error", 1418, http.StatusInternalServerError)
+		return
+	}
+	if !found || !bin.IsWritable() {
+		h.Error(w, r, fmt.Sprintf("Resumable upload %q rejected since bin %q is no longer writable", upload.Id, upload.Bin), "The bin is no longer available for uploads", 1419, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !upload.IsComplete() {
+		if r.ContentLength > 0 && uint64(r.ContentLength) > upload.Bytes-upload.Offset {
+			h.Error(w, r, fmt.Sprintf("Resumable upload %q received more than %d bytes", upload.Id, upload.Bytes), "The request body exceeds the upload length", 1423, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		previous := upload
+		nBytes, copyErr, ok := h.tusReceive(w, r, &upload)
+		if !ok {
+			return
+		}
+
+		// Record the bytes that were received, also when the request was
+		// interrupted, so that the client can resume from there.
+		upload.ExpiredAt = time.Now().UTC().Add(h.config.ResumableUploadTTL)
+		updated, err := h.dao.Upload().UpdateOffset(&upload, previous.Offset)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to update offset of upload %q: %s", upload.Id, err.Error()), "Database error", 1424, http.StatusInternalServerError)
+			return
+		}
+		if !updated {
+			h.Error(w, r, fmt.Sprintf("Offset of upload %q was modified concurrently", upload.Id), "Upload-Offset does not match the current offset", 1425, http.StatusConflict)
+			return
+		}
+		h.metrics.IncrBytesClientToFilebin(nBytes)
+
+		// The previous tail has been replaced by the parts and the new tail
+		if key := previous.TailKey(); key != "" && key != upload.TailKey() {
+			if err := h.s3.RemoveKey(key); err != nil {
+				slog.Warn("unable to remove tail of resumable upload", "upload", upload.Id, "key", key, "error", err)
+			}
+		}
+
+		if copyErr != nil {
+			h.Error(w, r, fmt.Sprintf("Resumable upload %q interrupted at %s of %s: %s", upload.Id, humanize.Bytes(upload.Offset), humanize.Bytes(upload.Bytes), copyErr.Error()), "Storage error", 1426, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatUint(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiredAt.Format(http.TimeFormat))
+
+	if !upload.IsComplete() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// All bytes have been received, so store the file. If this fails, the
+	// upload is kept and the client can retry by sending an empty PATCH
+	// request at the final offset.
+	if ok := h.tusComplete(w, r, &bin, upload, t0); !ok {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusReceive stores the bytes of the request body at the offset of the
+// upload. The bytes are stored as parts of the part size of the upload,
+// where the first part starts with the tail that was received earlier, and
+// the bytes that do not fill a part are stored as the new tail. The offset
+// of the upload is moved past the bytes that were stored, and the number of
+// bytes received from the client is returned. If the request body was
+// interrupted, the error is returned after the bytes received until then
+// have been stored. The error response is written to the client if the
+// bytes can not be stored.
+func (h *HTTP) tusReceive(w http.ResponseWriter, r *http.Request, upload *ds.Upload) (nBytes uint64, copyErr error, ok bool) {
+	fp, err := h.workspace.CreateTempFile(upload.PartSize, fmt.Sprintf("upload-%s-", time.Now().Format("20060102-150405")))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to create part file for resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1420, http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	tail := upload.TailBytes()
+	if tail > 0 {
+		if err := h.tusReadTail(fp, *upload); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to read tail of resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1421, http.StatusInternalServerError)
+			return 0, nil, false
+		}
+	}
+	start := upload.Offset - tail
+
+	// Accept one byte more than remaining to be able to detect clients
+	// that send more than the declared upload length.
+	body := io.LimitReader(r.Body, int64(upload.Bytes-upload.Offset)+1)
+	for {
+		size := min(upload.PartSize, upload.Bytes-start)
+		n, err := io.CopyN(fp, body, int64(size-(upload.Offset-start)))
+		nBytes += uint64(n)
+		upload.Offset += uint64(n)
+		if err != nil && err != io.EOF {
+			copyErr = err
+		}
+		if upload.Offset-start < size {
+			break
+		}
+
+		if upload.Offset == upload.Bytes {
+			if n, _ := body.Read(make([]byte, 1)); n > 0 {
+				h.Error(w, r, fmt.Sprintf("Resumable upload %q received more than %d bytes", upload.Id, upload.Bytes), "The request body exceeds the upload length", 1423, http.StatusRequestEntityTooLarge)
+				return 0, nil, false
+			}
+		}
+
+		number := int32(start/upload.PartSize) + 1
+		if err := h.tusStorePart(fp, *upload, number, size); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to store part %d of resumable upload %q: %s", number, upload.Id, err.Error()), "Storage error", 1422, http.StatusInternalServerError)
+			return 0, nil, false
+		}
+		start += size
+		if upload.Offset == upload.Bytes || copyErr != nil {
+			break
+		}
+		if err := resetFile(fp); err != nil {
+			h.Error(w, r, fmt.Sprintf("Failed to reset part file for resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1420, http.StatusInternalServerError)
+			return 0, nil, false
+		}
+	}
+
+	if key := upload.TailKey(); key != "" {
+		if _, err := fp.Seek(0, io.SeekStart); err != nil {
+			h.Error(w, r, fmt.Sprintf("Failed to seek in part file for resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1420, http.StatusInternalServerError)
+			return 0, nil, false
+		}
+		if err := h.s3.PutKey(key, fp, int64(upload.TailBytes())); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to store tail of resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1433, http.StatusInternalServerError)
+			return 0, nil, false
+		}
+		h.metrics.IncrBytesFilebinToStorage(upload.TailBytes())
+	}
+	return nBytes, copyErr, true
+}
+
+// tusReadTail writes the tail of the upload to the part file.
+func (h *HTTP) tusReadTail(fp *os.File, upload ds.Upload) error {
+	tail, err := h.s3.GetObject(upload.TailKey(), 0, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tail.Close() }()
+	n, err := io.Copy(fp, tail)
+	h.metrics.IncrBytesStorageToFilebin(uint64(n))
+	if err != nil {
+		return err
+	}
+	if uint64(n) != upload.TailBytes() {
+		return fmt.Errorf("got %d bytes, expected %d", n, upload.TailBytes())
+	}
+	return nil
+}
+
+// tusStorePart uploads the part file as the given part of the upload.
+func (h *HTTP) tusStorePart(fp *os.File, upload ds.Upload, number int32, size uint64) error {
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	checksum := sha256.New()
+	if _, err := io.Copy(checksum, fp); err != nil {
+		return err
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := h.s3.UploadPart(upload.ObjectKey, upload.StorageUploadId, number, fp, int64(size), base64.StdEncoding.EncodeToString(checksum.Sum(nil))); err != nil {
+		return err
+	}
+	h.metrics.IncrBytesFilebinToStorage(size)
+	return nil
+}
+
+// resetFile empties the file for the next part.
+func resetFile(fp *os.File) error {
+	if err := fp.Truncate(0); err != nil {
+		return err
+	}
+	_, err := fp.Seek(0, io.SeekStart)
+	return err
+}
+
+// verifyTusUploadParts checks that S3 has received every part of the
+// upload in full.
+func verifyTusUploadParts(upload ds.Upload, received []s3.Part) error {
+	expected := int((upload.Bytes + upload.PartSize - 1) / upload.PartSize)
+	if len(received) != expected {
+		return fmt.Errorf("expected %d parts, storage has received %d", expected, len(received))
+	}
+	for i, part := range received {
+		number := i + 1
+		if int(part.Number) != number {
+			return fmt.Errorf("part %d is missing", number)
+		}
+		size := min(upload.PartSize, upload.Bytes-uint64(i)*upload.PartSize)
+		if uint64(part.Size) != size {
+			return fmt.Errorf("part %d has %d bytes, expected %d", number, part.Size, size)
+		}
+	}
+	return nil
+}
+
+// tusComplete assembles the parts of a fully received upload, checksums the
+// content and stores it in the bin.
+func (h *HTTP) tusComplete(w http.ResponseWriter, r *http.Request, bin *ds.Bin, upload ds.Upload, t0 time.Time) bool {
+	received, err := h.s3.ListParts(upload.ObjectKey, upload.StorageUploadId)
+	if err != nil {
+		// The parts may have been assembled by an earlier request that
+		// failed later on, in which case the staged object exists.
+		stat, statErr := h.s3.StatObject(upload.ObjectKey)
+		if statErr != nil || stat.ContentLength == nil || uint64(*stat.ContentLength) != upload.Bytes {
+			h.Error(w, r, fmt.Sprintf("Unable to list parts of resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1427, http.StatusInternalServerError)
+			return false
+		}
+		slog.Debug("resumable upload is already assembled", "upload", upload.Id)
+	} else {
+		if err := verifyTusUploadParts(upload, received); err != nil {
+			h.Error(w, r, fmt.Sprintf("Resumable upload %q failed verification: %s", upload.Id, err.Error()), "Storage error", 1434, http.StatusInternalServerError)
+			return false
+		}
+		if err := h.s3.CompleteMultipartUpload(upload.ObjectKey, upload.StorageUploadId, received); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to complete resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1427, http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	// Read the staged object back to compute the checksums of the entire
+	// content, which S3 does not provide for multipart uploads.
+	fp, err := h.s3.GetObject(upload.ObjectKey, 0, 0)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read staged object of resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1428, http.StatusInternalServerError)
+		return false
+	}
+	md5Checksum := md5.New()
+	sha256Checksum := sha256.New()
+	nBytes, err := io.Copy(io.MultiWriter(md5Checksum, sha256Checksum), fp)
+	_ = fp.Close()
+	h.metrics.IncrBytesStorageToFilebin(uint64(nBytes))
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to read staged object of resumable upload %q: %s", upload.Id, err.Error()), "Storage error", 1428, http.StatusInternalServerError)
+		return false
+	}
+	if uint64(nBytes) != upload.Bytes {
+		h.Error(w, r, fmt.Sprintf("Staged object of resumable upload %q has %d bytes, expected %d", upload.Id, nBytes, upload.Bytes), "Storage error", 1429, http.StatusInternalServerError)
+		return false
+	}
+
+	_, ok := h.storeFile(w, r, bin, receivedFile{
+		staged:   upload.ObjectKey,
+		filename: upload.Filename,
+		bytes:    nBytes,
+		md5:      base64.StdEncoding.EncodeToString(md5Checksum.Sum(nil)),
+		sha256:   fmt.Sprintf("%x", sha256Checksum.Sum(nil)),
+	}, t0)
+	if !ok {
+		return false
+	}
+
+	if !h.discardTusUpload(upload) {
+		slog.Error("unable to discard completed upload", "upload", upload.Id)
+	}
+	return true
+}
+
+// discardTusUpload removes the parts, the staged object and the tails of a
+// resumable upload from S3, and the upload from the database.
+func (h *HTTP) discardTusUpload(upload ds.Upload) bool {
+	// The multipart upload no longer exists if the parts were assembled, so
+	// an error here is expected in that case.
+	_ = h.s3.AbortMultipartUpload(upload.ObjectKey, upload.StorageUploadId)
+	if err := h.s3.RemoveKeysWithPrefix(upload.ObjectKey); err != nil {
+		slog.Error("unable to remove staged objects", "upload", upload.Id, "key", upload.ObjectKey, "error", err)
+		return false
+	}
+	if err := h.dao.Upload().Delete(&upload); err != nil {
+		slog.Error("unable to delete upload", "upload", upload.Id, "error", err)
+		return false
+	}
+	return true
+}
+
+func (h *HTTP) tusDelete(w http.ResponseWriter, r *http.Request) {
+	h.tusHeaders(w)
+	if !h.tusVersionSupported(w, r) {
+		return
+	}
+
+	upload, ok := h.tusLookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.discardTusUpload(upload) {
+		h.Error(w, r, fmt.Sprintf("Unable to discard resumable upload %q", upload.Id), "Storage error", 1431, http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("terminated resumable upload", "upload", upload.Id, "filename", upload.Filename, "bin", upload.Bin, "offset", upload.Offset, "bytes", upload.Bytes)
+	w.WriteHeader(http.StatusNoContent)
+}
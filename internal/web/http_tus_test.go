@@ -0,0 +1,407 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/s3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func tusRequest(method, url string, headers map[string]string, body string) (*http.Response, string, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, "", err
+	}
+	return resp, string(content), nil
+}
+
+func tusCreateUpload(t *testing.T, bin string, filename string, length int) string {
+	resp, body, err := tusRequest("POST", "http://localhost:8080/tus/"+bin, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}, "")
+	if err != nil {
+		t.Fatalf("Unable to create upload: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d when creating upload, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.Contains(location, "/tus/"+bin+"/") {
+		t.Fatalf("Unexpected upload location %q", location)
+	}
+	// Use the local test server regardless of the configured base URL
+	return "http://localhost:8080" + location[strings.Index(location, "/tus/"):]
+}
+
+func TestTusOptions(t *testing.T) {
+	resp, _, err := tusRequest("OPTIONS", "http://localhost:8080/tus/tusoptions", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Tus-Version"); got != "1.0.0" {
+		t.Errorf("Expected Tus-Version 1.0.0, got %q", got)
+	}
+	if got := resp.Header.Get("Tus-Extension"); !strings.Contains(got, "creation") || !strings.Contains(got, "termination") {
+		t.Errorf("Expected creation and termination extensions, got %q", got)
+	}
+}
+
+func TestTusResumableUpload(t *testing.T) {
+	bin := "tusupload01"
+	content := "first part of the content|second part of the content"
+	split := strings.Index(content, "|")
+
+	uploadURL := tusCreateUpload(t, bin, "resumable.txt", len(content))
+
+	// The offset is zero before any content has been received
+	resp, _, err := tusRequest("HEAD", uploadURL, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Upload-Offset"); got != "0" {
+		t.Errorf("Expected Upload-Offset 0, got %q", got)
+	}
+	if got := resp.Header.Get("Upload-Length"); got != strconv.Itoa(len(content)) {
+		t.Errorf("Expected Upload-Length %d, got %q", len(content), got)
+	}
+
+	// Send the first part
+	resp, body, err := tusRequest("PATCH", uploadURL, map[string]string{
+		"Upload-Offset": "0",
+		"Content-Type":  "application/offset+octet-stream",
+	}, content[:split])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(split) {
+		t.Errorf("Expected Upload-Offset %d, got %q", split, got)
+	}
+
+	// The file is not available until the upload is complete
+	statusCode, _, err := httpRequest(TestCase{Method: "GET", Bin: bin, Filename: "resumable.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for incomplete upload, got %d", http.StatusNotFound, statusCode)
+	}
+
+	// Resuming from the wrong offset is rejected
+	resp, _, err = tusRequest("PATCH", uploadURL, map[string]string{
+		"Upload-Offset": "0",
+		"Content-Type":  "application/offset+octet-stream",
+	}, content[split:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status %d for wrong offset, got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	// Resume from the offset reported by HEAD
+	resp, _, err = tusRequest("HEAD", uploadURL, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := resp.Header.Get("Upload-Offset")
+	resp, body, err = tusRequest("PATCH", uploadURL, map[string]string{
+		"Upload-Offset": offset,
+		"Content-Type":  "application/offset+octet-stream",
+	}, content[split:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Upload-Offset"); got != strconv.Itoa(len(content)) {
+		t.Errorf("Expected Upload-Offset %d, got %q", len(content), got)
+	}
+
+	// The upload is removed once it is complete
+	resp, _, err = tusRequest("HEAD", uploadURL, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for completed upload, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	tcs := []TestCase{
+		{
+			Description:     "Download the file uploaded with a resumable upload",
+			Method:          "GET",
+			Bin:             bin,
+			Filename:        "resumable.txt",
+			StatusCode:      200,
+			DownloadContent: content,
+		},
+	}
+	runTests(tcs, t)
+}
+
+func TestTusAcrossInstances(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	// Stage the upload in parts of 5 MB
+	s3ao, err := s3.Init(s3.Config{
+		Endpoint:             testS3Endpoint,
+		Bucket:               testS3Bucket,
+		Region:               testS3Region,
+		AccessKey:            testS3AccessKey,
+		SecretKey:            testS3SecretKey,
+		Secure:               false,
+		PresignExpiry:        time.Second * 10,
+		Timeout:              time.Second * 30,
+		TransferTimeout:      time.Minute * 10,
+		MultipartPartSize:    5 * 1024 * 1024,
+		MultipartConcurrency: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.s3 = &s3ao
+
+	// Another instance that shares the database and the storage
+	other := &HTTP{
+		staticBox:       h.staticBox,
+		templateBox:     h.templateBox,
+		dao:             h.dao,
+		s3:              h.s3,
+		geodb:           h.geodb,
+		workspace:       h.workspace,
+		config:          h.config,
+		metrics:         h.metrics,
+		metricsRegistry: prometheus.NewRegistry(),
+	}
+	if err := other.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { other.Stop() })
+
+	content := make([]byte, 11*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/tus/tusinstancebin", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", strconv.Itoa(len(content)))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("large.bin")))
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	uploadPath := location[strings.Index(location, "/tus/"):]
+
+	// The chunks do not line up with the parts, and every chunk reaches
+	// another instance than the one before
+	offset := 0
+	for i, end := range []int{3 * 1024 * 1024, 7 * 1024 * 1024, len(content)} {
+		instance := h
+		if i%2 == 1 {
+			instance = other
+		}
+		req := httptest.NewRequest(http.MethodPatch, uploadPath, bytes.NewReader(content[offset:end]))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		rr := httptest.NewRecorder()
+		instance.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d for the chunk ending at %d, got %d. Body: %s", http.StatusNoContent, end, rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Upload-Offset"); got != strconv.Itoa(end) {
+			t.Errorf("Expected Upload-Offset %d, got %q", end, got)
+		}
+		offset = end
+	}
+
+	rr = httptest.NewRecorder()
+	other.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tusinstancebin/large.bin", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("Expected the content of the upload, got %d bytes", rr.Body.Len())
+	}
+
+	// The staged objects are removed once the upload is complete
+	uploadId := uploadPath[strings.LastIndex(uploadPath, "/")+1:]
+	objects, err := s3ao.ListObjectsWithPrefix("staging/" + uploadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Expected the staged objects to be removed, got %d", len(objects))
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	uploadURL := tusCreateUpload(t, "tusupload02", "terminated.txt", 10)
+
+	resp, _, err := tusRequest("DELETE", uploadURL, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	resp, _, err = tusRequest("PATCH", uploadURL, map[string]string{
+		"Upload-Offset": "0",
+		"Content-Type":  "application/offset+octet-stream",
+	}, "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d for terminated upload, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestTusInvalidRequests(t *testing.T) {
+	tcs := []struct {
+		name       string
+		headers    map[string]string
+		statusCode int
+	}{
+		{
+			name: "missing upload length",
+			headers: map[string]string{
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")),
+			},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name: "missing filename",
+			headers: map[string]string{
+				"Upload-Length": "10",
+			},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name: "empty upload",
+			headers: map[string]string{
+				"Upload-Length":   "0",
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")),
+			},
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name: "illegal extension",
+			headers: map[string]string{
+				"Upload-Length":   "10",
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.illegal1")),
+			},
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name: "unsupported version",
+			headers: map[string]string{
+				"Tus-Resumable":   "0.2.2",
+				"Upload-Length":   "10",
+				"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("a.txt")),
+			},
+			statusCode: http.StatusPreconditionFailed,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body, err := tusRequest("POST", "http://localhost:8080/tus/tusinvalid", tc.headers, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.statusCode {
+				t.Errorf("Expected status %d, got %d: %s", tc.statusCode, resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   map[string]string{},
+		},
+		{
+			name:   "filename and type",
+			header: "filename cGhvdG8uanBn,filetype aW1hZ2UvanBlZw==",
+			want:   map[string]string{"filename": "photo.jpg", "filetype": "image/jpeg"},
+		},
+		{
+			name:   "key without value",
+			header: "is_confidential, filename YS50eHQ=",
+			want:   map[string]string{"is_confidential": "", "filename": "a.txt"},
+		},
+		{
+			name:    "invalid base64",
+			header:  "filename !!!",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTusMetadata(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTusMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseTusMetadata() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("parseTusMetadata()[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// The query parameter that selects an earlier version of a file to download
+const versionParameter = "version"
+
+// keepVersion records the content that a file had before it is replaced by
+// an upload of the same filename. A file that is uploaded again after it was
+// deleted starts over, so its history is removed instead. The error response
+// is written to the client if the history could not be updated.
+func (h *HTTP) keepVersion(w http.ResponseWriter, r *http.Request, previous ds.File, sha256 string) bool {
+	if previous.IsDeleted() {
+		if _, err := h.dao.FileVersion().DeleteByFile(previous.Id); err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to delete the versions of filename %q in bin %q: %s", previous.Filename, previous.Bin, err.Error()), "Database error", 3301, http.StatusInternalServerError)
+			return false
+		}
+		return true
+	}
+
+	// Uploading the same content again does not make a new version
+	if previous.SHA256 == sha256 {
+		return true
+	}
+
+	version := ds.FileVersion{
+		FileId:     previous.Id,
+		SHA256:     previous.SHA256,
+		IP:         previous.IP,
+		UploadedAt: previous.UpdatedAt,
+	}
+	if err := h.dao.FileVersion().Insert(&version); err != nil {
+		h.Error(w, r, fmt.Sprintf("Unable to keep the previous version of filename %q in bin %q: %s", previous.Filename, previous.Bin, err.Error()), "Database error", 3302, http.StatusInternalServerError)
+		return false
+	}
+	slog.Debug("kept file version", "filename", previous.Filename, "bin", previous.Bin, "sha256", version.SHA256, "version", version.Id)
+	return true
+}
+
+// attachVersions adds the earlier versions that are still in storage to the
+// files in the bin
+func (h *HTTP) attachVersions(bin *ds.Bin, files []ds.File) {
+	if len(files) == 0 {
+		return
+	}
+	versions, err := h.dao.FileVersion().GetByBin(bin.Id)
+	if err != nil {
+		// The bin is usable without the history
+		slog.Error("unable to get file versions by bin", "bin", bin.Id, "error", err)
+		return
+	}
+	byFile := make(map[int][]ds.FileVersion)
+	for _, version := range versions {
+		if version.InStorage {
+			byFile[version.FileId] = append(byFile[version.FileId], version)
+		}
+	}
+	for i := range files {
+		files[i].Versions = byFile[files[i].Id]
+	}
+}
+
+// selectVersion replaces the content of the file with the version that is
+// requested in the query string, if any. The error response is written to
+// the client if the version is not available.
+func (h *HTTP) selectVersion(w http.ResponseWriter, r *http.Request, file *ds.File) bool {
+	input := r.URL.Query().Get(versionParameter)
+	if input == "" {
+		return true
+	}
+	id, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || id <= 0 {
+		h.Error(w, r, "", "Invalid version", 3303, http.StatusBadRequest)
+		return false
+	}
+	version, found, err := h.dao.FileVersion().Get(file.Id, id)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select version %d of filename %q in bin %q: %s", id, file.Filename, file.Bin, err.Error()), "Database error", 3304, http.StatusInternalServerError)
+		return false
+	}
+	if !found || !version.InStorage {
+		h.Error(w, r, "", "The version does not exist.", 3305, http.StatusNotFound)
+		return false
+	}
+	file.SHA256 = version.SHA256
+	file.MD5 = version.MD5
+	file.Mime = version.Mime
+	file.Bytes = version.Bytes
+	file.BytesReadable = version.BytesReadable
+	return true
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func fileVersions(t *testing.T, h *HTTP, bin string) []ds.FileVersion {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/"+bin, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var data struct {
+		Files []ds.File `json:"files"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Unable to parse response: %s", err)
+	}
+	if len(data.Files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(data.Files))
+	}
+	return data.Files[0].Versions
+}
+
+func TestFileVersions(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	for _, content := range []string{"first content", "second content", "third content", "third content"} {
+		rr := httptest.NewRecorder()
+		h.router.ServeHTTP(rr, uploadRequest("/versionbin/file.txt", content))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Uploading the same content again does not make a new version
+	versions := fileVersions(t, h, "versionbin")
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+
+	if code, body := downloadContent(t, h, "/versionbin/file.txt"); code != http.StatusOK || body != "third content" {
+		t.Errorf("Expected the latest content, got status %d and body %q", code, body)
+	}
+	expected := []string{"second content", "first content"}
+	for i, version := range versions {
+		code, body := downloadContent(t, h, fmt.Sprintf("/versionbin/file.txt?version=%d", version.Id))
+		if code != http.StatusOK || body != expected[i] {
+			t.Errorf("Expected version %d to be %q, got status %d and body %q", version.Id, expected[i], code, body)
+		}
+	}
+
+	tests := []struct {
+		description string
+		path        string
+		statusCode  int
+	}{
+		{"invalid version", "/versionbin/file.txt?version=first", http.StatusBadRequest},
+		{"missing version", fmt.Sprintf("/versionbin/file.txt?version=%d", versions[0].Id+1000), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if code, _ := downloadContent(t, h, test.path); code != test.statusCode {
+				t.Errorf("Expected status %d, got %d", test.statusCode, code)
+			}
+		})
+	}
+
+	// A file that is uploaded again after it was deleted starts over
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/versionbin/file.txt", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/versionbin/file.txt", "new content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	if versions := fileVersions(t, h, "versionbin"); len(versions) != 0 {
+		t.Errorf("Expected no versions, got %d", len(versions))
+	}
+}
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/highlight"
+	"github.com/gorilla/mux"
+)
+
+// Kinds of previews
+const (
+	previewText  = "text"
+	previewImage = "image"
+	previewAudio = "audio"
+	previewVideo = "video"
+	previewPDF   = "pdf"
+)
+
+const (
+	// Number of bytes of text files to show initially
+	previewTextBytes = 256 * 1024
+
+	// Number of bytes of text files to show at most. Larger files are
+	// only available for download.
+	maxPreviewTextBytes = 4 * 1024 * 1024
+)
+
+// previewKind returns the kind of preview of the file, or an empty string
+// if the file can not be previewed
+func previewKind(file ds.File) string {
+	switch {
+	case strings.HasPrefix(file.Mime, "image/"):
+		return previewImage
+	case strings.HasPrefix(file.Mime, "audio/"):
+		return previewAudio
+	case strings.HasPrefix(file.Mime, "video/"):
+		return previewVideo
+	case strings.HasPrefix(file.Mime, "application/pdf"):
+		return previewPDF
+	case strings.HasPrefix(file.Mime, "text/"), highlight.Language(file.Filename, file.Mime) != "":
+		return previewText
+	}
+	return ""
+}
+
+// previewLimit returns the number of bytes of a text file to show, which
+// is increased by the "show more" link
+func previewLimit(r *http.Request) uint64 {
+	limit := uint64(previewTextBytes)
+	if v, err := strconv.ParseUint(r.URL.Query().Get("bytes"), 10, 64); err == nil && v > limit {
+		limit = min(v, maxPreviewTextBytes)
+	}
+	return limit
+}
+
+// viewFile shows a file in a page with a viewer that depends on the content
+// type, along with the properties of the file.
+func (h *HTTP) viewFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "max-age=0")
+
+	// Files should never be indexed
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	params := mux.Vars(r)
+	inputBin := params["bin"]
+	inputFilename := params["filename"]
+
+	type Data struct {
+		ds.Common
+		Bin                  ds.Bin
+		File                 ds.File
+		Preview              string
+		Language             string
+		Lines                []highlight.Line
+		Truncated            bool
+		PreviewBytesReadable string
+		MoreUrl              string
+	}
+	var data Data
+	data.Page = "view"
+	data.Contact = h.config.Contact
+	data.BaseUrl = h.config.BaseUrl.String()
+
+	alias, binId, ok := h.resolveAlias(w, r, inputBin)
+	if !ok {
+		return
+	}
+
+	bin, found, err := h.dao.Bin().GetByID(binId)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select bin by id %q: %s", binId, err.Error()), "Database error", 2501, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The bin does not exist.", 2502, http.StatusNotFound)
+		return
+	}
+
+	if !bin.IsReadable() {
+		h.Error(w, r, "", "This bin is no longer available.", 2503, http.StatusNotFound)
+		return
+	}
+
+	if !h.binUnlocked(w, r, inputBin, &bin) {
+		return
+	}
+
+	if !bin.IsApproved() {
+		h.Error(w, r, "", "This bin requires approval before files can be downloaded.", 2504, http.StatusForbidden)
+		return
+	}
+
+	if !h.downloadPermitted(w, r) {
+		return
+	}
+
+	// The content of encrypted bins can only be decrypted in the bin page
+	if bin.Encrypted {
+		h.Error(w, r, "", "Previews are not available for files in encrypted bins.", 2505, http.StatusNotFound)
+		return
+	}
+
+	file, found, err := h.dao.File().GetByName(binId, inputFilename)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to select file by bin %q and filename %q: %s", binId, inputFilename, err.Error()), "Database error", 2506, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.Error(w, r, "", "The file does not exist.", 2507, http.StatusNotFound)
+		return
+	}
+
+	available, err := h.dao.File().IsAvailableForDownload(file.Id)
+	if err != nil {
+		h.Error(w, r, fmt.Sprintf("Failed to check file availability: %s", err.Error()), "Database error", 2508, http.StatusInternalServerError)
+		return
+	}
+	if !available {
+		h.Error(w, r, "", "The file is not available for download.", 2509, http.StatusNotFound)
+		return
+	}
+
+	if h.downloadLimitReached(file) {
+		h.Error(w, r, "", "The file has been requested too many times.", 2510, http.StatusForbidden)
+		return
+	}
+
+	if !h.cookieChallenge(w, r, bin, alias) {
+		return
+	}
+
+	if previewable(file) {
+		data.Preview = previewKind(file)
+	}
+	if data.Preview == previewText && r.Method != http.MethodHead {
+		limit := previewLimit(r)
+		text, truncated, err := h.readPreviewText(file, limit)
+		if err != nil {
+			h.Error(w, r, fmt.Sprintf("Unable to read file %q in bin %q for preview: %s", inputFilename, inputBin, err.Error()), "Storage error", 2511, http.StatusInternalServerError)
+			return
+		}
+		data.Language = highlight.Language(file.Filename, file.Mime)
+		data.Lines = highlight.Highlight(text, data.Language)
+		data.PreviewBytesReadable = humanize.Bytes(uint64(len(text)))
+		data.Truncated = truncated
+		if data.Truncated && limit < maxPreviewTextBytes {
+			data.MoreUrl = fmt.Sprintf("?bytes=%d", min(limit*4, maxPreviewTextBytes))
+		}
+
+		// The text is delivered to the client, so every preview counts
+		// as a download, including the ones that show more of the text
+		if !h.registerDownload(w, r, bin, &file) {
+			return
+		}
+		h.metrics.IncrFileDownloadCount()
+	}
+
+	if alias != nil {
+		files := []ds.File{file}
+		alias.Mask(&bin, files)
+		file = files[0]
+	}
+	data.Bin = bin
+	data.File = file
+
+	var binURL url.URL
+	binURL.Scheme = h.config.BaseUrl.Scheme
+	binURL.Host = h.config.BaseUrl.Host
+	binURL.Path = path.Join(h.config.BaseUrl.Path, bin.Id)
+	data.BinUrl = binURL.String()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.renderTemplate(w, "view", data); err != nil {
+		slog.Error("failed to execute template", "error", err)
+		return
+	}
+}
+
+// readPreviewText reads up to limit bytes from the beginning of a text file,
+// and reports whether the text is truncated. Truncated text ends at the last
+// complete line.
+func (h *HTTP) readPreviewText(file ds.File, limit uint64) (string, bool, error) {
+	n := min(limit, file.Bytes)
+	if n == 0 {
+		return "", false, nil
+	}
+	fp, err := h.s3.GetObject(file.SHA256, 0, int64(n)-1)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() { _ = fp.Close() }()
+	buf, err := io.ReadAll(io.LimitReader(fp, int64(n)))
+	if err != nil {
+		return "", false, err
+	}
+	h.metrics.IncrBytesStorageToFilebin(uint64(len(buf)))
+
+	truncated := uint64(len(buf)) < file.Bytes
+	if truncated {
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			buf = buf[:i+1]
+		}
+	}
+	return strings.ToValidUTF8(string(buf), "\uFFFD"), truncated, nil
+}
@@ -0,0 +1,154 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestViewFile(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	source := "package main\n\n// main does nothing\nfunc main() {}\n"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/viewbin/main.go", source))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/view/viewbin/main.go", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `<span class="hl-keyword">package</span>`) {
+		t.Error("Expected the source code to be highlighted")
+	}
+	if !strings.Contains(body, contentSHA256(source)) {
+		t.Error("Expected the checksum of the file")
+	}
+	if strings.Contains(body, "Show more") {
+		t.Error("Expected the complete file to be shown")
+	}
+
+	// Large text files are truncated
+	line := strings.Repeat("x", 99) + "\n"
+	large := strings.Repeat(line, previewTextBytes/len(line)+100)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/viewbin/large.txt", large))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/view/viewbin/large.txt", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Show more") {
+		t.Error("Expected the large file to be truncated")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/view/viewbin/large.txt?bytes=1048576", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "Show more") {
+		t.Error("Expected the complete file to be shown")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/view/viewbin/missing.txt", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestViewPDFInline(t *testing.T) {
+	h := setupProxyDownloadHandler(t)
+
+	content := "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n"
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/pdfviewbin/document.pdf", content))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/view/pdfviewbin/document.pdf", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `src="/pdfviewbin/document.pdf?inline"`) {
+		t.Error("Expected the PDF file to be embedded")
+	}
+
+	// PDF files are downloaded unless they are embedded by the preview
+	req = httptest.NewRequest(http.MethodGet, "/pdfviewbin/document.pdf", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("Expected an attachment, got %q", cd)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/pdfviewbin/document.pdf?inline", nil)
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "inline") {
+		t.Errorf("Expected the PDF file inline, got %q", cd)
+	}
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "sandbox") {
+		t.Errorf("Expected the PDF file to be sandboxed, got %q", csp)
+	}
+}
+
+func TestPreviewKind(t *testing.T) {
+	tests := []struct {
+		filename string
+		mime     string
+		expected string
+	}{
+		{"photo.jpg", "image/jpeg", previewImage},
+		{"song.mp3", "audio/mpeg", previewAudio},
+		{"movie.mp4", "video/mp4", previewVideo},
+		{"document.pdf", "application/pdf", previewPDF},
+		{"notes.txt", "text/plain; charset=utf-8", previewText},
+		{"data.json", "application/json", previewText},
+		{"archive.zip", "application/zip", ""},
+	}
+	for _, test := range tests {
+		file := ds.File{Filename: test.filename, Mime: test.mime}
+		if got := previewKind(file); got != test.expected {
+			t.Errorf("Expected preview %q for %s, got %q", test.expected, test.filename, got)
+		}
+	}
+}
+
+func TestPreviewLimit(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected uint64
+	}{
+		{"", previewTextBytes},
+		{"?bytes=10", previewTextBytes},
+		{"?bytes=invalid", previewTextBytes},
+		{"?bytes=1048576", 1048576},
+		{"?bytes=1000000000", maxPreviewTextBytes},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/view/bin/file.txt"+test.query, nil)
+		if got := previewLimit(req); got != test.expected {
+			t.Errorf("Expected a limit of %d for %q, got %d", test.expected, test.query, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/gorilla/mux"
+)
+
+// viewAdminWebhooks lists the webhook deliveries that have not been
+// delivered successfully
+func (h *HTTP) viewAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	type Data struct {
+		Page       string               `json:"-"`
+		Enabled    bool                 `json:"enabled"`
+		Deliveries []ds.WebhookDelivery `json:"deliveries"`
+	}
+	data := Data{
+		Page:    "webhooks",
+		Enabled: h.webhooks.Enabled(),
+	}
+
+	deliveries, err := h.dao.Webhook().GetUndelivered(100)
+	if err != nil {
+		slog.Error("unable to get webhook deliveries", "error", err)
+		http.Error(w, "Errno 2201", http.StatusInternalServerError)
+		return
+	}
+	data.Deliveries = deliveries
+
+	if r.Header.Get("accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		out, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			slog.Error("failed to parse json", "error", err)
+			http.Error(w, "Errno 2202", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write(out)
+		return
+	}
+
+	if err := h.renderTemplate(w, "admin_webhooks", data); err != nil {
+		slog.Error("failed to execute template", "error", err)
+		http.Error(w, "Errno 2203", http.StatusInternalServerError)
+		return
+	}
+}
+
+// replayWebhook delivers a failed webhook delivery again
+func (h *HTTP) replayWebhook(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook delivery", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dao.Webhook().Replay(id); err != nil {
+		slog.Error("unable to replay webhook delivery", "id", id, "error", err)
+		http.Error(w, "Unable to replay the webhook delivery", http.StatusNotFound)
+		return
+	}
+
+	slog.Info("replaying webhook delivery", "id", id)
+
+	// Redirect back to the webhook deliveries page
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+	"github.com/espebra/filebin2/internal/geoip"
+	"github.com/espebra/filebin2/internal/webhook"
+	"github.com/espebra/filebin2/internal/workspace"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// webhookReceiver records the webhook events it receives, and responds
+// with the given status code
+type webhookReceiver struct {
+	mu         sync.Mutex
+	statusCode int
+	events     []webhook.Event
+	verified   bool
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.verified = r.Header.Get(webhook.SignatureHeader) == "sha256="+webhook.Sign("webhooksecret", timestamp, body)
+	var event webhook.Event
+	if err := json.Unmarshal(body, &event); err == nil {
+		rcv.events = append(rcv.events, event)
+	}
+	w.WriteHeader(rcv.statusCode)
+}
+
+func setupWebhookHandler(t *testing.T, url string) *HTTP {
+	t.Helper()
+
+	dao, s3ao, err := tearUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tearDown(dao) })
+
+	geodb, err := geoip.Init("../../mmdb/GeoLite2-ASN.mmdb", "../../mmdb/GeoLite2-City.mmdb")
+	if err != nil {
+		t.Fatalf("Unable to load geoip database: %s", err)
+	}
+
+	wm, err := workspace.NewManager(os.TempDir(), 4.0)
+	if err != nil {
+		t.Fatalf("Unable to initialize workspace manager: %s", err)
+	}
+
+	c := ds.Config{
+		Expiration:    testExpiredAt,
+		AdminUsername: "admin",
+		AdminPassword: "changeme",
+	}
+
+	metricsRegistry := prometheus.NewRegistry()
+	metrics := ds.NewMetrics("test", metricsRegistry)
+
+	webhooks := webhook.New(&dao, []string{url}, "webhooksecret")
+	webhooks.Init(5*time.Second, 2)
+
+	h := &HTTP{
+		staticBox:       &staticBox,
+		templateBox:     &templateBox,
+		dao:             &dao,
+		s3:              &s3ao,
+		geodb:           &geodb,
+		workspace:       wm,
+		config:          &c,
+		metrics:         metrics,
+		metricsRegistry: metricsRegistry,
+		webhooks:        webhooks,
+	}
+	if err := h.Init(); err != nil {
+		t.Fatalf("Failed to initialize HTTP handler: %v", err)
+	}
+	t.Cleanup(func() { h.Stop() })
+
+	return h
+}
+
+func TestWebhookEvents(t *testing.T) {
+	rcv := &webhookReceiver{statusCode: http.StatusOK}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	h := setupWebhookHandler(t, server.URL)
+
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, uploadRequest("/webhookbin/file.txt", "webhook content"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/webhookbin/file.txt", nil)
+	req.Header.Set("Owner-Token", rr.Header().Get("Owner-Token"))
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	h.webhooks.DeliverPending()
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	expected := []string{webhook.BinCreated, webhook.FileUploaded, webhook.FileDeleted}
+	if len(rcv.events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(rcv.events), rcv.events)
+	}
+	for i, event := range expected {
+		if rcv.events[i].Event != event {
+			t.Errorf("Expected event %d to be %q, got %q", i, event, rcv.events[i].Event)
+		}
+		if rcv.events[i].Bin == nil || rcv.events[i].Bin.Id != "webhookbin" {
+			t.Errorf("Expected the bin in event %q", event)
+		}
+	}
+	if rcv.events[1].File == nil || rcv.events[1].File.Filename != "file.txt" || rcv.events[1].File.SHA256 != contentSHA256("webhook content") {
+		t.Errorf("Expected the file in the upload event, got %+v", rcv.events[1].File)
+	}
+	if !rcv.verified {
+		t.Error("Expected the webhook requests to be signed with the webhook secret")
+	}
+}
+
+func TestWebhookRetryAndReplay(t *testing.T) {
+	rcv := &webhookReceiver{statusCode: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	h := setupWebhookHandler(t, server.URL)
+
+	h.webhooks.Enqueue(webhook.BinEvent(webhook.BinLocked, ds.Bin{Id: "webhookretrybin"}))
+	h.webhooks.DeliverPending()
+
+	deliveries, err := h.dao.Webhook().GetUndelivered(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 undelivered delivery, got %d", len(deliveries))
+	}
+	delivery := deliveries[0]
+	if delivery.Status != ds.WebhookPending || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a pending delivery with one failed attempt, got %+v", delivery)
+	}
+	if !delivery.NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected the next attempt to be delayed, got %s", delivery.NextAttemptAt)
+	}
+
+	// Make the retry due, and give up after the second failed attempt
+	delivery.NextAttemptAt = time.Now().UTC().Add(-time.Second)
+	if err := h.dao.Webhook().Update(&delivery); err != nil {
+		t.Fatal(err)
+	}
+	h.webhooks.DeliverPending()
+	delivery, _, err = h.dao.Webhook().GetByID(delivery.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != ds.WebhookFailed || delivery.Attempts != 2 {
+		t.Fatalf("Expected the delivery to fail after 2 attempts, got %q after %d attempts", delivery.Status, delivery.Attempts)
+	}
+
+	// The failed delivery is shown in the admin view
+	req := httptest.NewRequest(http.MethodGet, "/admin/webhooks", nil)
+	req.SetBasicAuth("admin", "changeme")
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var view struct {
+		Deliveries []ds.WebhookDelivery `json:"deliveries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.Deliveries) != 1 || view.Deliveries[0].Id != delivery.Id {
+		t.Errorf("Expected the failed delivery in the admin view, got %+v", view.Deliveries)
+	}
+
+	// Replay the delivery once the receiver is back
+	rcv.mu.Lock()
+	rcv.statusCode = http.StatusOK
+	rcv.mu.Unlock()
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/webhooks/"+strconv.FormatInt(delivery.Id, 10)+"/replay", nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
+	}
+
+	h.webhooks.DeliverPending()
+	delivery, _, err = h.dao.Webhook().GetByID(delivery.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != ds.WebhookDelivered {
+		t.Errorf("Expected the replayed delivery to be delivered, got %q", delivery.Status)
+	}
+
+	// Only failed deliveries can be replayed
+	req = httptest.NewRequest(http.MethodPost, "/admin/webhooks/"+strconv.FormatInt(delivery.Id, 10)+"/replay", nil)
+	req.SetBasicAuth("admin", "changeme")
+	rr = httptest.NewRecorder()
+	h.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
@@ -0,0 +1,207 @@
+// End-to-end encryption for encrypted bins. File contents and filenames are
+// encrypted in the browser with AES-GCM before they are uploaded, so the
+// server only ever sees ciphertext. The key is kept in the fragment of the
+// bin URL (#key=...), which browsers never send to the server.
+//
+// Encrypted data is the 12 byte IV followed by the ciphertext. Encrypted
+// filenames are base64url encoded.
+var FilebinEncryption = (function () {
+    var ivLength = 12;
+
+    function supported() {
+        return !!(window.crypto && window.crypto.subtle);
+    }
+
+    function toBase64Url(bytes) {
+        var binary = "";
+        for (var i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    function fromBase64Url(s) {
+        s = s.replace(/-/g, "+").replace(/_/g, "/");
+        while (s.length % 4) {
+            s += "=";
+        }
+        var binary = atob(s);
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function generateKey() {
+        return crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt", "decrypt"]);
+    }
+
+    // exportKey returns the key in the form used in the URL fragment
+    function exportKey(key) {
+        return crypto.subtle.exportKey("raw", key).then(function (raw) {
+            return toBase64Url(new Uint8Array(raw));
+        });
+    }
+
+    function importKey(s) {
+        return crypto.subtle.importKey("raw", fromBase64Url(s), {name: "AES-GCM"}, false, ["encrypt", "decrypt"]);
+    }
+
+    // fragmentKey returns the exported key in the URL fragment, or null if
+    // the fragment does not contain a key
+    function fragmentKey() {
+        var match = /(?:^#|&)key=([A-Za-z0-9_-]+)/.exec(window.location.hash);
+        if (!match) {
+            return null;
+        }
+        return match[1];
+    }
+
+    // keyFromFragment resolves to the key in the URL fragment, or null if
+    // the fragment does not contain a key
+    function keyFromFragment() {
+        var exportedKey = fragmentKey();
+        if (exportedKey === null) {
+            return Promise.resolve(null);
+        }
+        return importKey(exportedKey);
+    }
+
+    function encrypt(key, data) {
+        var iv = crypto.getRandomValues(new Uint8Array(ivLength));
+        return crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, data).then(function (ciphertext) {
+            var out = new Uint8Array(ivLength + ciphertext.byteLength);
+            out.set(iv, 0);
+            out.set(new Uint8Array(ciphertext), ivLength);
+            return out;
+        });
+    }
+
+    function decrypt(key, data) {
+        var bytes = new Uint8Array(data);
+        var iv = bytes.slice(0, ivLength);
+        return crypto.subtle.decrypt({name: "AES-GCM", iv: iv}, key, bytes.slice(ivLength));
+    }
+
+    // encryptFile resolves to a blob with the encrypted content of the file
+    function encryptFile(key, file) {
+        return file.arrayBuffer().then(function (data) {
+            return encrypt(key, data);
+        }).then(function (ciphertext) {
+            return new Blob([ciphertext], {type: "application/octet-stream"});
+        });
+    }
+
+    function encryptName(key, name) {
+        return encrypt(key, new TextEncoder().encode(name)).then(toBase64Url);
+    }
+
+    function decryptName(key, encryptedName) {
+        return decrypt(key, fromBase64Url(encryptedName)).then(function (plaintext) {
+            return new TextDecoder().decode(plaintext);
+        });
+    }
+
+    // opaqueName returns a random filename for the encrypted file, which
+    // is what the server knows the file as
+    function opaqueName() {
+        var bytes = crypto.getRandomValues(new Uint8Array(16));
+        var name = "";
+        for (var i = 0; i < bytes.length; i++) {
+            name += ("0" + bytes[i].toString(16)).slice(-2);
+        }
+        return name;
+    }
+
+    return {
+        supported: supported,
+        generateKey: generateKey,
+        exportKey: exportKey,
+        importKey: importKey,
+        fragmentKey: fragmentKey,
+        keyFromFragment: keyFromFragment,
+        encryptFile: encryptFile,
+        encryptName: encryptName,
+        decrypt: decrypt,
+        decryptName: decryptName,
+        opaqueName: opaqueName
+    };
+})();
+
+// decryptBinPage decrypts the filenames in the file list of an encrypted
+// bin, and replaces the download links with links that decrypt the files
+// in the browser. Elements with the data-encrypted-name attribute get the
+// decrypted filename as text, and links with the data-encrypted-download
+// attribute download and decrypt the file.
+function decryptBinPage (messageBoxID) {
+    var box = document.getElementById(messageBoxID);
+
+    if (!FilebinEncryption.supported()) {
+        box.textContent = "The files in this bin are encrypted, but this browser does not support decrypting them.";
+        box.className = "alert alert-danger";
+        return;
+    }
+
+    FilebinEncryption.keyFromFragment().then(function (key) {
+        if (key === null) {
+            box.textContent = "The files in this bin are encrypted. The key is part of the link to the bin, which seems to be incomplete.";
+            box.className = "alert alert-warning";
+            return;
+        }
+
+        var names = document.querySelectorAll("[data-encrypted-name]");
+        names.forEach(function (el) {
+            var encryptedName = el.getAttribute("data-encrypted-name");
+            if (encryptedName === "") {
+                return;
+            }
+            FilebinEncryption.decryptName(key, encryptedName).then(function (name) {
+                el.textContent = name;
+            }).catch(function () {
+                el.textContent = "Unable to decrypt filename";
+                el.className += " text-danger";
+            });
+        });
+
+        var links = document.querySelectorAll("a[data-encrypted-download]");
+        links.forEach(function (link) {
+            link.addEventListener("click", function (ev) {
+                ev.preventDefault();
+                var encryptedName = link.getAttribute("data-encrypted-download");
+                var url = link.getAttribute("href");
+                var name = Promise.resolve(url.substring(url.lastIndexOf("/") + 1));
+                if (encryptedName !== "") {
+                    name = FilebinEncryption.decryptName(key, encryptedName);
+                }
+                fetch(url).then(function (resp) {
+                    if (!resp.ok) {
+                        throw new Error("Download failed with status " + resp.status);
+                    }
+                    return resp.arrayBuffer();
+                }).then(function (data) {
+                    return FilebinEncryption.decrypt(key, data);
+                }).then(function (plaintext) {
+                    return name.then(function (filename) {
+                        var a = document.createElement("a");
+                        a.href = URL.createObjectURL(new Blob([plaintext]));
+                        a.download = filename;
+                        document.body.appendChild(a);
+                        a.click();
+                        document.body.removeChild(a);
+                        setTimeout(function () {
+                            URL.revokeObjectURL(a.href);
+                        }, 1000);
+                    });
+                }).catch(function (e) {
+                    console.log("Unable to decrypt file: " + e);
+                    box.textContent = "Unable to download and decrypt the file. The key in the link may be wrong.";
+                    box.className = "alert alert-danger";
+                });
+            });
+        });
+    }).catch(function () {
+        box.textContent = "The key in the link to this bin is invalid.";
+        box.className = "alert alert-danger";
+    });
+}
@@ -24,7 +24,8 @@ function FileAPI (c, t, d, f, bin, binURL) {
         counter_failed = 0,
         concurrency = 4,
         fileQueue = [],
-        queueProcessorRunning = false;
+        queueProcessorRunning = false,
+        encryptionKey = null;
 
     function showDropZone() {
         dropZone.style.visibility = "visible";
@@ -60,6 +61,17 @@ function FileAPI (c, t, d, f, bin, binURL) {
         addFileListItems(this.files);
     }
 
+    // Encrypt files in the browser before they are uploaded. The key is
+    // added to the fragment of the bin URL, which is where the bin page
+    // reads it from.
+    this.setEncryptionKey = function (key, exportedKey) {
+        encryptionKey = key;
+        binURL = binURL.split("#")[0];
+        if (key !== null) {
+            binURL = binURL + "#key=" + exportedKey;
+        }
+    }
+
     function updateFileCount() {
         var box = document.getElementById('fileCount');
 
@@ -173,7 +185,7 @@ function FileAPI (c, t, d, f, bin, binURL) {
 
             var name = document.createElement("div");
             var strong = document.createElement("strong");
-            var nameText = document.createTextNode(file.name);
+            var nameText = document.createTextNode(file.webkitRelativePath || file.name);
             strong.appendChild(nameText);
             name.appendChild(strong);
             name.className = "col";
@@ -240,6 +252,20 @@ function FileAPI (c, t, d, f, bin, binURL) {
 
             var filesize = getReadableFileSizeString(file.size);
             var speed = container.getElementsByTagName("div")[2];
+
+            // XXX: Consider validating UTF-8 here
+            // Files in selected folders are uploaded with their relative
+            // path, which keeps the folder structure in the bin
+            var filename = file.webkitRelativePath || file.name;
+
+            // Encrypted files are uploaded with a random filename, which is
+            // kept across retries
+            if (encryptionKey !== null) {
+                if (!file.filebinOpaqueName) {
+                    file.filebinOpaqueName = FilebinEncryption.opaqueName();
+                }
+                filename = file.filebinOpaqueName;
+            }
             var bar = container.getElementsByTagName("div")[6];
 
             var xhr = new XMLHttpRequest();
@@ -366,7 +392,7 @@ function FileAPI (c, t, d, f, bin, binURL) {
                 }
                 postTelemetry("/api/telemetry/failure", {
                     bin: bin,
-                    filename: file.name,
+                    filename: filename,
                     upload_host: window.location.host,
                     upload_protocol: window.location.protocol,
                     script_host: filebinScriptURL ? filebinScriptURL.host : "",
@@ -421,7 +447,7 @@ function FileAPI (c, t, d, f, bin, binURL) {
                 }
                 postTelemetry("/api/telemetry/success", {
                     bin: bin,
-                    filename: file.name,
+                    filename: filename,
                     upload_host: window.location.host,
                     upload_protocol: window.location.protocol,
                     script_host: filebinScriptURL ? filebinScriptURL.host : "",
@@ -596,22 +622,50 @@ function FileAPI (c, t, d, f, bin, binURL) {
             xhr.onerror = handleNetworkError;
             upload.addEventListener("error", handleNetworkError, false);
 
-            // XXX: Consider validating UTF-8 here
-            var filename = file.name;
-
             // XXX: Do this properly using a path join function
             var uploadURL = "/" + bin + "/" + filename;
 
-            console.log("Uploading filename " + filename + " (" + file.size + " bytes) to bin " + bin + " at " + uploadURL + (retryAttempt > 0 ? " (retry " + retryAttempt + ")" : ""));
-            xhr.open(
-                "POST",
-                uploadURL
-            );
-            xhr.setRequestHeader("Cache-Control", "no-cache");
-            xhr.setRequestHeader("X-Requested-With", "XMLHttpRequest");
-            xhr.setRequestHeader("Size", file.size);
-            xhr.setRequestHeader("Bin", bin);
-            xhr.send(file);
+            var send = function (body, encryptedName) {
+                // Encryption happens before the upload starts, and should
+                // not count towards the speed or the stall detection
+                startTime = (new Date()).getTime();
+                lastProgressTime = startTime;
+                console.log("Uploading filename " + filename + " (" + body.size + " bytes) to bin " + bin + " at " + uploadURL + (retryAttempt > 0 ? " (retry " + retryAttempt + ")" : ""));
+                xhr.open(
+                    "POST",
+                    uploadURL
+                );
+                xhr.setRequestHeader("Cache-Control", "no-cache");
+                xhr.setRequestHeader("X-Requested-With", "XMLHttpRequest");
+                xhr.setRequestHeader("Size", body.size);
+                xhr.setRequestHeader("Bin", bin);
+                if (encryptedName) {
+                    xhr.setRequestHeader("Bin-Encrypted", "true");
+                    xhr.setRequestHeader("Encrypted-Filename", encryptedName);
+                }
+                xhr.send(body);
+            };
+
+            if (encryptionKey === null) {
+                send(file);
+                return;
+            }
+
+            speed.textContent = "Encrypting... (" + filesize + ")";
+            Promise.all([
+                FilebinEncryption.encryptFile(encryptionKey, file),
+                FilebinEncryption.encryptName(encryptionKey, file.name)
+            ]).then(function (encrypted) {
+                send(encrypted[0], encrypted[1]);
+            }).catch(function (e) {
+                console.log("Unable to encrypt " + file.name + ": " + e);
+                clearInterval(stallCheckInterval);
+                bar.className = "progress-bar bg-danger";
+                speed.textContent = "Encryption failed (" + filesize + ")";
+                counter_failed += 1;
+                counter_uploading -= 1;
+                updateFileCount();
+            });
         }
     }
 }
@@ -628,6 +682,29 @@ function getReadableFileSizeString(fileSizeInBytes) {
     return Math.max(fileSizeInBytes, 0.1).toFixed(1) + byteUnits[i];
 };
 
+// Collapse or expand a folder in the file listing of the bin page. The rows
+// in collapsed folders are hidden, including the rows in their subfolders.
+function toggleFolder (row) {
+    var collapsed = row.dataset.collapsed !== "true";
+    row.dataset.collapsed = collapsed;
+    row.querySelector("i").className = collapsed ? "far fa-fw fa-folder" : "far fa-fw fa-folder-open";
+
+    var table = row.closest("table");
+    var folders = {};
+    table.querySelectorAll("tr[data-folder]").forEach(function (r) {
+        folders[r.dataset.folder] = r;
+    });
+    table.querySelectorAll("tr[data-parent-folder]").forEach(function (r) {
+        var hidden = false;
+        var folder = r.dataset.parentFolder;
+        while (folder !== "" && !hidden) {
+            hidden = folders[folder] !== undefined && folders[folder].dataset.collapsed === "true";
+            folder = folder.substring(0, folder.lastIndexOf("/"));
+        }
+        r.style.display = hidden ? "none" : "";
+    });
+};
+
 function deleteURL (url, messageBoxID) {
     console.log("Delete url: " + url);
     var xhr = new XMLHttpRequest();
@@ -698,6 +775,259 @@ function lockBin (bin, messageBoxID) {
     xhr.send();
 };
 
+function setBinPassword (bin, inputID, messageBoxID) {
+    console.log("Set password of bin: " + bin);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var password = document.getElementById(inputID).value;
+
+    if (password === "") {
+        box.textContent = "The password can not be empty.";
+        box.className = "alert alert-danger";
+        return;
+    }
+
+    box.textContent = "Password operation in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 200 && xhr.readyState === 4) {
+            console.log("Password set successfully");
+            box.textContent = "The bin is now password protected.";
+            box.className = "alert alert-success";
+        } else {
+            console.log("Failed to set password");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "PUT",
+        "/password/" + bin
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"password": password}));
+};
+
+function removeBinPassword (bin, messageBoxID) {
+    console.log("Remove password of bin: " + bin);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+
+    box.textContent = "Password operation in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 200 && xhr.readyState === 4) {
+            console.log("Password removed successfully");
+            box.textContent = "The bin is no longer password protected.";
+            box.className = "alert alert-success";
+        } else if (xhr.status === 404 && xhr.readyState === 4) {
+            box.textContent = "The bin is not password protected.";
+            box.className = "alert alert-success";
+        } else {
+            console.log("Failed to remove password");
+            box.textContent = "Error " + xhr.status + ". Unable to verify the operation.";
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "DELETE",
+        "/password/" + bin
+    );
+
+    xhr.send();
+};
+
+function setBinExpiration (bin, inputID, messageBoxID) {
+    console.log("Set expiration of bin: " + bin);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var expiration = document.getElementById(inputID).value;
+
+    if (expiration === "") {
+        box.textContent = "The expiration time can not be empty.";
+        box.className = "alert alert-danger";
+        return;
+    }
+
+    box.textContent = "Expiration operation in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 200 && xhr.readyState === 4) {
+            console.log("Expiration set successfully");
+            var response = JSON.parse(xhr.responseText);
+            box.textContent = "The bin now expires " + response.expired_at_relative + ".";
+            box.className = "alert alert-success";
+        } else {
+            console.log("Failed to set expiration");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "PUT",
+        "/expiration/" + bin
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"expiration": expiration}));
+};
+
+function setBinMetadata (bin, titleID, descriptionID, messageBoxID) {
+    console.log("Set title and description of bin: " + bin);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var title = document.getElementById(titleID).value;
+    var description = document.getElementById(descriptionID).value;
+
+    box.textContent = "Update in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 200 && xhr.readyState === 4) {
+            console.log("Title and description set successfully");
+            box.textContent = "The title and the description are saved.";
+            box.className = "alert alert-success";
+        } else {
+            console.log("Failed to set title and description");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "PUT",
+        "/metadata/" + bin
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"title": title, "description": description}));
+};
+
+function setFileMetadata (url, descriptionID, tagsID, messageBoxID) {
+    console.log("Set description and tags of file: " + url);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var description = document.getElementById(descriptionID).value;
+
+    // Tags are separated by commas or whitespace
+    var tags = document.getElementById(tagsID).value.split(/[\s,]+/).filter(function (tag) {
+        return tag !== "";
+    });
+
+    box.textContent = "Update in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 200 && xhr.readyState === 4) {
+            console.log("Description and tags set successfully");
+            box.textContent = "The description and the tags are saved.";
+            box.className = "alert alert-success";
+        } else {
+            console.log("Failed to set description and tags");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "PUT",
+        url
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"description": description, "tags": tags}));
+};
+
+function transferFile (url, binID, filenameID, messageBoxID) {
+    console.log("Copy or move file: " + url);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var bin = document.getElementById(binID).value.trim();
+    var filename = document.getElementById(filenameID).value.trim();
+
+    box.textContent = "Copy in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 201 && xhr.readyState === 4) {
+            console.log("File copied successfully");
+            var data = JSON.parse(xhr.responseText);
+            window.location.href = "/" + data.bin.id;
+        } else {
+            console.log("Failed to copy file");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "POST",
+        url
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"bin": bin, "filename": filename}));
+};
+
+function cloneBin (bin, targetID, messageBoxID) {
+    console.log("Clone bin: " + bin);
+    var xhr = new XMLHttpRequest();
+    var box = document.getElementById(messageBoxID);
+    var target = document.getElementById(targetID).value.trim();
+
+    box.textContent = "Clone in progress ..."
+    box.className = "alert alert-dark";
+
+    xhr.onload = function() {
+        if (xhr.status === 201 && xhr.readyState === 4) {
+            console.log("Bin cloned successfully");
+            var data = JSON.parse(xhr.responseText);
+            window.location.href = "/" + data.id;
+        } else {
+            console.log("Failed to clone bin");
+            box.textContent = "Error " + xhr.status + ". " + xhr.responseText;
+            box.className = "alert alert-danger";
+        }
+    };
+
+    xhr.onerror = function () {
+        console.log("onerror: status: " + xhr.status + ", readystate: " + xhr.readyState);
+    };
+
+    xhr.open(
+        "POST",
+        "/clone/" + bin
+    );
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify({"bin": target}));
+};
+
 function banBin (bin, messageBoxID) {
     console.log("Ban bin: " + bin);
     var xhr = new XMLHttpRequest();
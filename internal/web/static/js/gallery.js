@@ -0,0 +1,55 @@
+// Gallery of the images in a bin. Clicking a thumbnail opens the large
+// thumbnail in a modal, where the previous and next images are shown with
+// the buttons or the arrow keys.
+var FilebinGallery = (function () {
+    var items = [];
+    var current = 0;
+    var modal = null;
+
+    function show(index) {
+        if (items.length === 0) {
+            return;
+        }
+        current = (index + items.length) % items.length;
+        var item = items[current];
+        var image = document.getElementById("galleryImage");
+        image.src = item.dataset.gallerySrc;
+        image.alt = item.dataset.galleryTitle;
+        document.getElementById("galleryTitle").textContent = item.dataset.galleryTitle;
+        document.getElementById("galleryPosition").textContent = (current + 1) + " of " + items.length;
+        document.getElementById("galleryDownload").href = item.href;
+    }
+
+    function init(modalID) {
+        var element = document.getElementById(modalID);
+        if (element === null) {
+            return;
+        }
+        modal = new bootstrap.Modal(element);
+        items = Array.prototype.slice.call(document.querySelectorAll("[data-gallery-src]"));
+        items.forEach(function (item, index) {
+            item.addEventListener("click", function (e) {
+                e.preventDefault();
+                show(index);
+                modal.show();
+            });
+        });
+        document.getElementById("galleryPrevious").addEventListener("click", function () {
+            show(current - 1);
+        });
+        document.getElementById("galleryNext").addEventListener("click", function () {
+            show(current + 1);
+        });
+        element.addEventListener("keydown", function (e) {
+            if (e.key === "ArrowLeft") {
+                show(current - 1);
+            } else if (e.key === "ArrowRight") {
+                show(current + 1);
+            }
+        });
+    }
+
+    return {
+        init: init
+    };
+})();
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// Event types
+const (
+	BinCreated     = "bin.created"
+	BinLocked      = "bin.locked"
+	BinApproved    = "bin.approved"
+	BinDeleted     = "bin.deleted"
+	BinExpired     = "bin.expired"
+	FileUploaded   = "file.uploaded"
+	FileDeleted    = "file.deleted"
+	ContentBlocked = "content.blocked"
+)
+
+// Event is the JSON payload of a webhook. Only the parts that are relevant
+// for the event type are set.
+type Event struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Bin       *Bin      `json:"bin,omitempty"`
+	File      *File     `json:"file,omitempty"`
+	Content   *Content  `json:"content,omitempty"`
+}
+
+// Bin describes the bin of an event. Secrets such as the owner token are
+// never part of the payload.
+type Bin struct {
+	Id        string    `json:"id"`
+	Readonly  bool      `json:"readonly"`
+	Approved  bool      `json:"approved"`
+	Encrypted bool      `json:"encrypted"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// File describes the file of an event
+type File struct {
+	Filename string `json:"filename"`
+	Bytes    uint64 `json:"bytes"`
+	Mime     string `json:"content-type"`
+	MD5      string `json:"md5"`
+	SHA256   string `json:"sha256"`
+}
+
+// Content describes the content of an event
+type Content struct {
+	SHA256 string `json:"sha256"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newBin(bin ds.Bin) *Bin {
+	return &Bin{
+		Id:        bin.Id,
+		Readonly:  bin.Readonly,
+		Approved:  bin.IsApproved(),
+		Encrypted: bin.Encrypted,
+		CreatedAt: bin.CreatedAt,
+		ExpiredAt: bin.ExpiredAt,
+	}
+}
+
+// BinEvent returns an event about a bin
+func BinEvent(event string, bin ds.Bin) Event {
+	return Event{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Bin:       newBin(bin),
+	}
+}
+
+// FileEvent returns an event about a file in a bin
+func FileEvent(event string, bin ds.Bin, file ds.File) Event {
+	return Event{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Bin:       newBin(bin),
+		File: &File{
+			Filename: file.Filename,
+			Bytes:    file.Bytes,
+			Mime:     file.Mime,
+			MD5:      file.MD5,
+			SHA256:   file.SHA256,
+		},
+	}
+}
+
+// ContentEvent returns an event about content, which may be referenced by
+// files in any number of bins
+func ContentEvent(event string, sha256 string, reason string) Event {
+	return Event{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Content: &Content{
+			SHA256: sha256,
+			Reason: reason,
+		},
+	}
+}
@@ -0,0 +1,230 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/espebra/filebin2/internal/dbl"
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+// Request headers of webhook deliveries. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a period and the request body, keyed with
+// the webhook secret.
+const (
+	EventHeader     = "Filebin-Event"
+	DeliveryHeader  = "Filebin-Delivery"
+	TimestampHeader = "Filebin-Timestamp"
+	SignatureHeader = "Filebin-Signature"
+)
+
+const (
+	// Time to sleep between each check for pending deliveries
+	pollInterval = 5 * time.Second
+
+	// Number of deliveries to claim at a time
+	batchSize = 20
+
+	// Delay before the first retry, doubled for each failed attempt
+	retryBase = 30 * time.Second
+	retryMax  = 6 * time.Hour
+)
+
+type Dispatcher struct {
+	dao         *dbl.DAO
+	urls        []string
+	secret      string
+	client      *http.Client
+	timeout     time.Duration
+	maxAttempts int
+	stopChan    chan struct{}
+}
+
+// New creates a dispatcher that delivers webhook events to the given URLs.
+// Webhooks are disabled if no URLs are given.
+func New(dao *dbl.DAO, urls []string, secret string) *Dispatcher {
+	return &Dispatcher{
+		dao:    dao,
+		urls:   urls,
+		secret: secret,
+	}
+}
+
+func (d *Dispatcher) Init(timeout time.Duration, maxAttempts int) {
+	d.timeout = timeout
+	d.maxAttempts = maxAttempts
+	d.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Enabled reports whether any webhook URLs are configured
+func (d *Dispatcher) Enabled() bool {
+	return d != nil && len(d.urls) > 0
+}
+
+// Enqueue adds a delivery of the event to each of the webhook URLs to the
+// outbox. Failing to enqueue is logged, and does not affect the operation
+// that caused the event.
+func (d *Dispatcher) Enqueue(event Event) {
+	if !d.Enabled() {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("unable to encode webhook event", "event", event.Event, "error", err)
+		return
+	}
+	for _, url := range d.urls {
+		delivery := ds.WebhookDelivery{
+			Event:   event.Event,
+			URL:     url,
+			Payload: string(payload),
+		}
+		if err := d.dao.Webhook().Insert(&delivery); err != nil {
+			slog.Error("unable to enqueue webhook delivery", "event", event.Event, "url", url, "error", err)
+		}
+	}
+}
+
+func (d *Dispatcher) Run() {
+	if !d.Enabled() {
+		return
+	}
+	slog.Info("starting webhook dispatcher", "urls", len(d.urls), "max_attempts", d.maxAttempts)
+	d.stopChan = make(chan struct{})
+	go func() {
+		for {
+			d.DeliverPending()
+			select {
+			case <-time.After(pollInterval):
+				// continue to next iteration
+			case <-d.stopChan:
+				slog.Info("webhook dispatcher stopped")
+				return
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) Stop() {
+	if d != nil && d.stopChan != nil {
+		close(d.stopChan)
+	}
+}
+
+// DeliverPending delivers the pending deliveries that are due
+func (d *Dispatcher) DeliverPending() {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("webhook dispatcher recovered from panic", "panic", r)
+		}
+	}()
+	for {
+		// The lease outlasts the attempts of the whole batch
+		deliveries, err := d.dao.Webhook().Claim(batchSize, time.Duration(batchSize+1)*d.timeout)
+		if err != nil {
+			slog.Error("unable to claim webhook deliveries", "error", err)
+			return
+		}
+		for i := range deliveries {
+			d.attempt(&deliveries[i])
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt makes one delivery attempt and records the outcome
+func (d *Dispatcher) attempt(delivery *ds.WebhookDelivery) {
+	statusCode, err := d.deliver(context.Background(), delivery)
+	delivery.Attempts = delivery.Attempts + 1
+	delivery.LastStatusCode = statusCode
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if err == nil {
+		delivery.Status = ds.WebhookDelivered
+		delivery.LastError = ""
+		delivery.NextAttemptAt = now
+		_ = delivery.DeliveredAt.Scan(now)
+		slog.Debug("delivered webhook", "id", delivery.Id, "event", delivery.Event, "url", delivery.URL, "status_code", statusCode)
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Status = ds.WebhookFailed
+			delivery.NextAttemptAt = now
+			slog.Error("gave up delivering webhook", "id", delivery.Id, "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts, "error", err)
+		} else {
+			delivery.NextAttemptAt = now.Add(retryDelay(delivery.Attempts))
+			slog.Warn("failed to deliver webhook, retrying", "id", delivery.Id, "event", delivery.Event, "url", delivery.URL, "attempts", delivery.Attempts, "next_attempt_at", delivery.NextAttemptAt, "error", err)
+		}
+	}
+	if err := d.dao.Webhook().Update(delivery); err != nil {
+		slog.Error("unable to update webhook delivery", "id", delivery.Id, "error", err)
+	}
+}
+
+// deliver sends the delivery to the webhook URL. Any response status code
+// other than 2xx is an error.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *ds.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(d.secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// Drain a little of the body to allow the connection to be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded signature of a webhook request body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns the delay before the next attempt, after the given
+// number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts; i++ {
+		delay = delay * 2
+		if delay >= retryMax {
+			return retryMax
+		}
+	}
+	return delay
+}
@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espebra/filebin2/internal/ds"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"bin.created"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if got := Sign("secret", 1700000000, body); got != expected {
+		t.Errorf("Expected signature %s, got %s", expected, got)
+	}
+	if Sign("other", 1700000000, body) == expected {
+		t.Error("Expected the signature to depend on the secret")
+	}
+	if Sign("secret", 1700000001, body) == expected {
+		t.Error("Expected the signature to depend on the timestamp")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := retryDelay(test.attempts); got != test.expected {
+			t.Errorf("Expected a delay of %s after %d attempts, got %s", test.expected, test.attempts, got)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := New(nil, []string{server.URL}, "secret")
+	d.Init(5*time.Second, 3)
+
+	delivery := &ds.WebhookDelivery{
+		Id:      42,
+		Event:   FileUploaded,
+		URL:     server.URL,
+		Payload: `{"event":"file.uploaded"}`,
+	}
+	statusCode, err := d.deliver(context.Background(), delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, statusCode)
+	}
+	if string(receivedBody) != delivery.Payload {
+		t.Errorf("Expected body %q, got %q", delivery.Payload, receivedBody)
+	}
+	if got := received.Header.Get(EventHeader); got != FileUploaded {
+		t.Errorf("Expected event header %q, got %q", FileUploaded, got)
+	}
+	if got := received.Header.Get(DeliveryHeader); got != "42" {
+		t.Errorf("Expected delivery header 42, got %q", got)
+	}
+	timestamp, err := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := received.Header.Get(SignatureHeader); got != "sha256="+Sign("secret", timestamp, receivedBody) {
+		t.Errorf("Unexpected signature %q", got)
+	}
+}
+
+func TestDeliverFailure(t *testing.T) {
+	statusCode := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode == http.StatusFound {
+			http.Redirect(w, r, "/elsewhere", statusCode)
+			return
+		}
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	d := New(nil, []string{server.URL}, "secret")
+	d.Init(5*time.Second, 3)
+	delivery := &ds.WebhookDelivery{Id: 1, Event: BinCreated, URL: server.URL, Payload: "{}"}
+
+	got, err := d.deliver(context.Background(), delivery)
+	if err == nil {
+		t.Error("Expected an error on status 500")
+	}
+	if got != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, got)
+	}
+
+	// Redirects are not followed
+	statusCode = http.StatusFound
+	if _, err := d.deliver(context.Background(), delivery); err == nil {
+		t.Error("Expected an error on a redirect")
+	}
+
+	// Unreachable URLs
+	server.Close()
+	if _, err := d.deliver(context.Background(), delivery); err == nil {
+		t.Error("Expected an error when the webhook URL is unreachable")
+	}
+}
+
+func TestBinEventOmitsSecrets(t *testing.T) {
+	bin := ds.Bin{
+		Id:             "mybin",
+		OwnerToken:     "ownertoken",
+		OwnerTokenHash: "ownertokenhash",
+		PasswordHash:   "passwordhash",
+	}
+	out, err := json.Marshal(BinEvent(BinCreated, bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"ownertoken", "passwordhash"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("Expected %q to not be part of the payload: %s", secret, out)
+		}
+	}
+	if !strings.Contains(string(out), `"id":"mybin"`) {
+		t.Errorf("Expected the bin id in the payload: %s", out)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var d *Dispatcher
+	if d.Enabled() {
+		t.Error("Expected a nil dispatcher to be disabled")
+	}
+	// Enqueue on a disabled dispatcher is a no-op
+	d.Enqueue(BinEvent(BinCreated, ds.Bin{Id: "mybin"}))
+	d.Stop()
+
+	if New(nil, nil, "secret").Enabled() {
+		t.Error("Expected a dispatcher without URLs to be disabled")
+	}
+}
@@ -216,6 +216,55 @@ func (m *Manager) SelectWorkspace(fileSize uint64) (*Workspace, error) {
 	return nil, fmt.Errorf("no workspace has sufficient space for %s file", humanize.Bytes(fileSize))
 }
 
+// SelectStreamWorkspace selects a workspace for a file of unknown size, which
+// is at most maxSize bytes (0 means no limit).
+// Strategy: Since the capacityThreshold safety margin can not be computed
+// without knowing the size, use the fastest workspace that can hold maxSize
+// bytes. Without a limit, or if no workspace can hold maxSize bytes, use the
+// workspace with the most space available to reduce the risk of running out
+// of space during the upload.
+func (m *Manager) SelectStreamWorkspace(maxSize uint64) (*Workspace, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if len(m.workspaces) == 0 {
+		return nil, fmt.Errorf("no workspaces available")
+	}
+
+	var best *Workspace
+	var maxAvailable uint64
+	for _, ws := range m.workspaces {
+		// Update capacity if it hasn't been checked recently (within last 10 seconds)
+		if time.Since(ws.LastChecked) > 10*time.Second {
+			if err := ws.UpdateCapacity(); err != nil {
+				slog.Warn("failed to update capacity for workspace", "path", ws.Path, "error", err)
+				continue
+			}
+		}
+
+		available := ws.GetAvailableBytes()
+		if maxSize > 0 && available >= maxSize {
+			return ws, nil
+		}
+		if best == nil || available > maxAvailable {
+			maxAvailable = available
+			best = ws
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no workspace available for file of unknown size")
+	}
+
+	if maxSize > 0 {
+		slog.Warn("no workspace can hold the maximum upload size",
+			"path", best.Path,
+			"available_bytes", humanize.Bytes(maxAvailable),
+			"max_size", humanize.Bytes(maxSize))
+	}
+	return best, nil
+}
+
 // Stats returns statistics about all workspaces
 type Stats struct {
 	Path           string
@@ -262,6 +311,22 @@ func (m *Manager) CreateTempFile(fileSize uint64, prefix string) (*os.File, erro
 	return fp, nil
 }
 
+// CreateStreamTempFile creates a temporary file for a file of unknown size,
+// which is at most maxSize bytes (0 means no limit)
+func (m *Manager) CreateStreamTempFile(maxSize uint64, prefix string) (*os.File, error) {
+	ws, err := m.SelectStreamWorkspace(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := os.CreateTemp(ws.Path, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file in %q: %w", ws.Path, err)
+	}
+
+	return fp, nil
+}
+
 // GetPrimaryPath returns the fastest workspace path (used for non-upload temp files)
 func (m *Manager) GetPrimaryPath() string {
 	m.mutex.RLock()
@@ -3,6 +3,7 @@ package workspace
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -676,3 +677,82 @@ func TestManager_CreateTempFile_FallbackBehavior(t *testing.T) {
 		t.Logf("CreateTempFile succeeded with warning (will fail on actual write)")
 	}
 }
+
+func TestManager_SelectStreamWorkspace(t *testing.T) {
+	// Workspaces are sorted by write speed, fastest first. Recent capacity
+	// checks prevent the available space from being refreshed.
+	fast := &Workspace{Path: "/fast", WriteMBps: 1000, AvailableBytes: 10 * 1024 * 1024, LastChecked: time.Now()}
+	slow := &Workspace{Path: "/slow", WriteMBps: 100, AvailableBytes: 100 * 1024 * 1024, LastChecked: time.Now()}
+	m := &Manager{
+		workspaces:        []*Workspace{fast, slow},
+		capacityThreshold: 4.0,
+	}
+
+	tests := []struct {
+		name    string
+		maxSize uint64
+		want    *Workspace
+	}{
+		{
+			name:    "fastest workspace that can hold the maximum size",
+			maxSize: 5 * 1024 * 1024,
+			want:    fast,
+		},
+		{
+			name:    "skip workspaces that can not hold the maximum size",
+			maxSize: 50 * 1024 * 1024,
+			want:    slow,
+		},
+		{
+			name:    "most space when no workspace can hold the maximum size",
+			maxSize: 500 * 1024 * 1024,
+			want:    slow,
+		},
+		{
+			name:    "most space without a maximum size",
+			maxSize: 0,
+			want:    slow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, err := m.SelectStreamWorkspace(tt.maxSize)
+			if err != nil {
+				t.Fatalf("SelectStreamWorkspace failed: %s", err)
+			}
+			if ws != tt.want {
+				t.Errorf("Expected workspace %s, got %s", tt.want.Path, ws.Path)
+			}
+		})
+	}
+}
+
+func TestManager_SelectStreamWorkspace_NoWorkspaces(t *testing.T) {
+	m := &Manager{
+		workspaces: []*Workspace{},
+	}
+
+	_, err := m.SelectStreamWorkspace(0)
+	if err == nil {
+		t.Error("Expected error when selecting from empty workspace list")
+	}
+}
+
+func TestManager_CreateStreamTempFile(t *testing.T) {
+	m, err := NewManager(os.TempDir(), 4.0)
+	if err != nil {
+		t.Fatalf("Failed to create workspace manager: %s", err)
+	}
+
+	fp, err := m.CreateStreamTempFile(0, "test-stream-")
+	if err != nil {
+		t.Fatalf("CreateStreamTempFile failed: %s", err)
+	}
+	defer func() { _ = os.Remove(fp.Name()) }()
+	defer func() { _ = fp.Close() }()
+
+	if !strings.HasPrefix(filepath.Base(fp.Name()), "test-stream-") {
+		t.Errorf("Expected temp file with prefix test-stream-, got %s", fp.Name())
+	}
+}